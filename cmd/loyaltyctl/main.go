@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"loyalty-core/models"
+)
+
+// loyaltyctl operates the loyalty program through the server's admin API. Storage lives in the
+// server process, so every command is a call to a running server.
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+const usage = `Usage: loyaltyctl [-server url] [-token token] [-merchant id] [-json] <command> [arguments]
+
+Commands:
+  login -email address [-password password]   print an admin token for LOYALTYCTL_TOKEN
+  member <email|loyaltyId>                     show a member
+  adjust -code reason -note text <member> <points>
+                                               credit (positive) or debit (negative) points
+  reverse [-code reason] -note text <member> <transaction>
+                                               undo a transaction with an opposite adjustment
+  approve [-note text] <adjustment>            apply an adjustment another admin requested
+  reject [-note text] <adjustment>             reject an adjustment another admin requested
+  lock [-reason text] <member>                 suspend an account and revoke its sessions
+  unlock <member>                              reactivate a locked account
+  reconcile [-mode dry_run|auto_correct]       reconcile local balances with Square now
+  export [-format json|csv] [-o file] <member> export a member's ledger
+
+Members are given by email or loyalty number. Commands act on the merchant given by -merchant,
+or else on the merchant of the server URL's host.
+
+Options:
+`
+
+// cli holds the global options shared by all commands
+type cli struct {
+	server     string
+	token      string
+	merchant   string
+	jsonOutput bool
+	httpClient *http.Client
+	stdout     io.Writer // command output; errors and usage go to standard error
+}
+
+// run runs the command line args, writing its output to stdout, and returns the exit code
+func run(args []string, stdout io.Writer) int {
+	c := &cli{httpClient: &http.Client{Timeout: 60 * time.Second}, stdout: stdout}
+
+	flags := flag.NewFlagSet("loyaltyctl", flag.ContinueOnError)
+	flags.StringVar(&c.server, "server", envOr("LOYALTYCTL_SERVER", "http://localhost:8080"), "server URL (LOYALTYCTL_SERVER)")
+	flags.StringVar(&c.token, "token", "", "admin JWT (default LOYALTYCTL_TOKEN)")
+	flags.StringVar(&c.merchant, "merchant", os.Getenv("LOYALTYCTL_MERCHANT"), "ID of the merchant to operate (LOYALTYCTL_MERCHANT)")
+	flags.BoolVar(&c.jsonOutput, "json", false, "print JSON instead of text")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	c.server = strings.TrimRight(c.server, "/")
+	if c.token == "" {
+		c.token = os.Getenv("LOYALTYCTL_TOKEN") // not a flag default, which usage would print
+	}
+
+	commands := map[string]func([]string) error{
+		"login":     c.login,
+		"member":    c.member,
+		"adjust":    c.adjust,
+		"reverse":   c.reverse,
+		"approve":   c.approve,
+		"reject":    c.reject,
+		"lock":      c.lock,
+		"unlock":    c.unlock,
+		"reconcile": c.reconcile,
+		"export":    c.export,
+	}
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	if err := command(flags.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// errUsage is returned by commands called with the wrong arguments, after printing their usage
+var errUsage = errors.New("usage")
+
+// parse parses a command's flags and checks it got the expected number of arguments
+func parse(flags *flag.FlagSet, args []string, synopsis string, nargs int) error {
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: loyaltyctl", flags.Name(), synopsis)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != nargs {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func (c *cli) login(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	email := flags.String("email", "", "admin email")
+	password := flags.String("password", "", "password (default LOYALTYCTL_PASSWORD)")
+	if err := parse(flags, args, "-email address [-password password]", 0); err != nil {
+		return err
+	}
+	if *password == "" {
+		*password = os.Getenv("LOYALTYCTL_PASSWORD")
+	}
+
+	var response models.LoginResponse
+	request := models.LoginRequest{Email: *email, Password: *password}
+	if err := c.call(http.MethodPost, "/api/auth/login", request, &response); err != nil {
+		return err
+	}
+	if response.User.Role != models.RoleAdmin {
+		return fmt.Errorf("%s is not an admin", *email)
+	}
+
+	fmt.Fprintln(c.stdout, response.Token)
+	return nil
+}
+
+func (c *cli) member(args []string) error {
+	flags := flag.NewFlagSet("member", flag.ContinueOnError)
+	if err := parse(flags, args, "<email|loyaltyId>", 1); err != nil {
+		return err
+	}
+
+	member, err := c.findMember(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.print(member, func(w io.Writer) {
+		printMember(w, member)
+	})
+}
+
+func (c *cli) adjust(args []string) error {
+	flags := flag.NewFlagSet("adjust", flag.ContinueOnError)
+	code := flags.String("code", "", "reason code: "+strings.Join(models.AdjustmentReasonCodes, ", ")+" (required)")
+	note := flags.String("note", "", "what happened, for the member's history (required)")
+	if err := parse(flags, args, "-code reason -note text <member> <points>", 2); err != nil {
+		return err
+	}
+	points, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("points must be a whole number, got %q", flags.Arg(1))
+	}
+
+	member, err := c.findMember(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var adjustment models.Adjustment
+	request := models.AdjustPointsRequest{Points: points, ReasonCode: *code, Note: *note}
+	if err := c.call(http.MethodPost, "/api/admin/members/"+member.ID+"/adjustments", request, &adjustment); err != nil {
+		return err
+	}
+	return c.print(adjustment, func(w io.Writer) {
+		printAdjustment(w, &adjustment)
+	})
+}
+
+func (c *cli) reverse(args []string) error {
+	flags := flag.NewFlagSet("reverse", flag.ContinueOnError)
+	code := flags.String("code", models.AdjustmentReasonCorrection, "reason code: "+strings.Join(models.AdjustmentReasonCodes, ", "))
+	note := flags.String("note", "", "why the transaction is reversed (required)")
+	if err := parse(flags, args, "[-code reason] -note text <member> <transactionId>", 2); err != nil {
+		return err
+	}
+
+	member, err := c.findMember(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var adjustment models.Adjustment
+	path := "/api/admin/members/" + member.ID + "/transactions/" + url.PathEscape(flags.Arg(1)) + "/reverse"
+	if err := c.call(http.MethodPost, path, models.ReverseTransactionRequest{ReasonCode: *code, Note: *note}, &adjustment); err != nil {
+		return err
+	}
+	return c.print(adjustment, func(w io.Writer) {
+		printAdjustment(w, &adjustment)
+	})
+}
+
+func (c *cli) approve(args []string) error {
+	return c.review(args, "approve")
+}
+
+func (c *cli) reject(args []string) error {
+	return c.review(args, "reject")
+}
+
+// review approves or rejects a pending adjustment, which must have been requested by another admin
+func (c *cli) review(args []string, action string) error {
+	flags := flag.NewFlagSet(action, flag.ContinueOnError)
+	note := flags.String("note", "", "review note")
+	if err := parse(flags, args, "[-note text] <adjustmentId>", 1); err != nil {
+		return err
+	}
+
+	var adjustment models.Adjustment
+	path := "/api/admin/adjustments/" + url.PathEscape(flags.Arg(0)) + "/" + action
+	if err := c.call(http.MethodPost, path, models.ReviewAdjustmentRequest{Note: *note}, &adjustment); err != nil {
+		return err
+	}
+	return c.print(adjustment, func(w io.Writer) {
+		printAdjustment(w, &adjustment)
+	})
+}
+
+func (c *cli) lock(args []string) error {
+	flags := flag.NewFlagSet("lock", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the account is locked")
+	if err := parse(flags, args, "[-reason text] <member>", 1); err != nil {
+		return err
+	}
+	return c.setLocked(flags.Arg(0), "lock", models.LockAccountRequest{Reason: *reason})
+}
+
+func (c *cli) unlock(args []string) error {
+	flags := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	if err := parse(flags, args, "<member>", 1); err != nil {
+		return err
+	}
+	return c.setLocked(flags.Arg(0), "unlock", nil)
+}
+
+// setLocked calls the lock or unlock endpoint of a member and prints the updated member
+func (c *cli) setLocked(query, action string, request interface{}) error {
+	member, err := c.findMember(query)
+	if err != nil {
+		return err
+	}
+
+	var updated models.User
+	if err := c.call(http.MethodPost, "/api/admin/members/"+member.ID+"/"+action, request, &updated); err != nil {
+		return err
+	}
+	return c.print(updated, func(w io.Writer) {
+		printMember(w, &updated)
+	})
+}
+
+func (c *cli) reconcile(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	mode := flags.String("mode", models.ReconciliationModeDryRun, "dry_run reports mismatches, auto_correct also posts corrections")
+	if err := parse(flags, args, "[-mode dry_run|auto_correct]", 0); err != nil {
+		return err
+	}
+
+	var report models.ReconciliationReport
+	if err := c.call(http.MethodPost, "/api/admin/reconciliation/run", models.RunReconciliationRequest{Mode: *mode}, &report); err != nil {
+		return err
+	}
+	return c.print(report, func(w io.Writer) {
+		fmt.Fprintf(w, "Report %s (%s): %d members checked, %d skipped, %d mismatches, %d corrections\n",
+			report.ID, report.Mode, report.UsersChecked, report.UsersSkipped, len(report.Mismatches), report.Corrections)
+		for _, problem := range report.Errors {
+			fmt.Fprintln(w, "Error:", problem)
+		}
+		if len(report.Mismatches) > 0 {
+			table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(table, "MEMBER\tTYPE\tPOINTS\tLOCAL\tSQUARE\tCORRECTED\tDETAIL")
+			for _, mismatch := range report.Mismatches {
+				fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%d\t%t\t%s\n", mismatch.UserID, mismatch.Type, mismatch.Points,
+					mismatch.LocalBalance, mismatch.SquareBalance, mismatch.Corrected, mismatch.Detail)
+			}
+			table.Flush()
+		}
+	})
+}
+
+func (c *cli) export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "json", "json or csv")
+	output := flags.String("o", "", "write to this file instead of standard output")
+	if err := parse(flags, args, "[-format json|csv] [-o file] <member>", 1); err != nil {
+		return err
+	}
+
+	member, err := c.findMember(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	body, err := c.request(http.MethodGet, "/api/admin/members/"+member.ID+"/ledger?format="+url.QueryEscape(*format), nil)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = c.stdout.Write(body)
+		return err
+	}
+	if err := os.WriteFile(*output, body, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Ledger of %s written to %s\n", member.LoyaltyID, *output)
+	return nil
+}
+
+// findMember looks a member up by email, or by loyalty number for anything without an @
+func (c *cli) findMember(query string) (*models.User, error) {
+	params := url.Values{}
+	if strings.Contains(query, "@") {
+		params.Set("email", query)
+	} else {
+		params.Set("loyaltyId", strings.ToUpper(query))
+	}
+
+	var member models.User
+	if err := c.call(http.MethodGet, "/api/admin/members?"+params.Encode(), nil, &member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// call sends a JSON request to the server and decodes the JSON response into out
+func (c *cli) call(method, path string, request, out interface{}) error {
+	body, err := c.request(method, path, request)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// request sends a request to the server and returns the response body. Error responses are
+// returned as errors carrying the server's message.
+func (c *cli) request(method, path string, request interface{}) ([]byte, error) {
+	var reader io.Reader
+	if request != nil {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.merchant != "" {
+		req.Header.Set("X-Merchant-ID", c.merchant)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiError) == nil && apiError.Error != "" {
+			return nil, fmt.Errorf("%s (%s)", apiError.Error, resp.Status)
+		}
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return body, nil
+}
+
+// print writes value as indented JSON with -json, otherwise as text
+func (c *cli) print(value interface{}, text func(w io.Writer)) error {
+	if c.jsonOutput {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	text(c.stdout)
+	return nil
+}
+
+func printMember(w io.Writer, member *models.User) {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "ID\t%s\n", member.ID)
+	fmt.Fprintf(table, "Loyalty ID\t%s\n", member.LoyaltyID)
+	fmt.Fprintf(table, "Merchant\t%s\n", member.MerchantID)
+	fmt.Fprintf(table, "Name\t%s %s\n", member.FirstName, member.LastName)
+	fmt.Fprintf(table, "Email\t%s\n", member.Email)
+	if member.Phone != "" {
+		fmt.Fprintf(table, "Phone\t%s\n", member.Phone)
+	}
+	fmt.Fprintf(table, "Status\t%s\n", member.Status)
+	if member.LockedAt != nil {
+		fmt.Fprintf(table, "Locked\t%s (%s)\n", member.LockedAt.Format(time.RFC3339), member.LockReason)
+	}
+	fmt.Fprintf(table, "Points\t%d\n", member.Points)
+	if member.SquareAccountID != "" {
+		fmt.Fprintf(table, "Square account\t%s\n", member.SquareAccountID)
+	}
+	fmt.Fprintf(table, "Member since\t%s\n", member.CreatedAt.Format(time.RFC3339))
+	table.Flush()
+}
+
+func printAdjustment(w io.Writer, adjustment *models.Adjustment) {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "Adjustment\t%s\n", adjustment.ID)
+	fmt.Fprintf(table, "Member\t%s\n", adjustment.UserID)
+	fmt.Fprintf(table, "Points\t%+d\n", adjustment.Points)
+	fmt.Fprintf(table, "Reason\t%s: %s\n", adjustment.ReasonCode, adjustment.Note)
+	if adjustment.ReversesID != "" {
+		fmt.Fprintf(table, "Reverses\t%s\n", adjustment.ReversesID)
+	}
+	fmt.Fprintf(table, "Status\t%s\n", adjustment.Status)
+	if adjustment.ReviewedBy != "" {
+		fmt.Fprintf(table, "Reviewed by\t%s\n", adjustment.ReviewedBy)
+	}
+	if adjustment.TransactionID != "" {
+		fmt.Fprintf(table, "Transaction\t%s\n", adjustment.TransactionID)
+	}
+	table.Flush()
+}
+
+// envOr returns the environment variable, or fallback when it is not set
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/routes"
+	"loyalty-core/squarefake"
+)
+
+const (
+	testPassword = "Zq7#kfLw92pX"
+	adminEmail   = "ops@example.com"
+	memberEmail  = "regular@example.com"
+)
+
+// newTestServer serves the loyalty API in-process, connected to a squarefake server, with an admin
+// and a member signed up. Storage is global, so they may exist from an earlier test.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	fake := squarefake.NewServer()
+	square := fake.Start()
+	t.Cleanup(square.Close)
+
+	cfg := config.Defaults()
+	cfg.JWTSecret = "test-secret"
+	cfg.AdminEmails = []string{adminEmail}
+	cfg.SquareBaseURL = square.URL
+	cfg.SquareAccessToken = "fake-token"
+	cfg.SquareLocationID = "fake-location"
+	cfg.SquareMaxRetries = 0
+
+	router := routes.NewMainRouter(cfg)
+	router.RegisterAllRoutes()
+	server := httptest.NewServer(router.Handler())
+	t.Cleanup(server.Close)
+
+	for _, email := range []string{adminEmail, memberEmail} {
+		body, _ := json.Marshal(models.SignupRequest{Email: email, Password: testPassword, FirstName: "Test", LastName: "User"})
+		resp, err := http.Post(server.URL+"/api/auth/signup", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("signup %s: %v", email, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+			t.Fatalf("signup %s: status %d", email, resp.StatusCode)
+		}
+	}
+	return server
+}
+
+// loyaltyctl runs a command and returns its exit code and output
+func loyaltyctl(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+
+	var out bytes.Buffer
+	code := run(args, &out)
+	return code, out.String()
+}
+
+// loyaltyctlJSON runs a command with -json and decodes its output into v
+func loyaltyctlJSON(t *testing.T, v interface{}, args ...string) {
+	t.Helper()
+
+	code, out := loyaltyctl(t, append([]string{"-json"}, args...)...)
+	if code != 0 {
+		t.Fatalf("loyaltyctl %s: exit code %d", strings.Join(args, " "), code)
+	}
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		t.Fatalf("loyaltyctl %s: decode %q: %v", strings.Join(args, " "), out, err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	server := newTestServer(t)
+	t.Setenv("LOYALTYCTL_SERVER", server.URL)
+	t.Setenv("LOYALTYCTL_PASSWORD", testPassword)
+
+	if code, _ := loyaltyctl(t, "login", "-email", memberEmail); code != 1 {
+		t.Fatalf("login as a member: exit code %d, want 1", code)
+	}
+	code, out := loyaltyctl(t, "login", "-email", adminEmail)
+	if code != 0 {
+		t.Fatalf("login: exit code %d", code)
+	}
+	t.Setenv("LOYALTYCTL_TOKEN", strings.TrimSpace(out))
+
+	var member models.User
+	loyaltyctlJSON(t, &member, "member", memberEmail)
+	if member.Email != memberEmail || member.LoyaltyID == "" {
+		t.Fatalf("member = %+v", member)
+	}
+	// Loyalty numbers are matched in any case, and the text output is a table
+	if code, out := loyaltyctl(t, "member", strings.ToLower(member.LoyaltyID)); code != 0 || !strings.Contains(out, memberEmail) || !strings.Contains(out, "Points") {
+		t.Errorf("member by loyalty ID: exit code %d, output:\n%s", code, out)
+	}
+
+	var adjustment models.Adjustment
+	loyaltyctlJSON(t, &adjustment, "adjust", "-code", models.AdjustmentReasonGoodwill, "-note", "Spilled coffee", member.LoyaltyID, "50")
+	if adjustment.Status != models.AdjustmentStatusApplied || adjustment.Points != 50 || adjustment.TransactionID == "" {
+		t.Fatalf("adjustment = %+v, want 50 points applied", adjustment)
+	}
+
+	var reversal models.Adjustment
+	loyaltyctlJSON(t, &reversal, "reverse", "-note", "Posted twice", memberEmail, adjustment.TransactionID)
+	if reversal.Points != -50 || reversal.ReversesID != adjustment.TransactionID {
+		t.Errorf("reversal = %+v, want -50 points reversing %s", reversal, adjustment.TransactionID)
+	}
+
+	loyaltyctlJSON(t, &member, "lock", "-reason", "Chargeback", memberEmail)
+	if member.Status != models.AccountStatusLocked {
+		t.Errorf("status after lock = %s", member.Status)
+	}
+	loyaltyctlJSON(t, &member, "unlock", memberEmail)
+	if member.Status != models.AccountStatusActive || member.Points != 0 {
+		t.Errorf("member after unlock = %s with %d points, want active with 0", member.Status, member.Points)
+	}
+
+	if code, out := loyaltyctl(t, "export", "-format", "csv", memberEmail); code != 0 || !strings.Contains(out, adjustment.TransactionID) || !strings.Contains(out, reversal.TransactionID) {
+		t.Errorf("export: exit code %d, output:\n%s", code, out)
+	}
+
+	var report models.ReconciliationReport
+	loyaltyctlJSON(t, &report, "reconcile")
+	if report.ID == "" || report.Mode != models.ReconciliationModeDryRun {
+		t.Errorf("reconciliation report = %+v, want a dry run", report)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	server := newTestServer(t)
+	t.Setenv("LOYALTYCTL_SERVER", server.URL)
+	t.Setenv("LOYALTYCTL_TOKEN", "")
+
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		{nil, 2},
+		{[]string{"promote"}, 2},
+		{[]string{"adjust", memberEmail}, 2},
+		{[]string{"adjust", "-code", "goodwill", "-note", "n", memberEmail, "lots"}, 1},
+		{[]string{"member", memberEmail}, 1}, // no token
+		{[]string{"-token", "forged", "member", memberEmail}, 1},
+	} {
+		if code, _ := loyaltyctl(t, tc.args...); code != tc.want {
+			t.Errorf("loyaltyctl %s: exit code %d, want %d", strings.Join(tc.args, " "), code, tc.want)
+		}
+	}
+}
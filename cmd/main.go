@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"loyalty-core/config"
 	"loyalty-core/models"
@@ -13,37 +19,24 @@ import (
 	"github.com/joho/godotenv"
 )
 
-func createDemoData(cfg *config.Config) {
+// createDemoData signs up the demo member, for the development profile (DEMO_DATA=true)
+func createDemoData(mainRouter *routes.MainRouter) {
 	fmt.Println("Creating demo data...")
-	
-	// Create auth service to add demo user
-	authService := services.NewAuthService(cfg)
-	
-	// Demo user data
-	demoUser := models.SignupRequest{
-		Email:     "demo@loyalty.com",
-		Password:  "password123",
-		FirstName: "Demo",
-		LastName:  "User",
-	}
-	
-	// Try to create demo user
-	response, err := authService.SignupUser(demoUser)
+
+	report, err := mainRouter.Seed(context.Background(), models.Fixtures{Members: []models.FixtureMember{services.DemoMember}})
 	if err != nil {
-		if err.Error() == "user already exists" {
-			fmt.Println("Demo user already exists")
-		} else {
-			fmt.Printf("⚠️  Failed to create demo user: %v\n", err)
-		}
+		fmt.Printf("⚠️  Failed to create demo user: %v\n", err)
+		return
+	}
+	if len(report.Skipped) > 0 {
+		fmt.Println("Demo user already exists")
 	} else {
 		fmt.Println("Demo user created successfully")
-		fmt.Printf("   Email: %s\n", response.User.Email)
-		fmt.Printf("   Name: %s %s\n", response.User.FirstName, response.User.LastName)
 	}
-	
+
 	fmt.Println("\nDemo Login Credentials:")
-	fmt.Println("   Email: demo@loyalty.com")
-	fmt.Println("   Password: password123")
+	fmt.Printf("   Email: %s\n", services.DemoMember.Email)
+	fmt.Printf("   Password: %s\n", services.DemoMember.Password)
 	fmt.Println()
 }
 
@@ -53,27 +46,139 @@ func main() {
 		log.Println("No .env file found, using default values")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		os.Exit(runSeed(os.Args[2:]))
+	}
+
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file; environment variables override its values")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted, and exit")
+	flag.Parse()
+
+	if *printConfig {
+		os.Exit(runPrintConfig(*configPath))
+	}
+
 	// Load configuration
-	cfg, err := config.LoadConfig()
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatal("Failed to load config:", err)
+		log.Fatal("Failed to load config: ", err)
 	}
 
-	// Create demo data for easy testing
-	createDemoData(cfg)
-
 	// Create main router
+	mainRouter, err := newMainRouter(cfg)
+	if err != nil {
+		log.Fatal("Failed to open audit log: ", err)
+	}
+
+	// Demo data is opt-in and refused in production by config validation
+	if cfg.DemoData {
+		createDemoData(mainRouter)
+	}
+
+	serve(cfg, mainRouter)
+}
+
+// newMainRouter creates the main router with its audit log restored, ready to serve requests
+func newMainRouter(cfg *config.Config) (*routes.MainRouter, error) {
 	mainRouter := routes.NewMainRouter(cfg)
+	if err := mainRouter.OpenAuditLog(); err != nil {
+		return nil, err
+	}
+	return mainRouter, nil
+}
 
+// serve registers the routes, starts the background workers and serves HTTP until SIGINT/SIGTERM
+func serve(cfg *config.Config, mainRouter *routes.MainRouter) {
 	// Register all routes
 	mainRouter.RegisterAllRoutes()
 
+	tlsEnabled := cfg.TLSCertFile != ""
+	server := newServer(cfg, mainRouter.Handler())
+
+	// Start background workers
+	mainRouter.StartWorkers()
+
 	// Start the server
-	serverAddr := ":" + cfg.Port
 	fmt.Printf("Server starting on port %s...\n", cfg.Port)
+	serverErr := make(chan error, 1)
+	go func() {
+		if tlsEnabled {
+			log.Printf("Server is listening on port %s (HTTPS)", cfg.Port)
+			serverErr <- server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Printf("Server is listening on port %s", cfg.Port)
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+
+	// Run until SIGINT/SIGTERM, e.g. from a deploy
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Printf("Server is listening on port %s", cfg.Port)
-	if err := http.ListenAndServe(serverAddr, nil); err != nil {
+	select {
+	case err := <-serverErr:
 		log.Fatal("Server failed to start:", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process right away
+
+	shutdown(cfg, server, mainRouter)
+}
+
+// runPrintConfig prints the effective configuration, secrets redacted, followed by any validation
+// problems. It returns the exit code.
+func runPrintConfig(configPath string) int {
+	cfg, err := config.Read(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out, err := cfg.YAML()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
+	os.Stdout.Write(out)
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// newServer creates the HTTP server with the configured timeouts and header size limit
+func newServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           ":" + cfg.Port,
+		Handler:        handler,
+		ReadTimeout:    time.Duration(cfg.ServerReadTimeoutSeconds) * time.Second,
+		WriteTimeout:   time.Duration(cfg.ServerWriteTimeoutSeconds) * time.Second,
+		IdleTimeout:    time.Duration(cfg.ServerIdleTimeoutSeconds) * time.Second,
+		MaxHeaderBytes: cfg.ServerMaxHeaderBytes,
+	}
+}
+
+// shutdown stops accepting connections and lets in-flight requests finish, then stops the
+// background workers, delivers pending Square writes and closes the audit log. Draining and
+// flushing each get SERVER_SHUTDOWN_TIMEOUT_SECONDS.
+func shutdown(cfg *config.Config, server *http.Server, mainRouter *routes.MainRouter) {
+	timeout := time.Duration(cfg.ServerShutdownTimeoutSeconds) * time.Second
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", timeout)
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), timeout)
+	defer cancelDrain()
+	if err := server.Shutdown(drainCtx); err != nil {
+		log.Printf("Requests still in flight at shutdown were cut off: %v", err)
+	}
+
+	mainRouter.StopWorkers()
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), timeout)
+	defer cancelFlush()
+	mainRouter.Flush(flushCtx)
+	mainRouter.CloseAuditLog()
+
+	log.Println("Server stopped")
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"loyalty-core/config"
+)
+
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg := config.Defaults()
+	cfg.JWTSecret = "test-secret"
+	cfg.AuditLogFile = filepath.Join(t.TempDir(), "audit.log")
+	return cfg
+}
+
+func TestNewServerAppliesConfig(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Port = "9443"
+	cfg.ServerReadTimeoutSeconds = 5
+	cfg.ServerWriteTimeoutSeconds = 10
+	cfg.ServerIdleTimeoutSeconds = 30
+	cfg.ServerMaxHeaderBytes = 4096
+
+	server := newServer(cfg, http.NotFoundHandler())
+	if server.Addr != ":9443" {
+		t.Errorf("Addr = %q, want :9443", server.Addr)
+	}
+	if server.ReadTimeout != 5*time.Second || server.WriteTimeout != 10*time.Second || server.IdleTimeout != 30*time.Second {
+		t.Errorf("timeouts = %s read, %s write, %s idle; want 5s, 10s, 30s", server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+	if server.MaxHeaderBytes != 4096 {
+		t.Errorf("MaxHeaderBytes = %d, want 4096", server.MaxHeaderBytes)
+	}
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ServerShutdownTimeoutSeconds = 5
+
+	mainRouter, err := newMainRouter(cfg)
+	if err != nil {
+		t.Fatalf("newMainRouter: %v", err)
+	}
+	mainRouter.RegisterAllRoutes()
+	mainRouter.StartWorkers()
+
+	// A request that is still running when the deploy sends SIGTERM
+	started, release := make(chan struct{}), make(chan struct{})
+	routes := mainRouter.Handler()
+	server := newServer(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Test-Slow") != "" {
+			close(started)
+			<-release
+		}
+		routes.ServeHTTP(w, r)
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	url := "http://" + listener.Addr().String()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, url+"/health", nil)
+		req.Header.Set("X-Test-Slow", "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("in-flight request: %v", err)
+			close(responses)
+			return
+		}
+		resp.Body.Close()
+		responses <- resp
+	}()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		shutdown(cfg, server, mainRouter)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("shutdown returned while a request was in flight")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("Serve = %v, want ErrServerClosed", err)
+	}
+	if _, err := http.Get(url + "/health"); err == nil {
+		t.Error("new request accepted while shutting down")
+	}
+
+	close(release)
+	if resp, ok := <-responses; ok && resp.StatusCode != http.StatusOK {
+		t.Errorf("in-flight request: status %d, want 200", resp.StatusCode)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return after the last request finished")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runSeed handles "seed [-config file] [-serve=false] fixtures.yaml". Storage is in memory, so
+// the seeded data only lives as long as the process: by default the server keeps running with it.
+// With -serve=false the fixtures are applied, summarized and the process exits, which checks a
+// fixtures file. It returns the exit code.
+func runSeed(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file; environment variables override its values")
+	serveAfter := flags.Bool("serve", true, "keep serving the seeded data; false only applies and summarizes the fixtures")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: seed [-config file] [-serve=false] fixtures.yaml|fixtures.json")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load config:", err)
+		return 1
+	}
+	if cfg.IsProduction() {
+		fmt.Fprintln(os.Stderr, "Seeding is not allowed in production")
+		return 1
+	}
+
+	fixtures, err := readFixtures(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	mainRouter, err := newMainRouter(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open audit log:", err)
+		return 1
+	}
+
+	report, err := mainRouter.Seed(context.Background(), *fixtures)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Seeding failed:", err)
+		return 1
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+
+	if !*serveAfter {
+		// Deliver the seeded points to Square, if it is configured, before exiting
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ServerShutdownTimeoutSeconds)*time.Second)
+		defer cancel()
+		mainRouter.Flush(ctx)
+		mainRouter.CloseAuditLog()
+		return 0
+	}
+
+	serve(cfg, mainRouter)
+	return 0
+}
+
+// readFixtures parses a YAML or JSON fixtures file; unknown keys are rejected to catch typos
+func readFixtures(path string) (*models.Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixtures: %w", err)
+	}
+
+	var fixtures models.Fixtures
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse fixtures %s: %w", path, err)
+	}
+	return &fixtures, nil
+}
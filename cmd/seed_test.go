@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+func TestReadFixtures(t *testing.T) {
+	fixtures, err := readFixtures(filepath.Join("..", "fixtures", "example.yaml"))
+	if err != nil {
+		t.Fatalf("readFixtures: %v", err)
+	}
+	if len(fixtures.Rewards) == 0 || len(fixtures.Members) == 0 || len(fixtures.Transactions) == 0 {
+		t.Errorf("example fixtures = %+v, want rewards, members and transactions", fixtures)
+	}
+
+	path := filepath.Join(t.TempDir(), "typo.json")
+	if err := os.WriteFile(path, []byte(`{"members":[{"email":"a@example.com","pasword":"x"}]}`), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := readFixtures(path); err == nil {
+		t.Error("readFixtures accepted a misspelt key")
+	}
+}
+
+func TestRunSeed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.yaml")
+	fixtures := "members:\n  - email: cmd-seed@example.com\n    password: Zq7#kfLw92pX\n    firstName: Cmd\n    lastName: Seed\n" +
+		"transactions:\n  - member: cmd-seed@example.com\n    type: earn\n    points: 40\n"
+	if err := os.WriteFile(path, []byte(fixtures), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("AUDIT_LOG_FILE", filepath.Join(t.TempDir(), "audit.log"))
+
+	t.Setenv("APP_ENV", config.EnvironmentProduction)
+	if code := runSeed([]string{"-serve=false", path}); code == 0 {
+		t.Fatal("seeding was allowed in production")
+	}
+
+	t.Setenv("APP_ENV", config.EnvironmentDevelopment)
+	for run := 0; run < 2; run++ {
+		if code := runSeed([]string{"-serve=false", path}); code != 0 {
+			t.Fatalf("run %d: exit code %d", run+1, code)
+		}
+	}
+	user, err := storage.GetGlobalUserStorage().GetUserByEmail(models.DefaultMerchantID, "cmd-seed@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if user.Points != 40 {
+		t.Errorf("balance = %d after seeding twice, want 40", user.Points)
+	}
+
+	if code := runSeed([]string{"-serve=false"}); code != 2 {
+		t.Errorf("exit code without a fixtures file = %d, want 2", code)
+	}
+}
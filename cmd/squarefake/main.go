@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"loyalty-core/squarefake"
+)
+
+// squarefake serves an in-memory fake of the Square Loyalty API for offline development.
+// Point the server at it with SQUARE_BASE_URL=http://localhost:8090.
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	locations := flag.String("locations", "", "comma-separated location IDs in addition to fake-location")
+	flag.Parse()
+
+	server := squarefake.NewServer()
+	for _, locationID := range strings.Split(*locations, ",") {
+		if locationID = strings.TrimSpace(locationID); locationID != "" {
+			server.AddLocation(locationID)
+		}
+	}
+
+	log.Printf("Fake Square Loyalty API listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		log.Fatal("Fake server failed:", err)
+	}
+}
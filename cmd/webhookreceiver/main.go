@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"loyalty-core/utils"
+)
+
+// webhookreceiver accepts outbound webhook deliveries for local development: it verifies each
+// signature with the subscription's secret and prints the event. Subscribe it with
+// {"url": "http://localhost:8091/"} and pass the returned secret with -secret.
+func main() {
+	addr := flag.String("addr", ":8091", "address to listen on")
+	secret := flag.String("secret", os.Getenv("WEBHOOK_SECRET"), "subscription secret used to verify signatures (default $WEBHOOK_SECRET)")
+	fail := flag.Int("fail", 0, "respond 503 to the first n deliveries, to exercise retries")
+	flag.Parse()
+
+	var received atomic.Int64
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		signature := r.Header.Get(utils.WebhookSignatureHeader)
+		if *secret != "" {
+			if err := utils.VerifyWebhookSignature(*secret, signature, body, 5*time.Minute); err != nil {
+				log.Printf("Rejected delivery %s: %v", r.Header.Get("X-Loyalty-Delivery"), err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if n := received.Add(1); n <= int64(*fail) {
+			log.Printf("Failing delivery %s of %s on purpose (%d of %d)", r.Header.Get("X-Loyalty-Delivery"), r.Header.Get("X-Loyalty-Event"), n, *fail)
+			http.Error(w, "failing on purpose", http.StatusServiceUnavailable)
+			return
+		}
+
+		var indented bytes.Buffer
+		if json.Indent(&indented, body, "", "  ") != nil {
+			indented.Write(body)
+		}
+		log.Printf("%s %s (delivery %s, signature verified: %t)\n%s", r.Header.Get("X-Loyalty-Event"), r.Header.Get("X-Loyalty-Event-ID"),
+			r.Header.Get("X-Loyalty-Delivery"), *secret != "", indented.String())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Printf("Webhook receiver listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, nil); err != nil {
+		log.Fatal("Webhook receiver failed:", err)
+	}
+}
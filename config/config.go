@@ -1,38 +1,263 @@
 package config
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"os"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+const (
+	EnvironmentDevelopment = "development"
+	EnvironmentProduction  = "production"
+)
+
+// Config is the application configuration. It is grouped in sections, which are also the
+// sections of the configuration file; the fields of the sections are promoted, so code reads
+// them as cfg.Port, cfg.JWTSecret and so on.
+//
+// Every field can be set in the file (yaml tag) and overridden by an environment variable
+// (env tag). Fields tagged secret are redacted when the configuration is printed.
 type Config struct {
-	Port                string
-	JWTSecret           string
-	SquareAccessToken   string
-	SquareApplicationID string
-	SquareLocationID    string
-	SquareEnvironment   string
+	Environment string `yaml:"environment" env:"APP_ENV"` // "development" or "production"
+
+	ServerConfig    `yaml:"server"`
+	AuthConfig      `yaml:"auth"`
+	StorageConfig   `yaml:"storage"`
+	SquareConfig    `yaml:"square"`
+	RulesConfig     `yaml:"rules"`
+	SchedulerConfig `yaml:"scheduler"`
 }
 
-func LoadConfig() (*Config, error) {
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Port                         string   `yaml:"port" env:"PORT"`
+	ServerReadTimeoutSeconds     int      `yaml:"read_timeout_seconds" env:"SERVER_READ_TIMEOUT_SECONDS"`
+	ServerWriteTimeoutSeconds    int      `yaml:"write_timeout_seconds" env:"SERVER_WRITE_TIMEOUT_SECONDS"`
+	ServerIdleTimeoutSeconds     int      `yaml:"idle_timeout_seconds" env:"SERVER_IDLE_TIMEOUT_SECONDS"`
+	ServerMaxHeaderBytes         int      `yaml:"max_header_bytes" env:"SERVER_MAX_HEADER_BYTES"`
+	ServerShutdownTimeoutSeconds int      `yaml:"shutdown_timeout_seconds" env:"SERVER_SHUTDOWN_TIMEOUT_SECONDS"` // how long in-flight requests get to finish at shutdown
+	TLSCertFile                  string   `yaml:"tls_cert_file" env:"TLS_CERT_FILE"`                              // HTTPS is served when both the certificate and key are set
+	TLSKeyFile                   string   `yaml:"tls_key_file" env:"TLS_KEY_FILE"`
+	CORSAllowedOrigins           []string `yaml:"cors_allowed_origins" env:"CORS_ALLOWED_ORIGINS"` // browser origins allowed to call the API; "*" allows any
+}
+
+// AuthConfig configures member authentication
+type AuthConfig struct {
+	JWTSecret string `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+
+	// Users signing up or logging in with these emails are granted the admin role
+	AdminEmails []string `yaml:"admin_emails" env:"ADMIN_EMAILS"`
+
+	// Password policy
+	PasswordMinLength     int  `yaml:"password_min_length" env:"PASSWORD_MIN_LENGTH"`
+	PasswordRequireUpper  bool `yaml:"password_require_upper" env:"PASSWORD_REQUIRE_UPPER"`
+	PasswordRequireLower  bool `yaml:"password_require_lower" env:"PASSWORD_REQUIRE_LOWER"`
+	PasswordRequireDigit  bool `yaml:"password_require_digit" env:"PASSWORD_REQUIRE_DIGIT"`
+	PasswordRequireSymbol bool `yaml:"password_require_symbol" env:"PASSWORD_REQUIRE_SYMBOL"`
+	PasswordHistorySize   int  `yaml:"password_history_size" env:"PASSWORD_HISTORY_SIZE"`
+
+	// Mail server for email verification tokens; without one, tokens are only logged, which is
+	// allowed in development only
+	SMTPHost     string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+	EmailFrom    string `yaml:"email_from" env:"EMAIL_FROM"` // sender address of member emails
+}
+
+// StorageConfig configures what is stored and where
+type StorageConfig struct {
+	TokenEncryptionKey      string `yaml:"token_encryption_key" env:"TOKEN_ENCRYPTION_KEY" secret:"true"` // encrypts stored OAuth tokens
+	ReconciliationReportDir string `yaml:"reconciliation_report_dir" env:"RECONCILIATION_REPORT_DIR"`     // if set, reports are also written here as JSON files
+	DemoData                bool   `yaml:"demo_data" env:"DEMO_DATA"`                                     // create the demo member at startup; development only
+	AuditLogFile            string `yaml:"audit_log_file" env:"AUDIT_LOG_FILE"`                           // if set, the audit log is also appended here and restored from it at startup
+}
+
+// SquareConfig configures the Square integration
+type SquareConfig struct {
+	SquareAccessToken   string   `yaml:"access_token" env:"SQUARE_ACCESS_TOKEN" secret:"true"`
+	SquareApplicationID string   `yaml:"application_id" env:"SQUARE_APPLICATION_ID"`
+	SquareLocationID    string   `yaml:"location_id" env:"SQUARE_LOCATION_ID"`   // default location of the default merchant
+	SquareLocationIDs   []string `yaml:"location_ids" env:"SQUARE_LOCATION_IDS"` // all locations of the default merchant, in addition to those synced from Square
+	SquareEnvironment   string   `yaml:"environment" env:"SQUARE_ENVIRONMENT"`   // "sandbox" or "production"
+	SquareBaseURL       string   `yaml:"base_url" env:"SQUARE_BASE_URL"`         // overrides the environment's API URL, e.g. for a local fake
+
+	// Square API client behaviour
+	SquareTimeoutSeconds          int `yaml:"timeout_seconds" env:"SQUARE_TIMEOUT_SECONDS"` // per attempt
+	SquareMaxRetries              int `yaml:"max_retries" env:"SQUARE_MAX_RETRIES"`         // retries of transient failures (network errors, timeouts, 429, 5xx)
+	SquareMaxIdleConns            int `yaml:"max_idle_conns" env:"SQUARE_MAX_IDLE_CONNS"`
+	SquareBreakerFailureThreshold int `yaml:"breaker_failure_threshold" env:"SQUARE_BREAKER_FAILURE_THRESHOLD"` // consecutive failures that open the circuit breaker
+	SquareBreakerCooldownSeconds  int `yaml:"breaker_cooldown_seconds" env:"SQUARE_BREAKER_COOLDOWN_SECONDS"`   // how long the breaker stays open before a trial call
+
+	// Square OAuth, for connecting additional merchants
+	SquareApplicationSecret string   `yaml:"application_secret" env:"SQUARE_APPLICATION_SECRET" secret:"true"`
+	SquareOAuthRedirectURL  string   `yaml:"oauth_redirect_url" env:"SQUARE_OAUTH_REDIRECT_URL"` // this server's /oauth/square/callback URL, as registered with Square
+	SquareOAuthScopes       []string `yaml:"oauth_scopes" env:"SQUARE_OAUTH_SCOPES"`             // permissions requested from merchants
+
+	// Square webhooks
+	SquareWebhookSignatureKey    string `yaml:"webhook_signature_key" env:"SQUARE_WEBHOOK_SIGNATURE_KEY" secret:"true"`
+	SquareWebhookNotificationURL string `yaml:"webhook_notification_url" env:"SQUARE_WEBHOOK_NOTIFICATION_URL"` // URL registered with the subscription, part of the signed payload
+
+	// Square loyalty account provisioning
+	SquareProvisioningMode        string `yaml:"provisioning_mode" env:"SQUARE_PROVISIONING_MODE"` // "eager" or "lazy"
+	SquareProvisioningMaxAttempts int    `yaml:"provisioning_max_attempts" env:"SQUARE_PROVISIONING_MAX_ATTEMPTS"`
+}
+
+// RulesConfig holds the loyalty program's business rules
+type RulesConfig struct {
+	// Account closure
+	AccountClosureBalancePolicy string `yaml:"account_closure_balance_policy" env:"ACCOUNT_CLOSURE_BALANCE_POLICY"` // "forfeit" or "payout"
+	PointValueCents             int    `yaml:"point_value_cents" env:"POINT_VALUE_CENTS"`                           // cash value of one point when paying out
+
+	// Phone numbers without a country code are assumed to be in this country
+	DefaultPhoneCountryCode string `yaml:"default_phone_country_code" env:"DEFAULT_PHONE_COUNTRY_CODE"`
+
+	// Whether reconciliation only reports differences with Square or also corrects them
+	ReconciliationMode string `yaml:"reconciliation_mode" env:"RECONCILIATION_MODE"` // "dry_run" or "auto_correct"
+
+	// Manual adjustments of more points than this, credit or debit, need a second admin's approval
+	AdjustmentApprovalThreshold int `yaml:"adjustment_approval_threshold" env:"ADJUSTMENT_APPROVAL_THRESHOLD"`
+}
+
+// SchedulerConfig configures the background workers
+type SchedulerConfig struct {
+	// Outbox delivery of Square writes
+	OutboxPollIntervalSeconds int `yaml:"outbox_poll_interval_seconds" env:"OUTBOX_POLL_INTERVAL_SECONDS"`
+	OutboxMaxAttempts         int `yaml:"outbox_max_attempts" env:"OUTBOX_MAX_ATTEMPTS"`
+
+	// Delivery of outbound webhooks to subscribers
+	WebhookPollIntervalSeconds int `yaml:"webhook_poll_interval_seconds" env:"WEBHOOK_POLL_INTERVAL_SECONDS"`
+	WebhookMaxAttempts         int `yaml:"webhook_max_attempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookTimeoutSeconds      int `yaml:"webhook_timeout_seconds" env:"WEBHOOK_TIMEOUT_SECONDS"` // per attempt
+
+	// How often the cached Square loyalty program definition is refreshed
+	LoyaltyProgramRefreshMinutes int `yaml:"loyalty_program_refresh_minutes" env:"LOYALTY_PROGRAM_REFRESH_MINUTES"`
+
+	// How often merchants' locations are synced from Square
+	LocationSyncIntervalMinutes int `yaml:"location_sync_interval_minutes" env:"LOCATION_SYNC_INTERVAL_MINUTES"`
+
+	// How often local balances are reconciled with Square; 0 disables the periodic run
+	ReconciliationIntervalMinutes int `yaml:"reconciliation_interval_minutes" env:"RECONCILIATION_INTERVAL_MINUTES"`
+
+	// How often merchants' OAuth tokens close to expiry are refreshed
+	SquareTokenRefreshIntervalMinutes int `yaml:"square_token_refresh_interval_minutes" env:"SQUARE_TOKEN_REFRESH_INTERVAL_MINUTES"`
+}
+
+// Defaults returns the configuration used for settings that are neither in the file nor in the
+// environment
+func Defaults() *Config {
+	return &Config{
+		Environment: EnvironmentDevelopment,
+		ServerConfig: ServerConfig{
+			Port:                         "8080",
+			ServerReadTimeoutSeconds:     15,
+			ServerWriteTimeoutSeconds:    60,
+			ServerIdleTimeoutSeconds:     120,
+			ServerMaxHeaderBytes:         1 << 20,
+			ServerShutdownTimeoutSeconds: 30,
+		},
+		AuthConfig: AuthConfig{
+			PasswordMinLength:    8,
+			PasswordRequireUpper: true,
+			PasswordRequireLower: true,
+			PasswordRequireDigit: true,
+			PasswordHistorySize:  5,
+			SMTPPort:             587,
+		},
+		SquareConfig: SquareConfig{
+			SquareEnvironment:             "sandbox",
+			SquareTimeoutSeconds:          10,
+			SquareMaxRetries:              2,
+			SquareMaxIdleConns:            20,
+			SquareBreakerFailureThreshold: 5,
+			SquareBreakerCooldownSeconds:  30,
+			SquareOAuthScopes:             []string{"LOYALTY_READ", "LOYALTY_WRITE", "CUSTOMERS_READ", "CUSTOMERS_WRITE", "ORDERS_READ", "MERCHANT_PROFILE_READ"},
+			SquareProvisioningMode:        "eager",
+			SquareProvisioningMaxAttempts: 5,
+		},
+		RulesConfig: RulesConfig{
+			AccountClosureBalancePolicy: "forfeit",
+			PointValueCents:             1,
+			DefaultPhoneCountryCode:     "1",
+			ReconciliationMode:          "dry_run",
+			AdjustmentApprovalThreshold: 1000,
+		},
+		SchedulerConfig: SchedulerConfig{
+			OutboxPollIntervalSeconds:         5,
+			OutboxMaxAttempts:                 8,
+			WebhookPollIntervalSeconds:        5,
+			WebhookMaxAttempts:                8,
+			WebhookTimeoutSeconds:             10,
+			LoyaltyProgramRefreshMinutes:      15,
+			LocationSyncIntervalMinutes:       60,
+			ReconciliationIntervalMinutes:     60,
+			SquareTokenRefreshIntervalMinutes: 60,
+		},
+	}
+}
+
+// Read layers the defaults, the YAML file at path (skipped when path is empty) and the
+// environment, including a .env file. It does not validate the result.
+func Read(path string) (*Config, error) {
 	godotenv.Load()
 
-	config := &Config{
-		Port:                getEnv("PORT", "8080"),
-		JWTSecret:           getEnv("JWT_SECRET", "your-secret-key"),
-		SquareAccessToken:   getEnv("SQUARE_ACCESS_TOKEN", "your-square-access-token"),
-		SquareApplicationID: getEnv("SQUARE_APPLICATION_ID", "your-square-application-id"),
-		SquareLocationID:    getEnv("SQUARE_LOCATION_ID", "your-square-location-id"),
-		SquareEnvironment:   getEnv("SQUARE_ENVIRONMENT", "sandbox"),
+	config := Defaults()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
 	}
 
+	if err := applyEnv(config); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// Load reads the configuration like Read and validates it. Outside production, a missing JWT
+// secret is replaced by a random one, so tokens do not survive a restart.
+func Load(path string) (*Config, error) {
+	config, err := Read(path)
+	if err != nil {
+		return nil, err
 	}
-	return defaultValue
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if config.JWTSecret == "" {
+		b := make([]byte, 32)
+		rand.Read(b)
+		config.JWTSecret = hex.EncodeToString(b)
+		log.Println("JWT_SECRET is not set; using a random secret until restart (not allowed in production)")
+	}
+
+	return config, nil
+}
+
+// LoadConfig loads the configuration from the file named by CONFIG_FILE, if set, and the environment
+func LoadConfig() (*Config, error) {
+	godotenv.Load()
+	return Load(os.Getenv("CONFIG_FILE"))
+}
+
+// IsProduction reports whether the application runs in production
+func (c *Config) IsProduction() bool {
+	return c.Environment == EnvironmentProduction
 }
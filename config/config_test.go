@@ -0,0 +1,152 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// productionConfig is a production configuration that passes validation
+func productionConfig() *Config {
+	cfg := Defaults()
+	cfg.Environment = EnvironmentProduction
+	cfg.JWTSecret = strings.Repeat("k", minProductionJWTSecretLength)
+	cfg.SMTPHost = "smtp.internal"
+	cfg.EmailFrom = "loyalty@brand.test"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	if err := Defaults().Validate(); err != nil {
+		t.Fatalf("defaults are invalid: %v", err)
+	}
+	if err := productionConfig().Validate(); err != nil {
+		t.Fatalf("production configuration is invalid: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"unknown environment", func(c *Config) { c.Environment = "staging" }, "environment must be one of"},
+		{"port out of range", func(c *Config) { c.Port = "70000" }, "server.port"},
+		{"certificate without key", func(c *Config) { c.TLSCertFile = "server.crt" }, "server.tls_cert_file and server.tls_key_file"},
+		{"SMTP without sender", func(c *Config) { c.SMTPHost = "smtp.internal" }, "auth.email_from (EMAIL_FROM) is required"},
+		{"unknown reconciliation mode", func(c *Config) { c.ReconciliationMode = "fix" }, "rules.reconciliation_mode"},
+		{"no outbox attempts", func(c *Config) { c.OutboxMaxAttempts = 0 }, "scheduler.outbox_max_attempts"},
+	} {
+		cfg := Defaults()
+		tc.change(cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Validate = %v, want a problem with %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestValidateProductionSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"missing JWT secret", func(c *Config) { c.JWTSecret = "" }, "auth.jwt_secret (JWT_SECRET) is required"},
+		{"short JWT secret", func(c *Config) { c.JWTSecret = "short-but-random" }, "must be at least 32 characters"},
+		{"placeholder JWT secret", func(c *Config) { c.JWTSecret = "your-secret-key-that-is-long-enough-to-pass" }, "auth.jwt_secret (JWT_SECRET) is a placeholder"},
+		{"placeholder Square token", func(c *Config) { c.SquareAccessToken = "your-square-access-token" }, "square.access_token (SQUARE_ACCESS_TOKEN) is a placeholder"},
+		{"no mail server", func(c *Config) { c.SMTPHost = "" }, "auth.smtp_host (SMTP_HOST) is required in production"},
+		{"demo data", func(c *Config) { c.DemoData = true }, "storage.demo_data (DEMO_DATA) is not allowed"},
+		{"OAuth tokens unencrypted", func(c *Config) { c.SquareApplicationSecret = "sq0csp-real" }, "storage.token_encryption_key"},
+	} {
+		cfg := productionConfig()
+		tc.change(cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Validate = %v, want a problem with %q", tc.name, err, tc.want)
+		}
+
+		// Development accepts what production refuses
+		cfg.Environment = EnvironmentDevelopment
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("%s: Validate in development = %v", tc.name, err)
+		}
+	}
+}
+
+func TestReadLayersFileAndEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "server:\n  port: \"9090\"\n  read_timeout_seconds: 7\nsquare:\n  location_ids: [store-1, store-2]\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	t.Setenv("PORT", "9191")
+	t.Setenv("SQUARE_LOCATION_IDS", "")
+	t.Setenv("SERVER_READ_TIMEOUT_SECONDS", "")
+	t.Setenv("OUTBOX_MAX_ATTEMPTS", "9")
+
+	cfg, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if cfg.Port != "9191" {
+		t.Errorf("port = %q, want the environment's 9191 over the file's", cfg.Port)
+	}
+	if cfg.ServerReadTimeoutSeconds != 7 || len(cfg.SquareLocationIDs) != 2 {
+		t.Errorf("read timeout %d and locations %v, want the file's 7 and two stores", cfg.ServerReadTimeoutSeconds, cfg.SquareLocationIDs)
+	}
+	if cfg.OutboxMaxAttempts != 9 || cfg.ServerWriteTimeoutSeconds != Defaults().ServerWriteTimeoutSeconds {
+		t.Errorf("outbox attempts %d and write timeout %d, want the environment's 9 and the default", cfg.OutboxMaxAttempts, cfg.ServerWriteTimeoutSeconds)
+	}
+
+	t.Setenv("OUTBOX_MAX_ATTEMPTS", "many")
+	if _, err := Read(path); err == nil || !strings.Contains(err.Error(), "OUTBOX_MAX_ATTEMPTS must be an integer") {
+		t.Errorf("Read with a malformed variable = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("server:\n  prot: \"9090\"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := Read(path); err == nil {
+		t.Error("Read accepted a misspelt key")
+	}
+}
+
+func TestLoadRefusesInvalidConfiguration(t *testing.T) {
+	t.Setenv("APP_ENV", EnvironmentProduction)
+	t.Setenv("JWT_SECRET", "your-secret-key")
+	if _, err := Load(""); err == nil {
+		t.Fatal("Load accepted a placeholder JWT secret in production")
+	}
+
+	t.Setenv("APP_ENV", EnvironmentDevelopment)
+	t.Setenv("JWT_SECRET", "")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.JWTSecret) < minProductionJWTSecretLength {
+		t.Errorf("JWT secret = %q, want a random secret in development", cfg.JWTSecret)
+	}
+}
+
+func TestYAMLRedactsSecrets(t *testing.T) {
+	cfg := productionConfig()
+	cfg.SMTPPassword = "smtp-pass-1234"
+	cfg.SquareAccessToken = "EAAA-live-token"
+
+	out, err := cfg.YAML()
+	if err != nil {
+		t.Fatalf("YAML: %v", err)
+	}
+	for _, secret := range []string{cfg.JWTSecret, cfg.SMTPPassword, cfg.SquareAccessToken} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("printed configuration holds the secret %q", secret)
+		}
+	}
+	if !strings.Contains(string(out), redactedValue) || !strings.Contains(string(out), "smtp.internal") {
+		t.Errorf("printed configuration:\n%s", out)
+	}
+	if cfg.SMTPPassword != "smtp-pass-1234" {
+		t.Error("redacting changed the configuration itself")
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// applyEnv overrides the fields of config with the environment variables named by their env tags.
+// Unset or empty variables leave the field alone; lists are comma-separated.
+func applyEnv(config *Config) error {
+	var problems []error
+	forEachField(reflect.ValueOf(config).Elem(), "", func(_ string, field reflect.StructField, value reflect.Value) {
+		key := field.Tag.Get("env")
+		raw := strings.TrimSpace(os.Getenv(key))
+		if key == "" || raw == "" {
+			return
+		}
+
+		switch value.Kind() {
+		case reflect.String:
+			value.SetString(raw)
+		case reflect.Int:
+			parsed, err := strconv.Atoi(raw)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s must be an integer, got %q", key, raw))
+				return
+			}
+			value.SetInt(int64(parsed))
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(raw)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s must be true or false, got %q", key, raw))
+				return
+			}
+			value.SetBool(parsed)
+		case reflect.Slice:
+			value.Set(reflect.ValueOf(splitList(raw)))
+		}
+	})
+	return errors.Join(problems...)
+}
+
+// forEachField calls fn for every field of a struct, descending into the embedded sections. The
+// path is the field's key in the configuration file, such as "server.port".
+func forEachField(value reflect.Value, prefix string, fn func(path string, field reflect.StructField, value reflect.Value)) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		path := prefix + field.Tag.Get("yaml")
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			forEachField(value.Field(i), path+".", fn)
+			continue
+		}
+		fn(path, field, value.Field(i))
+	}
+}
+
+func splitList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secrets in printed configurations
+const redactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with its secrets replaced
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	forEachField(reflect.ValueOf(&redacted).Elem(), "", func(_ string, field reflect.StructField, value reflect.Value) {
+		if field.Tag.Get("secret") == "true" && value.String() != "" {
+			value.SetString(redactedValue)
+		}
+	})
+	return &redacted
+}
+
+// YAML renders the configuration, secrets redacted, in the format of the configuration file
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c.Redacted())
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// minProductionJWTSecretLength is the shortest JWT signing key accepted in production
+const minProductionJWTSecretLength = 32
+
+// Validate checks the configuration for values the application cannot run with. In production
+// it also rejects missing, placeholder or short secrets.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	oneOf := func(name, value string, allowed ...string) {
+		check(slices.Contains(allowed, value), "%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value)
+	}
+
+	oneOf("environment", c.Environment, EnvironmentDevelopment, EnvironmentProduction)
+
+	// Server
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port <= 65535, "server.port must be a port number, got %q", c.Port)
+	check(c.ServerReadTimeoutSeconds > 0, "server.read_timeout_seconds must be positive")
+	check(c.ServerWriteTimeoutSeconds > 0, "server.write_timeout_seconds must be positive")
+	check(c.ServerIdleTimeoutSeconds > 0, "server.idle_timeout_seconds must be positive")
+	check(c.ServerMaxHeaderBytes > 0, "server.max_header_bytes must be positive")
+	check(c.ServerShutdownTimeoutSeconds > 0, "server.shutdown_timeout_seconds must be positive")
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "server.tls_cert_file and server.tls_key_file must be set together")
+
+	// Auth
+	check(c.PasswordMinLength > 0, "auth.password_min_length must be positive")
+	check(c.PasswordHistorySize >= 0, "auth.password_history_size cannot be negative")
+	check(c.SMTPPort > 0 && c.SMTPPort <= 65535, "auth.smtp_port must be a port number, got %d", c.SMTPPort)
+	check(c.SMTPHost == "" || c.EmailFrom != "", "auth.email_from (EMAIL_FROM) is required when auth.smtp_host is set")
+
+	// Square
+	oneOf("square.environment", c.SquareEnvironment, "sandbox", "production")
+	check(c.SquareTimeoutSeconds > 0, "square.timeout_seconds must be positive")
+	check(c.SquareMaxRetries >= 0, "square.max_retries cannot be negative")
+	check(c.SquareMaxIdleConns > 0, "square.max_idle_conns must be positive")
+	check(c.SquareBreakerFailureThreshold > 0, "square.breaker_failure_threshold must be positive")
+	check(c.SquareBreakerCooldownSeconds > 0, "square.breaker_cooldown_seconds must be positive")
+	oneOf("square.provisioning_mode", c.SquareProvisioningMode, "eager", "lazy")
+	check(c.SquareProvisioningMaxAttempts > 0, "square.provisioning_max_attempts must be positive")
+
+	// Rules
+	oneOf("rules.account_closure_balance_policy", c.AccountClosureBalancePolicy, "forfeit", "payout")
+	check(c.PointValueCents >= 0, "rules.point_value_cents cannot be negative")
+	oneOf("rules.reconciliation_mode", c.ReconciliationMode, "dry_run", "auto_correct")
+	check(c.AdjustmentApprovalThreshold >= 0, "rules.adjustment_approval_threshold cannot be negative")
+
+	// Scheduler
+	check(c.OutboxPollIntervalSeconds > 0, "scheduler.outbox_poll_interval_seconds must be positive")
+	check(c.OutboxMaxAttempts > 0, "scheduler.outbox_max_attempts must be positive")
+	check(c.WebhookPollIntervalSeconds > 0, "scheduler.webhook_poll_interval_seconds must be positive")
+	check(c.WebhookMaxAttempts > 0, "scheduler.webhook_max_attempts must be positive")
+	check(c.WebhookTimeoutSeconds > 0, "scheduler.webhook_timeout_seconds must be positive")
+	check(c.LoyaltyProgramRefreshMinutes > 0, "scheduler.loyalty_program_refresh_minutes must be positive")
+	check(c.LocationSyncIntervalMinutes > 0, "scheduler.location_sync_interval_minutes must be positive")
+	check(c.ReconciliationIntervalMinutes >= 0, "scheduler.reconciliation_interval_minutes cannot be negative")
+	check(c.SquareTokenRefreshIntervalMinutes > 0, "scheduler.square_token_refresh_interval_minutes must be positive")
+
+	if c.IsProduction() {
+		check(c.JWTSecret != "", "auth.jwt_secret (JWT_SECRET) is required in production")
+		check(c.JWTSecret == "" || len(c.JWTSecret) >= minProductionJWTSecretLength,
+			"auth.jwt_secret (JWT_SECRET) must be at least %d characters in production", minProductionJWTSecretLength)
+		check(c.SMTPHost != "", "auth.smtp_host (SMTP_HOST) is required in production, so email verification tokens are sent rather than logged")
+		check(!c.DemoData, "storage.demo_data (DEMO_DATA) is not allowed in production")
+		check(c.SquareApplicationSecret == "" || c.TokenEncryptionKey != "",
+			"storage.token_encryption_key (TOKEN_ENCRYPTION_KEY) is required in production when Square OAuth is configured")
+
+		forEachField(reflect.ValueOf(c).Elem(), "", func(path string, field reflect.StructField, value reflect.Value) {
+			if field.Tag.Get("secret") == "true" {
+				check(!isPlaceholder(value.String()), "%s (%s) is a placeholder value; set a real secret in production", path, field.Tag.Get("env"))
+			}
+		})
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// isPlaceholder reports whether a secret still holds an example value, like the ones in the README
+func isPlaceholder(value string) bool {
+	lower := strings.ToLower(value)
+	if strings.HasPrefix(lower, "your-") || strings.HasPrefix(lower, "your_") {
+		return true
+	}
+	for _, marker := range []string{"changeme", "change-me", "change_me", "change-this", "example", "placeholder"} {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return lower == "secret" || lower == "password"
+}
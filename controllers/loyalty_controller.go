@@ -1,113 +0,0 @@
-package controllers
-
-import (
-	"net/http"
-	"strconv"
-
-	"loyalty-core/models"
-	"loyalty-core/services"
-
-	"github.com/gin-gonic/gin"
-)
-
-type LoyaltyController struct {
-	service *services.LoyaltyService
-}
-
-func NewLoyaltyController(service *services.LoyaltyService) *LoyaltyController {
-	return &LoyaltyController{
-		service: service,
-	}
-}
-
-func (ctrl *LoyaltyController) EarnPoints(c *gin.Context) {
-	userID := c.GetString("user_id")
-	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
-		return
-	}
-
-	var req models.EarnRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	transaction, err := ctrl.service.EarnPoints(userID, req.Points, req.Description)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, transaction)
-}
-
-func (ctrl *LoyaltyController) RedeemPoints(c *gin.Context) {
-	userID := c.GetString("user_id")
-	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
-		return
-	}
-
-	var req models.RedeemRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	transaction, err := ctrl.service.RedeemPoints(userID, req.Points, req.Description)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, transaction)
-}
-
-func (ctrl *LoyaltyController) GetBalance(c *gin.Context) {
-	userID := c.GetString("user_id")
-	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
-		return
-	}
-
-	balance, err := ctrl.service.GetBalance(userID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, balance)
-}
-
-func (ctrl *LoyaltyController) GetHistory(c *gin.Context) {
-	userID := c.GetString("user_id")
-	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
-		return
-	}
-
-	// Optional limit parameter
-	limitStr := c.DefaultQuery("limit", "10")
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil {
-		limit = 10
-	}
-
-	transactions, err := ctrl.service.GetTransactionHistory(userID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Apply limit
-	if len(transactions) > limit {
-		transactions = transactions[:limit]
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"transactions": transactions,
-		"count":        len(transactions),
-	})
-}
-
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLog redirects the standard logger to a buffer for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	output := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(output) })
+	return &buf
+}
+
+func TestLogging(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name:    "explicit status",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) },
+			want:    "GET /api/test 418 ",
+		},
+		{
+			name:    "implicit status",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+			want:    "GET /api/test 200 ",
+		},
+		{
+			name:    "no response",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			want:    "GET /api/test 200 ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLog(t)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+			req.Header.Set(RequestIDHeader, "req-1")
+			Chain(tt.handler, RequestID, Logging).ServeHTTP(httptest.NewRecorder(), req)
+
+			line := buf.String()
+			if !strings.Contains(line, tt.want) || !strings.Contains(line, "request_id=req-1") {
+				t.Errorf("log = %q, want %q and the request ID", line, tt.want)
+			}
+		})
+	}
+}
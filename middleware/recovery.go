@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recovery turns a panicking handler into a 500 response instead of a dropped connection, and
+// logs the panic with its stack trace
+func Recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				// Deliberate abort of the response; let net/http handle it
+				panic(recovered)
+			}
+
+			log.Printf("Panic serving %s %s (request_id=%s): %v\n%s", r.Method, r.URL.Path, GetRequestID(r), recovered, debug.Stack())
+			writeError(w, http.StatusInternalServerError, "internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "client ID", header: "client-123", keep: true},
+		{name: "missing", header: ""},
+		{name: "too long", header: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "control characters", header: "id\x01with\x7fcontrol"},
+		{name: "space", header: "two words"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = GetRequestID(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			echoed := rec.Header().Get(RequestIDHeader)
+			if echoed == "" || echoed != seen {
+				t.Fatalf("response ID %q, context ID %q; want the same non-empty ID", echoed, seen)
+			}
+			if tt.keep && seen != tt.header {
+				t.Errorf("ID = %q, want the client's %q", seen, tt.header)
+			}
+			if !tt.keep && (seen == tt.header || len(seen) != 32) {
+				t.Errorf("ID = %q, want a generated one", seen)
+			}
+		})
+	}
+}
+
+func TestGetRequestIDWithoutMiddleware(t *testing.T) {
+	if id := GetRequestID(httptest.NewRequest(http.MethodGet, "/", nil)); id != "" {
+		t.Errorf("GetRequestID = %q, want empty", id)
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"loyalty-core/models"
+	"loyalty-core/services"
+)
+
+// MerchantHeader names the merchant an admin request acts on. The deployment's admins belong to
+// the default merchant and use it to operate the others.
+const MerchantHeader = "X-Merchant-ID"
+
+type merchantIDContextKey struct{}
+
+type merchantSelectionContextKey struct{}
+
+// merchantSelection is the merchant named by MerchantHeader, applied by RequireAdmin
+type merchantSelection struct {
+	merchantID string
+	exists     bool
+}
+
+// Tenant resolves the merchant (tenant) serving each request from its host and stores it in the
+// request context. Requests with a token issued to a member of another merchant are rejected, so
+// one brand's host never serves another brand's members or ledger. A merchant named by
+// MerchantHeader is only looked up here; RequireAdmin applies it to admins.
+func Tenant(authService *services.AuthService, merchantService *services.MerchantService) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			merchantID := merchantService.MerchantIDForHost(r.Host)
+			ctx := context.WithValue(r.Context(), merchantIDContextKey{}, merchantID)
+			if selected := r.Header.Get(MerchantHeader); selected != "" {
+				_, err := merchantService.GetMerchant(selected)
+				ctx = context.WithValue(ctx, merchantSelectionContextKey{}, merchantSelection{merchantID: selected, exists: err == nil})
+			}
+
+			// Invalid tokens are left to RequireAuth, which rejects them as unauthorized
+			authHeader := r.Header.Get("Authorization")
+			if tokenString := strings.TrimPrefix(authHeader, "Bearer "); tokenString != authHeader {
+				if claims, err := authService.ValidateToken(tokenString); err == nil && claims.MerchantID != merchantID {
+					writeError(w, http.StatusForbidden, "token belongs to another merchant")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// MerchantID returns the merchant resolved by Tenant, or the default merchant for requests that
+// did not pass through it
+func MerchantID(r *http.Request) string {
+	if merchantID, ok := r.Context().Value(merchantIDContextKey{}).(string); ok {
+		return merchantID
+	}
+	return models.DefaultMerchantID
+}
+
+// selectMerchant applies the merchant named by MerchantHeader to an admin's request. It reports
+// false after rejecting a merchant that does not exist.
+func selectMerchant(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	selection, ok := r.Context().Value(merchantSelectionContextKey{}).(merchantSelection)
+	if !ok {
+		return r, true
+	}
+	if !selection.exists {
+		writeError(w, http.StatusNotFound, "merchant not found")
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), merchantIDContextKey{}, selection.merchantID)), true
+}
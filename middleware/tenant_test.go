@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/services"
+)
+
+func TestTenantResolvesMerchantFromHost(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.JWTSecret = "test-secret"
+	merchantService := services.NewMerchantService(cfg)
+	merchant, err := merchantService.CreateMerchant(models.CreateMerchantRequest{Name: "Tenant Test", Hosts: []string{"tenant-test.example.com"}})
+	if err != nil {
+		t.Fatalf("CreateMerchant: %v", err)
+	}
+
+	var seen string
+	handler := Tenant(services.NewAuthService(cfg), merchantService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = MerchantID(r)
+	}))
+
+	for host, want := range map[string]string{
+		"tenant-test.example.com":      merchant.ID,
+		"Tenant-Test.example.com:8443": merchant.ID,
+		"unknown.example.com":          models.DefaultMerchantID,
+		"localhost:8080":               models.DefaultMerchantID,
+	} {
+		seen = ""
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://"+host+"/api/loyalty/program", nil))
+		if seen != want {
+			t.Errorf("merchant for %s = %q, want %q", host, seen, want)
+		}
+	}
+
+	// An invalid token is left to RequireAuth
+	req := httptest.NewRequest(http.MethodGet, "http://tenant-test.example.com/", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	rec := httptest.NewRecorder()
+	seen = ""
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || seen != merchant.ID {
+		t.Errorf("invalid token: status %d, merchant %q; want it passed on to the handler", rec.Code, seen)
+	}
+}
+
+func TestMerchantIDWithoutTenant(t *testing.T) {
+	if merchantID := MerchantID(httptest.NewRequest(http.MethodGet, "/", nil)); merchantID != models.DefaultMerchantID {
+		t.Errorf("MerchantID = %q, want the default merchant", merchantID)
+	}
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	AccountStatusActive = "active"
+	AccountStatusClosed = "closed"
+	AccountStatusLocked = "locked" // suspended by an admin; the member cannot sign in or use points
+
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+)
+
+const (
+	ProvisioningStatusPending     = "pending"
+	ProvisioningStatusProvisioned = "provisioned"
+	ProvisioningStatusFailed      = "failed"
+	ProvisioningStatusDeactivated = "deactivated"
+	ProvisioningStatusNotRequired = "not_required" // Square integration disabled
+)
+
+// SquareProvisioning tracks creation of the member's Square loyalty account
+type SquareProvisioning struct {
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastAttemptAt *time.Time `json:"lastAttemptAt,omitempty"`
+	ProvisionedAt *time.Time `json:"provisionedAt,omitempty"`
+}
+
+// LoyaltyAccountResponse reports the member's loyalty number and Square account status
+type LoyaltyAccountResponse struct {
+	LoyaltyID       string             `json:"loyaltyId"`
+	SquareAccountID string             `json:"squareAccountId,omitempty"`
+	Provisioning    SquareProvisioning `json:"provisioning"`
+}
+
+// Session records a login and the JWT issued for it
+type Session struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"userId"`
+	IssuedAt  time.Time  `json:"issuedAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// AccountExport is the data-subject archive of everything held about a member. Rewards and
+// vouchers are held by Square and read from it when the member has a Square loyalty account.
+type AccountExport struct {
+	ExportedAt     time.Time       `json:"exportedAt"`
+	Profile        User            `json:"profile"`
+	ProfileHistory []ProfileChange `json:"profileHistory"`
+	Transactions   []Transaction   `json:"transactions"`
+	Sessions       []Session       `json:"sessions"`
+	Rewards        []MemberReward  `json:"rewards"`  // rewards already redeemed or deleted
+	Vouchers       []MemberReward  `json:"vouchers"` // issued rewards the member can still apply to an order
+}
+
+// Square loyalty reward statuses
+const (
+	RewardStatusIssued   = "ISSUED"
+	RewardStatusRedeemed = "REDEEMED"
+	RewardStatusDeleted  = "DELETED"
+)
+
+// MemberReward is a loyalty reward a member's points were spent on
+type MemberReward struct {
+	ID           string     `json:"id"`
+	RewardTierID string     `json:"rewardTierId"`
+	Status       string     `json:"status"` // one of the RewardStatus constants
+	Points       int        `json:"points"`
+	OrderID      string     `json:"orderId,omitempty"`
+	CreatedAt    *time.Time `json:"createdAt,omitempty"`
+	RedeemedAt   *time.Time `json:"redeemedAt,omitempty"`
+}
+
+// LockAccountRequest suspends a member's account
+type LockAccountRequest struct {
+	Reason string `json:"reason"`
+}
+
+type CloseAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type CloseAccountResponse struct {
+	Message       string `json:"message"`
+	BalancePolicy string `json:"balancePolicy"` // "forfeit" or "payout"
+	Points        int    `json:"points"`        // balance at closure
+	PayoutCents   int    `json:"payoutCents"`   // cash value paid out, 0 when forfeited
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+)
+
+// Adjustment reason codes, required on every manual adjustment
+const (
+	AdjustmentReasonGoodwill        = "goodwill"         // courtesy credit
+	AdjustmentReasonServiceRecovery = "service_recovery" // compensation for a service failure
+	AdjustmentReasonMissingPoints   = "missing_points"   // purchase that did not earn its points
+	AdjustmentReasonCorrection      = "correction"       // fixes a wrong balance or transaction
+	AdjustmentReasonFraud           = "fraud"            // removes points gained by abuse
+	AdjustmentReasonOther           = "other"            // anything else; the note must explain
+)
+
+// AdjustmentReasonCodes lists the valid reason codes
+var AdjustmentReasonCodes = []string{
+	AdjustmentReasonGoodwill,
+	AdjustmentReasonServiceRecovery,
+	AdjustmentReasonMissingPoints,
+	AdjustmentReasonCorrection,
+	AdjustmentReasonFraud,
+	AdjustmentReasonOther,
+}
+
+// Adjustment statuses
+const (
+	AdjustmentStatusPending  = "pending_approval" // above the approval threshold, waiting for a second admin
+	AdjustmentStatusApplied  = "applied"          // posted to the ledger
+	AdjustmentStatusRejected = "rejected"
+)
+
+// Adjustment is an admin's manual change to a member's balance. Adjustments above the approval
+// threshold are applied only once a second admin approves them.
+type Adjustment struct {
+	ID            string     `json:"id"`
+	MerchantID    string     `json:"merchantId"`
+	UserID        string     `json:"userId"`
+	Points        int        `json:"points"` // positive credits, negative debits
+	ReasonCode    string     `json:"reasonCode"`
+	Note          string     `json:"note"`
+	ReversesID    string     `json:"reversesId,omitempty"` // the transaction this adjustment reverses
+	Status        string     `json:"status"`
+	RequestedBy   string     `json:"requestedBy"` // admin user ID
+	RequestedAt   time.Time  `json:"requestedAt"`
+	ReviewedBy    string     `json:"reviewedBy,omitempty"` // admin who approved or rejected it
+	ReviewedAt    *time.Time `json:"reviewedAt,omitempty"`
+	ReviewNote    string     `json:"reviewNote,omitempty"`
+	TransactionID string     `json:"transactionId,omitempty"` // ledger entry, once applied
+}
+
+// AdjustPointsRequest is an admin's manual credit (positive points) or debit (negative points)
+type AdjustPointsRequest struct {
+	Points     int    `json:"points"`
+	ReasonCode string `json:"reasonCode"`
+	Note       string `json:"note"`
+}
+
+// ReverseTransactionRequest undoes a ledger transaction with an opposite adjustment
+type ReverseTransactionRequest struct {
+	ReasonCode string `json:"reasonCode"`
+	Note       string `json:"note"`
+}
+
+// ReviewAdjustmentRequest approves or rejects a pending adjustment
+type ReviewAdjustmentRequest struct {
+	Note string `json:"note"`
+}
+
+// MemberHistory is everything support agents see about a member's account
+type MemberHistory struct {
+	Member         User            `json:"member"`
+	Transactions   []Transaction   `json:"transactions"` // newest first
+	Adjustments    []Adjustment    `json:"adjustments"`  // newest first, including pending and rejected ones
+	ProfileChanges []ProfileChange `json:"profileChanges"`
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Audit log actions
+const (
+	AuditActionSignup         = "member.signup"
+	AuditActionLogin          = "member.login"
+	AuditActionLoginFailed    = "member.login_failed"
+	AuditActionPasswordChange = "member.password_change"
+	AuditActionProfileUpdate  = "member.profile_update"
+	AuditActionEmailVerify    = "member.email_verify"
+	AuditActionAccountClose   = "member.account_close"
+	AuditActionProvision      = "member.provision"
+
+	AuditActionEarn   = "points.earn"
+	AuditActionRedeem = "points.redeem"
+
+	AuditActionAdjustmentRequest = "adjustment.request"
+	AuditActionAdjustmentApprove = "adjustment.approve"
+	AuditActionAdjustmentReject  = "adjustment.reject"
+
+	AuditActionMemberLock           = "admin.member_lock"
+	AuditActionMemberUnlock         = "admin.member_unlock"
+	AuditActionOutboxReplay         = "admin.outbox_replay"
+	AuditActionReconciliationRun    = "admin.reconciliation_run"
+	AuditActionMerchantCreate       = "admin.merchant_create"
+	AuditActionMerchantConnect      = "admin.merchant_connect"
+	AuditActionMerchantTokenRefresh = "admin.merchant_token_refresh"
+	AuditActionMerchantSettings     = "admin.merchant_settings"
+	AuditActionLocationSync         = "admin.location_sync"
+	AuditActionLocationRules        = "admin.location_rules"
+	AuditActionWebhookCreate        = "admin.webhook_create"
+	AuditActionWebhookUpdate        = "admin.webhook_update"
+	AuditActionWebhookDelete        = "admin.webhook_delete"
+	AuditActionWebhookReplay        = "admin.webhook_replay"
+
+	AuditActionSquareEventImport        = "square.event_import" // a POS event added to the local ledger
+	AuditActionSquareBalanceSync        = "square.balance_sync"
+	AuditActionSquareAccountLink        = "square.account_link"
+	AuditActionSquareAccountUnlink      = "square.account_unlink"
+	AuditActionOutboxDeliver            = "outbox.deliver"
+	AuditActionOutboxDeadLetter         = "outbox.dead_letter"
+	AuditActionReconciliationCorrection = "reconciliation.correction"
+)
+
+// System actors make changes on the server's own initiative rather than for a request
+const (
+	AuditActorSquareWebhook  = "system:square_webhook"
+	AuditActorOutbox         = "system:outbox"
+	AuditActorReconciliation = "system:reconciliation"
+	AuditActorSeed           = "system:seed"
+
+	AuditRoleSystem = "system" // the role recorded for system actors
+)
+
+// Audit log target types
+const (
+	AuditTargetMember              = "member"
+	AuditTargetTransaction         = "transaction"
+	AuditTargetAdjustment          = "adjustment"
+	AuditTargetOutboxItem          = "outbox_item"
+	AuditTargetReconciliation      = "reconciliation_report"
+	AuditTargetMerchant            = "merchant"
+	AuditTargetLocation            = "location"
+	AuditTargetWebhookSubscription = "webhook_subscription"
+	AuditTargetWebhookDelivery     = "webhook_delivery"
+)
+
+// AuditActor is who made a request, as far as the server can tell
+type AuditActor struct {
+	UserID       string // empty for anonymous requests, e.g. failed logins
+	System       bool   // the server itself; UserID is one of the AuditActor constants
+	MerchantID   string // the merchant the request was made to
+	IP           string // the connecting address
+	ForwardedFor string // X-Forwarded-For as sent, unverified
+	RequestID    string
+}
+
+// SystemActor is the actor of a change a server component makes to a merchant's data
+func SystemActor(actorID, merchantID string) AuditActor {
+	return AuditActor{UserID: actorID, MerchantID: merchantID, System: true}
+}
+
+// AuditEntry is one record of the append-only audit log. Each entry's hash covers its content and
+// the previous entry's hash, so changing, removing or reordering entries breaks the chain.
+type AuditEntry struct {
+	Sequence     int64           `json:"sequence"` // 1 for the first entry
+	ID           string          `json:"id"`
+	Time         time.Time       `json:"time"`
+	MerchantID   string          `json:"merchantId"`
+	ActorID      string          `json:"actorId,omitempty"`
+	ActorRole    string          `json:"actorRole,omitempty"` // the actor's role at the time
+	IP           string          `json:"ip,omitempty"`
+	ForwardedFor string          `json:"forwardedFor,omitempty"`
+	RequestID    string          `json:"requestId,omitempty"`
+	Action       string          `json:"action"`               // one of the AuditAction constants
+	TargetType   string          `json:"targetType,omitempty"` // e.g. "member", "adjustment", "merchant"
+	TargetID     string          `json:"targetId,omitempty"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+	PrevHash     string          `json:"prevHash"` // empty for the first entry
+	Hash         string          `json:"hash"`
+}
+
+// ComputeHash returns the SHA-256 of the entry's JSON encoding without its own hash
+func (e AuditEntry) ComputeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditMember is how a member appears in the audit log. The log is append-only and hash-chained,
+// so it holds the member's identifiers and account state but no personal data, which closing the
+// account could then not erase.
+type AuditMember struct {
+	ID                 string     `json:"id"`
+	MerchantID         string     `json:"merchantId"`
+	LoyaltyID          string     `json:"loyaltyId"`
+	SquareAccountID    string     `json:"squareAccountId,omitempty"`
+	Role               string     `json:"role"`
+	Status             string     `json:"status"`
+	EmailVerified      bool       `json:"emailVerified"`
+	EmailChangePending bool       `json:"emailChangePending,omitempty"`
+	Points             int        `json:"points"`
+	ProvisioningStatus string     `json:"provisioningStatus,omitempty"`
+	LockedAt           *time.Time `json:"lockedAt,omitempty"`
+	ClosedAt           *time.Time `json:"closedAt,omitempty"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
+}
+
+// AuditFilter narrows an audit log query. Empty fields match everything.
+type AuditFilter struct {
+	MerchantID string
+	ActorID    string
+	Action     string // an action, or a prefix ending in "." such as "admin."
+	TargetID   string
+	From       *time.Time // inclusive
+	To         *time.Time // exclusive
+	Before     int64      // only entries with a lower sequence, for paging
+	Limit      int
+}
+
+// AuditPage is one page of audit entries, newest first. NextBefore is empty on the last page.
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	Count      int          `json:"count"`
+	NextBefore int64        `json:"nextBefore,omitempty"`
+}
+
+// AuditVerification is the outcome of checking the audit log's hash chain
+type AuditVerification struct {
+	Valid            bool   `json:"valid"`
+	Entries          int    `json:"entries"`
+	LastHash         string `json:"lastHash,omitempty"`
+	BrokenAtSequence int64  `json:"brokenAtSequence,omitempty"` // first entry that does not match the chain
+	Error            string `json:"error,omitempty"`
+}
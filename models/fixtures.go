@@ -0,0 +1,48 @@
+package models
+
+// Fixtures is a reproducible data set for development and QA: reward tiers, members and their
+// transactions. Fixture files are YAML or JSON.
+type Fixtures struct {
+	Rewards      []FixtureReward      `json:"rewards" yaml:"rewards"`
+	Members      []FixtureMember      `json:"members" yaml:"members"`
+	Transactions []FixtureTransaction `json:"transactions" yaml:"transactions"`
+}
+
+// FixtureReward is a reward tier added to a merchant's loyalty program
+type FixtureReward struct {
+	MerchantID string `json:"merchantId,omitempty" yaml:"merchantId"` // the default merchant if empty
+	ID         string `json:"id" yaml:"id"`
+	Name       string `json:"name" yaml:"name"`
+	Points     int    `json:"points" yaml:"points"`
+}
+
+// FixtureMember is a member signed up with a merchant
+type FixtureMember struct {
+	MerchantID string `json:"merchantId,omitempty" yaml:"merchantId"` // the default merchant if empty
+	Email      string `json:"email" yaml:"email"`
+	Password   string `json:"password" yaml:"password"`
+	FirstName  string `json:"firstName" yaml:"firstName"`
+	LastName   string `json:"lastName" yaml:"lastName"`
+	Phone      string `json:"phone,omitempty" yaml:"phone"`
+}
+
+// FixtureTransaction is an earn or redemption of a fixture member. Transactions are applied in
+// file order.
+type FixtureTransaction struct {
+	MerchantID  string `json:"merchantId,omitempty" yaml:"merchantId"` // the default merchant if empty
+	Member      string `json:"member" yaml:"member"`                   // the member's email
+	Type        string `json:"type" yaml:"type"`                       // "earn" or "redeem"
+	Points      int    `json:"points,omitempty" yaml:"points"`
+	Reward      string `json:"reward,omitempty" yaml:"reward"`   // redeem: reward tier whose points are redeemed, unless points is set
+	OrderID     string `json:"orderId,omitempty" yaml:"orderId"` // earn: credit a Square order instead of points
+	Description string `json:"description,omitempty" yaml:"description"`
+	LocationID  string `json:"locationId,omitempty" yaml:"locationId"`
+}
+
+// SeedReport counts what a seed run created
+type SeedReport struct {
+	Rewards      int      `json:"rewards"`
+	Members      int      `json:"members"`
+	Transactions int      `json:"transactions"`
+	Skipped      []string `json:"skipped,omitempty"` // members that already existed; their transactions were not applied
+}
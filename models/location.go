@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+)
+
+// Location sources and statuses
+const (
+	LocationSourceConfig = "config" // listed in SQUARE_LOCATION_IDS
+	LocationSourceSquare = "square" // synced from the merchant's Square locations
+
+	LocationStatusActive   = "ACTIVE"
+	LocationStatusInactive = "INACTIVE"
+)
+
+// Location is a store of a merchant where members earn and redeem points, with the loyalty rules
+// that apply there
+type Location struct {
+	ID         string `json:"id"` // Square location ID
+	MerchantID string `json:"merchantId"`
+	Name       string `json:"name"`
+	Status     string `json:"status"` // LocationStatusActive or LocationStatusInactive
+	Source     string `json:"source"`
+	LocationRules
+	SyncedAt  *time.Time `json:"syncedAt,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// LocationRules are the loyalty rules of one location. Zero values apply the program unchanged.
+type LocationRules struct {
+	EarnMultiplier float64  `json:"earnMultiplier,omitempty"` // multiplies points earned by points; 0 means 1
+	EarnDisabled   bool     `json:"earnDisabled"`
+	RedeemDisabled bool     `json:"redeemDisabled"`
+	RewardTierIDs  []string `json:"rewardTierIds,omitempty"` // reward tiers offered here; empty offers all
+}
+
+// OffersRewardTier reports whether a reward tier can be redeemed at the location
+func (l Location) OffersRewardTier(rewardTierID string) bool {
+	if len(l.RewardTierIDs) == 0 {
+		return true
+	}
+	for _, id := range l.RewardTierIDs {
+		if id == rewardTierID {
+			return true
+		}
+	}
+	return false
+}
+
+// LocationReport sums up loyalty activity at one location. Transactions without a location are
+// reported under an empty location ID.
+type LocationReport struct {
+	LocationID     string `json:"locationId"`
+	Name           string `json:"name,omitempty"`
+	Transactions   int    `json:"transactions"`
+	Members        int    `json:"members"`
+	PointsEarned   int    `json:"pointsEarned"`
+	PointsRedeemed int    `json:"pointsRedeemed"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// DefaultMerchantID identifies the merchant configured through SQUARE_ACCESS_TOKEN and
+// SQUARE_LOCATION_ID. Users and tokens without a merchant belong to it.
+const DefaultMerchantID = "default"
+
+// MerchantIDOrDefault maps an empty merchant ID to the default merchant
+func MerchantIDOrDefault(merchantID string) string {
+	if merchantID == "" {
+		return DefaultMerchantID
+	}
+	return merchantID
+}
+
+// Merchant connection statuses
+const (
+	MerchantStatusPending   = "pending"   // created, waiting for the Square OAuth authorization
+	MerchantStatusConnected = "connected" // Square credentials are valid
+	MerchantStatusError     = "error"     // the connection failed or the token could not be refreshed
+	MerchantStatusLocal     = "local"     // the default merchant running without Square
+)
+
+// Merchant is a brand (tenant) served by this deployment, with its own Square seller account
+type Merchant struct {
+	ID               string           `json:"id"`
+	Name             string           `json:"name"`
+	Hosts            []string         `json:"hosts"` // request hosts that resolve to this merchant
+	Status           string           `json:"status"`
+	SquareMerchantID string           `json:"squareMerchantId,omitempty"`
+	SquareLocationID string           `json:"squareLocationId,omitempty"`
+	SquareStatus     string           `json:"squareStatus,omitempty"` // "ok", "degraded" or "disabled"
+	ConnectedAt      *time.Time       `json:"connectedAt,omitempty"`
+	TokenExpiresAt   *time.Time       `json:"tokenExpiresAt,omitempty"`
+	TokenRefreshedAt *time.Time       `json:"tokenRefreshedAt,omitempty"`
+	LastError        string           `json:"lastError,omitempty"`
+	Settings         MerchantSettings `json:"settings"`
+	CreatedAt        time.Time        `json:"createdAt"`
+	UpdatedAt        time.Time        `json:"updatedAt"`
+}
+
+// MerchantSettings overrides deployment-wide configuration for one merchant. Empty fields use
+// the configured value.
+type MerchantSettings struct {
+	AccountClosureBalancePolicy string `json:"accountClosureBalancePolicy,omitempty"` // "forfeit" or "payout"
+	PointValueCents             int    `json:"pointValueCents,omitempty"`             // cash value of one point when paying out
+}
+
+// MerchantCredentials holds a merchant's Square OAuth tokens, encrypted at rest
+type MerchantCredentials struct {
+	MerchantID            string
+	EncryptedAccessToken  string
+	EncryptedRefreshToken string
+	ExpiresAt             time.Time
+}
+
+// OAuthState ties a Square OAuth authorization request to the merchant being connected
+type OAuthState struct {
+	State      string
+	MerchantID string
+	ExpiresAt  time.Time
+}
+
+type CreateMerchantRequest struct {
+	Name             string           `json:"name"`
+	Hosts            []string         `json:"hosts"`
+	SquareLocationID string           `json:"squareLocationId"` // optional, defaults to the seller's first active location
+	Settings         MerchantSettings `json:"settings"`
+}
+
+type ConnectMerchantResponse struct {
+	AuthorizeURL string `json:"authorizeUrl"`
+	ExpiresAt    string `json:"expiresAt"`
+}
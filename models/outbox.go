@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	OutboxOperationAccumulatePoints = "accumulate_points"
+	OutboxOperationAdjustPoints     = "adjust_points"
+
+	OutboxStatusPending   = "pending"
+	OutboxStatusDelivered = "delivered"
+	OutboxStatusDead      = "dead" // gave up after the maximum number of attempts
+)
+
+// OutboxItem is a pending Square write recorded together with its local transaction
+type OutboxItem struct {
+	ID             string     `json:"id"`
+	MerchantID     string     `json:"merchantId"`
+	UserID         string     `json:"userId"`
+	TransactionID  string     `json:"transactionId"`
+	Operation      string     `json:"operation"`
+	Points         int        `json:"points"` // signed for adjustments
+	OrderID        string     `json:"orderId,omitempty"`
+	LocationID     string     `json:"locationId,omitempty"`
+	Reason         string     `json:"reason,omitempty"`
+	IdempotencyKey string     `json:"idempotencyKey"` // stable across retries
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastError      string     `json:"lastError,omitempty"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt"`
+	SquareEventID  string     `json:"squareEventId,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
+
+// WithoutFreeText returns a copy without the reason, which copies the free text of the
+// transaction and may contain personal data
+func (i OutboxItem) WithoutFreeText() OutboxItem {
+	i.Reason = ""
+	return i
+}
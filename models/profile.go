@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// UpdateProfileRequest is a partial update; nil fields are left unchanged
+type UpdateProfileRequest struct {
+	FirstName                *string                   `json:"firstName"`
+	LastName                 *string                   `json:"lastName"`
+	Email                    *string                   `json:"email"`
+	Phone                    *string                   `json:"phone"`
+	DateOfBirth              *string                   `json:"dateOfBirth"`
+	CommunicationPreferences *CommunicationPreferences `json:"communicationPreferences"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ProfileChange is one entry in a user's profile audit history
+type ProfileChange struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Field     string    `json:"field"`
+	OldValue  string    `json:"oldValue"`
+	NewValue  string    `json:"newValue"`
+	ChangedAt time.Time `json:"changedAt"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// LoyaltyProgram describes how members earn points and what rewards cost, as configured in Square
+type LoyaltyProgram struct {
+	ID               string                   `json:"id"`
+	Status           string                   `json:"status"` // "ACTIVE" or "INACTIVE"
+	Terminology      ProgramTerminology       `json:"terminology"`
+	RewardTiers      []ProgramRewardTier      `json:"rewardTiers"`
+	AccrualRules     []ProgramAccrualRule     `json:"accrualRules"`
+	ExpirationPolicy *ProgramExpirationPolicy `json:"expirationPolicy,omitempty"` // nil if points never expire
+	LocationIDs      []string                 `json:"locationIds"`
+	UpdatedAt        string                   `json:"updatedAt,omitempty"` // as reported by Square
+	FetchedAt        time.Time                `json:"fetchedAt"`
+}
+
+// ProgramTerminology is the singular and plural name of the program's points, e.g. "Star"/"Stars"
+type ProgramTerminology struct {
+	One   string `json:"one"`
+	Other string `json:"other"`
+}
+
+// ProgramRewardTier is a reward members can redeem points for
+type ProgramRewardTier struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Points int    `json:"points"`
+}
+
+// ProgramAccrualRule is a way to earn points. Which fields are set depends on the accrual type:
+// SPEND (points per spend amount), VISIT (points per visit over a minimum), ITEM_VARIATION or
+// CATEGORY (points per item bought).
+type ProgramAccrualRule struct {
+	AccrualType        string `json:"accrualType"`
+	Points             int    `json:"points"`
+	SpendAmountCents   int64  `json:"spendAmountCents,omitempty"`
+	MinimumAmountCents int64  `json:"minimumAmountCents,omitempty"`
+	Currency           string `json:"currency,omitempty"`
+	TaxMode            string `json:"taxMode,omitempty"` // "BEFORE_TAX" or "AFTER_TAX"
+	ItemVariationID    string `json:"itemVariationId,omitempty"`
+	CategoryID         string `json:"categoryId,omitempty"`
+}
+
+// ProgramExpirationPolicy is how long points last, as an ISO 8601 duration such as "P1Y"
+type ProgramExpirationPolicy struct {
+	ExpirationDuration string `json:"expirationDuration"`
+}
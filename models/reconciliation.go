@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	ReconciliationModeDryRun      = "dry_run"      // report mismatches only
+	ReconciliationModeAutoCorrect = "auto_correct" // report mismatches and post corrections
+
+	MismatchMissingLocalEvent  = "missing_local_event"  // Square event not in the local ledger
+	MismatchMissingRemoteEvent = "missing_remote_event" // local transaction not in Square
+	MismatchAmountDrift        = "amount_drift"         // local and Square balances differ
+)
+
+// ReconciliationMismatch is a difference found between a member's local ledger and Square
+type ReconciliationMismatch struct {
+	UserID          string `json:"userId"`
+	SquareAccountID string `json:"squareAccountId"`
+	Type            string `json:"type"`
+	SquareEventID   string `json:"squareEventId,omitempty"`
+	TransactionID   string `json:"transactionId,omitempty"`
+	OutboxItemID    string `json:"outboxItemId,omitempty"` // Square write queued by the correction
+	Points          int    `json:"points"`                 // signed effect on the balance; for drift, local minus Square
+	LocalBalance    int    `json:"localBalance"`
+	SquareBalance   int    `json:"squareBalance"`
+	Detail          string `json:"detail"`
+	Corrected       bool   `json:"corrected"`
+	CorrectionError string `json:"correctionError,omitempty"`
+}
+
+// ReconciliationReport is the outcome of one reconciliation run
+type ReconciliationReport struct {
+	ID           string                   `json:"id"`
+	Mode         string                   `json:"mode"`
+	StartedAt    time.Time                `json:"startedAt"`
+	CompletedAt  time.Time                `json:"completedAt"`
+	UsersChecked int                      `json:"usersChecked"`
+	UsersSkipped int                      `json:"usersSkipped"` // e.g. Square writes still in flight
+	Errors       []string                 `json:"errors,omitempty"`
+	Mismatches   []ReconciliationMismatch `json:"mismatches"`
+	Corrections  int                      `json:"corrections"`
+}
+
+type RunReconciliationRequest struct {
+	Mode string `json:"mode"` // "dry_run" (default) or "auto_correct"
+}
@@ -4,26 +4,113 @@ import (
 	"time"
 )
 
+// Transaction types. Points are recorded as a positive amount and the type decides whether they
+// are added to or subtracted from the balance, except for adjustments, whose points are signed.
+const (
+	TransactionTypeEarn           = "earn"            // points accumulated for a purchase
+	TransactionTypePromotionEarn  = "promotion_earn"  // extra points from a loyalty promotion
+	TransactionTypeRedeem         = "redeem"          // points redeemed through this API
+	TransactionTypeAdjustCredit   = "adjust_credit"   // manual adjustment adding points
+	TransactionTypeAdjustDebit    = "adjust_debit"    // manual adjustment removing points
+	TransactionTypeRewardCreated  = "reward_created"  // points spent on a reward
+	TransactionTypeRewardDeleted  = "reward_deleted"  // points returned when a reward is deleted
+	TransactionTypeRewardRedeemed = "reward_redeemed" // reward applied to an order, no points change
+	TransactionTypeExpire         = "expire"          // points expired under the program's policy
+	TransactionTypeOtherCredit    = "other_credit"    // Square event of type OTHER adding points
+	TransactionTypeOtherDebit     = "other_debit"     // Square event of type OTHER removing points
+	TransactionTypeForfeit        = "forfeit"         // balance forfeited on account closure
+	TransactionTypePayout         = "payout"          // balance paid out on account closure
+	TransactionTypeAdjustment     = "adjustment"      // manual adjustment by an admin, signed points
+)
+
+// Transaction sources
+const (
+	TransactionSourceLocal      = "local"       // recorded by this application
+	TransactionSourceSquare     = "square"      // Square POS or Seller Dashboard
+	TransactionSourceLoyaltyAPI = "loyalty_api" // Square Loyalty API
+)
+
 type Transaction struct {
-	ID          string    `json:"id"`
-	UserID      string    `json:"userId"`
-	Type        string    `json:"type"` // "earn" or "redeem"
-	Points      int       `json:"points"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"createdAt"`
+	ID            string    `json:"id"`
+	MerchantID    string    `json:"merchantId"`
+	UserID        string    `json:"userId"`
+	Type          string    `json:"type"` // one of the TransactionType constants
+	Points        int       `json:"points"`
+	Description   string    `json:"description"`
+	Source        string    `json:"source,omitempty"`
+	OrderID       string    `json:"orderId,omitempty"`
+	LocationID    string    `json:"locationId,omitempty"`
+	Reason        string    `json:"reason,omitempty"`       // adjustment reason given to Square
+	ReversesID    string    `json:"reversesId,omitempty"`   // the transaction this one reverses
+	AdjustmentID  string    `json:"adjustmentId,omitempty"` // the admin adjustment this transaction applies
+	SquareEventID string    `json:"squareEventId,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
 }
 
+// SignedPoints returns the effect of the transaction on the balance
+func (t Transaction) SignedPoints() int {
+	switch t.Type {
+	case TransactionTypeAdjustment:
+		return t.Points
+	case TransactionTypeEarn, TransactionTypePromotionEarn, TransactionTypeAdjustCredit,
+		TransactionTypeRewardDeleted, TransactionTypeOtherCredit:
+		return t.Points
+	case TransactionTypeRewardRedeemed:
+		return 0
+	default:
+		return -t.Points
+	}
+}
+
+// WithoutFreeText returns a copy without the description and reason, which members and admins
+// write freely and may contain personal data
+func (t Transaction) WithoutFreeText() Transaction {
+	t.Description = ""
+	t.Reason = ""
+	return t
+}
+
+// EarnRequest credits either a number of points or the points a Square order earns
 type EarnRequest struct {
-	Points      int    `json:"points" binding:"required"`
+	Points      int    `json:"points"`
+	OrderID     string `json:"orderId"` // Square order ID; Square calculates the points
 	Description string `json:"description"`
+	LocationID  string `json:"locationId"` // optional, defaults to the merchant's default location
 }
 
 type RedeemRequest struct {
-	Points      int    `json:"points" binding:"required"`
-	Description string `json:"description"`
+	Points       int    `json:"points"`       // required unless a reward tier is given
+	RewardTierID string `json:"rewardTierId"` // optional reward of the program; points default to its cost
+	Description  string `json:"description"`
+	LocationID   string `json:"locationId"` // optional, defaults to the merchant's default location
+}
+
+// LedgerExport is a member's complete local ledger, oldest first
+type LedgerExport struct {
+	ExportedAt   time.Time     `json:"exportedAt"`
+	MerchantID   string        `json:"merchantId"`
+	UserID       string        `json:"userId"`
+	LoyaltyID    string        `json:"loyaltyId"`
+	Balance      int           `json:"balance"`
+	Transactions []Transaction `json:"transactions"`
 }
 
 type BalanceResponse struct {
 	Points       int           `json:"points"`
 	Transactions []Transaction `json:"transactions"`
 }
+
+// HistoryFilter narrows the transaction history. Empty fields match everything.
+type HistoryFilter struct {
+	Types       []string   // Square loyalty event types, e.g. ACCUMULATE_POINTS
+	From        *time.Time // inclusive
+	To          *time.Time // exclusive
+	LocationIDs []string
+}
+
+// HistoryPage is one page of transaction history. NextCursor is empty on the last page.
+type HistoryPage struct {
+	Transactions []Transaction `json:"transactions"`
+	Count        int           `json:"count"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+}
@@ -5,22 +5,52 @@ import (
 )
 
 type User struct {
-	ID        string    `json:"id"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password,omitempty"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	LoyaltyID string    `json:"loyaltyId"`
-	Points    int       `json:"points"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID                       string                   `json:"id"`
+	MerchantID               string                   `json:"merchantId"` // the merchant (brand) the member signed up with
+	Email                    string                   `json:"email"`
+	EmailVerified            bool                     `json:"emailVerified"`
+	PendingEmail             string                   `json:"pendingEmail,omitempty"`
+	Password                 string                   `json:"password,omitempty"`
+	FirstName                string                   `json:"firstName"`
+	LastName                 string                   `json:"lastName"`
+	Phone                    string                   `json:"phone,omitempty"`       // E.164
+	DateOfBirth              string                   `json:"dateOfBirth,omitempty"` // YYYY-MM-DD
+	CommunicationPreferences CommunicationPreferences `json:"communicationPreferences"`
+	LoyaltyID                string                   `json:"loyaltyId"`                 // member-facing loyalty number
+	SquareAccountID          string                   `json:"squareAccountId,omitempty"` // Square loyalty account ID
+	Provisioning             SquareProvisioning       `json:"provisioning"`
+	SquareBalanceSyncedAt    *time.Time               `json:"squareBalanceSyncedAt,omitempty"` // Square updated_at of the last balance snapshot
+	Points                   int                      `json:"points"`
+	Role                     string                   `json:"role"`   // "member" or "admin"
+	Status                   string                   `json:"status"` // "active", "locked" or "closed"
+	LockReason               string                   `json:"lockReason,omitempty"`
+	LockedAt                 *time.Time               `json:"lockedAt,omitempty"`
+	ClosedAt                 *time.Time               `json:"closedAt,omitempty"`
+	CreatedAt                time.Time                `json:"createdAt"`
+	UpdatedAt                time.Time                `json:"updatedAt"`
+
+	// PasswordHistory holds hashes of previously used passwords, newest last
+	PasswordHistory []string `json:"-"`
+	// EmailVerificationToken confirms ownership of PendingEmail
+	EmailVerificationToken string `json:"-"`
+}
+
+// CommunicationPreferences records which channels a member agreed to be contacted on
+type CommunicationPreferences struct {
+	Email     bool `json:"email"`
+	SMS       bool `json:"sms"`
+	Push      bool `json:"push"`
+	Marketing bool `json:"marketing"`
 }
 
 type SignupRequest struct {
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=6"`
+	Password  string `json:"password" binding:"required"`
 	FirstName string `json:"firstName" binding:"required"`
 	LastName  string `json:"lastName" binding:"required"`
+	Phone     string `json:"phone"` // optional, normalized to E.164
+
+	MerchantID string `json:"-"` // resolved from the request host
 }
 
 type SignupResponse struct {
@@ -31,10 +61,17 @@ type SignupResponse struct {
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
+
+	MerchantID string `json:"-"` // resolved from the request host
 }
 
 type LoginResponse struct {
 	Message string `json:"message"`
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token   string `json:"token"`
+	User    User   `json:"user"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
 }
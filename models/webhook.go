@@ -0,0 +1,28 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SquareWebhookEvent is the envelope Square posts to webhook subscribers
+type SquareWebhookEvent struct {
+	MerchantID string                 `json:"merchant_id"`
+	Type       string                 `json:"type"`
+	EventID    string                 `json:"event_id"`
+	CreatedAt  string                 `json:"created_at"`
+	Data       SquareWebhookEventData `json:"data"`
+}
+
+type SquareWebhookEventData struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id"`
+	Object json.RawMessage `json:"object"`
+}
+
+// ProcessedWebhookEvent records a handled webhook delivery for deduplication
+type ProcessedWebhookEvent struct {
+	EventID     string    `json:"eventId"`
+	Type        string    `json:"type"`
+	ProcessedAt time.Time `json:"processedAt"`
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Outbound webhook event types
+const (
+	WebhookEventMemberSignup   = "member.signup"       // a member signed up
+	WebhookEventPointsEarned   = "points.earned"       // points were earned for a purchase or promotion
+	WebhookEventPointsRedeemed = "points.redeemed"     // points were redeemed or spent on a reward
+	WebhookEventTierChanged    = "member.tier_changed" // the best reward tier the balance reaches changed
+	WebhookEventTest           = "webhook.test"        // sent on request to check a subscription; cannot be subscribed to
+)
+
+// WebhookEventTypes lists the event types subscriptions can choose from
+var WebhookEventTypes = []string{
+	WebhookEventMemberSignup,
+	WebhookEventPointsEarned,
+	WebhookEventPointsRedeemed,
+	WebhookEventTierChanged,
+}
+
+// Webhook delivery statuses
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead" // gave up after the maximum number of attempts
+)
+
+// WebhookSubscription is an endpoint of another system that is notified of a merchant's loyalty
+// events. The secret signs every delivery and is only returned when the subscription is created
+// or the secret is replaced.
+type WebhookSubscription struct {
+	ID          string    `json:"id"`
+	MerchantID  string    `json:"merchantId"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	EventTypes  []string  `json:"eventTypes"`
+	Description string    `json:"description,omitempty"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// WebhookSubscriptionRequest creates or updates a subscription. On update, empty fields keep
+// their value; a secret replaces the current one.
+type WebhookSubscriptionRequest struct {
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret"` // generated when empty on create
+	EventTypes  []string `json:"eventTypes"`
+	Description string   `json:"description"`
+	Active      *bool    `json:"active"` // defaults to true on create
+}
+
+// WebhookEvent is the body of a delivery. Its ID stays the same across retries and replays, so
+// receivers can discard duplicates.
+type WebhookEvent struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	MerchantID string          `json:"merchantId"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// WebhookMember identifies the member an event is about. Payloads are stored for retries and
+// replays, so they carry no personal data that closing the account would have to erase.
+type WebhookMember struct {
+	ID        string `json:"id"`
+	LoyaltyID string `json:"loyaltyId"`
+}
+
+// WebhookPointsData is the data of points.earned and points.redeemed events
+type WebhookPointsData struct {
+	Member      WebhookMember `json:"member"`
+	Transaction Transaction   `json:"transaction"`
+	Balance     int           `json:"balance"` // after the transaction
+}
+
+// WebhookTierData is the data of member.tier_changed events. A nil tier means the balance
+// reaches no reward tier.
+type WebhookTierData struct {
+	Member       WebhookMember      `json:"member"`
+	PreviousTier *ProgramRewardTier `json:"previousTier"`
+	Tier         *ProgramRewardTier `json:"tier"`
+	Balance      int                `json:"balance"`
+}
+
+// WebhookDelivery is one event sent to one subscription, with every attempt made
+type WebhookDelivery struct {
+	ID             string           `json:"id"`
+	SubscriptionID string           `json:"subscriptionId"`
+	MerchantID     string           `json:"merchantId"`
+	EventID        string           `json:"eventId"`
+	EventType      string           `json:"eventType"`
+	Payload        json.RawMessage  `json:"payload"` // the WebhookEvent, as sent
+	Status         string           `json:"status"`
+	Attempts       []WebhookAttempt `json:"attempts"`
+	NextAttemptAt  time.Time        `json:"nextAttemptAt"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	DeliveredAt    *time.Time       `json:"deliveredAt,omitempty"`
+}
+
+// WebhookAttempt is the outcome of one attempt to deliver a webhook
+type WebhookAttempt struct {
+	Number       int       `json:"number"`
+	Replay       bool      `json:"replay,omitempty"` // made by an admin's replay
+	AttemptedAt  time.Time `json:"attemptedAt"`
+	DurationMs   int64     `json:"durationMs"`
+	StatusCode   int       `json:"statusCode,omitempty"` // 0 when no response was received
+	Error        string    `json:"error,omitempty"`
+	ResponseBody string    `json:"responseBody,omitempty"` // truncated
+}
@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/middleware"
+	"loyalty-core/models"
+	"loyalty-core/services"
+)
+
+type AccountRoutes struct {
+	accountService *services.AccountService
+	authService    *services.AuthService
+	auditService   *services.AuditService
+	config         *config.Config
+}
+
+func NewAccountRoutes(cfg *config.Config, accountService *services.AccountService, authService *services.AuthService, auditService *services.AuditService) *AccountRoutes {
+	return &AccountRoutes{
+		accountService: accountService,
+		authService:    authService,
+		auditService:   auditService,
+		config:         cfg,
+	}
+}
+
+// Export handles downloading everything held about the authenticated user as a JSON archive
+func (ar *AccountRoutes) Export(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := middleware.UserID(r)
+
+	export, err := ar.accountService.ExportAccount(r.Context(), userID)
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, services.ErrSquareUnavailable) {
+			status = http.StatusServiceUnavailable
+		} else if err.Error() != "user not found" {
+			status = http.StatusBadGateway
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("loyalty-export-%s-%s.json", userID, time.Now().Format("20060102"))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(export)
+}
+
+// Close handles closing the authenticated user's account
+func (ar *AccountRoutes) Close(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := middleware.UserID(r)
+
+	var req models.CloseAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	response, err := ar.accountService.CloseAccount(r.Context(), userID, req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err.Error() == "invalid credentials" {
+			status = http.StatusUnauthorized
+		} else if err.Error() == "account is closed" {
+			status = http.StatusConflict
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ar.auditService.Record(auditActor(r), models.AuditActionAccountClose, models.AuditTargetMember, userID, nil, response)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RegisterRoutes registers all account routes
+func (ar *AccountRoutes) RegisterRoutes(mux *http.ServeMux) {
+	auth := middleware.RequireAuth(ar.authService)
+	mux.Handle("GET /api/account/export", auth(http.HandlerFunc(ar.Export)))
+	mux.Handle("POST /api/account/close", auth(http.HandlerFunc(ar.Close)))
+
+	log.Println("Account routes registered")
+}
@@ -0,0 +1,167 @@
+package routes
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"loyalty-core/config"
+	"loyalty-core/middleware"
+	"loyalty-core/models"
+	"loyalty-core/services"
+)
+
+// AdjustmentRoutes serves customer-service adjustments of member balances
+type AdjustmentRoutes struct {
+	adjustmentService *services.AdjustmentService
+	authService       *services.AuthService
+	auditService      *services.AuditService
+	config            *config.Config
+}
+
+func NewAdjustmentRoutes(cfg *config.Config, adjustmentService *services.AdjustmentService, authService *services.AuthService, auditService *services.AuditService) *AdjustmentRoutes {
+	return &AdjustmentRoutes{
+		adjustmentService: adjustmentService,
+		authService:       authService,
+		auditService:      auditService,
+		config:            cfg,
+	}
+}
+
+// AdjustPoints handles a manual adjustment of a member's balance. Applied adjustments are
+// returned with 201 Created, those waiting for a second admin with 202 Accepted.
+func (ar *AdjustmentRoutes) AdjustPoints(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.AdjustPointsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	adjustment, err := ar.adjustmentService.RequestAdjustment(middleware.MerchantID(r), middleware.UserID(r), r.PathValue("id"), req)
+	if err != nil {
+		w.WriteHeader(memberErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ar.auditService.Record(auditActor(r), models.AuditActionAdjustmentRequest, models.AuditTargetAdjustment, adjustment.ID, nil, adjustment)
+	writeAdjustment(w, adjustment)
+}
+
+// ReverseTransaction handles POST /api/admin/members/{id}/transactions/{transactionId}/reverse
+func (ar *AdjustmentRoutes) ReverseTransaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.ReverseTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	adjustment, err := ar.adjustmentService.RequestReversal(middleware.MerchantID(r), middleware.UserID(r), r.PathValue("id"), r.PathValue("transactionId"), req)
+	if err != nil {
+		w.WriteHeader(memberErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ar.auditService.Record(auditActor(r), models.AuditActionAdjustmentRequest, models.AuditTargetAdjustment, adjustment.ID, nil, adjustment)
+	writeAdjustment(w, adjustment)
+}
+
+// ListAdjustments handles listing adjustments, optionally filtered by
+// ?status=pending_approval|applied|rejected
+func (ar *AdjustmentRoutes) ListAdjustments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adjustments := ar.adjustmentService.ListAdjustments(middleware.MerchantID(r), r.URL.Query().Get("status"))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"adjustments": adjustments,
+		"count":       len(adjustments),
+	})
+}
+
+// GetAdjustment handles GET /api/admin/adjustments/{id}
+func (ar *AdjustmentRoutes) GetAdjustment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adjustment, err := ar.adjustmentService.GetAdjustment(middleware.MerchantID(r), r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(adjustment)
+}
+
+// ApproveAdjustment handles POST /api/admin/adjustments/{id}/approve
+func (ar *AdjustmentRoutes) ApproveAdjustment(w http.ResponseWriter, r *http.Request) {
+	ar.review(w, r, models.AuditActionAdjustmentApprove, ar.adjustmentService.Approve)
+}
+
+// RejectAdjustment handles POST /api/admin/adjustments/{id}/reject
+func (ar *AdjustmentRoutes) RejectAdjustment(w http.ResponseWriter, r *http.Request) {
+	ar.review(w, r, models.AuditActionAdjustmentReject, ar.adjustmentService.Reject)
+}
+
+// review decodes an optional review note and approves or rejects the adjustment with it; action
+// is the audit action recorded for the decision
+func (ar *AdjustmentRoutes) review(w http.ResponseWriter, r *http.Request, action string, decide func(merchantID, adminID, adjustmentID string, req models.ReviewAdjustmentRequest) (*models.Adjustment, error)) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.ReviewAdjustmentRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	merchantID, adjustmentID := middleware.MerchantID(r), r.PathValue("id")
+	before, _ := ar.adjustmentService.GetAdjustment(merchantID, adjustmentID)
+
+	adjustment, err := decide(merchantID, middleware.UserID(r), adjustmentID, req)
+	if err != nil {
+		w.WriteHeader(memberErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ar.auditService.Record(auditActor(r), action, models.AuditTargetAdjustment, adjustment.ID, before, adjustment)
+
+	log.Printf("Adjustment %s %s by admin %s", adjustment.ID, adjustment.Status, middleware.UserID(r))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(adjustment)
+}
+
+// writeAdjustment responds with a new adjustment: 201 once applied, 202 while pending approval
+func writeAdjustment(w http.ResponseWriter, adjustment *models.Adjustment) {
+	status := http.StatusCreated
+	if adjustment.Status == models.AdjustmentStatusPending {
+		status = http.StatusAccepted
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(adjustment)
+}
+
+// RegisterRoutes registers the adjustment routes. They require the admin role.
+func (ar *AdjustmentRoutes) RegisterRoutes(mux *http.ServeMux) {
+	admin := middleware.RequireAdmin(ar.authService)
+	mux.Handle("POST /api/admin/members/{id}/adjustments", admin(http.HandlerFunc(ar.AdjustPoints)))
+	mux.Handle("POST /api/admin/members/{id}/transactions/{transactionId}/reverse", admin(http.HandlerFunc(ar.ReverseTransaction)))
+	mux.Handle("GET /api/admin/adjustments", admin(http.HandlerFunc(ar.ListAdjustments)))
+	mux.Handle("GET /api/admin/adjustments/{id}", admin(http.HandlerFunc(ar.GetAdjustment)))
+	mux.Handle("POST /api/admin/adjustments/{id}/approve", admin(http.HandlerFunc(ar.ApproveAdjustment)))
+	mux.Handle("POST /api/admin/adjustments/{id}/reject", admin(http.HandlerFunc(ar.RejectAdjustment)))
+
+	log.Println("Adjustment routes registered")
+}
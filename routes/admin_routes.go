@@ -0,0 +1,372 @@
+package routes
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/middleware"
+	"loyalty-core/models"
+	"loyalty-core/services"
+)
+
+type AdminRoutes struct {
+	outboxService         *services.OutboxService
+	reconciliationService *services.ReconciliationService
+	authService           *services.AuthService
+	accountService        *services.AccountService
+	loyaltyService        *services.LoyaltyService
+	auditService          *services.AuditService
+	config                *config.Config
+}
+
+func NewAdminRoutes(cfg *config.Config, outboxService *services.OutboxService, reconciliationService *services.ReconciliationService, authService *services.AuthService, accountService *services.AccountService, loyaltyService *services.LoyaltyService, auditService *services.AuditService) *AdminRoutes {
+	return &AdminRoutes{
+		outboxService:         outboxService,
+		reconciliationService: reconciliationService,
+		authService:           authService,
+		accountService:        accountService,
+		loyaltyService:        loyaltyService,
+		auditService:          auditService,
+		config:                cfg,
+	}
+}
+
+// ListOutbox handles listing outbox items, optionally filtered by ?status=pending|delivered|dead
+func (ar *AdminRoutes) ListOutbox(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	items := ar.outboxService.ListItems(r.URL.Query().Get("status"))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": items,
+		"count": len(items),
+	})
+}
+
+// GetOutboxItem handles GET /api/admin/outbox/{id}
+func (ar *AdminRoutes) GetOutboxItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	item, err := ar.outboxService.GetItem(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// ReplayOutboxItem handles POST /api/admin/outbox/{id}/replay
+func (ar *AdminRoutes) ReplayOutboxItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adminID := middleware.UserID(r)
+	itemID := r.PathValue("id")
+
+	before, _ := ar.outboxService.GetItem(itemID)
+
+	item, err := ar.outboxService.ReplayItem(itemID)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err.Error() == "outbox item not found" {
+			status = http.StatusNotFound
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Outbox item %s replayed by admin %s", itemID, adminID)
+	ar.auditService.Record(auditActor(r), models.AuditActionOutboxReplay, models.AuditTargetOutboxItem, itemID, before, item)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// RunReconciliation handles starting a reconciliation run and returns its report
+func (ar *AdminRoutes) RunReconciliation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	adminID := middleware.UserID(r)
+
+	var req models.RunReconciliationRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	if req.Mode == "" {
+		req.Mode = models.ReconciliationModeDryRun
+	}
+
+	log.Printf("Manual reconciliation (%s) started by admin %s", req.Mode, adminID)
+	report, err := ar.reconciliationService.Run(r.Context(), req.Mode)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrSquareUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ar.auditService.Record(auditActor(r), models.AuditActionReconciliationRun, models.AuditTargetReconciliation, report.ID, nil, report)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// ListReconciliationReports handles listing reconciliation reports, newest first
+func (ar *AdminRoutes) ListReconciliationReports(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	reports := ar.reconciliationService.ListReports()
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reports": reports,
+		"count":   len(reports),
+	})
+}
+
+// ReconciliationReport handles GET /api/admin/reconciliation/reports/{id}
+func (ar *AdminRoutes) ReconciliationReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	report, err := ar.reconciliationService.GetReport(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// FindMember handles looking up a member of the request's merchant by ?email= or ?loyaltyId=
+func (ar *AdminRoutes) FindMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	member, err := ar.accountService.FindMember(middleware.MerchantID(r), query.Get("email"), query.Get("loyaltyId"))
+	if err != nil {
+		w.WriteHeader(memberErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(member)
+}
+
+// SearchMembers handles searching the request's merchant's members by ?q= (email, name, phone
+// or loyalty number), with an optional ?limit=
+func (ar *AdminRoutes) SearchMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "limit must be a positive number"})
+			return
+		}
+	}
+
+	members, err := ar.accountService.SearchMembers(middleware.MerchantID(r), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"members": members,
+		"count":   len(members),
+	})
+}
+
+// MemberHistory handles GET /api/admin/members/{id}/history
+func (ar *AdminRoutes) MemberHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	history, err := ar.accountService.MemberHistory(middleware.MerchantID(r), r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(memberErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(history)
+}
+
+// GetMember handles GET /api/admin/members/{id}
+func (ar *AdminRoutes) GetMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	member, err := ar.accountService.GetMember(middleware.MerchantID(r), r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(memberErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(member)
+}
+
+// LockMember handles POST /api/admin/members/{id}/lock
+func (ar *AdminRoutes) LockMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.LockAccountRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	merchantID, memberID := middleware.MerchantID(r), r.PathValue("id")
+	before, _ := ar.accountService.GetMember(merchantID, memberID)
+
+	member, err := ar.accountService.LockAccount(merchantID, memberID, req.Reason)
+	if err != nil {
+		w.WriteHeader(memberErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Member %s locked by admin %s", member.ID, middleware.UserID(r))
+	ar.auditService.Record(auditActor(r), models.AuditActionMemberLock, models.AuditTargetMember, member.ID, before, member)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(member)
+}
+
+// UnlockMember handles POST /api/admin/members/{id}/unlock
+func (ar *AdminRoutes) UnlockMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchantID, memberID := middleware.MerchantID(r), r.PathValue("id")
+	before, _ := ar.accountService.GetMember(merchantID, memberID)
+
+	member, err := ar.accountService.UnlockAccount(merchantID, memberID)
+	if err != nil {
+		w.WriteHeader(memberErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Member %s unlocked by admin %s", member.ID, middleware.UserID(r))
+	ar.auditService.Record(auditActor(r), models.AuditActionMemberUnlock, models.AuditTargetMember, member.ID, before, member)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(member)
+}
+
+// ExportLedger handles exporting a member's ledger as JSON, or as CSV with ?format=csv
+func (ar *AdminRoutes) ExportLedger(w http.ResponseWriter, r *http.Request) {
+	ledger, err := ar.loyaltyService.ExportLedger(middleware.MerchantID(r), r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(memberErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ledger)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"ledger-%s.csv\"", ledger.LoyaltyID))
+		w.WriteHeader(http.StatusOK)
+		writeLedgerCSV(w, ledger)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "format must be json or csv"})
+	}
+}
+
+// writeLedgerCSV writes a ledger with one row per transaction and its running balance
+func writeLedgerCSV(w io.Writer, ledger *models.LedgerExport) {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "createdAt", "type", "points", "balance", "description", "reason", "source", "orderId", "locationId", "reversesId", "squareEventId"})
+
+	balance := 0
+	for _, transaction := range ledger.Transactions {
+		balance += transaction.SignedPoints()
+		writer.Write([]string{
+			transaction.ID,
+			transaction.CreatedAt.Format(time.RFC3339),
+			transaction.Type,
+			strconv.Itoa(transaction.SignedPoints()),
+			strconv.Itoa(balance),
+			transaction.Description,
+			transaction.Reason,
+			transaction.Source,
+			transaction.OrderID,
+			transaction.LocationID,
+			transaction.ReversesID,
+			transaction.SquareEventID,
+		})
+	}
+	writer.Flush()
+}
+
+// memberErrorStatus maps errors of the member admin operations to HTTP statuses
+func memberErrorStatus(err error) int {
+	switch {
+	case err.Error() == "user not found", err.Error() == "adjustment not found", errors.Is(err, services.ErrTransactionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrSelfApproval):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrTransactionAlreadyReversed), errors.Is(err, services.ErrAccountLocked),
+		errors.Is(err, services.ErrAdjustmentNotPending):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// RegisterRoutes registers all admin routes. They require the admin role.
+func (ar *AdminRoutes) RegisterRoutes(mux *http.ServeMux) {
+	admin := middleware.RequireAdmin(ar.authService)
+	mux.Handle("GET /api/admin/outbox", admin(http.HandlerFunc(ar.ListOutbox)))
+	mux.Handle("GET /api/admin/outbox/{id}", admin(http.HandlerFunc(ar.GetOutboxItem)))
+	mux.Handle("POST /api/admin/outbox/{id}/replay", admin(http.HandlerFunc(ar.ReplayOutboxItem)))
+	mux.Handle("POST /api/admin/reconciliation/run", admin(http.HandlerFunc(ar.RunReconciliation)))
+	mux.Handle("GET /api/admin/reconciliation/reports", admin(http.HandlerFunc(ar.ListReconciliationReports)))
+	mux.Handle("GET /api/admin/reconciliation/reports/{id}", admin(http.HandlerFunc(ar.ReconciliationReport)))
+	mux.Handle("GET /api/admin/members", admin(http.HandlerFunc(ar.FindMember)))
+	mux.Handle("GET /api/admin/members/search", admin(http.HandlerFunc(ar.SearchMembers)))
+	mux.Handle("GET /api/admin/members/{id}", admin(http.HandlerFunc(ar.GetMember)))
+	mux.Handle("GET /api/admin/members/{id}/history", admin(http.HandlerFunc(ar.MemberHistory)))
+	mux.Handle("GET /api/admin/members/{id}/ledger", admin(http.HandlerFunc(ar.ExportLedger)))
+	mux.Handle("POST /api/admin/members/{id}/lock", admin(http.HandlerFunc(ar.LockMember)))
+	mux.Handle("POST /api/admin/members/{id}/unlock", admin(http.HandlerFunc(ar.UnlockMember)))
+
+	log.Println("Admin routes registered")
+}
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"loyalty-core/middleware"
+	"loyalty-core/models"
+)
+
+// doForMerchant serves an admin API request on the default merchant's host for another merchant
+func (ts *testServer) doForMerchant(t *testing.T, merchantID, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := newRequest(t, method, defaultHost, path, token, body)
+	req.Header.Set(middleware.MerchantHeader, merchantID)
+	return ts.serve(req)
+}
+
+func TestAdminOperatesAnotherMerchant(t *testing.T) {
+	ts := newTestServer(t)
+	adminToken := ts.admin(t)
+	merchantB, hostB := ts.createMerchant(t)
+	member, memberToken := ts.member(t, hostB)
+	findPath := "/api/admin/members?email=" + url.QueryEscape(member.Email)
+
+	// Without the header, the admin sees the default merchant
+	if rec := ts.do(t, http.MethodGet, defaultHost, findPath, adminToken, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("find at the default merchant: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec := ts.doForMerchant(t, merchantB, http.MethodGet, findPath, adminToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("find: status %d: %s", rec.Code, rec.Body)
+	}
+	var found models.User
+	decodeJSON(t, rec, &found)
+	if found.ID != member.ID || found.MerchantID != merchantB {
+		t.Errorf("found %s of %s, want %s of %s", found.ID, found.MerchantID, member.ID, merchantB)
+	}
+
+	rec = ts.doForMerchant(t, merchantB, http.MethodGet, "/api/admin/members/search?q="+url.QueryEscape(member.Email), adminToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("search: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = ts.doForMerchant(t, merchantB, http.MethodPost, "/api/admin/members/"+member.ID+"/adjustments", adminToken, models.AdjustPointsRequest{
+		Points:     40,
+		ReasonCode: models.AdjustmentReasonGoodwill,
+		Note:       "Late delivery",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("adjust: status %d: %s", rec.Code, rec.Body)
+	}
+	var adjustment models.Adjustment
+	decodeJSON(t, rec, &adjustment)
+	if adjustment.MerchantID != merchantB || adjustment.Status != models.AdjustmentStatusApplied {
+		t.Errorf("adjustment of %s is %s, want applied at %s", adjustment.MerchantID, adjustment.Status, merchantB)
+	}
+
+	rec = ts.do(t, http.MethodGet, hostB, "/api/loyalty/balance", memberToken, nil)
+	var balance models.BalanceResponse
+	decodeJSON(t, rec, &balance)
+	if rec.Code != http.StatusOK || balance.Points != 40 {
+		t.Errorf("member balance: status %d, %d points; want 40", rec.Code, balance.Points)
+	}
+
+	rec = ts.doForMerchant(t, merchantB, http.MethodPost, "/api/admin/members/"+member.ID+"/lock", adminToken, models.LockAccountRequest{Reason: "Fraud review"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("lock: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ts.do(t, http.MethodGet, hostB, "/api/loyalty/balance", memberToken, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("locked member's balance: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestMerchantHeaderIsForAdmins(t *testing.T) {
+	ts := newTestServer(t)
+	adminToken := ts.admin(t)
+	merchantB, hostB := ts.createMerchant(t)
+	_, memberToken := ts.member(t, defaultHost)
+	_, memberTokenB := ts.member(t, hostB)
+
+	if rec := ts.doForMerchant(t, merchantB, http.MethodGet, "/api/admin/members/search?q=member", memberToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("member selecting a merchant: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := ts.doForMerchant(t, "no-such-merchant", http.MethodGet, "/api/admin/members/search?q=member", adminToken, nil); rec.Code != http.StatusNotFound || errorMessage(t, rec) != "merchant not found" {
+		t.Errorf("unknown merchant: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// Outside the admin API the header is ignored, and the host still decides
+	if rec := ts.doForMerchant(t, merchantB, http.MethodGet, "/api/loyalty/balance", memberToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("member endpoint with the header: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ts.doForMerchant(t, merchantB, http.MethodGet, "/api/loyalty/balance", memberTokenB, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("brand B member on the default host: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// Admins still use the default merchant's hosts
+	if rec := ts.do(t, http.MethodGet, hostB, "/api/admin/members/search?q=member", adminToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("admin on another brand's host: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
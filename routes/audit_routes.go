@@ -0,0 +1,134 @@
+package routes
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/middleware"
+	"loyalty-core/models"
+	"loyalty-core/services"
+)
+
+type AuditRoutes struct {
+	auditService *services.AuditService
+	authService  *services.AuthService
+	config       *config.Config
+}
+
+func NewAuditRoutes(cfg *config.Config, auditService *services.AuditService, authService *services.AuthService) *AuditRoutes {
+	return &AuditRoutes{
+		auditService: auditService,
+		authService:  authService,
+		config:       cfg,
+	}
+}
+
+// auditActor describes who made a request, for the audit log. The actor is empty before login.
+func auditActor(r *http.Request) models.AuditActor {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	return models.AuditActor{
+		UserID:       middleware.UserID(r),
+		MerchantID:   middleware.MerchantID(r),
+		IP:           ip,
+		ForwardedFor: r.Header.Get("X-Forwarded-For"),
+		RequestID:    middleware.GetRequestID(r),
+	}
+}
+
+// auditBalance is a member's balance as recorded before and after earns and redemptions
+func auditBalance(loyaltyService *services.LoyaltyService, userID string, transaction *models.Transaction) map[string]interface{} {
+	value := map[string]interface{}{}
+	if balance, err := loyaltyService.GetBalance(userID); err == nil {
+		value["points"] = balance.Points
+	}
+	if transaction != nil {
+		value["transaction"] = transaction.WithoutFreeText()
+	}
+	return value
+}
+
+// ListEntries handles querying the audit log, newest first. Filters: actor, action (or a prefix
+// ending in "." such as admin.), target, merchantId, from/to as in the history filters, and
+// before and limit for paging.
+func (ar *AuditRoutes) ListEntries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	period, err := historyFilterFromQuery(query)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	var limit int64
+	filter := models.AuditFilter{
+		MerchantID: query.Get("merchantId"),
+		ActorID:    query.Get("actor"),
+		Action:     query.Get("action"),
+		TargetID:   query.Get("target"),
+		From:       period.From,
+		To:         period.To,
+	}
+	for _, param := range []struct {
+		name   string
+		target *int64
+	}{{"before", &filter.Before}, {"limit", &limit}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": param.name + " must be a positive number"})
+			return
+		}
+		*param.target = parsed
+	}
+	filter.Limit = int(limit)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ar.auditService.Query(filter))
+}
+
+// Verify handles checking the audit log's hash chain
+func (ar *AuditRoutes) Verify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	verification := ar.auditService.Verify()
+	if !verification.Valid {
+		log.Printf("Audit log verification failed at entry %d: %s", verification.BrokenAtSequence, verification.Error)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(verification)
+}
+
+// Export handles downloading the complete audit log as JSON lines, oldest first
+func (ar *AuditRoutes) Export(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"audit-"+time.Now().UTC().Format("20060102T150405Z")+".jsonl\"")
+	w.WriteHeader(http.StatusOK)
+
+	if err := ar.auditService.Export(w); err != nil {
+		log.Printf("Audit log export failed: %v", err)
+	}
+}
+
+// RegisterRoutes registers the audit log routes, all admin only
+func (ar *AuditRoutes) RegisterRoutes(mux *http.ServeMux) {
+	admin := middleware.RequireAdmin(ar.authService)
+	mux.Handle("GET /api/admin/audit", admin(http.HandlerFunc(ar.ListEntries)))
+	mux.Handle("GET /api/admin/audit/verify", admin(http.HandlerFunc(ar.Verify)))
+	mux.Handle("GET /api/admin/audit/export", admin(http.HandlerFunc(ar.Export)))
+	log.Println("Audit routes registered")
+}
@@ -2,21 +2,27 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
 	"loyalty-core/config"
+	"loyalty-core/middleware"
 	"loyalty-core/models"
 	"loyalty-core/services"
 )
 
 type AuthRoutes struct {
-	authService *services.AuthService
+	authService  *services.AuthService
+	auditService *services.AuditService
+	config       *config.Config
 }
 
-func NewAuthRoutes(cfg *config.Config) *AuthRoutes {
+func NewAuthRoutes(cfg *config.Config, authService *services.AuthService, auditService *services.AuditService) *AuthRoutes {
 	return &AuthRoutes{
-		authService: services.NewAuthService(cfg),
+		authService:  authService,
+		auditService: auditService,
+		config:       cfg,
 	}
 }
 
@@ -24,12 +30,6 @@ func NewAuthRoutes(cfg *config.Config) *AuthRoutes {
 func (ar *AuthRoutes) Signup(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
 	var req models.SignupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -37,6 +37,9 @@ func (ar *AuthRoutes) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Members sign up with the merchant whose host they are on
+	req.MerchantID = middleware.MerchantID(r)
+
 	response, err := ar.authService.SignupUser(req)
 	if err != nil {
 		status := http.StatusBadRequest
@@ -51,6 +54,10 @@ func (ar *AuthRoutes) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	actor := auditActor(r)
+	actor.UserID = response.User.ID
+	ar.auditService.Record(actor, models.AuditActionSignup, models.AuditTargetMember, response.User.ID, nil, response.User)
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(response)
 }
@@ -59,12 +66,6 @@ func (ar *AuthRoutes) Signup(w http.ResponseWriter, r *http.Request) {
 func (ar *AuthRoutes) Login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
 	var req models.LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -72,28 +73,185 @@ func (ar *AuthRoutes) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Members log in to the merchant whose host they are on
+	req.MerchantID = middleware.MerchantID(r)
+
 	response, err := ar.authService.LoginUser(req)
 	if err != nil {
 		status := http.StatusBadRequest
 		if err.Error() == "invalid credentials" {
 			status = http.StatusUnauthorized
+		} else if errors.Is(err, services.ErrAccountLocked) {
+			status = http.StatusForbidden
 		} else if err.Error() == "internal server error" {
 			status = http.StatusInternalServerError
 		}
+		// The attempted email is personal data, so only the member it belongs to is recorded
+		if status != http.StatusInternalServerError {
+			ar.auditService.Record(auditActor(r), models.AuditActionLoginFailed, models.AuditTargetMember,
+				ar.authService.MemberIDByEmail(req.MerchantID, req.Email), nil, map[string]string{"error": err.Error()})
+		}
 
 		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
 
+	actor := auditActor(r)
+	actor.UserID = response.User.ID
+	ar.auditService.Record(actor, models.AuditActionLogin, models.AuditTargetMember, response.User.ID, nil, nil)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
+// ChangePassword handles changing the authenticated user's password
+func (ar *AuthRoutes) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := middleware.UserID(r)
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if err := ar.authService.ChangePassword(userID, middleware.SessionID(r), req); err != nil {
+		status := http.StatusBadRequest
+		if err.Error() == "invalid credentials" {
+			status = http.StatusUnauthorized
+		} else if err.Error() == "internal server error" {
+			status = http.StatusInternalServerError
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// The passwords themselves are never recorded
+	ar.auditService.Record(auditActor(r), models.AuditActionPasswordChange, models.AuditTargetMember, userID, nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully"})
+}
+
+// GetProfile handles reading the authenticated user's profile
+func (ar *AuthRoutes) GetProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, err := ar.authService.GetUserProfile(middleware.UserID(r))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateProfile handles updating the authenticated user's profile
+func (ar *AuthRoutes) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	userID := middleware.UserID(r)
+	before, _ := ar.authService.GetUserProfile(userID)
+
+	user, err := ar.authService.UpdateProfile(userID, req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err.Error() == "user not found" {
+			status = http.StatusNotFound
+		} else if err.Error() == "email already in use" {
+			status = http.StatusConflict
+		} else if err.Error() == "failed to send verification email" {
+			status = http.StatusBadGateway
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ar.auditService.Record(auditActor(r), models.AuditActionProfileUpdate, models.AuditTargetMember, userID, before, user)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// ProfileHistory handles listing the authenticated user's profile change history
+func (ar *AuthRoutes) ProfileHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := middleware.UserID(r)
+
+	changes, err := ar.authService.GetProfileHistory(userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changes": changes,
+		"count":   len(changes),
+	})
+}
+
+// VerifyEmail handles confirming a pending email change
+func (ar *AuthRoutes) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := middleware.UserID(r)
+
+	var req models.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	before, _ := ar.authService.GetUserProfile(userID)
+
+	user, err := ar.authService.VerifyEmail(userID, req.Token)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err.Error() == "email already in use" {
+			status = http.StatusConflict
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ar.auditService.Record(auditActor(r), models.AuditActionEmailVerify, models.AuditTargetMember, userID, before, user)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
 // RegisterRoutes registers all auth routes
-func (ar *AuthRoutes) RegisterRoutes() {
-	http.HandleFunc("/api/auth/signup", ar.Signup)
-	http.HandleFunc("/api/auth/login", ar.Login)
+func (ar *AuthRoutes) RegisterRoutes(mux *http.ServeMux) {
+	auth := middleware.RequireAuth(ar.authService)
+	mux.HandleFunc("POST /api/auth/signup", ar.Signup)
+	mux.HandleFunc("POST /api/auth/login", ar.Login)
+	mux.Handle("POST /api/auth/password/change", auth(http.HandlerFunc(ar.ChangePassword)))
+	mux.Handle("GET /api/auth/profile", auth(http.HandlerFunc(ar.GetProfile)))
+	mux.Handle("PATCH /api/auth/profile", auth(http.HandlerFunc(ar.UpdateProfile)))
+	mux.Handle("GET /api/auth/profile/history", auth(http.HandlerFunc(ar.ProfileHistory)))
+	mux.Handle("POST /api/auth/email/verify", auth(http.HandlerFunc(ar.VerifyEmail)))
 	log.Println("Auth routes registered")
 }
 
@@ -3,29 +3,37 @@ package routes
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"loyalty-core/config"
+	"loyalty-core/middleware"
 	"loyalty-core/models"
 	"loyalty-core/services"
-	"loyalty-core/utils"
 )
 
 type LoyaltyRoutes struct {
-	loyaltyService *services.LoyaltyService
-	authService    *services.AuthService
-	config         *config.Config
+	loyaltyService  *services.LoyaltyService
+	programService  *services.ProgramService
+	locationService *services.LocationService
+	authService     *services.AuthService
+	auditService    *services.AuditService
+	config          *config.Config
 }
 
-func NewLoyaltyRoutes(cfg *config.Config) *LoyaltyRoutes {
-	authService := services.NewAuthService(cfg)
+func NewLoyaltyRoutes(cfg *config.Config, loyaltyService *services.LoyaltyService, programService *services.ProgramService, locationService *services.LocationService, authService *services.AuthService, auditService *services.AuditService) *LoyaltyRoutes {
 	return &LoyaltyRoutes{
-		loyaltyService: services.NewLoyaltyService(cfg),
-		authService:    authService,
-		config:         cfg,
+		loyaltyService:  loyaltyService,
+		programService:  programService,
+		locationService: locationService,
+		authService:     authService,
+		auditService:    auditService,
+		config:          cfg,
 	}
 }
 
@@ -33,19 +41,7 @@ func NewLoyaltyRoutes(cfg *config.Config) *LoyaltyRoutes {
 func (lr *LoyaltyRoutes) EarnPoints(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
-	// Get user ID from token
-	userID, err := lr.getUserIDFromToken(r)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
-	}
+	userID := middleware.UserID(r)
 
 	var req models.EarnRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -54,20 +50,46 @@ func (lr *LoyaltyRoutes) EarnPoints(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate points
-	if req.Points <= 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Points must be greater than 0"})
-		return
-	}
+	before := auditBalance(lr.loyaltyService, userID, nil)
+
+	var transaction *models.Transaction
+	var err error
+	if req.OrderID != "" {
+		if req.Points != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Provide either points or orderId, not both"})
+			return
+		}
+
+		transaction, err = lr.loyaltyService.EarnPointsForOrder(r.Context(), userID, req.OrderID, req.Description, req.LocationID)
+	} else {
+		// Validate points
+		if req.Points <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Points must be greater than 0"})
+			return
+		}
 
-	transaction, err := lr.loyaltyService.EarnPoints(userID, req.Points, req.Description)
+		transaction, err = lr.loyaltyService.EarnPoints(r.Context(), userID, req.Points, req.Description, req.LocationID)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrOrderAlreadyCredited) {
+			status = http.StatusConflict
+		} else if errors.Is(err, services.ErrSquareUnavailable) {
+			status = http.StatusServiceUnavailable
+		} else if errors.Is(err, services.ErrLocationRuleViolation) {
+			status = http.StatusForbidden
+		}
+
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
 
+	lr.auditService.Record(auditActor(r), models.AuditActionEarn, models.AuditTargetTransaction, transaction.ID,
+		before, auditBalance(lr.loyaltyService, userID, transaction))
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(transaction)
 }
@@ -76,19 +98,7 @@ func (lr *LoyaltyRoutes) EarnPoints(w http.ResponseWriter, r *http.Request) {
 func (lr *LoyaltyRoutes) RedeemPoints(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
-	// Get user ID from token
-	userID, err := lr.getUserIDFromToken(r)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
-	}
+	userID := middleware.UserID(r)
 
 	var req models.RedeemRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -97,6 +107,26 @@ func (lr *LoyaltyRoutes) RedeemPoints(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A reward costs its tier's points
+	if req.RewardTierID != "" {
+		tier, err := lr.programService.RewardTier(r.Context(), middleware.MerchantID(r), req.RewardTierID)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if req.Points == 0 {
+			req.Points = tier.Points
+		} else if req.Points != tier.Points {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Reward tier %s costs %d points", tier.ID, tier.Points)})
+			return
+		}
+		if req.Description == "" {
+			req.Description = "Redeemed " + tier.Name
+		}
+	}
+
 	// Validate points
 	if req.Points <= 0 {
 		w.WriteHeader(http.StatusBadRequest)
@@ -104,13 +134,23 @@ func (lr *LoyaltyRoutes) RedeemPoints(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	transaction, err := lr.loyaltyService.RedeemPoints(userID, req.Points, req.Description)
+	before := auditBalance(lr.loyaltyService, userID, nil)
+
+	transaction, err := lr.loyaltyService.RedeemPoints(r.Context(), userID, req.Points, req.Description, req.LocationID, req.RewardTierID)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrLocationRuleViolation) {
+			status = http.StatusForbidden
+		}
+
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
 
+	lr.auditService.Record(auditActor(r), models.AuditActionRedeem, models.AuditTargetTransaction, transaction.ID,
+		before, auditBalance(lr.loyaltyService, userID, transaction))
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(transaction)
 }
@@ -119,19 +159,7 @@ func (lr *LoyaltyRoutes) RedeemPoints(w http.ResponseWriter, r *http.Request) {
 func (lr *LoyaltyRoutes) GetBalance(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
-	// Get user ID from token
-	userID, err := lr.getUserIDFromToken(r)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
-	}
+	userID := middleware.UserID(r)
 
 	balance, err := lr.loyaltyService.GetBalance(userID)
 	if err != nil {
@@ -148,19 +176,7 @@ func (lr *LoyaltyRoutes) GetBalance(w http.ResponseWriter, r *http.Request) {
 func (lr *LoyaltyRoutes) GetHistory(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
-	// Get user ID from token
-	userID, err := lr.getUserIDFromToken(r)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
-	}
+	userID := middleware.UserID(r)
 
 	// Get limit from query parameter
 	limitStr := r.URL.Query().Get("limit")
@@ -171,56 +187,165 @@ func (lr *LoyaltyRoutes) GetHistory(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	transactions, err := lr.loyaltyService.GetTransactionHistory(userID)
+	filter, err := historyFilterFromQuery(r.URL.Query())
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
 
-	// Apply limit
-	if len(transactions) > limit {
-		transactions = transactions[:limit]
+	page, err := lr.loyaltyService.GetTransactionHistory(r.Context(), userID, filter, limit, r.URL.Query().Get("cursor"))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrSquareUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
 	}
 
-	response := map[string]interface{}{
-		"transactions": transactions,
-		"count":        len(transactions),
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
+
+// historyFilterFromQuery reads the history filters: type and location (comma-separated or
+// repeated) and from/to (RFC 3339 timestamps or YYYY-MM-DD dates)
+func historyFilterFromQuery(query url.Values) (models.HistoryFilter, error) {
+	filter := models.HistoryFilter{
+		LocationIDs: queryList(query, "location"),
+	}
+	for _, eventType := range queryList(query, "type") {
+		filter.Types = append(filter.Types, strings.ToUpper(eventType))
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	for _, bound := range []struct {
+		name   string
+		target **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if parsed, err = time.Parse("2006-01-02", value); err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", bound.name)
+			}
+		}
+		*bound.target = &parsed
+	}
+
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+	return filter, nil
 }
 
-// getUserIDFromToken extracts user ID from JWT token
-func (lr *LoyaltyRoutes) getUserIDFromToken(r *http.Request) (string, error) {
-	// Get Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return "", errors.New("authorization header required")
+// queryList collects a query parameter given as a comma-separated list or repeated
+func queryList(query url.Values, key string) []string {
+	var values []string
+	for _, raw := range query[key] {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
 	}
+	return values
+}
+
+// Account handles reading the user's loyalty number and Square provisioning status
+func (lr *LoyaltyRoutes) Account(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := middleware.UserID(r)
+
+	account, err := lr.loyaltyService.GetLoyaltyAccount(userID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(account)
+}
 
-	// Extract token from Bearer header
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	if tokenString == authHeader {
-		return "", errors.New("invalid authorization header format")
+// ProvisionAccount handles retrying Square loyalty account provisioning for the user
+func (lr *LoyaltyRoutes) ProvisionAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := middleware.UserID(r)
+
+	before, _ := lr.loyaltyService.GetLoyaltyAccount(userID)
+
+	account, err := lr.loyaltyService.ProvisionAccount(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
 	}
 
-	// Validate token
-	claims, err := utils.ValidateToken(tokenString, lr.config.JWTSecret)
+	lr.auditService.Record(auditActor(r), models.AuditActionProvision, models.AuditTargetMember, userID, before, account)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(account)
+}
+
+// Program handles describing the loyalty program: how points are earned and what rewards cost.
+// It does not require authentication; the merchant comes from the request host. With a
+// locationId query parameter, only the rewards offered at that location are listed.
+func (lr *LoyaltyRoutes) Program(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	program, err := lr.programService.GetProgramAt(r.Context(), middleware.MerchantID(r), r.URL.Query().Get("locationId"))
 	if err != nil {
-		return "", errors.New("invalid token")
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, services.ErrUnknownLocation) || errors.Is(err, services.ErrLocationInactive) {
+			status = http.StatusBadRequest
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
 	}
 
-	return claims.UserID, nil
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(program)
+}
+
+// Locations handles listing the active locations of the request host's merchant with their
+// loyalty rules. It does not require authentication.
+func (lr *LoyaltyRoutes) Locations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	locations := []models.Location{}
+	for _, location := range lr.locationService.ListLocations(middleware.MerchantID(r)) {
+		if location.Status == models.LocationStatusActive {
+			locations = append(locations, location)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locations": locations,
+		"count":     len(locations),
+	})
 }
 
-// RegisterRoutes registers all loyalty routes
-func (lr *LoyaltyRoutes) RegisterRoutes() {
-	http.HandleFunc("/api/loyalty/earn", lr.EarnPoints)
-	http.HandleFunc("/api/loyalty/redeem", lr.RedeemPoints)
-	http.HandleFunc("/api/loyalty/balance", lr.GetBalance)
-	http.HandleFunc("/api/loyalty/history", lr.GetHistory)
+// RegisterRoutes registers all loyalty routes. The program and locations are public.
+func (lr *LoyaltyRoutes) RegisterRoutes(mux *http.ServeMux) {
+	auth := middleware.RequireAuth(lr.authService)
+	mux.Handle("POST /api/loyalty/earn", auth(http.HandlerFunc(lr.EarnPoints)))
+	mux.Handle("POST /api/loyalty/redeem", auth(http.HandlerFunc(lr.RedeemPoints)))
+	mux.Handle("GET /api/loyalty/balance", auth(http.HandlerFunc(lr.GetBalance)))
+	mux.Handle("GET /api/loyalty/history", auth(http.HandlerFunc(lr.GetHistory)))
+	mux.Handle("GET /api/loyalty/account", auth(http.HandlerFunc(lr.Account)))
+	mux.Handle("POST /api/loyalty/account/provision", auth(http.HandlerFunc(lr.ProvisionAccount)))
+	mux.HandleFunc("GET /api/loyalty/program", lr.Program)
+	mux.HandleFunc("GET /api/loyalty/locations", lr.Locations)
 
 	log.Println("Loyalty routes registered")
 }
@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+func TestRedeemRewardTierAtLocation(t *testing.T) {
+	ts := newTestServer(t)
+	ts.router.programService.AddRewardTiers("", []models.ProgramRewardTier{
+		{ID: "tier-coffee", Name: "Free coffee", Points: 100},
+		{ID: "tier-lunch", Name: "Free lunch", Points: 500},
+	})
+	kiosk := fmt.Sprintf("kiosk-%d", testIDs.Add(1))
+	storage.GetGlobalLocationStorage().SaveLocation(models.Location{
+		ID:         kiosk,
+		MerchantID: models.DefaultMerchantID,
+		Name:       kiosk,
+		Status:     models.LocationStatusActive,
+		Source:     models.LocationSourceConfig,
+		UpdatedAt:  time.Now(),
+	})
+	if _, err := ts.router.locationService.UpdateRules(models.DefaultMerchantID, kiosk, models.LocationRules{RewardTierIDs: []string{"tier-coffee"}}); err != nil {
+		t.Fatalf("UpdateRules: %v", err)
+	}
+
+	_, token := ts.member(t, defaultHost)
+	if rec := ts.do(t, http.MethodPost, defaultHost, "/api/loyalty/earn", token, models.EarnRequest{Points: 600}); rec.Code != http.StatusOK {
+		t.Fatalf("earn: status %d: %s", rec.Code, rec.Body)
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  models.RedeemRequest
+		want int
+	}{
+		{"tier not offered at the location", models.RedeemRequest{RewardTierID: "tier-lunch", LocationID: kiosk}, http.StatusForbidden},
+		{"unknown tier", models.RedeemRequest{RewardTierID: "tier-yacht", LocationID: kiosk}, http.StatusBadRequest},
+		{"points differ from the tier", models.RedeemRequest{RewardTierID: "tier-coffee", Points: 10, LocationID: kiosk}, http.StatusBadRequest},
+	} {
+		if rec := ts.do(t, http.MethodPost, defaultHost, "/api/loyalty/redeem", token, tc.req); rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+	}
+
+	rec := ts.do(t, http.MethodPost, defaultHost, "/api/loyalty/redeem", token, models.RedeemRequest{RewardTierID: "tier-coffee", LocationID: kiosk})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("redeem an offered tier: status %d: %s", rec.Code, rec.Body)
+	}
+	var transaction models.Transaction
+	decodeJSON(t, rec, &transaction)
+	if transaction.Points != 100 || transaction.Description != "Redeemed Free coffee" || transaction.LocationID != kiosk {
+		t.Errorf("redemption = %+v, want 100 points for a free coffee at %s", transaction, kiosk)
+	}
+}
@@ -1,41 +1,217 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 
 	"loyalty-core/config"
+	"loyalty-core/middleware"
+	"loyalty-core/models"
+	"loyalty-core/services"
 )
 
 type MainRouter struct {
-	cfg           *config.Config
-	authRoutes    *AuthRoutes
-	loyaltyRoutes *LoyaltyRoutes
+	cfg                   *config.Config
+	authService           *services.AuthService
+	loyaltyService        *services.LoyaltyService
+	merchantService       *services.MerchantService
+	locationService       *services.LocationService
+	provisioningService   *services.ProvisioningService
+	outboxService         *services.OutboxService
+	reconciliationService *services.ReconciliationService
+	programService        *services.ProgramService
+	seedService           *services.SeedService
+	auditService          *services.AuditService
+	webhookService        *services.OutboundWebhookService
+	authRoutes            *AuthRoutes
+	loyaltyRoutes         *LoyaltyRoutes
+	accountRoutes         *AccountRoutes
+	webhookRoutes         *WebhookRoutes
+	adminRoutes           *AdminRoutes
+	adjustmentRoutes      *AdjustmentRoutes
+	auditRoutes           *AuditRoutes
+	webhookSubscriptions  *WebhookSubscriptionRoutes
+	merchantRoutes        *MerchantRoutes
+	mux                   *http.ServeMux
 }
 
 func NewMainRouter(cfg *config.Config) *MainRouter {
+	// Services are shared by all route groups
+	authService := services.NewAuthService(cfg)
+	merchantService := services.NewMerchantService(cfg)
+	locationService := services.NewLocationService(cfg, merchantService)
+	auditService := services.NewAuditService(cfg)
+	loyaltyService := services.NewLoyaltyService(cfg, merchantService, locationService, auditService)
+	accountService := services.NewAccountService(cfg, authService, loyaltyService)
+	provisioningService := services.NewProvisioningService(cfg, loyaltyService)
+	authService.OnSignup(provisioningService.HandleSignup)
+	programService := services.NewProgramService(cfg, loyaltyService)
+	webhookService := services.NewOutboundWebhookService(cfg, programService)
+	authService.OnSignup(webhookService.HandleSignup)
+	loyaltyService.OnTransaction(webhookService.HandleTransaction)
+	squareWebhookService := services.NewSquareWebhookService(cfg, loyaltyService)
+	squareWebhookService.OnProgramUpdated(programService.HandleProgramUpdated)
+	outboxService := services.NewOutboxService(cfg, loyaltyService)
+	reconciliationService := services.NewReconciliationService(cfg, loyaltyService)
+	adjustmentService := services.NewAdjustmentService(cfg, loyaltyService)
+
 	return &MainRouter{
-		cfg:           cfg,
-		authRoutes:    NewAuthRoutes(cfg),
-		loyaltyRoutes: NewLoyaltyRoutes(cfg),
+		cfg:                   cfg,
+		authService:           authService,
+		loyaltyService:        loyaltyService,
+		merchantService:       merchantService,
+		locationService:       locationService,
+		provisioningService:   provisioningService,
+		outboxService:         outboxService,
+		reconciliationService: reconciliationService,
+		programService:        programService,
+		seedService:           services.NewSeedService(cfg, authService, loyaltyService, programService),
+		auditService:          auditService,
+		webhookService:        webhookService,
+		authRoutes:            NewAuthRoutes(cfg, authService, auditService),
+		loyaltyRoutes:         NewLoyaltyRoutes(cfg, loyaltyService, programService, locationService, authService, auditService),
+		accountRoutes:         NewAccountRoutes(cfg, accountService, authService, auditService),
+		webhookRoutes:         NewWebhookRoutes(cfg, squareWebhookService),
+		adminRoutes:           NewAdminRoutes(cfg, outboxService, reconciliationService, authService, accountService, loyaltyService, auditService),
+		adjustmentRoutes:      NewAdjustmentRoutes(cfg, adjustmentService, authService, auditService),
+		auditRoutes:           NewAuditRoutes(cfg, auditService, authService),
+		webhookSubscriptions:  NewWebhookSubscriptionRoutes(cfg, webhookService, authService, auditService),
+		merchantRoutes:        NewMerchantRoutes(cfg, merchantService, locationService, authService, auditService),
+		mux:                   http.NewServeMux(),
 	}
 }
 
 func (mr *MainRouter) RegisterAllRoutes() {
 	// Register auth routes
-	mr.authRoutes.RegisterRoutes()
+	mr.authRoutes.RegisterRoutes(mr.mux)
 
 	// Register loyalty routes
-	mr.loyaltyRoutes.RegisterRoutes()
+	mr.loyaltyRoutes.RegisterRoutes(mr.mux)
+
+	// Register account routes
+	mr.accountRoutes.RegisterRoutes(mr.mux)
+
+	// Register webhook routes
+	mr.webhookRoutes.RegisterRoutes(mr.mux)
+
+	// Register admin routes
+	mr.adminRoutes.RegisterRoutes(mr.mux)
+
+	// Register adjustment routes
+	mr.adjustmentRoutes.RegisterRoutes(mr.mux)
+
+	// Register audit log routes
+	mr.auditRoutes.RegisterRoutes(mr.mux)
+
+	// Register outbound webhook subscription routes
+	mr.webhookSubscriptions.RegisterRoutes(mr.mux)
+
+	// Register merchant routes
+	mr.merchantRoutes.RegisterRoutes(mr.mux)
 
 	// Register general routes
 	mr.registerGeneralRoutes()
 }
 
+// Handler returns the handler serving all registered routes. Every request passes through the
+// middleware chain: request ID, logging, panic recovery, CORS and the merchant (tenant) lookup.
+// Authentication is applied per route when the routes are registered.
+func (mr *MainRouter) Handler() http.Handler {
+	return middleware.Chain(http.HandlerFunc(mr.serveMux),
+		middleware.RequestID,
+		middleware.Logging,
+		middleware.Recovery,
+		middleware.CORS(mr.cfg.CORSAllowedOrigins),
+		middleware.Tenant(mr.authService, mr.merchantService),
+	)
+}
+
+// serveMux dispatches a request to its route. Requests matching no route get the JSON errors the
+// handlers use rather than ServeMux's plain text ones.
+func (mr *MainRouter) serveMux(w http.ResponseWriter, r *http.Request) {
+	handler, pattern := mr.mux.Handler(r)
+	if pattern != "" {
+		mr.mux.ServeHTTP(w, r)
+		return
+	}
+
+	// Without a pattern, the handler is ServeMux's not found or method not allowed response
+	probe := &statusProbe{header: http.Header{}}
+	handler.ServeHTTP(probe, r)
+
+	w.Header().Set("Content-Type", "application/json")
+	if probe.status == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", probe.header.Get("Allow"))
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Not found"})
+}
+
+// statusProbe is a response writer that only keeps the headers and status code
+type statusProbe struct {
+	header http.Header
+	status int
+}
+
+func (sp *statusProbe) Header() http.Header         { return sp.header }
+func (sp *statusProbe) Write(b []byte) (int, error) { return len(b), nil }
+func (sp *statusProbe) WriteHeader(status int)      { sp.status = status }
+
+// StartWorkers starts the background workers used by the services
+func (mr *MainRouter) StartWorkers() {
+	mr.merchantService.Start()
+	mr.locationService.Start()
+	mr.provisioningService.Start()
+	mr.outboxService.Start()
+	mr.reconciliationService.Start()
+	mr.programService.Start()
+	mr.webhookService.Start()
+}
+
+// StopWorkers stops the background workers
+func (mr *MainRouter) StopWorkers() {
+	mr.webhookService.Stop()
+	mr.programService.Stop()
+	mr.reconciliationService.Stop()
+	mr.outboxService.Stop()
+	mr.provisioningService.Stop()
+	mr.locationService.Stop()
+	mr.merchantService.Stop()
+}
+
+// Seed loads fixtures into storage through the services
+func (mr *MainRouter) Seed(ctx context.Context, fixtures models.Fixtures) (*models.SeedReport, error) {
+	return mr.seedService.Seed(ctx, fixtures)
+}
+
+// OpenAuditLog restores the audit log from AUDIT_LOG_FILE, if set. Call it before serving requests.
+func (mr *MainRouter) OpenAuditLog() error {
+	return mr.auditService.Open()
+}
+
+// CloseAuditLog closes the audit log file. Call it once requests have been drained.
+func (mr *MainRouter) CloseAuditLog() {
+	if err := mr.auditService.Close(); err != nil {
+		log.Printf("Failed to close audit log: %v", err)
+	}
+}
+
+// Flush delivers what can still be delivered before the process exits, until ctx is done. Call it
+// after StopWorkers.
+func (mr *MainRouter) Flush(ctx context.Context) {
+	mr.outboxService.Flush(ctx)
+	mr.webhookService.Flush(ctx)
+}
+
 func (mr *MainRouter) registerGeneralRoutes() {
 	// Root endpoint
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mr.mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, `{
@@ -46,18 +222,20 @@ func (mr *MainRouter) registerGeneralRoutes() {
 		}`, mr.cfg.Port, r.URL.Path)
 	})
 
-	// Health check endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	// Health check endpoint. The server stays healthy while Square is degraded; it runs in
+	// local mode until the circuit breaker lets calls through again.
+	mr.mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{
 			"status":  "healthy",
 			"message": "Server is up and running",
+			"square":  mr.loyaltyService.SquareStatus(),
 		})
 	})
 
 	// API info endpoint
-	http.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {
+	mr.mux.HandleFunc("GET /api/info", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -68,24 +246,86 @@ func (mr *MainRouter) registerGeneralRoutes() {
 	})
 
 	// API endpoints info
-	http.HandleFunc("/api/endpoints", func(w http.ResponseWriter, r *http.Request) {
+	mr.mux.HandleFunc("GET /api/endpoints", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		endpoints := map[string]interface{}{
 			"auth": map[string]string{
-				"signup":  "POST /api/auth/signup",
-				"login":   "POST /api/auth/login",
-				"profile": "GET /api/auth/profile",
+				"signup":         "POST /api/auth/signup",
+				"login":          "POST /api/auth/login",
+				"profile":        "GET /api/auth/profile",
+				"updateProfile":  "PATCH /api/auth/profile",
+				"profileHistory": "GET /api/auth/profile/history",
+				"verifyEmail":    "POST /api/auth/email/verify",
+				"changePassword": "POST /api/auth/password/change",
 			},
 			"loyalty": map[string]string{
-				"earn":    "POST /api/loyalty/earn",
-				"redeem":  "POST /api/loyalty/redeem",
-				"balance": "GET /api/loyalty/balance",
-				"history": "GET /api/loyalty/history",
+				"earn":      "POST /api/loyalty/earn",
+				"redeem":    "POST /api/loyalty/redeem",
+				"balance":   "GET /api/loyalty/balance",
+				"history":   "GET /api/loyalty/history",
+				"account":   "GET /api/loyalty/account",
+				"provision": "POST /api/loyalty/account/provision",
+				"program":   "GET /api/loyalty/program",
+				"locations": "GET /api/loyalty/locations",
+			},
+			"account": map[string]string{
+				"export": "GET /api/account/export",
+				"close":  "POST /api/account/close",
+			},
+			"webhooks": map[string]string{
+				"square": "POST /webhooks/square",
+			},
+			"admin": map[string]string{
+				"outbox":                "GET /api/admin/outbox",
+				"outboxItem":            "GET /api/admin/outbox/{id}",
+				"outboxReplay":          "POST /api/admin/outbox/{id}/replay",
+				"reconcile":             "POST /api/admin/reconciliation/run",
+				"reconciliationReports": "GET /api/admin/reconciliation/reports",
+				"reconciliationReport":  "GET /api/admin/reconciliation/reports/{id}",
+				"findMember":            "GET /api/admin/members",
+				"searchMembers":         "GET /api/admin/members/search",
+				"member":                "GET /api/admin/members/{id}",
+				"memberHistory":         "GET /api/admin/members/{id}/history",
+				"ledger":                "GET /api/admin/members/{id}/ledger",
+				"adjustPoints":          "POST /api/admin/members/{id}/adjustments",
+				"reverseTransaction":    "POST /api/admin/members/{id}/transactions/{transactionId}/reverse",
+				"adjustments":           "GET /api/admin/adjustments",
+				"adjustment":            "GET /api/admin/adjustments/{id}",
+				"approveAdjustment":     "POST /api/admin/adjustments/{id}/approve",
+				"rejectAdjustment":      "POST /api/admin/adjustments/{id}/reject",
+				"lockMember":            "POST /api/admin/members/{id}/lock",
+				"unlockMember":          "POST /api/admin/members/{id}/unlock",
+				"audit":                 "GET /api/admin/audit",
+				"auditVerify":           "GET /api/admin/audit/verify",
+				"auditExport":           "GET /api/admin/audit/export",
+				"webhooks":              "GET /api/admin/webhooks",
+				"createWebhook":         "POST /api/admin/webhooks",
+				"webhook":               "GET /api/admin/webhooks/{id}",
+				"updateWebhook":         "PUT /api/admin/webhooks/{id}",
+				"deleteWebhook":         "DELETE /api/admin/webhooks/{id}",
+				"testWebhook":           "POST /api/admin/webhooks/{id}/test",
+				"webhookDeliveries":     "GET /api/admin/webhooks/{id}/deliveries",
+				"webhookDelivery":       "GET /api/admin/webhooks/{id}/deliveries/{deliveryId}",
+				"replayWebhookDelivery": "POST /api/admin/webhooks/{id}/deliveries/{deliveryId}/replay",
+				"merchants":             "GET /api/admin/merchants",
+				"createMerchant":        "POST /api/admin/merchants",
+				"merchant":              "GET /api/admin/merchants/{id}",
+				"connectMerchant":       "POST /api/admin/merchants/{id}/connect",
+				"refreshMerchantToken":  "POST /api/admin/merchants/{id}/refresh",
+				"merchantSettings":      "PUT /api/admin/merchants/{id}/settings",
+				"locations":             "GET /api/admin/merchants/{id}/locations",
+				"syncLocations":         "POST /api/admin/merchants/{id}/locations/sync",
+				"locationReport":        "GET /api/admin/merchants/{id}/locations/report",
+				"locationRules":         "PUT /api/admin/merchants/{id}/locations/{locationId}",
+			},
+			"oauth": map[string]string{
+				"squareCallback": "GET /oauth/square/callback",
 			},
 			"general": map[string]string{
-				"health": "GET /health",
-				"info":   "GET /api/info",
+				"health":    "GET /health",
+				"info":      "GET /api/info",
+				"endpoints": "GET /api/endpoints",
 			},
 		}
 		json.NewEncoder(w).Encode(endpoints)
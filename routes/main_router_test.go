@@ -0,0 +1,283 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+)
+
+// testPassword passes the default password policy
+const testPassword = "Zq7#kfLw92pX"
+
+// defaultHost is served by the default merchant, as is every host no merchant claims
+const defaultHost = "localhost:8080"
+
+// testIDs keeps the emails and hosts of different tests apart, since storage is shared
+var testIDs atomic.Int64
+
+// testServer serves the full middleware chain and routes in-process
+type testServer struct {
+	router  *MainRouter
+	handler http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	cfg := config.Defaults()
+	cfg.JWTSecret = "test-secret"
+	cfg.AdminEmails = []string{"admin@example.com"}
+	cfg.CORSAllowedOrigins = []string{"https://app.example.com"}
+
+	router := NewMainRouter(cfg)
+	router.RegisterAllRoutes()
+	return &testServer{router: router, handler: router.Handler()}
+}
+
+// do serves a request for host, with a bearer token when token is set and body encoded as JSON
+// when it is not nil
+func (ts *testServer) do(t *testing.T, method, host, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return ts.serve(newRequest(t, method, host, path, token, body))
+}
+
+func newRequest(t *testing.T, method, host, path, token string, body any) *http.Request {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("encode request body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, "http://"+host+path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func (ts *testServer) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// createMerchant creates a brand served on its own host
+func (ts *testServer) createMerchant(t *testing.T) (merchantID, host string) {
+	t.Helper()
+
+	host = fmt.Sprintf("brand-%d.example.com", testIDs.Add(1))
+	merchant, err := ts.router.merchantService.CreateMerchant(models.CreateMerchantRequest{Name: host, Hosts: []string{host}})
+	if err != nil {
+		t.Fatalf("CreateMerchant: %v", err)
+	}
+	return merchant.ID, host
+}
+
+// signup creates a member at the brand served on host
+func (ts *testServer) signup(t *testing.T, host, email string) models.User {
+	t.Helper()
+
+	rec := ts.do(t, http.MethodPost, host, "/api/auth/signup", "", models.SignupRequest{
+		Email:     email,
+		Password:  testPassword,
+		FirstName: "Test",
+		LastName:  "Member",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signup %s at %s: status %d: %s", email, host, rec.Code, rec.Body)
+	}
+	var resp models.SignupResponse
+	decodeJSON(t, rec, &resp)
+	return resp.User
+}
+
+// login signs a member in at the brand served on host and returns the token
+func (ts *testServer) login(t *testing.T, host, email string) string {
+	t.Helper()
+
+	rec := ts.do(t, http.MethodPost, host, "/api/auth/login", "", models.LoginRequest{Email: email, Password: testPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login %s at %s: status %d: %s", email, host, rec.Code, rec.Body)
+	}
+	var resp models.LoginResponse
+	decodeJSON(t, rec, &resp)
+	return resp.Token
+}
+
+// member signs up a new member at the brand served on host and returns it with a token
+func (ts *testServer) member(t *testing.T, host string) (models.User, string) {
+	t.Helper()
+
+	email := fmt.Sprintf("member-%d@example.com", testIDs.Add(1))
+	user := ts.signup(t, host, email)
+	return user, ts.login(t, host, email)
+}
+
+// admin signs in the admin of the default merchant, signing them up on first use
+func (ts *testServer) admin(t *testing.T) string {
+	t.Helper()
+
+	email := ts.router.cfg.AdminEmails[0]
+	rec := ts.do(t, http.MethodPost, defaultHost, "/api/auth/signup", "", models.SignupRequest{
+		Email:     email,
+		Password:  testPassword,
+		FirstName: "Test",
+		LastName:  "Admin",
+	})
+	if rec.Code != http.StatusCreated && rec.Code != http.StatusConflict {
+		t.Fatalf("signup %s: status %d: %s", email, rec.Code, rec.Body)
+	}
+	return ts.login(t, defaultHost, email)
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body, err)
+	}
+}
+
+// errorMessage returns the message of a JSON error response
+func errorMessage(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var resp map[string]string
+	decodeJSON(t, rec, &resp)
+	return resp["error"]
+}
+
+func TestRouterServesThroughMiddlewareChain(t *testing.T) {
+	ts := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+defaultHost+"/health", nil)
+	req.Header.Set("X-Request-ID", "req-chain-1")
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("health: status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("X-Request-ID"); got != "req-chain-1" {
+		t.Errorf("X-Request-ID = %q, want the client's ID", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+
+	// Preflight requests are answered before routing, so they never reach method matching
+	req = httptest.NewRequest(http.MethodOptions, "http://"+defaultHost+"/api/loyalty/earn", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec = httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("preflight: status %d, headers %v", rec.Code, rec.Header())
+	}
+	if rec.Header().Get("X-Request-ID") == "" {
+		t.Error("preflight response has no request ID")
+	}
+}
+
+func TestRouterJSONErrors(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do(t, http.MethodGet, defaultHost, "/api/unknown", "", nil)
+	if rec.Code != http.StatusNotFound || errorMessage(t, rec) != "Not found" {
+		t.Errorf("unknown route: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = ts.do(t, http.MethodDelete, defaultHost, "/api/auth/login", "", nil)
+	if rec.Code != http.StatusMethodNotAllowed || errorMessage(t, rec) != "Method not allowed" {
+		t.Errorf("wrong method: status %d: %s", rec.Code, rec.Body)
+	}
+	if allow := rec.Header().Get("Allow"); !strings.Contains(allow, http.MethodPost) {
+		t.Errorf("Allow = %q, want it to list POST", allow)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestRouterRequiresAuth(t *testing.T) {
+	ts := newTestServer(t)
+	routes := []string{
+		"GET /api/auth/profile",
+		"POST /api/auth/password/change",
+		"POST /api/loyalty/earn",
+		"GET /api/loyalty/balance",
+		"GET /api/account/export",
+		"GET /api/admin/members",
+		"GET /api/admin/audit",
+		"GET /api/admin/merchants",
+	}
+
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		for token, want := range map[string]string{
+			"":          "authorization header required",
+			"not-a-jwt": "invalid token",
+		} {
+			rec := ts.do(t, method, defaultHost, path, token, nil)
+			if rec.Code != http.StatusUnauthorized || errorMessage(t, rec) != want {
+				t.Errorf("%s with token %q: status %d: %s", route, token, rec.Code, rec.Body)
+			}
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+defaultHost+"/api/loyalty/balance", nil)
+	req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || errorMessage(t, rec) != "invalid authorization header format" {
+		t.Errorf("basic auth: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRouterRequiresAdmin(t *testing.T) {
+	ts := newTestServer(t)
+	_, memberToken := ts.member(t, defaultHost)
+	adminToken := ts.admin(t)
+
+	for _, path := range []string{"/api/admin/members/search?q=member", "/api/admin/outbox", "/api/admin/audit", "/api/admin/merchants"} {
+		if rec := ts.do(t, http.MethodGet, defaultHost, path, memberToken, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("%s as member: status %d, want %d", path, rec.Code, http.StatusForbidden)
+		}
+		if rec := ts.do(t, http.MethodGet, defaultHost, path, adminToken, nil); rec.Code != http.StatusOK {
+			t.Errorf("%s as admin: status %d: %s", path, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestRouterRegistersListedEndpoints(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec := ts.do(t, http.MethodGet, defaultHost, "/api/endpoints", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("endpoints: status %d", rec.Code)
+	}
+	var groups map[string]map[string]string
+	decodeJSON(t, rec, &groups)
+
+	for group, endpoints := range groups {
+		for name, endpoint := range endpoints {
+			method, path, _ := strings.Cut(endpoint, " ")
+			path = strings.NewReplacer("{id}", "x", "{transactionId}", "y", "{deliveryId}", "y", "{locationId}", "y").Replace(path)
+			if _, pattern := ts.router.mux.Handler(httptest.NewRequest(method, path, nil)); pattern == "" {
+				t.Errorf("%s.%s: %s is not registered", group, name, endpoint)
+			}
+		}
+	}
+}
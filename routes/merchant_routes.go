@@ -0,0 +1,316 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"loyalty-core/config"
+	"loyalty-core/middleware"
+	"loyalty-core/models"
+	"loyalty-core/services"
+)
+
+type MerchantRoutes struct {
+	merchantService *services.MerchantService
+	locationService *services.LocationService
+	authService     *services.AuthService
+	auditService    *services.AuditService
+	config          *config.Config
+}
+
+func NewMerchantRoutes(cfg *config.Config, merchantService *services.MerchantService, locationService *services.LocationService, authService *services.AuthService, auditService *services.AuditService) *MerchantRoutes {
+	return &MerchantRoutes{
+		merchantService: merchantService,
+		locationService: locationService,
+		authService:     authService,
+		auditService:    auditService,
+		config:          cfg,
+	}
+}
+
+// ListMerchants handles GET /api/admin/merchants
+func (mr *MerchantRoutes) ListMerchants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchants := mr.merchantService.ListMerchants()
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"merchants": merchants,
+		"count":     len(merchants),
+	})
+}
+
+// CreateMerchant handles POST /api/admin/merchants
+func (mr *MerchantRoutes) CreateMerchant(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.CreateMerchantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	merchant, err := mr.merchantService.CreateMerchant(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Merchant %s created by admin %s", merchant.ID, middleware.UserID(r))
+	mr.auditService.Record(auditActor(r), models.AuditActionMerchantCreate, models.AuditTargetMerchant, merchant.ID, nil, merchant)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+// GetMerchant handles GET /api/admin/merchants/{id}
+func (mr *MerchantRoutes) GetMerchant(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchant, err := mr.merchantService.GetMerchant(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+// ConnectMerchant handles POST /api/admin/merchants/{id}/connect
+func (mr *MerchantRoutes) ConnectMerchant(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchantID := r.PathValue("id")
+	response, err := mr.merchantService.ConnectURL(merchantID)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err.Error() == "merchant not found" {
+			status = http.StatusNotFound
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Square connection of merchant %s started by admin %s", merchantID, middleware.UserID(r))
+	// The authorization URL carries the OAuth state, so it is not recorded
+	mr.auditService.Record(auditActor(r), models.AuditActionMerchantConnect, models.AuditTargetMerchant, merchantID, nil, nil)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RefreshMerchantToken handles POST /api/admin/merchants/{id}/refresh
+func (mr *MerchantRoutes) RefreshMerchantToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchantID := r.PathValue("id")
+	before, _ := mr.merchantService.GetMerchant(merchantID)
+	if err := mr.merchantService.RefreshToken(r.Context(), merchantID); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	merchant, err := mr.merchantService.GetMerchant(merchantID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Square token of merchant %s refreshed by admin %s", merchantID, middleware.UserID(r))
+	mr.auditService.Record(auditActor(r), models.AuditActionMerchantTokenRefresh, models.AuditTargetMerchant, merchantID, before, merchant)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+// UpdateMerchantSettings handles PUT /api/admin/merchants/{id}/settings
+func (mr *MerchantRoutes) UpdateMerchantSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var settings models.MerchantSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	merchantID := r.PathValue("id")
+	before, _ := mr.merchantService.GetMerchant(merchantID)
+	merchant, err := mr.merchantService.UpdateSettings(merchantID, settings)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err.Error() == "merchant not found" {
+			status = http.StatusNotFound
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Settings of merchant %s updated by admin %s", merchantID, middleware.UserID(r))
+	mr.auditService.Record(auditActor(r), models.AuditActionMerchantSettings, models.AuditTargetMerchant, merchantID, before, merchant)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+// ListLocations handles GET /api/admin/merchants/{id}/locations
+func (mr *MerchantRoutes) ListLocations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	locations := mr.locationService.ListLocations(r.PathValue("id"))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locations": locations,
+		"count":     len(locations),
+	})
+}
+
+// SyncLocations handles POST /api/admin/merchants/{id}/locations/sync
+func (mr *MerchantRoutes) SyncLocations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchantID := r.PathValue("id")
+	before := mr.locationService.ListLocations(merchantID)
+	locations, err := mr.locationService.Sync(r.Context(), merchantID)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrSquareUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Locations of merchant %s synced by admin %s", merchantID, middleware.UserID(r))
+	mr.auditService.Record(auditActor(r), models.AuditActionLocationSync, models.AuditTargetMerchant, merchantID, before, locations)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locations": locations,
+		"count":     len(locations),
+	})
+}
+
+// LocationReport handles GET /api/admin/merchants/{id}/locations/report, with optional from/to
+// as in the history filters
+func (mr *MerchantRoutes) LocationReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	filter, err := historyFilterFromQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	merchantID := r.PathValue("id")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"merchantId": merchantID,
+		"locations":  mr.locationService.Report(merchantID, filter.From, filter.To),
+	})
+}
+
+// UpdateLocationRules handles PUT /api/admin/merchants/{id}/locations/{locationId}
+func (mr *MerchantRoutes) UpdateLocationRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var rules models.LocationRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	merchantID, locationID := r.PathValue("id"), r.PathValue("locationId")
+	before, _ := mr.locationService.GetLocation(merchantID, locationID)
+	location, err := mr.locationService.UpdateRules(merchantID, locationID, rules)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrUnknownLocation) {
+			status = http.StatusNotFound
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Rules of location %s of merchant %s updated by admin %s", locationID, merchantID, middleware.UserID(r))
+	mr.auditService.Record(auditActor(r), models.AuditActionLocationRules, models.AuditTargetLocation, locationID, before, location)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(location)
+}
+
+// requireMerchant responds 404 for a {id} path value that is not a merchant
+func (mr *MerchantRoutes) requireMerchant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := mr.merchantService.GetMerchant(r.PathValue("id")); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SquareOAuthCallback handles Square's redirect after a merchant authorized (or declined) the
+// connection. It is authenticated by the single-use state created by the connect endpoint.
+func (mr *MerchantRoutes) SquareOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	if squareError := query.Get("error"); squareError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Square authorization failed: " + squareError + " " + query.Get("error_description")})
+		return
+	}
+
+	merchant, err := mr.merchantService.HandleOAuthCallback(r.Context(), query.Get("state"), query.Get("code"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Merchant connected to Square",
+		"merchant": merchant,
+	})
+}
+
+// RegisterRoutes registers the merchant routes. Except for the OAuth callback, they require
+// the admin role.
+func (mr *MerchantRoutes) RegisterRoutes(mux *http.ServeMux) {
+	admin := middleware.RequireAdmin(mr.authService)
+	merchant := func(handler http.HandlerFunc) http.Handler {
+		return admin(mr.requireMerchant(handler))
+	}
+
+	mux.Handle("GET /api/admin/merchants", admin(http.HandlerFunc(mr.ListMerchants)))
+	mux.Handle("POST /api/admin/merchants", admin(http.HandlerFunc(mr.CreateMerchant)))
+	mux.Handle("GET /api/admin/merchants/{id}", admin(http.HandlerFunc(mr.GetMerchant)))
+	mux.Handle("POST /api/admin/merchants/{id}/connect", admin(http.HandlerFunc(mr.ConnectMerchant)))
+	mux.Handle("POST /api/admin/merchants/{id}/refresh", admin(http.HandlerFunc(mr.RefreshMerchantToken)))
+	mux.Handle("PUT /api/admin/merchants/{id}/settings", admin(http.HandlerFunc(mr.UpdateMerchantSettings)))
+	mux.Handle("GET /api/admin/merchants/{id}/locations", merchant(mr.ListLocations))
+	mux.Handle("POST /api/admin/merchants/{id}/locations/sync", merchant(mr.SyncLocations))
+	mux.Handle("GET /api/admin/merchants/{id}/locations/report", merchant(mr.LocationReport))
+	mux.Handle("PUT /api/admin/merchants/{id}/locations/{locationId}", merchant(mr.UpdateLocationRules))
+	mux.HandleFunc("GET /oauth/square/callback", mr.SquareOAuthCallback)
+
+	log.Println("Merchant routes registered")
+}
@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"loyalty-core/models"
+	"loyalty-core/utils"
+)
+
+func TestTenantRejectsTokenOnAnotherBrandsHost(t *testing.T) {
+	ts := newTestServer(t)
+	_, hostA := ts.createMerchant(t)
+	_, hostB := ts.createMerchant(t)
+	_, token := ts.member(t, hostA)
+
+	if rec := ts.do(t, http.MethodGet, hostA, "/api/loyalty/balance", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("balance at own brand: status %d: %s", rec.Code, rec.Body)
+	}
+	for _, host := range []string{hostB, defaultHost} {
+		rec := ts.do(t, http.MethodGet, host, "/api/loyalty/balance", token, nil)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("balance at %s: status %d, want %d", host, rec.Code, http.StatusForbidden)
+			continue
+		}
+		if msg := errorMessage(t, rec); msg != "token belongs to another merchant" {
+			t.Errorf("balance at %s: error %q", host, msg)
+		}
+	}
+}
+
+func TestTenantRejectsTokenNamingAnotherMerchant(t *testing.T) {
+	ts := newTestServer(t)
+	_, hostA := ts.createMerchant(t)
+	merchantB, hostB := ts.createMerchant(t)
+	user, token := ts.member(t, hostA)
+
+	claims, err := utils.ValidateToken(token, ts.router.cfg.JWTSecret)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+
+	// A validly signed token for a live session whose merchant_id was changed to brand B
+	forged, err := utils.GenerateToken(user.ID, user.Email, merchantB, claims.ID, ts.router.cfg.JWTSecret)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	for _, host := range []string{hostA, hostB} {
+		if rec := ts.do(t, http.MethodGet, host, "/api/loyalty/balance", forged, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("forged token at %s: status %d, want %d", host, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestTenantKeepsSameEmailSeparate(t *testing.T) {
+	ts := newTestServer(t)
+	merchantA, hostA := ts.createMerchant(t)
+	merchantB, hostB := ts.createMerchant(t)
+	email := fmt.Sprintf("shared-%d@example.com", testIDs.Add(1))
+
+	userA := ts.signup(t, hostA, email)
+	userB := ts.signup(t, hostB, email)
+	if userA.ID == userB.ID {
+		t.Fatal("the same email at two brands shares one account")
+	}
+	if userA.MerchantID != merchantA || userB.MerchantID != merchantB {
+		t.Errorf("merchants %q and %q, want %q and %q", userA.MerchantID, userB.MerchantID, merchantA, merchantB)
+	}
+
+	// A member of brands A and B is not a member of the default merchant
+	rec := ts.do(t, http.MethodPost, defaultHost, "/api/auth/login", "", models.LoginRequest{Email: email, Password: testPassword})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("login at another brand: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestTenantKeepsLedgersSeparate(t *testing.T) {
+	ts := newTestServer(t)
+	_, hostA := ts.createMerchant(t)
+	_, hostB := ts.createMerchant(t)
+	email := fmt.Sprintf("shared-%d@example.com", testIDs.Add(1))
+	ts.signup(t, hostA, email)
+	ts.signup(t, hostB, email)
+	tokenA := ts.login(t, hostA, email)
+	tokenB := ts.login(t, hostB, email)
+
+	if rec := ts.do(t, http.MethodPost, hostA, "/api/loyalty/earn", tokenA, models.EarnRequest{Points: 25}); rec.Code != http.StatusOK {
+		t.Fatalf("earn at brand A: status %d: %s", rec.Code, rec.Body)
+	}
+
+	balance := func(host, token string) models.BalanceResponse {
+		rec := ts.do(t, http.MethodGet, host, "/api/loyalty/balance", token, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("balance at %s: status %d: %s", host, rec.Code, rec.Body)
+		}
+		var resp models.BalanceResponse
+		decodeJSON(t, rec, &resp)
+		return resp
+	}
+	if got := balance(hostA, tokenA); got.Points != 25 || len(got.Transactions) != 1 {
+		t.Errorf("brand A balance = %d with %d transactions, want 25 with 1", got.Points, len(got.Transactions))
+	}
+	if got := balance(hostB, tokenB); got.Points != 0 || len(got.Transactions) != 0 {
+		t.Errorf("brand B balance = %d with %d transactions, want 0 with none", got.Points, len(got.Transactions))
+	}
+}
@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"loyalty-core/config"
+	"loyalty-core/services"
+)
+
+// maxWebhookBodyBytes bounds the size of an accepted webhook payload
+const maxWebhookBodyBytes = 1 << 20
+
+type WebhookRoutes struct {
+	squareWebhookService *services.SquareWebhookService
+	config               *config.Config
+}
+
+func NewWebhookRoutes(cfg *config.Config, squareWebhookService *services.SquareWebhookService) *WebhookRoutes {
+	return &WebhookRoutes{
+		squareWebhookService: squareWebhookService,
+		config:               cfg,
+	}
+}
+
+// Square handles Square webhook notifications
+func (wr *WebhookRoutes) Square(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !wr.squareWebhookService.Enabled() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Square webhooks are not configured"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if !wr.squareWebhookService.VerifySignature(body, r.Header.Get("x-square-hmacsha256-signature")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid signature"})
+		return
+	}
+
+	if err := wr.squareWebhookService.HandleEvent(body); err != nil {
+		if errors.Is(err, services.ErrDuplicateWebhookEvent) {
+			// Already applied; acknowledge so Square stops redelivering
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]string{"status": "duplicate"})
+			return
+		}
+
+		log.Printf("Square webhook failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "processed"})
+}
+
+// RegisterRoutes registers all webhook routes
+func (wr *WebhookRoutes) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /webhooks/square", wr.Square)
+
+	log.Println("Webhook routes registered")
+}
@@ -0,0 +1,248 @@
+package routes
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"loyalty-core/config"
+	"loyalty-core/middleware"
+	"loyalty-core/models"
+	"loyalty-core/services"
+)
+
+// WebhookSubscriptionRoutes serves the admin API of outbound webhook subscriptions and their
+// deliveries
+type WebhookSubscriptionRoutes struct {
+	webhookService *services.OutboundWebhookService
+	authService    *services.AuthService
+	auditService   *services.AuditService
+	config         *config.Config
+}
+
+func NewWebhookSubscriptionRoutes(cfg *config.Config, webhookService *services.OutboundWebhookService, authService *services.AuthService, auditService *services.AuditService) *WebhookSubscriptionRoutes {
+	return &WebhookSubscriptionRoutes{
+		webhookService: webhookService,
+		authService:    authService,
+		auditService:   auditService,
+		config:         cfg,
+	}
+}
+
+// ListSubscriptions handles GET /api/admin/webhooks
+func (wr *WebhookSubscriptionRoutes) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	subscriptions := wr.webhookService.ListSubscriptions(middleware.MerchantID(r))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"subscriptions": subscriptions,
+		"count":         len(subscriptions),
+		"eventTypes":    models.WebhookEventTypes,
+	})
+}
+
+// CreateSubscription handles POST /api/admin/webhooks. The response is the only one that
+// includes a generated secret.
+func (wr *WebhookSubscriptionRoutes) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.WebhookSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	subscription, err := wr.webhookService.CreateSubscription(middleware.MerchantID(r), req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Webhook subscription %s created by admin %s", subscription.ID, middleware.UserID(r))
+	recorded := *subscription
+	recorded.Secret = ""
+	wr.auditService.Record(auditActor(r), models.AuditActionWebhookCreate, models.AuditTargetWebhookSubscription, subscription.ID, nil, recorded)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+// GetSubscription handles GET /api/admin/webhooks/{id}
+func (wr *WebhookSubscriptionRoutes) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	subscription, err := wr.webhookService.GetSubscription(middleware.MerchantID(r), r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+// UpdateSubscription handles PUT /api/admin/webhooks/{id}. Omitted fields keep their value.
+func (wr *WebhookSubscriptionRoutes) UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.WebhookSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	merchantID, subscriptionID := middleware.MerchantID(r), r.PathValue("id")
+	before, _ := wr.webhookService.GetSubscription(merchantID, subscriptionID)
+
+	subscription, err := wr.webhookService.UpdateSubscription(merchantID, subscriptionID, req)
+	if err != nil {
+		w.WriteHeader(webhookErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	recorded := *subscription
+	recorded.Secret = ""
+	wr.auditService.Record(auditActor(r), models.AuditActionWebhookUpdate, models.AuditTargetWebhookSubscription, subscriptionID, before, recorded)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+// DeleteSubscription handles DELETE /api/admin/webhooks/{id}
+func (wr *WebhookSubscriptionRoutes) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchantID, subscriptionID := middleware.MerchantID(r), r.PathValue("id")
+	before, _ := wr.webhookService.GetSubscription(merchantID, subscriptionID)
+
+	if err := wr.webhookService.DeleteSubscription(merchantID, subscriptionID); err != nil {
+		w.WriteHeader(webhookErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Webhook subscription %s deleted by admin %s", subscriptionID, middleware.UserID(r))
+	wr.auditService.Record(auditActor(r), models.AuditActionWebhookDelete, models.AuditTargetWebhookSubscription, subscriptionID, before, nil)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Webhook subscription deleted"})
+}
+
+// TestSubscription handles POST /api/admin/webhooks/{id}/test, sending a webhook.test event now
+func (wr *WebhookSubscriptionRoutes) TestSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	delivery, err := wr.webhookService.SendTest(middleware.MerchantID(r), r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(webhookErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// ListDeliveries handles listing a subscription's deliveries, newest first, optionally filtered
+// by ?status=pending|delivered|dead
+func (wr *WebhookSubscriptionRoutes) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	deliveries, err := wr.webhookService.ListDeliveries(middleware.MerchantID(r), r.PathValue("id"), r.URL.Query().Get("status"))
+	if err != nil {
+		w.WriteHeader(webhookErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deliveries": deliveries,
+		"count":      len(deliveries),
+	})
+}
+
+// GetDelivery handles GET /api/admin/webhooks/{id}/deliveries/{deliveryId}
+func (wr *WebhookSubscriptionRoutes) GetDelivery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	delivery, err := wr.webhookService.GetDelivery(middleware.MerchantID(r), r.PathValue("id"), r.PathValue("deliveryId"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// ReplayDelivery handles POST /api/admin/webhooks/{id}/deliveries/{deliveryId}/replay
+func (wr *WebhookSubscriptionRoutes) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	merchantID, subscriptionID, deliveryID := middleware.MerchantID(r), r.PathValue("id"), r.PathValue("deliveryId")
+	before, _ := wr.webhookService.GetDelivery(merchantID, subscriptionID, deliveryID)
+
+	delivery, err := wr.webhookService.ReplayDelivery(merchantID, subscriptionID, deliveryID)
+	if err != nil {
+		w.WriteHeader(webhookErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Webhook delivery %s replayed by admin %s", deliveryID, middleware.UserID(r))
+	wr.auditService.Record(auditActor(r), models.AuditActionWebhookReplay, models.AuditTargetWebhookDelivery, deliveryID,
+		webhookDeliveryStatus(before), webhookDeliveryStatus(delivery))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// webhookDeliveryStatus is the part of a delivery recorded in the audit log; the payload is left
+// out, as it may hold member details
+func webhookDeliveryStatus(delivery *models.WebhookDelivery) map[string]interface{} {
+	if delivery == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"subscriptionId": delivery.SubscriptionID,
+		"eventId":        delivery.EventID,
+		"eventType":      delivery.EventType,
+		"status":         delivery.Status,
+		"attempts":       len(delivery.Attempts),
+	}
+}
+
+// webhookErrorStatus maps webhook subscription errors to HTTP statuses
+func webhookErrorStatus(err error) int {
+	switch err.Error() {
+	case "webhook subscription not found", "webhook delivery not found":
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// RegisterRoutes registers the webhook subscription routes. They require the admin role.
+func (wr *WebhookSubscriptionRoutes) RegisterRoutes(mux *http.ServeMux) {
+	admin := middleware.RequireAdmin(wr.authService)
+	mux.Handle("GET /api/admin/webhooks", admin(http.HandlerFunc(wr.ListSubscriptions)))
+	mux.Handle("POST /api/admin/webhooks", admin(http.HandlerFunc(wr.CreateSubscription)))
+	mux.Handle("GET /api/admin/webhooks/{id}", admin(http.HandlerFunc(wr.GetSubscription)))
+	mux.Handle("PUT /api/admin/webhooks/{id}", admin(http.HandlerFunc(wr.UpdateSubscription)))
+	mux.Handle("DELETE /api/admin/webhooks/{id}", admin(http.HandlerFunc(wr.DeleteSubscription)))
+	mux.Handle("POST /api/admin/webhooks/{id}/test", admin(http.HandlerFunc(wr.TestSubscription)))
+	mux.Handle("GET /api/admin/webhooks/{id}/deliveries", admin(http.HandlerFunc(wr.ListDeliveries)))
+	mux.Handle("GET /api/admin/webhooks/{id}/deliveries/{deliveryId}", admin(http.HandlerFunc(wr.GetDelivery)))
+	mux.Handle("POST /api/admin/webhooks/{id}/deliveries/{deliveryId}/replay", admin(http.HandlerFunc(wr.ReplayDelivery)))
+
+	log.Println("Webhook subscription routes registered")
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+const (
+	ClosureBalanceForfeit = "forfeit"
+	ClosureBalancePayout  = "payout"
+
+	// redactedText replaces free text that may contain personal data
+	redactedText = "[redacted]"
+
+	minMemberSearchLength  = 2
+	maxMemberSearchResults = 50
+)
+
+// ErrAccountLocked is returned for operations a locked account may not perform
+var ErrAccountLocked = errors.New("account is locked")
+
+// AccountService handles data-subject requests (account export and closure) and admin
+// account management: member lookup and locking
+type AccountService struct {
+	config         *config.Config
+	userStorage    *storage.UserStorage
+	transactions   *storage.TransactionStorage
+	profileHistory *storage.ProfileHistoryStorage
+	sessions       *storage.SessionStorage
+	adjustments    *storage.AdjustmentStorage
+	authService    *AuthService
+	loyaltyService *LoyaltyService
+}
+
+func NewAccountService(cfg *config.Config, authService *AuthService, loyaltyService *LoyaltyService) *AccountService {
+	return &AccountService{
+		config:         cfg,
+		userStorage:    storage.GetGlobalUserStorage(),
+		transactions:   storage.GetGlobalTransactionStorage(),
+		profileHistory: storage.GetGlobalProfileHistoryStorage(),
+		sessions:       storage.GetGlobalSessionStorage(),
+		adjustments:    storage.GetGlobalAdjustmentStorage(),
+		authService:    authService,
+		loyaltyService: loyaltyService,
+	}
+}
+
+// ExportAccount collects everything held about a member into a single archive. The member's
+// rewards are read from Square, so the export fails rather than leave them out while Square is
+// unreachable.
+func (s *AccountService) ExportAccount(ctx context.Context, userID string) (*models.AccountExport, error) {
+	profile, err := s.authService.GetUserProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rewards, err := s.loyaltyService.ListRewards(ctx, profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rewards: %w", err)
+	}
+
+	export := &models.AccountExport{
+		ExportedAt:     time.Now(),
+		Profile:        *profile,
+		ProfileHistory: s.profileHistory.GetByUserID(userID),
+		Transactions:   s.transactions.GetTransactionsByUserID(profile.MerchantID, userID),
+		Sessions:       s.sessions.GetSessionsByUserID(userID),
+		Rewards:        []models.MemberReward{},
+		Vouchers:       []models.MemberReward{},
+	}
+	for _, reward := range rewards {
+		if reward.Status == models.RewardStatusIssued {
+			export.Vouchers = append(export.Vouchers, reward)
+		} else {
+			export.Rewards = append(export.Rewards, reward)
+		}
+	}
+
+	log.Printf("Account data exported: %s", userID)
+	return export, nil
+}
+
+// CloseAccount settles the balance according to the closure policy, anonymizes the member's
+// personal data and revokes their sessions. Ledger entries are kept with redacted descriptions.
+func (s *AccountService) CloseAccount(ctx context.Context, userID string, req models.CloseAccountRequest) (*models.CloseAccountResponse, error) {
+	if req.Password == "" {
+		return nil, errors.New("password is required")
+	}
+
+	user, err := s.userStorage.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Status == models.AccountStatusClosed {
+		return nil, errors.New("account is closed")
+	}
+
+	if !s.authService.checkPasswordHash(req.Password, user.Password) {
+		return nil, errors.New("invalid credentials")
+	}
+
+	settings := s.loyaltyService.merchants.Settings(user.MerchantID)
+	policy := settings.AccountClosureBalancePolicy
+	if policy != ClosureBalancePayout {
+		policy = ClosureBalanceForfeit
+	}
+
+	points, err := s.loyaltyService.settleClosingAccount(ctx, user, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to settle balance: %w", err)
+	}
+
+	payoutCents := 0
+	if policy == ClosureBalancePayout {
+		payoutCents = points * settings.PointValueCents
+	}
+
+	// Anonymize personal data; the ID is kept so the ledger stays attributable
+	now := time.Now()
+	user.Email = fmt.Sprintf("closed-%s@anonymized.invalid", user.ID)
+	user.EmailVerified = false
+	user.PendingEmail = ""
+	user.EmailVerificationToken = ""
+	user.Password = ""
+	user.PasswordHistory = nil
+	user.FirstName = "Deleted"
+	user.LastName = "Member"
+	user.Phone = ""
+	user.DateOfBirth = ""
+	user.CommunicationPreferences = models.CommunicationPreferences{}
+	user.Status = models.AccountStatusClosed
+	user.ClosedAt = &now
+	user.UpdatedAt = now
+
+	if err := s.userStorage.UpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	s.transactions.RedactDescriptions(user.MerchantID, userID, redactedText)
+	s.profileHistory.Redact(userID, redactedText)
+	s.sessions.RevokeUserSessions(userID)
+
+	log.Printf("Account closed: %s (%d points %s)", userID, points, policy)
+	return &models.CloseAccountResponse{
+		Message:       "Account closed successfully",
+		BalancePolicy: policy,
+		Points:        points,
+		PayoutCents:   payoutCents,
+	}, nil
+}
+
+// FindMember looks up a member of the merchant by email or by loyalty number; the password hash
+// is left out
+func (s *AccountService) FindMember(merchantID, email, loyaltyID string) (*models.User, error) {
+	var user *models.User
+	var err error
+	switch {
+	case email != "":
+		user, err = s.userStorage.GetUserByEmail(merchantID, email)
+	case loyaltyID != "":
+		user, err = s.userStorage.GetUserByLoyaltyID(merchantID, loyaltyID)
+	default:
+		return nil, errors.New("email or loyaltyId is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return memberView(user), nil
+}
+
+// GetMember returns a member of the merchant, without the password hash
+func (s *AccountService) GetMember(merchantID, userID string) (*models.User, error) {
+	user, err := s.userStorage.GetMerchantUserByID(merchantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return memberView(user), nil
+}
+
+// SearchMembers finds up to limit members of the merchant whose email, name, phone or loyalty
+// number contains the query, ignoring case. Results are ordered by email.
+func (s *AccountService) SearchMembers(merchantID, query string, limit int) ([]models.User, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if len(query) < minMemberSearchLength {
+		return nil, fmt.Errorf("search query must be at least %d characters", minMemberSearchLength)
+	}
+	if limit <= 0 || limit > maxMemberSearchResults {
+		limit = maxMemberSearchResults
+	}
+
+	members := []models.User{}
+	for _, user := range s.userStorage.GetMerchantUsers(merchantID) {
+		fields := []string{user.Email, user.FirstName + " " + user.LastName, user.Phone, user.LoyaltyID}
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), query) {
+				members = append(members, *memberView(user))
+				break
+			}
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Email < members[j].Email
+	})
+	if len(members) > limit {
+		members = members[:limit]
+	}
+	return members, nil
+}
+
+// MemberHistory returns a member of the merchant with their complete ledger, adjustments and
+// profile changes
+func (s *AccountService) MemberHistory(merchantID, userID string) (*models.MemberHistory, error) {
+	member, err := s.GetMember(merchantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := s.transactions.GetTransactionsByUserID(member.MerchantID, member.ID)
+	slices.Reverse(ledger)
+
+	return &models.MemberHistory{
+		Member:         *member,
+		Transactions:   ledger,
+		Adjustments:    s.adjustments.ListAdjustments(member.MerchantID, member.ID, ""),
+		ProfileChanges: s.profileHistory.GetByUserID(member.ID),
+	}, nil
+}
+
+// LockAccount suspends a member of the merchant: they cannot sign in, earn or redeem, and their
+// sessions are revoked. Admins can still adjust the balance of a locked account.
+func (s *AccountService) LockAccount(merchantID, userID, reason string) (*models.User, error) {
+	user, err := s.userStorage.GetMerchantUserByID(merchantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch user.Status {
+	case models.AccountStatusClosed:
+		return nil, errors.New("account is closed")
+	case models.AccountStatusLocked:
+		return nil, ErrAccountLocked
+	}
+
+	now := time.Now()
+	user.Status = models.AccountStatusLocked
+	user.LockReason = reason
+	user.LockedAt = &now
+	user.UpdatedAt = now
+	if err := s.userStorage.UpdateUser(user); err != nil {
+		return nil, err
+	}
+	s.sessions.RevokeUserSessions(userID)
+
+	log.Printf("Account locked: %s (%s)", userID, reason)
+	return memberView(user), nil
+}
+
+// UnlockAccount reactivates a locked member of the merchant
+func (s *AccountService) UnlockAccount(merchantID, userID string) (*models.User, error) {
+	user, err := s.userStorage.GetMerchantUserByID(merchantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Status != models.AccountStatusLocked {
+		return nil, errors.New("account is not locked")
+	}
+
+	user.Status = models.AccountStatusActive
+	user.LockReason = ""
+	user.LockedAt = nil
+	user.UpdatedAt = time.Now()
+	if err := s.userStorage.UpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Account unlocked: %s", userID)
+	return memberView(user), nil
+}
+
+// memberView copies a user for an admin response, leaving out the password hash
+func memberView(user *models.User) *models.User {
+	view := *user
+	view.Password = ""
+	return &view
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"loyalty-core/models"
+	"loyalty-core/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestAccountService(sc *squareScenario) *AccountService {
+	return NewAccountService(sc.cfg, NewAuthService(sc.cfg), sc.loyalty)
+}
+
+func TestExportAccountIncludesRewardsAndVouchers(t *testing.T) {
+	sc := newSquareScenario(t)
+	accounts := newTestAccountService(sc)
+	user := sc.newMember(t)
+	sc.fake.SeedAccount(user.Phone, 300)
+	if _, err := sc.loyalty.ProvisionAccount(context.Background(), user.ID); err != nil {
+		t.Fatalf("ProvisionAccount: %v", err)
+	}
+	ctx := context.Background()
+
+	// Two coffees: one applied to an order at the POS, one still to be used
+	redeemed, err := sc.square.CreateLoyaltyReward(ctx, user.SquareAccountID, "tier-coffee", "order-1")
+	if err != nil {
+		t.Fatalf("CreateLoyaltyReward: %v", err)
+	}
+	issued, err := sc.square.CreateLoyaltyReward(ctx, user.SquareAccountID, "tier-coffee", "order-2")
+	if err != nil {
+		t.Fatalf("CreateLoyaltyReward: %v", err)
+	}
+	resp, err := http.Post(sc.cfg.SquareBaseURL+"/v2/loyalty/rewards/"+*redeemed.ID+"/redeem", "application/json", bytes.NewBufferString(`{"location_id":"fake-location"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("redeem reward: %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	export, err := accounts.ExportAccount(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("ExportAccount: %v", err)
+	}
+	if len(export.Vouchers) != 1 || export.Vouchers[0].ID != *issued.ID || export.Vouchers[0].Status != models.RewardStatusIssued {
+		t.Errorf("vouchers = %+v, want the issued reward %s", export.Vouchers, *issued.ID)
+	}
+	if len(export.Rewards) != 1 || export.Rewards[0].ID != *redeemed.ID || export.Rewards[0].Status != models.RewardStatusRedeemed {
+		t.Errorf("rewards = %+v, want the redeemed reward %s", export.Rewards, *redeemed.ID)
+	}
+	if reward := export.Vouchers[0]; reward.RewardTierID != "tier-coffee" || reward.Points != 100 || reward.OrderID != "order-2" || reward.CreatedAt == nil {
+		t.Errorf("voucher = %+v", reward)
+	}
+	if export.Profile.ID != user.ID || export.Profile.Password != "" {
+		t.Errorf("profile = %+v, want the member without a password hash", export.Profile)
+	}
+}
+
+func TestExportAccountFailsWhenRewardsCannotBeRead(t *testing.T) {
+	sc := newSquareScenario(t)
+	accounts := newTestAccountService(sc)
+	user := sc.provisionedMember(t)
+	sc.fake.FailNext("search_rewards", http.StatusInternalServerError)
+
+	if export, err := accounts.ExportAccount(context.Background(), user.ID); err == nil {
+		t.Fatalf("ExportAccount = %+v, want an error rather than an export without rewards", export)
+	}
+}
+
+func TestExportAccountWithoutSquareAccount(t *testing.T) {
+	sc := newSquareScenario(t)
+	accounts := newTestAccountService(sc)
+	user := sc.newMember(t)
+	storage.GetGlobalSessionStorage().CreateSession(&models.Session{ID: "session-" + user.ID, UserID: user.ID, IssuedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+
+	export, err := accounts.ExportAccount(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("ExportAccount: %v", err)
+	}
+	if export.Rewards == nil || export.Vouchers == nil || len(export.Rewards)+len(export.Vouchers) != 0 {
+		t.Errorf("rewards %v and vouchers %v, want empty lists", export.Rewards, export.Vouchers)
+	}
+	if len(export.Sessions) != 1 || export.Sessions[0].UserID != user.ID {
+		t.Errorf("sessions = %+v, want the member's session", export.Sessions)
+	}
+}
+
+func TestCloseAccountSettlesAndAnonymizes(t *testing.T) {
+	sc := newSquareScenario(t)
+	sc.cfg.AccountClosureBalancePolicy = ClosureBalancePayout
+	sc.cfg.PointValueCents = 2
+	accounts := newTestAccountService(sc)
+	user := sc.newMember(t)
+	sc.fake.SeedAccount(user.Phone, 150)
+	if _, err := sc.loyalty.ProvisionAccount(context.Background(), user.ID); err != nil {
+		t.Fatalf("ProvisionAccount: %v", err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	user.Password = string(hash)
+	user.Points = 150
+	if _, err := sc.loyalty.recordTransaction(user, models.Transaction{ID: "txn-" + user.ID, UserID: user.ID, Type: models.TransactionTypeEarn, Points: 150, Description: "Birthday treat for Scenario"}, false); err != nil {
+		t.Fatalf("recordTransaction: %v", err)
+	}
+	account, email := user.SquareAccountID, user.Email
+	storage.GetGlobalSessionStorage().CreateSession(&models.Session{ID: "session-" + user.ID, UserID: user.ID, IssuedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+
+	if _, err := accounts.CloseAccount(context.Background(), user.ID, models.CloseAccountRequest{Password: "Wrong#Pass99"}); err == nil {
+		t.Fatal("CloseAccount accepted a wrong password")
+	}
+	resp, err := accounts.CloseAccount(context.Background(), user.ID, models.CloseAccountRequest{Password: testPassword})
+	if err != nil {
+		t.Fatalf("CloseAccount: %v", err)
+	}
+	if resp.BalancePolicy != ClosureBalancePayout || resp.Points != 150 || resp.PayoutCents != 300 {
+		t.Errorf("response = %+v, want 150 points paid out as 300 cents", resp)
+	}
+
+	if balance, _ := sc.fake.Account(account); balance.Balance != 0 {
+		t.Errorf("Square balance = %d, want 0", balance.Balance)
+	}
+	closed, err := storage.GetGlobalUserStorage().GetUserByID(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if closed.Status != models.AccountStatusClosed || closed.Points != 0 || closed.SquareAccountID != "" || closed.Password != "" {
+		t.Errorf("closed member = %+v", closed)
+	}
+	if closed.Email == email || closed.Phone != "" || closed.FirstName == "Scenario" {
+		t.Errorf("personal data kept: %q, %q, %q", closed.Email, closed.Phone, closed.FirstName)
+	}
+	for _, transaction := range storage.GetGlobalTransactionStorage().GetTransactionsByUserID(closed.MerchantID, closed.ID) {
+		if transaction.Description != redactedText {
+			t.Errorf("transaction %s description %q, want it redacted", transaction.ID, transaction.Description)
+		}
+	}
+	for _, session := range storage.GetGlobalSessionStorage().GetSessionsByUserID(user.ID) {
+		if session.RevokedAt == nil {
+			t.Errorf("session %s still valid", session.ID)
+		}
+	}
+
+	if _, err := accounts.CloseAccount(context.Background(), user.ID, models.CloseAccountRequest{Password: testPassword}); err == nil {
+		t.Error("a closed account was closed again")
+	}
+}
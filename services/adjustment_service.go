@@ -0,0 +1,266 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+var (
+	// ErrTransactionNotFound is returned for a transaction that is not in the member's ledger
+	ErrTransactionNotFound = errors.New("transaction not found")
+	// ErrTransactionAlreadyReversed is returned when reversing a transaction a second time
+	ErrTransactionAlreadyReversed = errors.New("transaction has already been reversed")
+	// ErrAdjustmentNotPending is returned when reviewing an adjustment that was already applied or rejected
+	ErrAdjustmentNotPending = errors.New("adjustment is not pending approval")
+	// ErrSelfApproval is returned when an admin reviews their own adjustment
+	ErrSelfApproval = errors.New("adjustments must be reviewed by a second admin")
+)
+
+// maxAdjustmentNoteLength limits the free-text note of an adjustment
+const maxAdjustmentNoteLength = 1000
+
+// AdjustmentService handles customer-service adjustments of member balances. Each adjustment has
+// a reason code and a note; adjustments of more points than ADJUSTMENT_APPROVAL_THRESHOLD wait
+// for a second admin's approval. Applied adjustments are "adjustment" transactions, mirrored to
+// Square's AdjustLoyaltyPoints through the outbox.
+type AdjustmentService struct {
+	config         *config.Config
+	loyaltyService *LoyaltyService
+	userStorage    *storage.UserStorage
+	transactions   *storage.TransactionStorage
+	adjustments    *storage.AdjustmentStorage
+	mu             sync.Mutex // serializes status changes, so an adjustment is applied once
+}
+
+func NewAdjustmentService(cfg *config.Config, loyaltyService *LoyaltyService) *AdjustmentService {
+	return &AdjustmentService{
+		config:         cfg,
+		loyaltyService: loyaltyService,
+		userStorage:    storage.GetGlobalUserStorage(),
+		transactions:   storage.GetGlobalTransactionStorage(),
+		adjustments:    storage.GetGlobalAdjustmentStorage(),
+	}
+}
+
+// RequestAdjustment records an admin's adjustment of a member of the merchant. It is applied
+// right away unless it needs approval, in which case it is returned pending.
+func (s *AdjustmentService) RequestAdjustment(merchantID, adminID, userID string, req models.AdjustPointsRequest) (*models.Adjustment, error) {
+	if req.Points == 0 {
+		return nil, errors.New("points must not be zero")
+	}
+
+	return s.request(merchantID, adminID, userID, models.Adjustment{
+		Points:     req.Points,
+		ReasonCode: req.ReasonCode,
+		Note:       strings.TrimSpace(req.Note),
+	})
+}
+
+// RequestReversal records an adjustment undoing a ledger transaction of a member of the
+// merchant. Each transaction can be reversed once; reversals themselves cannot be.
+func (s *AdjustmentService) RequestReversal(merchantID, adminID, userID, transactionID string, req models.ReverseTransactionRequest) (*models.Adjustment, error) {
+	user, err := s.userStorage.GetMerchantUserByID(merchantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var original *models.Transaction
+	ledger := s.transactions.GetTransactionsByUserID(user.MerchantID, user.ID)
+	for i := range ledger {
+		if ledger[i].ID == transactionID {
+			original = &ledger[i]
+		}
+	}
+	if original == nil {
+		return nil, ErrTransactionNotFound
+	}
+	if original.ReversesID != "" {
+		return nil, errors.New("a reversal cannot be reversed; post an adjustment instead")
+	}
+	if original.SignedPoints() == 0 {
+		return nil, errors.New("transaction did not change the balance")
+	}
+
+	return s.request(merchantID, adminID, userID, models.Adjustment{
+		Points:     -original.SignedPoints(),
+		ReasonCode: req.ReasonCode,
+		Note:       strings.TrimSpace(req.Note),
+		ReversesID: transactionID,
+	})
+}
+
+// request validates a new adjustment and applies it or leaves it pending approval
+func (s *AdjustmentService) request(merchantID, adminID, userID string, adjustment models.Adjustment) (*models.Adjustment, error) {
+	if !slices.Contains(models.AdjustmentReasonCodes, adjustment.ReasonCode) {
+		return nil, fmt.Errorf("reasonCode must be one of %s", strings.Join(models.AdjustmentReasonCodes, ", "))
+	}
+	if adjustment.Note == "" {
+		return nil, errors.New("note is required")
+	}
+	if len(adjustment.Note) > maxAdjustmentNoteLength {
+		return nil, fmt.Errorf("note cannot be longer than %d characters", maxAdjustmentNoteLength)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.loyaltyService.getOpenMerchantUser(merchantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Points+adjustment.Points < 0 {
+		return nil, errors.New("insufficient points")
+	}
+	if adjustment.ReversesID != "" && s.isReversed(user, adjustment.ReversesID) {
+		return nil, ErrTransactionAlreadyReversed
+	}
+
+	adjustment.ID = s.loyaltyService.generateID()
+	adjustment.MerchantID = user.MerchantID
+	adjustment.UserID = user.ID
+	adjustment.RequestedBy = adminID
+	adjustment.RequestedAt = time.Now()
+
+	if abs(adjustment.Points) > s.config.AdjustmentApprovalThreshold {
+		adjustment.Status = models.AdjustmentStatusPending
+		s.adjustments.SaveAdjustment(adjustment)
+		log.Printf("Adjustment %s of %d points for member %s by admin %s is pending approval", adjustment.ID, adjustment.Points, user.ID, adminID)
+		return &adjustment, nil
+	}
+
+	if err := s.apply(user, &adjustment); err != nil {
+		return nil, err
+	}
+	return &adjustment, nil
+}
+
+// Approve applies a pending adjustment on behalf of a second admin
+func (s *AdjustmentService) Approve(merchantID, adminID, adjustmentID string, req models.ReviewAdjustmentRequest) (*models.Adjustment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	adjustment, err := s.pending(merchantID, adminID, adjustmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.loyaltyService.getOpenMerchantUser(adjustment.MerchantID, adjustment.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	adjustment.ReviewedBy = adminID
+	adjustment.ReviewedAt = &now
+	adjustment.ReviewNote = strings.TrimSpace(req.Note)
+	if err := s.apply(user, adjustment); err != nil {
+		return nil, err
+	}
+	return adjustment, nil
+}
+
+// Reject closes a pending adjustment without applying it
+func (s *AdjustmentService) Reject(merchantID, adminID, adjustmentID string, req models.ReviewAdjustmentRequest) (*models.Adjustment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	adjustment, err := s.pending(merchantID, adminID, adjustmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	adjustment.Status = models.AdjustmentStatusRejected
+	adjustment.ReviewedBy = adminID
+	adjustment.ReviewedAt = &now
+	adjustment.ReviewNote = strings.TrimSpace(req.Note)
+	s.adjustments.SaveAdjustment(*adjustment)
+
+	log.Printf("Adjustment %s rejected by admin %s", adjustment.ID, adminID)
+	return adjustment, nil
+}
+
+// GetAdjustment returns one of the merchant's adjustments
+func (s *AdjustmentService) GetAdjustment(merchantID, adjustmentID string) (*models.Adjustment, error) {
+	return s.adjustments.GetAdjustment(merchantID, adjustmentID)
+}
+
+// ListAdjustments returns the merchant's adjustments, newest first, optionally of one status
+func (s *AdjustmentService) ListAdjustments(merchantID, status string) []models.Adjustment {
+	return s.adjustments.ListAdjustments(merchantID, "", status)
+}
+
+// pending retrieves an adjustment the admin may review
+func (s *AdjustmentService) pending(merchantID, adminID, adjustmentID string) (*models.Adjustment, error) {
+	adjustment, err := s.adjustments.GetAdjustment(merchantID, adjustmentID)
+	if err != nil {
+		return nil, err
+	}
+	if adjustment.Status != models.AdjustmentStatusPending {
+		return nil, ErrAdjustmentNotPending
+	}
+	if adjustment.RequestedBy == adminID {
+		return nil, ErrSelfApproval
+	}
+	return adjustment, nil
+}
+
+// apply posts an adjustment to the member's ledger and queues its Square write
+func (s *AdjustmentService) apply(user *models.User, adjustment *models.Adjustment) error {
+	description := adjustment.Note
+	if adjustment.ReversesID != "" {
+		description = fmt.Sprintf("Reversal of %s: %s", adjustment.ReversesID, adjustment.Note)
+	}
+	reason := adjustment.ReasonCode + ": " + description
+
+	transaction, err := s.loyaltyService.recordWithOutbox(user, models.Transaction{
+		ID:           s.loyaltyService.generateID(),
+		UserID:       user.ID,
+		Type:         models.TransactionTypeAdjustment,
+		Points:       adjustment.Points,
+		Description:  description,
+		Source:       models.TransactionSourceLocal,
+		Reason:       reason,
+		ReversesID:   adjustment.ReversesID,
+		AdjustmentID: adjustment.ID,
+		CreatedAt:    time.Now(),
+	}, models.OutboxItem{
+		Operation: models.OutboxOperationAdjustPoints,
+		Points:    adjustment.Points,
+		Reason:    reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	adjustment.Status = models.AdjustmentStatusApplied
+	adjustment.TransactionID = transaction.ID
+	s.adjustments.SaveAdjustment(*adjustment)
+
+	log.Printf("Adjustment %s of %d points (%s) applied to member %s", adjustment.ID, adjustment.Points, adjustment.ReasonCode, user.ID)
+	return nil
+}
+
+// isReversed reports whether a transaction has been reversed or has a reversal pending approval
+func (s *AdjustmentService) isReversed(user *models.User, transactionID string) bool {
+	for _, transaction := range s.transactions.GetTransactionsByUserID(user.MerchantID, user.ID) {
+		if transaction.ReversesID == transactionID {
+			return true
+		}
+	}
+	for _, adjustment := range s.adjustments.ListAdjustments(user.MerchantID, user.ID, models.AdjustmentStatusPending) {
+		if adjustment.ReversesID == transactionID {
+			return true
+		}
+	}
+	return false
+}
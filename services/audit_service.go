@@ -0,0 +1,307 @@
+package services
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// AuditService keeps the tamper-evident audit log of state-changing operations. Entries are
+// hash-chained in memory and, when AUDIT_LOG_FILE is set, also appended to that file as JSON
+// lines, from which the log is restored at startup.
+type AuditService struct {
+	config      *config.Config
+	entries     *storage.AuditStorage
+	userStorage *storage.UserStorage
+	file        *os.File
+	mu          sync.Mutex // keeps the file in the order of the chain
+}
+
+// NewAuditService creates the audit service. The log starts empty; Open restores it from
+// AUDIT_LOG_FILE.
+func NewAuditService(cfg *config.Config) *AuditService {
+	return &AuditService{
+		config:      cfg,
+		entries:     storage.GetGlobalAuditStorage(),
+		userStorage: storage.GetGlobalUserStorage(),
+	}
+}
+
+// Open restores the log from AUDIT_LOG_FILE, verifies it and keeps the file open for appending.
+// It does nothing when no file is configured. A file that cannot be opened or parsed is an error;
+// a broken chain is only logged, as the evidence must be kept.
+func (s *AuditService) Open() error {
+	if s.config.AuditLogFile == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(s.config.AuditLogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	restored, err := readAuditEntries(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to read audit log %s: %w", s.config.AuditLogFile, err)
+	}
+
+	s.mu.Lock()
+	s.entries.Restore(restored)
+	s.file = file
+	s.mu.Unlock()
+
+	if verification := s.Verify(); !verification.Valid {
+		log.Printf("WARNING: audit log %s failed verification at entry %d: %s", s.config.AuditLogFile, verification.BrokenAtSequence, verification.Error)
+	}
+	log.Printf("Audit log restored from %s (%d entries)", s.config.AuditLogFile, len(restored))
+	return nil
+}
+
+// Record appends an entry for an operation to the audit log. before and after are the changed
+// values, encoded as JSON; either may be nil. Members' personal data is never recorded.
+func (s *AuditService) Record(actor models.AuditActor, action, targetType, targetID string, before, after interface{}) {
+	entry := models.AuditEntry{
+		ID:           s.generateID(),
+		Time:         time.Now().UTC(),
+		MerchantID:   models.MerchantIDOrDefault(actor.MerchantID),
+		ActorID:      actor.UserID,
+		IP:           actor.IP,
+		ForwardedFor: actor.ForwardedFor,
+		RequestID:    actor.RequestID,
+		Action:       action,
+		TargetType:   targetType,
+		TargetID:     targetID,
+		Before:       auditValue(before),
+		After:        auditValue(after),
+	}
+	if actor.System {
+		entry.ActorRole = models.AuditRoleSystem
+	} else if actor.UserID != "" {
+		if user, err := s.userStorage.GetUserByID(actor.UserID); err == nil {
+			entry.ActorRole = user.Role
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry = s.entries.Append(entry)
+	if s.file != nil {
+		line, _ := json.Marshal(entry)
+		if _, err := s.file.Write(append(line, '\n')); err != nil {
+			log.Printf("Failed to write audit entry %d to %s: %v", entry.Sequence, s.config.AuditLogFile, err)
+		}
+	}
+}
+
+// Query returns one page of entries matching the filter, newest first
+func (s *AuditService) Query(filter models.AuditFilter) *models.AuditPage {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditPageSize
+	}
+	if filter.Limit > maxAuditPageSize {
+		filter.Limit = maxAuditPageSize
+	}
+
+	page := &models.AuditPage{Entries: []models.AuditEntry{}}
+	entries := s.entries.Entries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !auditEntryMatches(entry, filter) {
+			continue
+		}
+		if len(page.Entries) == filter.Limit {
+			page.NextBefore = page.Entries[len(page.Entries)-1].Sequence
+			break
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	page.Count = len(page.Entries)
+	return page
+}
+
+// Export writes the complete log, oldest first, as JSON lines. The export can be verified on its
+// own by recomputing the hash chain.
+func (s *AuditService) Export(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, entry := range s.entries.Entries() {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify recomputes the hash chain and reports the first entry that does not match it
+func (s *AuditService) Verify() models.AuditVerification {
+	entries := s.entries.Entries()
+	verification := models.AuditVerification{Valid: true, Entries: len(entries)}
+
+	prevHash := ""
+	for i, entry := range entries {
+		var problem string
+		switch {
+		case entry.Sequence != int64(i)+1:
+			problem = fmt.Sprintf("expected sequence %d, found %d", i+1, entry.Sequence)
+		case entry.PrevHash != prevHash:
+			problem = "previous hash does not match the preceding entry"
+		case entry.Hash != entry.ComputeHash():
+			problem = "entry content does not match its hash"
+		}
+		if problem != "" {
+			verification.Valid = false
+			verification.BrokenAtSequence = int64(i) + 1
+			verification.Error = problem
+			return verification
+		}
+		prevHash = entry.Hash
+	}
+
+	verification.LastHash = prevHash
+	return verification
+}
+
+// Close closes the audit log file
+func (s *AuditService) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// auditEntryMatches applies an audit filter to an entry
+func auditEntryMatches(entry models.AuditEntry, filter models.AuditFilter) bool {
+	if filter.Before > 0 && entry.Sequence >= filter.Before {
+		return false
+	}
+	if filter.MerchantID != "" && entry.MerchantID != filter.MerchantID {
+		return false
+	}
+	if filter.ActorID != "" && entry.ActorID != filter.ActorID {
+		return false
+	}
+	if filter.TargetID != "" && entry.TargetID != filter.TargetID {
+		return false
+	}
+	if filter.Action != "" {
+		if strings.HasSuffix(filter.Action, ".") {
+			if !strings.HasPrefix(entry.Action, filter.Action) {
+				return false
+			}
+		} else if entry.Action != filter.Action {
+			return false
+		}
+	}
+	if filter.From != nil && entry.Time.Before(*filter.From) {
+		return false
+	}
+	if filter.To != nil && !entry.Time.Before(*filter.To) {
+		return false
+	}
+	return true
+}
+
+// auditValue encodes a before or after value. Members are recorded as models.AuditMember, and
+// transactions and outbox items without their free text, so the log holds no personal data.
+func auditValue(value interface{}) json.RawMessage {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case *models.User:
+		if v == nil {
+			return nil
+		}
+		value = auditMember(v)
+	case models.User:
+		value = auditMember(&v)
+	case *models.Transaction:
+		if v == nil {
+			return nil
+		}
+		value = v.WithoutFreeText()
+	case models.Transaction:
+		value = v.WithoutFreeText()
+	case *models.OutboxItem:
+		if v == nil {
+			return nil
+		}
+		value = v.WithoutFreeText()
+	case models.OutboxItem:
+		value = v.WithoutFreeText()
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to encode audit value: %v", err)
+		return nil
+	}
+	return data
+}
+
+// auditMember describes a member in the audit log by identifiers and account state only
+func auditMember(user *models.User) models.AuditMember {
+	return models.AuditMember{
+		ID:                 user.ID,
+		MerchantID:         user.MerchantID,
+		LoyaltyID:          user.LoyaltyID,
+		SquareAccountID:    user.SquareAccountID,
+		Role:               user.Role,
+		Status:             user.Status,
+		EmailVerified:      user.EmailVerified,
+		EmailChangePending: user.PendingEmail != "",
+		Points:             user.Points,
+		ProvisioningStatus: user.Provisioning.Status,
+		LockedAt:           user.LockedAt,
+		ClosedAt:           user.ClosedAt,
+		UpdatedAt:          user.UpdatedAt,
+	}
+}
+
+// readAuditEntries parses a JSON lines audit log
+func readAuditEntries(r io.Reader) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry models.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *AuditService) generateID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+func TestAuditRecordsNoPersonalData(t *testing.T) {
+	audit := NewAuditService(config.Defaults())
+	before := &models.User{
+		ID:          "audit-pii-user",
+		MerchantID:  models.DefaultMerchantID,
+		LoyaltyID:   "100077",
+		Email:       "john.roe@example.com",
+		FirstName:   "John",
+		LastName:    "Roe",
+		Phone:       "+15550100077",
+		DateOfBirth: "1980-02-29",
+		Password:    "$2a$10$hash",
+		Status:      models.AccountStatusActive,
+		Points:      40,
+		UpdatedAt:   time.Now(),
+	}
+	after := *before
+	after.PendingEmail = "john.new@example.com"
+	after.LockReason = "John Roe called support"
+
+	audit.Record(models.AuditActor{UserID: before.ID}, models.AuditActionProfileUpdate, models.AuditTargetMember, before.ID, before, after)
+	audit.Record(models.AuditActor{UserID: before.ID}, models.AuditActionRedeem, models.AuditTargetTransaction, "audit-pii-txn", nil,
+		models.Transaction{ID: "audit-pii-txn", UserID: before.ID, Type: models.TransactionTypeRedeem, Points: 10, Description: "Gift for John Roe"})
+
+	for _, target := range []string{before.ID, "audit-pii-txn"} {
+		page := audit.Query(models.AuditFilter{TargetID: target})
+		if page.Count != 1 {
+			t.Fatalf("%d entries for %s, want 1", page.Count, target)
+		}
+		recorded := string(page.Entries[0].Before) + string(page.Entries[0].After)
+		for _, personal := range []string{"john", "John", "Roe", before.Phone, before.DateOfBirth, before.Password} {
+			if strings.Contains(recorded, personal) {
+				t.Errorf("entry for %s holds %q: %s", target, personal, recorded)
+			}
+		}
+		if !strings.Contains(recorded, before.ID) {
+			t.Errorf("entry for %s does not identify the member: %s", target, recorded)
+		}
+	}
+
+	entry := audit.Query(models.AuditFilter{TargetID: before.ID}).Entries[0]
+	if !strings.Contains(string(entry.After), `"emailChangePending":true`) || !strings.Contains(string(entry.After), before.LoyaltyID) {
+		t.Errorf("member recorded as %s, want the loyalty ID and the pending email change", entry.After)
+	}
+}
+
+// systemEntries returns the entries of a target recorded for an action, newest first, and checks
+// they were made by the given system actor
+func systemEntries(t *testing.T, actor, action, targetID string) []models.AuditEntry {
+	t.Helper()
+
+	entries := NewAuditService(config.Defaults()).Query(models.AuditFilter{TargetID: targetID, Action: action}).Entries
+	for _, entry := range entries {
+		if entry.ActorID != actor || entry.ActorRole != models.AuditRoleSystem || entry.MerchantID != models.DefaultMerchantID {
+			t.Errorf("%s entry by %s (%s) of merchant %s, want %s of the default merchant", action, entry.ActorID, entry.ActorRole, entry.MerchantID, actor)
+		}
+	}
+	return entries
+}
+
+func TestAuditRecordsSquareWebhookChanges(t *testing.T) {
+	sc := newSquareScenario(t)
+	webhooks := NewSquareWebhookService(sc.cfg, sc.loyalty)
+	user := sc.newMember(t)
+	account := sc.fake.SeedAccount(user.Phone, 45)
+	snapshot := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	if err := webhooks.HandleEvent(loyaltyAccountWebhook(t, "audit-link-"+user.ID, account.ID, user.Phone, 45, snapshot)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	links := systemEntries(t, models.AuditActorSquareWebhook, models.AuditActionSquareAccountLink, user.ID)
+	if len(links) != 1 || !strings.Contains(string(links[0].After), `"squareAccountId":"`+account.ID+`"`) || !strings.Contains(string(links[0].After), `"points":45`) {
+		t.Fatalf("link entries = %+v, want one linking %s with 45 points", links, account.ID)
+	}
+	if strings.Contains(string(links[0].Before)+string(links[0].After), user.Phone) {
+		t.Errorf("link entry holds the phone number the account was matched by")
+	}
+
+	// Only snapshots that change the balance are balance changes
+	for i, balance := range []int{70, 70} {
+		body := loyaltyAccountWebhook(t, fmt.Sprintf("audit-sync-%d-%s", i, user.ID), account.ID, user.Phone, balance, snapshot.Add(time.Duration(i+1)*time.Minute))
+		if err := webhooks.HandleEvent(body); err != nil {
+			t.Fatalf("HandleEvent: %v", err)
+		}
+	}
+	syncs := systemEntries(t, models.AuditActorSquareWebhook, models.AuditActionSquareBalanceSync, user.ID)
+	if len(syncs) != 1 || !strings.Contains(string(syncs[0].Before), `"points":45`) || !strings.Contains(string(syncs[0].After), `"points":70`) {
+		t.Fatalf("balance sync entries = %+v, want one from 45 to 70", syncs)
+	}
+
+	event, err := sc.fake.RecordPOSEvent(account.ID, 25)
+	if err != nil {
+		t.Fatalf("RecordPOSEvent: %v", err)
+	}
+	if err := webhooks.HandleEvent(loyaltyEventWebhook(t, "audit-event-"+user.ID, event)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	transaction, found := storage.GetGlobalTransactionStorage().GetTransactionBySquareEventID(user.MerchantID, user.ID, event.ID)
+	if !found {
+		t.Fatalf("POS event %s not imported", event.ID)
+	}
+	if imports := systemEntries(t, models.AuditActorSquareWebhook, models.AuditActionSquareEventImport, transaction.ID); len(imports) != 1 {
+		t.Fatalf("%d import entries for transaction %s, want 1", len(imports), transaction.ID)
+	}
+}
+
+func TestAuditRecordsOutboxOutcomes(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+	ctx := context.Background()
+
+	if _, err := sc.loyalty.EarnPoints(ctx, user.ID, 60, "Lunch", ""); err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+	// Redemptions send their description to Square as the adjustment's reason
+	delivered, err := sc.loyalty.RedeemPoints(ctx, user.ID, 20, "Lunch with Jane Roe", "", "")
+	if err != nil {
+		t.Fatalf("RedeemPoints: %v", err)
+	}
+	outbox.deliverDue(nil)
+	item := outboxItemFor(t, delivered.ID)
+	entries := systemEntries(t, models.AuditActorOutbox, models.AuditActionOutboxDeliver, item.ID)
+	if len(entries) != 1 || !strings.Contains(string(entries[0].After), `"status":"delivered"`) {
+		t.Fatalf("delivery entries = %+v, want one delivered item", entries)
+	}
+	if strings.Contains(string(entries[0].Before)+string(entries[0].After), "Jane") {
+		t.Errorf("delivery entry holds the redemption's description: %s", entries[0].After)
+	}
+
+	dead, err := sc.loyalty.EarnPoints(ctx, user.ID, 10, "Coffee", "")
+	if err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+	item = outboxItemFor(t, dead.ID)
+	for attempt := 1; attempt <= sc.cfg.OutboxMaxAttempts; attempt++ {
+		sc.fake.FailNext("accumulate", http.StatusInternalServerError)
+		makeDue(t, item.ID)
+		outbox.deliverDue(nil)
+	}
+	// Retries are not recorded, only the outcome
+	if entries := systemEntries(t, models.AuditActorOutbox, "outbox.", item.ID); len(entries) != 1 || entries[0].Action != models.AuditActionOutboxDeadLetter {
+		t.Fatalf("entries for the dead item = %+v, want one dead letter", entries)
+	}
+}
+
+func TestAuditRecordsReconciliationChanges(t *testing.T) {
+	sc := newSquareScenario(t)
+	user := sc.provisionedMember(t)
+	event, err := sc.fake.RecordPOSEvent(user.SquareAccountID, 40)
+	if err != nil {
+		t.Fatalf("RecordPOSEvent: %v", err)
+	}
+	drifted := sc.provisionedMember(t)
+	drifted.Points = 30
+
+	if _, err := NewReconciliationService(sc.cfg, sc.loyalty).Run(context.Background(), models.ReconciliationModeAutoCorrect); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	transaction, found := storage.GetGlobalTransactionStorage().GetTransactionBySquareEventID(user.MerchantID, user.ID, event.ID)
+	if !found {
+		t.Fatalf("POS event %s not imported", event.ID)
+	}
+	if imports := systemEntries(t, models.AuditActorReconciliation, models.AuditActionSquareEventImport, transaction.ID); len(imports) != 1 {
+		t.Fatalf("%d import entries for transaction %s, want 1", len(imports), transaction.ID)
+	}
+
+	corrections := systemEntries(t, models.AuditActorReconciliation, models.AuditActionReconciliationCorrection, drifted.ID)
+	if len(corrections) != 1 || !strings.Contains(string(corrections[0].After), `"outboxItemId":"`) || !strings.Contains(string(corrections[0].After), `"points":30`) {
+		t.Fatalf("correction entries = %+v, want one queued correction of 30 points", corrections)
+	}
+}
+
+func TestAuditRecordsSeed(t *testing.T) {
+	seeds := newTestSeedService()
+	email := fmt.Sprintf("seed-audit-%d@example.com", scenarioMembers.Add(1))
+
+	if _, err := seeds.Seed(context.Background(), seedFixtures(email)); err != nil {
+		t.Fatalf("Seed: %v", err)
+	}
+	user, err := storage.GetGlobalUserStorage().GetUserByEmail(models.DefaultMerchantID, email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+
+	if signups := systemEntries(t, models.AuditActorSeed, models.AuditActionSignup, user.ID); len(signups) != 1 || strings.Contains(string(signups[0].After), email) {
+		t.Fatalf("signup entries = %+v, want one without the email", signups)
+	}
+	for _, transaction := range storage.GetGlobalTransactionStorage().GetTransactionsByUserID(user.MerchantID, user.ID) {
+		action := models.AuditActionEarn
+		if transaction.Type == models.TransactionTypeRedeem {
+			action = models.AuditActionRedeem
+		}
+		if entries := systemEntries(t, models.AuditActorSeed, action, transaction.ID); len(entries) != 1 {
+			t.Errorf("%d %s entries for transaction %s, want 1", len(entries), action, transaction.ID)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"log"
@@ -17,17 +18,31 @@ import (
 )
 
 type AuthService struct {
-	config      *config.Config
-	userStorage *storage.UserStorage
+	config         *config.Config
+	userStorage    *storage.UserStorage
+	profileHistory *storage.ProfileHistoryStorage
+	sessions       *storage.SessionStorage
+	passwordPolicy *PasswordPolicy
+	notifier       Notifier
+	signupHooks    []func(user *models.User)
 }
 
 func NewAuthService(cfg *config.Config) *AuthService {
 	return &AuthService{
-		config:      cfg,
-		userStorage: storage.GetGlobalUserStorage(),
+		config:         cfg,
+		userStorage:    storage.GetGlobalUserStorage(),
+		profileHistory: storage.GetGlobalProfileHistoryStorage(),
+		sessions:       storage.GetGlobalSessionStorage(),
+		passwordPolicy: NewPasswordPolicy(cfg),
+		notifier:       NewNotifier(cfg),
 	}
 }
 
+// OnSignup registers a hook that runs after a new user has been stored
+func (as *AuthService) OnSignup(hook func(user *models.User)) {
+	as.signupHooks = append(as.signupHooks, hook)
+}
+
 // generateLoyaltyID generates a unique loyalty ID
 func (as *AuthService) generateLoyaltyID() string {
 	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -46,14 +61,46 @@ func (as *AuthService) generateUserID() string {
 	return fmt.Sprintf("%x", b)
 }
 
+// roleForEmail returns the role granted to a user of a merchant with the given email. Admins
+// operate the whole deployment, so only accounts of the default merchant can be admins.
+func (as *AuthService) roleForEmail(merchantID, email string) string {
+	if models.MerchantIDOrDefault(merchantID) != models.DefaultMerchantID {
+		return models.RoleMember
+	}
+	for _, adminEmail := range as.config.AdminEmails {
+		if strings.EqualFold(adminEmail, email) {
+			return models.RoleAdmin
+		}
+	}
+	return models.RoleMember
+}
+
+// IsAdmin reports whether the user has the admin role
+func (as *AuthService) IsAdmin(userID string) bool {
+	user, err := as.userStorage.GetUserByID(userID)
+	if err != nil {
+		return false
+	}
+	return user.Role == models.RoleAdmin && user.Status != models.AccountStatusClosed
+}
+
 // validateEmail checks if email is valid format
 func (as *AuthService) validateEmail(email string) bool {
 	return strings.Contains(email, "@") && strings.Contains(email, ".")
 }
 
-// validatePassword checks if password meets requirements
-func (as *AuthService) validatePassword(password string) bool {
-	return len(password) >= 6
+// normalizePhone validates a phone number and converts it to E.164
+func (as *AuthService) normalizePhone(phone string) (string, error) {
+	normalized, err := utils.NormalizePhone(phone, as.config.DefaultPhoneCountryCode)
+	if err != nil {
+		return "", fmt.Errorf("invalid phone: %w", err)
+	}
+	return normalized, nil
+}
+
+// validatePassword checks if password meets the configured policy
+func (as *AuthService) validatePassword(password string) error {
+	return as.passwordPolicy.Validate(password)
 }
 
 // hashPassword hashes the password using bcrypt
@@ -81,12 +128,24 @@ func (as *AuthService) SignupUser(req models.SignupRequest) (*models.SignupRespo
 	}
 
 	// Validate password strength
-	if !as.validatePassword(req.Password) {
-		return nil, errors.New("password must be at least 6 characters")
+	if err := as.validatePassword(req.Password); err != nil {
+		return nil, err
+	}
+
+	// Validate and normalize the optional phone number
+	phone := ""
+	if strings.TrimSpace(req.Phone) != "" {
+		normalized, err := as.normalizePhone(req.Phone)
+		if err != nil {
+			return nil, err
+		}
+		phone = normalized
 	}
 
-	// Check if user already exists
-	if _, err := as.userStorage.GetUserByEmail(req.Email); err == nil {
+	merchantID := models.MerchantIDOrDefault(req.MerchantID)
+
+	// Check if user already exists; the same email may belong to members of other merchants
+	if _, err := as.userStorage.GetUserByEmail(merchantID, req.Email); err == nil {
 		return nil, errors.New("user already exists")
 	}
 
@@ -99,13 +158,20 @@ func (as *AuthService) SignupUser(req models.SignupRequest) (*models.SignupRespo
 
 	// Create new user
 	user := &models.User{
-		ID:        as.generateUserID(),
-		Email:     req.Email,
-		Password:  hashedPassword,
-		FirstName: req.FirstName,
-		LastName:  req.LastName,
-		LoyaltyID: as.generateLoyaltyID(),
-		Points:    0,
+		ID:         as.generateUserID(),
+		MerchantID: merchantID,
+		Email:      req.Email,
+		Password:   hashedPassword,
+		FirstName:  req.FirstName,
+		LastName:   req.LastName,
+		Phone:      phone,
+		LoyaltyID:  as.generateLoyaltyID(),
+		Points:     0,
+		Role:       as.roleForEmail(merchantID, req.Email),
+		Status:     models.AccountStatusActive,
+		Provisioning: models.SquareProvisioning{
+			Status: models.ProvisioningStatusPending,
+		},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -115,6 +181,10 @@ func (as *AuthService) SignupUser(req models.SignupRequest) (*models.SignupRespo
 		return nil, err
 	}
 
+	for _, hook := range as.signupHooks {
+		hook(user)
+	}
+
 	// Create response (exclude password)
 	responseUser := *user
 	responseUser.Password = ""
@@ -135,23 +205,50 @@ func (as *AuthService) LoginUser(req models.LoginRequest) (*models.LoginResponse
 		return nil, errors.New("email and password are required")
 	}
 
-	// Find user by email
-	foundUser, err := as.userStorage.GetUserByEmail(req.Email)
+	// Find the merchant's user by email
+	foundUser, err := as.userStorage.GetUserByEmail(req.MerchantID, req.Email)
 	if err != nil {
 		return nil, errors.New("invalid credentials")
 	}
 
+	// Closed accounts can no longer sign in
+	if foundUser.Status == models.AccountStatusClosed {
+		return nil, errors.New("invalid credentials")
+	}
+
 	// Check password
 	if !as.checkPasswordHash(req.Password, foundUser.Password) {
 		return nil, errors.New("invalid credentials")
 	}
 
+	// Locked accounts are told so, but only once the password has been checked
+	if foundUser.Status == models.AccountStatusLocked {
+		return nil, ErrAccountLocked
+	}
+
+	// Promote users newly listed as admins in the configuration
+	if role := as.roleForEmail(foundUser.MerchantID, foundUser.Email); role == models.RoleAdmin && foundUser.Role != role {
+		foundUser.Role = role
+		if err := as.userStorage.UpdateUser(foundUser); err != nil {
+			log.Printf("Error promoting user to admin: %v", err)
+		}
+	}
+
+	// Record the session the token belongs to
+	session := &models.Session{
+		ID:        as.generateUserID(),
+		UserID:    foundUser.ID,
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(utils.TokenTTL),
+	}
+
 	// Generate JWT token
-	token, err := utils.GenerateToken(foundUser.ID, foundUser.Email, as.config.JWTSecret)
+	token, err := utils.GenerateToken(foundUser.ID, foundUser.Email, foundUser.MerchantID, session.ID, as.config.JWTSecret)
 	if err != nil {
 		log.Printf("Error generating token: %v", err)
 		return nil, errors.New("internal server error")
 	}
+	as.sessions.CreateSession(session)
 
 	// Create response (exclude password)
 	responseUser := *foundUser
@@ -167,14 +264,41 @@ func (as *AuthService) LoginUser(req models.LoginRequest) (*models.LoginResponse
 	return response, nil
 }
 
+// MemberIDByEmail returns the ID of the merchant's member with an email, or empty when there is none
+func (as *AuthService) MemberIDByEmail(merchantID, email string) string {
+	user, err := as.userStorage.GetUserByEmail(merchantID, email)
+	if err != nil {
+		return ""
+	}
+	return user.ID
+}
+
 // GetAllUsers returns all users (for testing purposes)
 func (as *AuthService) GetAllUsers() map[string]*models.User {
 	return as.userStorage.GetAllUsers()
 }
 
-// ValidateToken validates JWT token and returns user claims
+// ValidateToken validates JWT token and returns user claims.
+// Tokens whose session has been revoked are rejected.
 func (as *AuthService) ValidateToken(tokenString string) (*utils.Claims, error) {
-	return utils.ValidateToken(tokenString, as.config.JWTSecret)
+	claims, err := utils.ValidateToken(tokenString, as.config.JWTSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := as.sessions.GetSession(claims.ID)
+	if err != nil || session.RevokedAt != nil {
+		return nil, errors.New("invalid token")
+	}
+
+	// Tokens issued before merchants existed belong to the default merchant. A token is only
+	// valid for the merchant of the user it was issued to.
+	claims.MerchantID = models.MerchantIDOrDefault(claims.MerchantID)
+	if _, err := as.userStorage.GetMerchantUserByID(claims.MerchantID, claims.UserID); err != nil {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
 }
 
 // GetUserProfile retrieves user profile by user ID
@@ -190,3 +314,219 @@ func (as *AuthService) GetUserProfile(userID string) (*models.User, error) {
 
 	return &responseUser, nil
 }
+
+// UpdateProfile applies a partial profile update and records each change in the audit history.
+// A new email is held as pending until it is confirmed with VerifyEmail.
+func (as *AuthService) UpdateProfile(userID string, req models.UpdateProfileRequest) (*models.User, error) {
+	stored, err := as.userStorage.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	// Changes are made on a copy, so nothing is stored if the verification email cannot be sent
+	updated := *stored
+	user := &updated
+
+	// Validate everything before touching the user
+	if req.FirstName != nil && strings.TrimSpace(*req.FirstName) == "" {
+		return nil, errors.New("first name cannot be empty")
+	}
+	if req.LastName != nil && strings.TrimSpace(*req.LastName) == "" {
+		return nil, errors.New("last name cannot be empty")
+	}
+	phone := ""
+	if req.Phone != nil && strings.TrimSpace(*req.Phone) != "" {
+		normalized, err := as.normalizePhone(*req.Phone)
+		if err != nil {
+			return nil, err
+		}
+		phone = normalized
+	}
+	if req.DateOfBirth != nil && *req.DateOfBirth != "" {
+		if err := as.validateDateOfBirth(*req.DateOfBirth); err != nil {
+			return nil, err
+		}
+	}
+	emailChanged := req.Email != nil && *req.Email != user.Email
+	if emailChanged {
+		if !as.validateEmail(*req.Email) {
+			return nil, errors.New("invalid email format")
+		}
+		if _, err := as.userStorage.GetUserByEmail(user.MerchantID, *req.Email); err == nil {
+			return nil, errors.New("email already in use")
+		}
+	}
+
+	var changes []models.ProfileChange
+	record := func(field, oldValue, newValue string) {
+		if oldValue == newValue {
+			return
+		}
+		changes = append(changes, models.ProfileChange{
+			ID:        as.generateUserID(),
+			UserID:    user.ID,
+			Field:     field,
+			OldValue:  oldValue,
+			NewValue:  newValue,
+			ChangedAt: time.Now(),
+		})
+	}
+
+	if req.FirstName != nil {
+		value := strings.TrimSpace(*req.FirstName)
+		record("firstName", user.FirstName, value)
+		user.FirstName = value
+	}
+	if req.LastName != nil {
+		value := strings.TrimSpace(*req.LastName)
+		record("lastName", user.LastName, value)
+		user.LastName = value
+	}
+	if req.Phone != nil {
+		record("phone", user.Phone, phone)
+		user.Phone = phone
+	}
+	if req.DateOfBirth != nil {
+		record("dateOfBirth", user.DateOfBirth, *req.DateOfBirth)
+		user.DateOfBirth = *req.DateOfBirth
+	}
+	if req.CommunicationPreferences != nil {
+		record("communicationPreferences", formatPreferences(user.CommunicationPreferences), formatPreferences(*req.CommunicationPreferences))
+		user.CommunicationPreferences = *req.CommunicationPreferences
+	}
+	if emailChanged {
+		record("pendingEmail", user.PendingEmail, *req.Email)
+		user.PendingEmail = *req.Email
+		user.EmailVerificationToken = as.generateUserID()
+
+		// The token proves ownership of the address, so it only goes to the address itself
+		if err := as.notifier.SendEmailVerification(user, user.PendingEmail, user.EmailVerificationToken); err != nil {
+			log.Printf("Failed to send email verification for user %s: %v", user.ID, err)
+			return nil, errors.New("failed to send verification email")
+		}
+		log.Printf("Email verification sent for user %s", user.ID)
+	}
+
+	if len(changes) > 0 {
+		user.UpdatedAt = time.Now()
+		if err := as.userStorage.UpdateUser(user); err != nil {
+			return nil, err
+		}
+		as.profileHistory.Append(changes...)
+		log.Printf("Profile updated: %s (%d changes)", user.Email, len(changes))
+	}
+
+	return as.GetUserProfile(userID)
+}
+
+// VerifyEmail confirms a pending email change using the token sent to the new address
+func (as *AuthService) VerifyEmail(userID, token string) (*models.User, error) {
+	user, err := as.userStorage.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.PendingEmail == "" || user.EmailVerificationToken == "" {
+		return nil, errors.New("no pending email change")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(user.EmailVerificationToken)) != 1 {
+		return nil, errors.New("invalid verification token")
+	}
+
+	// The address may have been taken since the change was requested
+	if _, err := as.userStorage.GetUserByEmail(user.MerchantID, user.PendingEmail); err == nil {
+		return nil, errors.New("email already in use")
+	}
+
+	oldEmail := user.Email
+	user.Email = user.PendingEmail
+	user.EmailVerified = true
+	user.PendingEmail = ""
+	user.EmailVerificationToken = ""
+	user.UpdatedAt = time.Now()
+
+	if err := as.userStorage.UpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	as.profileHistory.Append(models.ProfileChange{
+		ID:        as.generateUserID(),
+		UserID:    user.ID,
+		Field:     "email",
+		OldValue:  oldEmail,
+		NewValue:  user.Email,
+		ChangedAt: time.Now(),
+	})
+
+	log.Printf("Email changed: %s -> %s", oldEmail, user.Email)
+	return as.GetUserProfile(userID)
+}
+
+// GetProfileHistory returns the audit history of a user's profile changes
+func (as *AuthService) GetProfileHistory(userID string) ([]models.ProfileChange, error) {
+	if _, err := as.userStorage.GetUserByID(userID); err != nil {
+		return nil, err
+	}
+	return as.profileHistory.GetByUserID(userID), nil
+}
+
+// validateDateOfBirth checks a YYYY-MM-DD date lies in the past
+func (as *AuthService) validateDateOfBirth(value string) error {
+	dob, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return errors.New("date of birth must be in YYYY-MM-DD format")
+	}
+	if !dob.Before(time.Now()) || dob.Year() < 1900 {
+		return errors.New("invalid date of birth")
+	}
+	return nil
+}
+
+// formatPreferences renders communication preferences for the audit history
+func formatPreferences(p models.CommunicationPreferences) string {
+	return fmt.Sprintf("email=%t,sms=%t,push=%t,marketing=%t", p.Email, p.SMS, p.Push, p.Marketing)
+}
+
+// ChangePassword replaces the user's password after verifying the current one. The user's other
+// sessions are revoked; the session making the change stays valid.
+func (as *AuthService) ChangePassword(userID, sessionID string, req models.ChangePasswordRequest) error {
+	// Validate required fields
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		return errors.New("current and new password are required")
+	}
+
+	user, err := as.userStorage.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	// Verify the current password
+	if !as.checkPasswordHash(req.CurrentPassword, user.Password) {
+		return errors.New("invalid credentials")
+	}
+
+	// Validate the new password against the policy and recent history
+	if err := as.validatePassword(req.NewPassword); err != nil {
+		return err
+	}
+	if err := as.passwordPolicy.CheckReuse(req.NewPassword, user.Password, user.PasswordHistory); err != nil {
+		return err
+	}
+
+	hashedPassword, err := as.hashPassword(req.NewPassword)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		return errors.New("internal server error")
+	}
+
+	user.PasswordHistory = as.passwordPolicy.AppendHistory(user.PasswordHistory, user.Password)
+	user.Password = hashedPassword
+	user.UpdatedAt = time.Now()
+
+	if err := as.userStorage.UpdateUser(user); err != nil {
+		return err
+	}
+	as.sessions.RevokeOtherUserSessions(userID, sessionID)
+
+	log.Printf("Password changed: %s", user.Email)
+	return nil
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/utils"
+)
+
+// testPassword passes the default password policy
+const testPassword = "Zq7#kfLw92pX"
+
+// authTestUsers keeps the emails of different tests apart, since storage is shared
+var authTestUsers atomic.Int64
+
+func newTestAuthService(t *testing.T) *AuthService {
+	t.Helper()
+
+	cfg := config.Defaults()
+	cfg.JWTSecret = "test-secret"
+	return NewAuthService(cfg)
+}
+
+// signupTestUser signs up a member of the default merchant with a unique email
+func signupTestUser(t *testing.T, as *AuthService) models.User {
+	t.Helper()
+
+	resp, err := as.SignupUser(models.SignupRequest{
+		Email:     fmt.Sprintf("auth-%d@example.com", authTestUsers.Add(1)),
+		Password:  testPassword,
+		FirstName: "Test",
+		LastName:  "Member",
+	})
+	if err != nil {
+		t.Fatalf("SignupUser: %v", err)
+	}
+	return resp.User
+}
+
+// loginTestUser signs a user in and returns the token's session ID with the token
+func loginTestUser(t *testing.T, as *AuthService, email, password string) (sessionID, token string) {
+	t.Helper()
+
+	resp, err := as.LoginUser(models.LoginRequest{Email: email, Password: password})
+	if err != nil {
+		t.Fatalf("LoginUser: %v", err)
+	}
+	claims, err := utils.ValidateToken(resp.Token, as.config.JWTSecret)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	return claims.ID, resp.Token
+}
+
+func TestSignupEnforcesPasswordPolicy(t *testing.T) {
+	as := newTestAuthService(t)
+
+	_, err := as.SignupUser(models.SignupRequest{Email: "weak@example.com", Password: "password1", FirstName: "Weak", LastName: "Password"})
+	if err == nil {
+		t.Fatal("SignupUser accepted a weak password")
+	}
+}
+
+func TestSignupNormalizesPhone(t *testing.T) {
+	as := newTestAuthService(t)
+	signup := func(phone string) (*models.SignupResponse, error) {
+		return as.SignupUser(models.SignupRequest{
+			Email:     fmt.Sprintf("auth-%d@example.com", authTestUsers.Add(1)),
+			Password:  testPassword,
+			FirstName: "Test",
+			LastName:  "Member",
+			Phone:     phone,
+		})
+	}
+
+	resp, err := signup("(555) 010-2030")
+	if err != nil {
+		t.Fatalf("SignupUser: %v", err)
+	}
+	if resp.User.Phone != "+15550102030" {
+		t.Errorf("phone = %q, want it in E.164 with the default country code", resp.User.Phone)
+	}
+	if _, err := signup("call me"); err == nil {
+		t.Error("SignupUser accepted an invalid phone number")
+	}
+	if resp, err = signup(""); err != nil {
+		t.Fatalf("SignupUser without a phone: %v", err)
+	}
+	if resp.User.Phone != "" {
+		t.Errorf("phone = %q, want none", resp.User.Phone)
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	as := newTestAuthService(t)
+	user := signupTestUser(t, as)
+	current, currentToken := loginTestUser(t, as, user.Email, testPassword)
+	_, otherToken := loginTestUser(t, as, user.Email, testPassword)
+
+	const newPassword = "Vb4!mRt8yQw2"
+	if err := as.ChangePassword(user.ID, current, models.ChangePasswordRequest{CurrentPassword: testPassword, NewPassword: newPassword}); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	if _, err := as.ValidateToken(currentToken); err != nil {
+		t.Errorf("the session that changed the password was revoked: %v", err)
+	}
+	if _, err := as.ValidateToken(otherToken); err == nil {
+		t.Error("another session is still valid after the password change")
+	}
+	if _, err := as.LoginUser(models.LoginRequest{Email: user.Email, Password: testPassword}); err == nil {
+		t.Error("the old password still signs in")
+	}
+	loginTestUser(t, as, user.Email, newPassword)
+}
+
+func TestChangePasswordRejections(t *testing.T) {
+	as := newTestAuthService(t)
+	user := signupTestUser(t, as)
+	session, _ := loginTestUser(t, as, user.Email, testPassword)
+
+	tests := []struct {
+		name string
+		req  models.ChangePasswordRequest
+	}{
+		{name: "wrong current password", req: models.ChangePasswordRequest{CurrentPassword: "Wrong#Pass99", NewPassword: "Vb4!mRt8yQw2"}},
+		{name: "weak new password", req: models.ChangePasswordRequest{CurrentPassword: testPassword, NewPassword: "short"}},
+		{name: "current password again", req: models.ChangePasswordRequest{CurrentPassword: testPassword, NewPassword: testPassword}},
+		{name: "missing fields", req: models.ChangePasswordRequest{CurrentPassword: testPassword}},
+	}
+	for _, tt := range tests {
+		if err := as.ChangePassword(user.ID, session, tt.req); err == nil {
+			t.Errorf("%s: ChangePassword succeeded", tt.name)
+		}
+	}
+}
+
+func TestChangePasswordRejectsRecentPasswords(t *testing.T) {
+	as := newTestAuthService(t)
+	as.passwordPolicy.HistorySize = 3
+	user := signupTestUser(t, as)
+	session, _ := loginTestUser(t, as, user.Email, testPassword)
+
+	change := func(from, to string) error {
+		return as.ChangePassword(user.ID, session, models.ChangePasswordRequest{CurrentPassword: from, NewPassword: to})
+	}
+	passwords := []string{testPassword, "Vb4!mRt8yQw2", "Hn6$pLx3zKe7"}
+	for i := 1; i < len(passwords); i++ {
+		if err := change(passwords[i-1], passwords[i]); err != nil {
+			t.Fatalf("ChangePassword to password %d: %v", i+1, err)
+		}
+	}
+
+	if err := change(passwords[2], passwords[0]); err == nil {
+		t.Error("ChangePassword accepted a password from the last 3")
+	}
+	if err := change(passwords[2], "Jc9%wTq5rNb1"); err != nil {
+		t.Fatalf("ChangePassword to a new password: %v", err)
+	}
+	// The first password has now left the window of the last 3
+	if err := change("Jc9%wTq5rNb1", passwords[0]); err != nil {
+		t.Errorf("ChangePassword back to an old password outside the window: %v", err)
+	}
+}
+
+// sentVerification is an email verification token delivered by recordingNotifier
+type sentVerification struct {
+	userID, email, token string
+}
+
+// recordingNotifier keeps the messages it is asked to send, or fails them when err is set
+type recordingNotifier struct {
+	sent []sentVerification
+	err  error
+}
+
+func (n *recordingNotifier) SendEmailVerification(user *models.User, email, token string) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, sentVerification{userID: user.ID, email: email, token: token})
+	return nil
+}
+
+func TestEmailChangeIsVerifiedWithTheSentToken(t *testing.T) {
+	as := newTestAuthService(t)
+	notifier := &recordingNotifier{}
+	as.notifier = notifier
+	user := signupTestUser(t, as)
+	newEmail := fmt.Sprintf("auth-%d@example.com", authTestUsers.Add(1))
+
+	profile, err := as.UpdateProfile(user.ID, models.UpdateProfileRequest{Email: &newEmail})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if profile.Email != user.Email || profile.PendingEmail != newEmail {
+		t.Errorf("after the change: email %q, pending %q; want %q pending %q", profile.Email, profile.PendingEmail, user.Email, newEmail)
+	}
+	if len(notifier.sent) != 1 || notifier.sent[0].email != newEmail || notifier.sent[0].userID != user.ID || notifier.sent[0].token == "" {
+		t.Fatalf("sent = %+v, want one token to %s", notifier.sent, newEmail)
+	}
+
+	if _, err := as.VerifyEmail(user.ID, "not-the-token"); err == nil {
+		t.Error("VerifyEmail accepted a wrong token")
+	}
+	profile, err = as.VerifyEmail(user.ID, notifier.sent[0].token)
+	if err != nil {
+		t.Fatalf("VerifyEmail with the sent token: %v", err)
+	}
+	if profile.Email != newEmail || profile.PendingEmail != "" || !profile.EmailVerified {
+		t.Errorf("after verification: email %q, pending %q, verified %v", profile.Email, profile.PendingEmail, profile.EmailVerified)
+	}
+	if _, err := as.VerifyEmail(user.ID, notifier.sent[0].token); err == nil {
+		t.Error("the token was accepted twice")
+	}
+
+	// The member now signs in with the new address only
+	loginTestUser(t, as, newEmail, testPassword)
+	if _, err := as.LoginUser(models.LoginRequest{Email: user.Email, Password: testPassword}); err == nil {
+		t.Error("the old email still signs in")
+	}
+
+	history, err := as.GetProfileHistory(user.ID)
+	if err != nil {
+		t.Fatalf("GetProfileHistory: %v", err)
+	}
+	if last := history[len(history)-1]; last.Field != "email" || last.OldValue != user.Email || last.NewValue != newEmail {
+		t.Errorf("last history entry = %+v, want the email change", last)
+	}
+}
+
+func TestEmailChangeIsNotStoredWhenSendingFails(t *testing.T) {
+	as := newTestAuthService(t)
+	as.notifier = &recordingNotifier{err: errors.New("mail server down")}
+	user := signupTestUser(t, as)
+	newEmail := fmt.Sprintf("auth-%d@example.com", authTestUsers.Add(1))
+	firstName := "Changed"
+
+	if _, err := as.UpdateProfile(user.ID, models.UpdateProfileRequest{FirstName: &firstName, Email: &newEmail}); err == nil {
+		t.Fatal("UpdateProfile succeeded without sending the verification email")
+	}
+	profile, err := as.GetUserProfile(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserProfile: %v", err)
+	}
+	if profile.PendingEmail != "" || profile.FirstName != user.FirstName {
+		t.Errorf("profile changed by a failed update: pending %q, first name %q", profile.PendingEmail, profile.FirstName)
+	}
+	if history, _ := as.GetProfileHistory(user.ID); len(history) != 0 {
+		t.Errorf("history = %+v, want none", history)
+	}
+}
+
+func TestUpdateProfileValidatesBeforeChanging(t *testing.T) {
+	as := newTestAuthService(t)
+	as.notifier = &recordingNotifier{}
+	user := signupTestUser(t, as)
+	taken := signupTestUser(t, as)
+
+	firstName := "Changed"
+	empty := " "
+	badPhone := "12"
+	badDate := "31/12/1990"
+	invalidEmail := "not-an-email"
+	tests := []struct {
+		name string
+		req  models.UpdateProfileRequest
+	}{
+		{name: "empty last name", req: models.UpdateProfileRequest{FirstName: &firstName, LastName: &empty}},
+		{name: "invalid phone", req: models.UpdateProfileRequest{FirstName: &firstName, Phone: &badPhone}},
+		{name: "invalid date of birth", req: models.UpdateProfileRequest{FirstName: &firstName, DateOfBirth: &badDate}},
+		{name: "invalid email", req: models.UpdateProfileRequest{FirstName: &firstName, Email: &invalidEmail}},
+		{name: "email in use", req: models.UpdateProfileRequest{FirstName: &firstName, Email: &taken.Email}},
+	}
+	for _, tt := range tests {
+		if _, err := as.UpdateProfile(user.ID, tt.req); err == nil {
+			t.Errorf("%s: UpdateProfile succeeded", tt.name)
+		}
+	}
+
+	profile, err := as.GetUserProfile(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserProfile: %v", err)
+	}
+	if profile.FirstName != user.FirstName {
+		t.Errorf("first name = %q, changed by rejected updates", profile.FirstName)
+	}
+}
+
+func TestUpdateProfileRecordsChanges(t *testing.T) {
+	as := newTestAuthService(t)
+	user := signupTestUser(t, as)
+
+	firstName := "  Renamed "
+	phone := "(555) 010-2030"
+	profile, err := as.UpdateProfile(user.ID, models.UpdateProfileRequest{FirstName: &firstName, Phone: &phone, LastName: &user.LastName})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if profile.FirstName != "Renamed" || profile.Phone != "+15550102030" {
+		t.Errorf("profile = %q, %q; want the trimmed name and normalized phone", profile.FirstName, profile.Phone)
+	}
+
+	// An unchanged last name is not a change
+	history, err := as.GetProfileHistory(user.ID)
+	if err != nil {
+		t.Fatalf("GetProfileHistory: %v", err)
+	}
+	fields := map[string]bool{}
+	for _, change := range history {
+		fields[change.Field] = true
+	}
+	if len(history) != 2 || !fields["firstName"] || !fields["phone"] {
+		t.Errorf("history = %+v, want firstName and phone changes", history)
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	breaker := newCircuitBreaker(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		breaker.failure()
+	}
+	if !breaker.allow() || breaker.currentState() != breakerClosed {
+		t.Fatalf("breaker %s after 2 failures, want closed", breaker.currentState())
+	}
+
+	// A success resets the count of consecutive failures
+	breaker.success()
+	for i := 0; i < 2; i++ {
+		breaker.failure()
+	}
+	if breaker.currentState() != breakerClosed {
+		t.Fatalf("breaker %s after a success and 2 failures, want closed", breaker.currentState())
+	}
+
+	breaker.failure()
+	if breaker.allow() || breaker.available() || breaker.currentState() != breakerOpen {
+		t.Fatalf("breaker %s after 3 consecutive failures, want open", breaker.currentState())
+	}
+}
+
+func TestCircuitBreakerHalfOpenTrial(t *testing.T) {
+	breaker := newCircuitBreaker(1, time.Minute)
+	breaker.failure()
+
+	// After the cooldown a single trial call is let through
+	breaker.openedAt = time.Now().Add(-2 * time.Minute)
+	if !breaker.available() {
+		t.Fatal("breaker not available after the cooldown")
+	}
+	if !breaker.allow() || breaker.currentState() != breakerHalfOpen {
+		t.Fatalf("breaker %s, want a half-open trial", breaker.currentState())
+	}
+	if breaker.allow() {
+		t.Fatal("a second call was let through during the trial")
+	}
+
+	// A failed trial opens the breaker for another cooldown
+	breaker.failure()
+	if breaker.allow() || breaker.currentState() != breakerOpen {
+		t.Fatalf("breaker %s after a failed trial, want open", breaker.currentState())
+	}
+
+	breaker.openedAt = time.Now().Add(-2 * time.Minute)
+	breaker.allow()
+	breaker.success()
+	if breaker.currentState() != breakerClosed || !breaker.allow() {
+		t.Fatalf("breaker %s after a successful trial, want closed", breaker.currentState())
+	}
+}
+
+func TestCircuitBreakerReleaseEndsTrial(t *testing.T) {
+	breaker := newCircuitBreaker(1, time.Minute)
+	breaker.failure()
+	breaker.openedAt = time.Now().Add(-2 * time.Minute)
+
+	if !breaker.allow() {
+		t.Fatal("trial call not allowed")
+	}
+	// The trial was canceled by its caller, so another trial may run
+	breaker.release()
+	if breaker.currentState() != breakerHalfOpen || !breaker.allow() {
+		t.Fatalf("breaker %s, want another half-open trial", breaker.currentState())
+	}
+}
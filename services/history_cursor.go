@@ -0,0 +1,88 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"loyalty-core/models"
+)
+
+// maxHistoryPageSize is the largest page of loyalty events Square returns
+const maxHistoryPageSize = 30
+
+// History cursors are opaque to clients: they wrap either Square's cursor or an offset into
+// the local ledger, so a cursor cannot be replayed against the other source
+const (
+	historyCursorSquare = "square"
+	historyCursorLocal  = "local"
+)
+
+func encodeHistoryCursor(source, position string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(source + ":" + position))
+}
+
+// decodeHistoryCursor returns the source and position of a cursor; both are empty for no cursor
+func decodeHistoryCursor(cursor string) (string, string, error) {
+	if cursor == "" {
+		return "", "", nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", errors.New("invalid cursor")
+	}
+
+	source, position, found := strings.Cut(string(decoded), ":")
+	if !found || position == "" || (source != historyCursorSquare && source != historyCursorLocal) {
+		return "", "", errors.New("invalid cursor")
+	}
+	return source, position, nil
+}
+
+// squareEventTypes maps transaction types to the Square event type they come from or mirror
+var squareEventTypes = map[string]string{
+	models.TransactionTypeEarn:           "ACCUMULATE_POINTS",
+	models.TransactionTypePromotionEarn:  "ACCUMULATE_PROMOTION_POINTS",
+	models.TransactionTypeAdjustCredit:   "ADJUST_POINTS",
+	models.TransactionTypeAdjustDebit:    "ADJUST_POINTS",
+	models.TransactionTypeAdjustment:     "ADJUST_POINTS",
+	models.TransactionTypeRewardCreated:  "CREATE_REWARD",
+	models.TransactionTypeRewardDeleted:  "DELETE_REWARD",
+	models.TransactionTypeRewardRedeemed: "REDEEM_REWARD",
+	models.TransactionTypeExpire:         "EXPIRE_POINTS",
+	models.TransactionTypeOtherCredit:    "OTHER",
+	models.TransactionTypeOtherDebit:     "OTHER",
+}
+
+// transactionMatchesFilter applies a history filter to a local ledger entry, matching it by the
+// Square event type it comes from or mirrors
+func transactionMatchesFilter(transaction models.Transaction, filter models.HistoryFilter) bool {
+	if filter.From != nil && transaction.CreatedAt.Before(*filter.From) {
+		return false
+	}
+	if filter.To != nil && !transaction.CreatedAt.Before(*filter.To) {
+		return false
+	}
+	if len(filter.LocationIDs) > 0 && !contains(filter.LocationIDs, transaction.LocationID) {
+		return false
+	}
+
+	if len(filter.Types) == 0 {
+		return true
+	}
+	eventType := squareEventTypes[transaction.Type]
+	if eventType == "" {
+		eventType = "ADJUST_POINTS" // local redemptions and closure settlements
+	}
+	return contains(filter.Types, eventType)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"loyalty-core/models"
+)
+
+func TestHistoryCursorRoundTrip(t *testing.T) {
+	for _, source := range []string{historyCursorSquare, historyCursorLocal} {
+		gotSource, position, err := decodeHistoryCursor(encodeHistoryCursor(source, "30"))
+		if err != nil || gotSource != source || position != "30" {
+			t.Errorf("decode(encode(%s, 30)) = %q, %q, %v", source, gotSource, position, err)
+		}
+	}
+
+	if source, position, err := decodeHistoryCursor(""); err != nil || source != "" || position != "" {
+		t.Errorf("empty cursor = %q, %q, %v, want the first page", source, position, err)
+	}
+	for _, cursor := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("30")),
+		base64.RawURLEncoding.EncodeToString([]byte("square:")),
+		base64.RawURLEncoding.EncodeToString([]byte("elsewhere:30")),
+	} {
+		if _, _, err := decodeHistoryCursor(cursor); err == nil {
+			t.Errorf("cursor %q accepted", cursor)
+		}
+	}
+}
+
+func TestTransactionMatchesFilter(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	earn := models.Transaction{Type: models.TransactionTypeEarn, LocationID: "downtown", CreatedAt: now}
+	redeem := models.Transaction{Type: models.TransactionTypeRedeem, CreatedAt: now}
+
+	tests := []struct {
+		name        string
+		transaction models.Transaction
+		filter      models.HistoryFilter
+		want        bool
+	}{
+		{"no filter", earn, models.HistoryFilter{}, true},
+		{"matching type", earn, models.HistoryFilter{Types: []string{"ACCUMULATE_POINTS"}}, true},
+		{"other type", earn, models.HistoryFilter{Types: []string{"REDEEM_REWARD"}}, false},
+		{"local redemption is an adjustment", redeem, models.HistoryFilter{Types: []string{"ADJUST_POINTS"}}, true},
+		{"from is inclusive", earn, models.HistoryFilter{From: &now}, true},
+		{"to is exclusive", earn, models.HistoryFilter{To: &now}, false},
+		{"after to", earn, models.HistoryFilter{To: &earlier}, false},
+		{"before from", models.Transaction{Type: models.TransactionTypeEarn, CreatedAt: earlier}, models.HistoryFilter{From: &now}, false},
+		{"matching location", earn, models.HistoryFilter{LocationIDs: []string{"uptown", "downtown"}}, true},
+		{"other location", earn, models.HistoryFilter{LocationIDs: []string{"uptown"}}, false},
+	}
+	for _, tt := range tests {
+		if got := transactionMatchesFilter(tt.transaction, tt.filter); got != tt.want {
+			t.Errorf("%s: transactionMatchesFilter = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSquareHistoryPagesWithCursor(t *testing.T) {
+	sc := newSquareScenario(t)
+	user := sc.provisionedMember(t)
+	for points := 1; points <= 5; points++ {
+		if _, err := sc.fake.RecordPOSEvent(user.SquareAccountID, points); err != nil {
+			t.Fatalf("RecordPOSEvent: %v", err)
+		}
+	}
+
+	var pages [][]int
+	cursor := ""
+	for {
+		page, err := sc.loyalty.GetTransactionHistory(context.Background(), user.ID, models.HistoryFilter{}, 2, cursor)
+		if err != nil {
+			t.Fatalf("GetTransactionHistory: %v", err)
+		}
+		points := []int{}
+		for _, transaction := range page.Transactions {
+			points = append(points, transaction.Points)
+		}
+		pages = append(pages, points)
+		if page.NextCursor == "" {
+			break
+		}
+		if source, _, _ := decodeHistoryCursor(page.NextCursor); source != historyCursorSquare {
+			t.Fatalf("cursor source = %q, want square", source)
+		}
+		cursor = page.NextCursor
+	}
+
+	// Newest first, two per page
+	if got, want := fmt.Sprint(pages), "[[5 4] [3 2] [1]]"; got != want {
+		t.Fatalf("pages = %s, want %s", got, want)
+	}
+}
+
+func TestLocalHistoryPagesWithCursor(t *testing.T) {
+	sc := newSquareScenario(t)
+	loyalty := NewLoyaltyServiceWithProvider(sc.cfg, nil)
+	user := sc.newMember(t)
+	for i := 1; i <= 3; i++ {
+		if _, err := loyalty.EarnPoints(context.Background(), user.ID, i*10, "Local earn", ""); err != nil {
+			t.Fatalf("EarnPoints: %v", err)
+		}
+	}
+
+	first, err := loyalty.GetTransactionHistory(context.Background(), user.ID, models.HistoryFilter{}, 2, "")
+	if err != nil {
+		t.Fatalf("GetTransactionHistory: %v", err)
+	}
+	if first.Count != 2 || first.Transactions[0].Points != 30 || first.NextCursor == "" {
+		t.Fatalf("first page = %+v, want the two newest earns and a cursor", first)
+	}
+	second, err := loyalty.GetTransactionHistory(context.Background(), user.ID, models.HistoryFilter{}, 2, first.NextCursor)
+	if err != nil {
+		t.Fatalf("GetTransactionHistory: %v", err)
+	}
+	if second.Count != 1 || second.Transactions[0].Points != 10 || second.NextCursor != "" {
+		t.Fatalf("second page = %+v, want the oldest earn and no cursor", second)
+	}
+
+	// A local cursor cannot be replayed against Square, nor a Square cursor against the ledger
+	if _, err := loyalty.GetTransactionHistory(context.Background(), user.ID, models.HistoryFilter{}, 2, encodeHistoryCursor(historyCursorSquare, "2")); err == nil {
+		t.Error("Square cursor accepted by the local ledger")
+	}
+	if _, err := loyalty.GetTransactionHistory(context.Background(), user.ID, models.HistoryFilter{Types: []string{"NOT_A_TYPE"}}, 2, ""); err == nil {
+		t.Error("unknown event type accepted")
+	}
+}
+
+func TestSquareHistoryCursorFailsWhileSquareIsDown(t *testing.T) {
+	sc := newSquareScenario(t)
+	user := sc.provisionedMember(t)
+	for i := 0; i < 3; i++ {
+		if _, err := sc.fake.RecordPOSEvent(user.SquareAccountID, 5); err != nil {
+			t.Fatalf("RecordPOSEvent: %v", err)
+		}
+	}
+	page, err := sc.loyalty.GetTransactionHistory(context.Background(), user.ID, models.HistoryFilter{}, 2, "")
+	if err != nil || page.NextCursor == "" {
+		t.Fatalf("first page = %+v, %v, want a Square cursor", page, err)
+	}
+
+	for i := 0; i < sc.cfg.SquareBreakerFailureThreshold; i++ {
+		sc.square.breaker.failure()
+	}
+	if _, err := sc.loyalty.GetTransactionHistory(context.Background(), user.ID, models.HistoryFilter{}, 2, page.NextCursor); !errors.Is(err, ErrSquareUnavailable) {
+		t.Fatalf("continuing a Square cursor while Square is down = %v, want ErrSquareUnavailable", err)
+	}
+}
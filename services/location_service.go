@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+var (
+	// ErrUnknownLocation is returned for a location that is neither configured nor synced from Square
+	ErrUnknownLocation = errors.New("unknown location")
+	// ErrLocationInactive is returned for a location Square reports as inactive
+	ErrLocationInactive = errors.New("location is not active")
+)
+
+// locationResyncInterval limits on-demand syncs triggered by unknown location IDs
+const locationResyncInterval = time.Minute
+
+// LocationService keeps each merchant's list of locations, from SQUARE_LOCATION_IDS and from
+// Square, along with the loyalty rules of each location
+type LocationService struct {
+	config       *config.Config
+	merchants    *MerchantService
+	locations    *storage.LocationStorage
+	transactions *storage.TransactionStorage
+	interval     time.Duration
+
+	lastSync map[string]time.Time // merchantID -> last sync attempt
+	mu       sync.Mutex
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewLocationService creates the location service with the default merchant's configured locations
+func NewLocationService(cfg *config.Config, merchants *MerchantService) *LocationService {
+	ls := &LocationService{
+		config:       cfg,
+		merchants:    merchants,
+		locations:    storage.GetGlobalLocationStorage(),
+		transactions: storage.GetGlobalTransactionStorage(),
+		interval:     time.Duration(cfg.LocationSyncIntervalMinutes) * time.Minute,
+		lastSync:     make(map[string]time.Time),
+		stop:         make(chan struct{}),
+	}
+
+	configured := append([]string{}, cfg.SquareLocationIDs...)
+	if cfg.SquareLocationID != "" && cfg.SquareLocationID != "your-square-location-id" {
+		configured = append(configured, cfg.SquareLocationID)
+	}
+	for _, locationID := range configured {
+		if _, err := ls.locations.GetLocation(models.DefaultMerchantID, locationID); err == nil {
+			continue
+		}
+		ls.locations.SaveLocation(models.Location{
+			ID:         locationID,
+			MerchantID: models.DefaultMerchantID,
+			Name:       locationID,
+			Status:     models.LocationStatusActive,
+			Source:     models.LocationSourceConfig,
+			UpdatedAt:  time.Now(),
+		})
+	}
+
+	return ls
+}
+
+// Start runs the periodic sync of connected merchants' locations
+func (ls *LocationService) Start() {
+	if !ls.merchants.SquareEnabled() {
+		log.Println("Location sync disabled: Square is not available")
+		return
+	}
+	if ls.interval <= 0 {
+		ls.interval = time.Hour
+	}
+
+	ls.wg.Add(1)
+	go ls.run()
+	log.Printf("Location sync worker started (interval: %s)", ls.interval)
+}
+
+// Stop stops the sync worker
+func (ls *LocationService) Stop() {
+	close(ls.stop)
+	ls.wg.Wait()
+}
+
+// ListLocations returns a merchant's locations
+func (ls *LocationService) ListLocations(merchantID string) []models.Location {
+	return ls.locations.ListLocations(merchantID)
+}
+
+// GetLocation returns one of a merchant's locations
+func (ls *LocationService) GetLocation(merchantID, locationID string) (*models.Location, error) {
+	location, err := ls.locations.GetLocation(merchantID, locationID)
+	if err != nil {
+		return nil, ErrUnknownLocation
+	}
+	return location, nil
+}
+
+// Sync loads a merchant's locations from Square. Rules of known locations are kept; locations
+// Square no longer lists are marked inactive.
+func (ls *LocationService) Sync(ctx context.Context, merchantID string) ([]models.Location, error) {
+	merchantID = models.MerchantIDOrDefault(merchantID)
+
+	ls.mu.Lock()
+	ls.lastSync[merchantID] = time.Now()
+	ls.mu.Unlock()
+
+	provider := ls.merchants.Provider(merchantID)
+	if provider == nil {
+		return nil, errors.New("merchant is not connected to Square")
+	}
+
+	squareLocations, err := provider.ListLocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	synced := map[string]bool{}
+	for _, squareLocation := range squareLocations {
+		if squareLocation == nil || squareLocation.ID == nil {
+			continue
+		}
+		synced[*squareLocation.ID] = true
+
+		location, err := ls.locations.GetLocation(merchantID, *squareLocation.ID)
+		if err != nil {
+			location = &models.Location{ID: *squareLocation.ID, MerchantID: merchantID}
+		}
+		location.Name = stringValue(squareLocation.Name)
+		if location.Name == "" {
+			location.Name = location.ID
+		}
+		location.Status = models.LocationStatusActive
+		if squareLocation.Status != nil {
+			location.Status = string(*squareLocation.Status)
+		}
+		location.Source = models.LocationSourceSquare
+		location.SyncedAt = &now
+		location.UpdatedAt = now
+		ls.locations.SaveLocation(*location)
+	}
+
+	for _, location := range ls.locations.ListLocations(merchantID) {
+		if location.Source == models.LocationSourceSquare && !synced[location.ID] && location.Status != models.LocationStatusInactive {
+			location.Status = models.LocationStatusInactive
+			location.UpdatedAt = now
+			ls.locations.SaveLocation(location)
+		}
+	}
+
+	log.Printf("Synced %d Square locations of merchant %s", len(synced), merchantID)
+	return ls.locations.ListLocations(merchantID), nil
+}
+
+// UpdateRules replaces the loyalty rules of a merchant's location
+func (ls *LocationService) UpdateRules(merchantID, locationID string, rules models.LocationRules) (*models.Location, error) {
+	if rules.EarnMultiplier < 0 {
+		return nil, errors.New("earnMultiplier cannot be negative")
+	}
+
+	location, err := ls.locations.GetLocation(merchantID, locationID)
+	if err != nil {
+		return nil, ErrUnknownLocation
+	}
+
+	location.LocationRules = rules
+	location.UpdatedAt = time.Now()
+	ls.locations.SaveLocation(*location)
+
+	log.Printf("Loyalty rules of location %s of merchant %s updated", locationID, location.MerchantID)
+	return ls.locations.GetLocation(merchantID, locationID)
+}
+
+// ResolveLocation returns the location a member transaction happens at. An empty location ID
+// means the merchant's default location, and nil when the merchant has none. Unknown IDs are
+// looked up in Square once before they are rejected, so new stores work before the next sync.
+func (ls *LocationService) ResolveLocation(ctx context.Context, merchantID, locationID string) (*models.Location, error) {
+	merchantID = models.MerchantIDOrDefault(merchantID)
+
+	defaulted := locationID == ""
+	if defaulted {
+		if merchant, err := ls.merchants.GetMerchant(merchantID); err == nil {
+			locationID = merchant.SquareLocationID
+		}
+		if locationID == "" {
+			return nil, nil
+		}
+	}
+
+	location, err := ls.locations.GetLocation(merchantID, locationID)
+	if err != nil && ls.canResync(merchantID) {
+		if _, syncErr := ls.Sync(ctx, merchantID); syncErr != nil {
+			log.Printf("Failed to sync locations of merchant %s: %v", merchantID, syncErr)
+		}
+		location, err = ls.locations.GetLocation(merchantID, locationID)
+	}
+	if err != nil {
+		// The default location is valid even before it has been synced
+		if defaulted {
+			return &models.Location{ID: locationID, MerchantID: merchantID, Status: models.LocationStatusActive}, nil
+		}
+		return nil, fmt.Errorf("%w %q", ErrUnknownLocation, locationID)
+	}
+
+	if location.Status != models.LocationStatusActive {
+		return nil, fmt.Errorf("%w: %q", ErrLocationInactive, locationID)
+	}
+	return location, nil
+}
+
+// Report sums up a merchant's loyalty activity per location within [from, to). Nil bounds are open.
+func (ls *LocationService) Report(merchantID string, from, to *time.Time) []models.LocationReport {
+	reports := map[string]*models.LocationReport{}
+	members := map[string]map[string]bool{}
+	for _, location := range ls.locations.ListLocations(merchantID) {
+		reports[location.ID] = &models.LocationReport{LocationID: location.ID, Name: location.Name}
+		members[location.ID] = map[string]bool{}
+	}
+
+	for _, transaction := range ls.transactions.GetMerchantTransactions(merchantID) {
+		if (from != nil && transaction.CreatedAt.Before(*from)) || (to != nil && !transaction.CreatedAt.Before(*to)) {
+			continue
+		}
+
+		report := reports[transaction.LocationID]
+		if report == nil {
+			report = &models.LocationReport{LocationID: transaction.LocationID}
+			reports[transaction.LocationID] = report
+			members[transaction.LocationID] = map[string]bool{}
+		}
+
+		report.Transactions++
+		members[transaction.LocationID][transaction.UserID] = true
+		switch transaction.Type {
+		case models.TransactionTypeEarn, models.TransactionTypePromotionEarn:
+			report.PointsEarned += transaction.Points
+		case models.TransactionTypeRedeem, models.TransactionTypeRewardCreated:
+			report.PointsRedeemed += transaction.Points
+		}
+	}
+
+	result := make([]models.LocationReport, 0, len(reports))
+	for locationID, report := range reports {
+		report.Members = len(members[locationID])
+		result = append(result, *report)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LocationID < result[j].LocationID
+	})
+	return result
+}
+
+// canResync reports whether an unknown location may trigger a sync of the merchant's locations
+func (ls *LocationService) canResync(merchantID string) bool {
+	if ls.merchants.Provider(merchantID) == nil {
+		return false
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return time.Since(ls.lastSync[merchantID]) >= locationResyncInterval
+}
+
+// syncAll syncs the locations of all connected merchants
+func (ls *LocationService) syncAll() {
+	for merchantID, provider := range ls.merchants.Providers() {
+		if !provider.Available() {
+			continue
+		}
+		if _, err := ls.Sync(context.Background(), merchantID); err != nil {
+			log.Printf("Failed to sync locations of merchant %s: %v", merchantID, err)
+		}
+	}
+}
+
+func (ls *LocationService) run() {
+	defer ls.wg.Done()
+
+	ls.syncAll()
+
+	ticker := time.NewTicker(ls.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ls.stop:
+			return
+		case <-ticker.C:
+			ls.syncAll()
+		}
+	}
+}
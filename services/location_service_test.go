@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+// storeLocation configures a location of the default merchant with loyalty rules. Square never
+// lists it, so syncs leave it alone.
+func (sc *squareScenario) storeLocation(t *testing.T, locationID string, rules models.LocationRules) {
+	t.Helper()
+
+	storage.GetGlobalLocationStorage().SaveLocation(models.Location{
+		ID:         locationID,
+		MerchantID: models.DefaultMerchantID,
+		Name:       locationID,
+		Status:     models.LocationStatusActive,
+		Source:     models.LocationSourceConfig,
+		UpdatedAt:  time.Now(),
+	})
+	if _, err := sc.loyalty.locations.UpdateRules(models.DefaultMerchantID, locationID, rules); err != nil {
+		t.Fatalf("UpdateRules: %v", err)
+	}
+}
+
+func TestRedeemOnlyOffersLocationRewardTiers(t *testing.T) {
+	sc := newSquareScenario(t)
+	user := sc.provisionedMember(t)
+	kiosk := "kiosk-" + user.ID
+	sc.storeLocation(t, kiosk, models.LocationRules{RewardTierIDs: []string{"tier-coffee"}})
+	ctx := context.Background()
+
+	if _, err := sc.loyalty.EarnPoints(ctx, user.ID, 1000, "Catering order", ""); err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+
+	if _, err := sc.loyalty.RedeemPoints(ctx, user.ID, 500, "Lunch", kiosk, "tier-lunch"); !errors.Is(err, ErrLocationRuleViolation) {
+		t.Fatalf("redeem a tier the kiosk does not offer = %v, want ErrLocationRuleViolation", err)
+	}
+	if user.Points != 1000 {
+		t.Fatalf("balance = %d after a refused redemption, want 1000", user.Points)
+	}
+	if _, err := sc.loyalty.RedeemPoints(ctx, user.ID, 100, "Coffee", kiosk, "tier-coffee"); err != nil {
+		t.Fatalf("redeem an offered tier: %v", err)
+	}
+	// The default location offers every tier
+	if _, err := sc.loyalty.RedeemPoints(ctx, user.ID, 500, "Lunch", "", "tier-lunch"); err != nil {
+		t.Fatalf("redeem at the default location: %v", err)
+	}
+	if user.Points != 400 {
+		t.Fatalf("balance = %d, want 400", user.Points)
+	}
+}
+
+func TestLocationRulesApplyToEarnAndRedeem(t *testing.T) {
+	sc := newSquareScenario(t)
+	user := sc.provisionedMember(t)
+	doubled, closed, noRedeem := "doubled-"+user.ID, "closed-"+user.ID, "no-redeem-"+user.ID
+	sc.storeLocation(t, doubled, models.LocationRules{EarnMultiplier: 2})
+	sc.storeLocation(t, closed, models.LocationRules{EarnDisabled: true})
+	sc.storeLocation(t, noRedeem, models.LocationRules{RedeemDisabled: true})
+	ctx := context.Background()
+
+	transaction, err := sc.loyalty.EarnPoints(ctx, user.ID, 15, "Double points day", doubled)
+	if err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+	if transaction.Points != 30 || transaction.LocationID != doubled {
+		t.Fatalf("earned %d at %q, want 30 at %s", transaction.Points, transaction.LocationID, doubled)
+	}
+	if _, err := sc.loyalty.EarnPoints(ctx, user.ID, 10, "Closed store", closed); !errors.Is(err, ErrLocationRuleViolation) {
+		t.Errorf("earn where earning is disabled = %v, want ErrLocationRuleViolation", err)
+	}
+	if _, err := sc.loyalty.RedeemPoints(ctx, user.ID, 10, "Treat", noRedeem, ""); !errors.Is(err, ErrLocationRuleViolation) {
+		t.Errorf("redeem where redeeming is disabled = %v, want ErrLocationRuleViolation", err)
+	}
+	if _, err := sc.loyalty.RedeemPoints(ctx, user.ID, 10, "Treat", closed, ""); err != nil {
+		t.Errorf("redeem where only earning is disabled: %v", err)
+	}
+	if _, err := sc.loyalty.EarnPoints(ctx, user.ID, 10, "Nowhere", "missing-"+user.ID); !errors.Is(err, ErrUnknownLocation) {
+		t.Errorf("earn at an unknown location = %v, want ErrUnknownLocation", err)
+	}
+	if user.Points != 20 {
+		t.Errorf("balance = %d, want 20", user.Points)
+	}
+
+	if _, err := sc.loyalty.locations.UpdateRules(models.DefaultMerchantID, doubled, models.LocationRules{EarnMultiplier: -1}); err == nil {
+		t.Error("negative earn multiplier accepted")
+	}
+}
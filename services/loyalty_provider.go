@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+
+	"loyalty-core/models"
+
+	square "github.com/square/square-go-sdk"
+)
+
+// LoyaltyProvider is the external loyalty backend used by LoyaltyService.
+// SquareService implements it against the Square Loyalty API.
+// Calls are bounded by the context; Available reports false while the provider is known to be
+// unhealthy, in which case callers degrade to local mode.
+type LoyaltyProvider interface {
+	Available() bool
+	GetLoyaltyProgram(ctx context.Context) (*square.LoyaltyProgram, error)
+	CreateLoyaltyAccount(ctx context.Context, phoneNumber, givenName, familyName string) (*square.LoyaltyAccount, error)
+	GetLoyaltyAccount(ctx context.Context, accountID string) (*square.LoyaltyAccount, error)
+	SearchLoyaltyAccounts(ctx context.Context, phoneNumber string) ([]*square.LoyaltyAccount, error)
+	AccumulateLoyaltyPoints(ctx context.Context, accountID string, points int, orderID, locationID, idempotencyKey string) (*square.LoyaltyEvent, error)
+	CalculateLoyaltyPoints(ctx context.Context, orderID, accountID string) (int, error)
+	AdjustLoyaltyPoints(ctx context.Context, accountID string, points int, reason, idempotencyKey string) (*square.LoyaltyEvent, error)
+	CreateLoyaltyReward(ctx context.Context, accountID string, rewardTierID string, orderID string) (*square.LoyaltyReward, error)
+	SearchLoyaltyRewards(ctx context.Context, accountID string) ([]*square.LoyaltyReward, error)
+	ListLocations(ctx context.Context) ([]*square.Location, error)
+	DefaultLocationID() string
+	SearchLoyaltyEvents(ctx context.Context, accountID string, filter models.HistoryFilter, limit int, cursor string) ([]*square.LoyaltyEvent, string, error)
+}
+
+var _ LoyaltyProvider = (*SquareService)(nil)
@@ -1,12 +1,14 @@
 package services
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"sync"
 	"time"
 
 	"loyalty-core/config"
@@ -16,136 +18,443 @@ import (
 	square "github.com/square/square-go-sdk"
 )
 
+// ErrOrderAlreadyCredited is returned when points were already earned for a Square order
+var ErrOrderAlreadyCredited = errors.New("order has already been credited")
+
+// ErrLocationRuleViolation is returned when a location's rules do not allow earning or redeeming there
+var ErrLocationRuleViolation = errors.New("not allowed at this location")
+
 type LoyaltyService struct {
-	config        *config.Config
-	userStorage   *storage.UserStorage
-	squareService *SquareService
-	transactions  map[string][]models.Transaction
-	instanceID    string // Debug: track service instance
+	config       *config.Config
+	userStorage  *storage.UserStorage
+	merchants    *MerchantService // Square clients of each merchant
+	locations    *LocationService
+	transactions *storage.TransactionStorage
+	outbox       *storage.OutboxStorage
+	audit        *AuditService // records changes made outside requests, e.g. by webhooks and workers
+	provisionMu  sync.Mutex
+	ledgerMu     sync.Mutex
+	instanceID   string // Debug: track service instance
+
+	transactionHooks []func(user models.User, transaction models.Transaction, previousBalance int)
 }
 
-func NewLoyaltyService(cfg *config.Config) *LoyaltyService {
-	var squareService *SquareService
+// NewLoyaltyService creates a loyalty service that talks to each member's merchant through the
+// merchant's own Square client
+func NewLoyaltyService(cfg *config.Config, merchants *MerchantService, locations *LocationService, audit *AuditService) *LoyaltyService {
+	service := &LoyaltyService{
+		config:       cfg,
+		userStorage:  storage.GetGlobalUserStorage(),
+		merchants:    merchants,
+		locations:    locations,
+		transactions: storage.GetGlobalTransactionStorage(),
+		outbox:       storage.GetGlobalOutboxStorage(),
+		audit:        audit,
+	}
 
-	// Try to initialize Square service, but don't fail if it's not available
-	if cfg.SquareAccessToken != "" && cfg.SquareAccessToken != "your-square-access-token" &&
-		cfg.SquareLocationID != "" && cfg.SquareLocationID != "your-square-location-id" {
-		var err error
-		squareService, err = NewSquareService(cfg)
-		if err != nil {
-			log.Printf("Warning: Square service not available: %v", err)
-			log.Printf("Running in fallback mode with in-memory storage")
-		} else {
-			log.Printf("Square service initialized successfully")
+	return service
+}
+
+// NewLoyaltyServiceWithProvider creates a loyalty service whose default merchant is backed by the
+// given provider. A nil provider runs the service in fallback mode with local storage only.
+func NewLoyaltyServiceWithProvider(cfg *config.Config, provider LoyaltyProvider) *LoyaltyService {
+	merchants := NewMerchantServiceWithProvider(cfg, provider)
+	return NewLoyaltyService(cfg, merchants, NewLocationService(cfg, merchants), NewAuditService(cfg))
+}
+
+// OnTransaction registers a hook that runs after a new transaction has been added to the ledger,
+// with the member as updated and their balance before the transaction. Hooks run while the
+// ledger is locked, so they must be quick and must not call back into the service.
+func (s *LoyaltyService) OnTransaction(hook func(user models.User, transaction models.Transaction, previousBalance int)) {
+	s.transactionHooks = append(s.transactionHooks, hook)
+}
+
+// providerFor returns the Square client of the user's merchant, or nil when the merchant is not
+// connected to Square
+func (s *LoyaltyService) providerFor(user *models.User) LoyaltyProvider {
+	return s.merchants.Provider(user.MerchantID)
+}
+
+// EarnPoints credits points earned at a location, scaled by the location's earn multiplier.
+// An empty location ID means the merchant's default location.
+func (s *LoyaltyService) EarnPoints(ctx context.Context, userID string, points int, description, locationID string) (*models.Transaction, error) {
+	user, err := s.getActiveUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := s.earnLocation(ctx, user, locationID)
+	if err != nil {
+		return nil, err
+	}
+	if location != nil && location.EarnMultiplier > 0 {
+		points = int(float64(points) * location.EarnMultiplier)
+		if points <= 0 {
+			return nil, errors.New("points earned at this location round down to zero")
 		}
-	} else {
-		log.Printf("Square credentials not configured, running in fallback mode")
 	}
 
-	service := &LoyaltyService{
-		config:        cfg,
-		userStorage:   storage.GetGlobalUserStorage(),
-		squareService: squareService,
-		transactions:  make(map[string][]models.Transaction),
+	// Create transaction
+	transaction := models.Transaction{
+		ID:          s.generateID(),
+		UserID:      userID,
+		Type:        models.TransactionTypeEarn,
+		Points:      points,
+		Description: description,
+		Source:      models.TransactionSourceLocal,
+		LocationID:  locationIDOf(location),
+		CreatedAt:   time.Now(),
 	}
 
-	return service
+	// Record locally; the Square write is delivered by the outbox worker
+	return s.recordWithOutbox(user, transaction, models.OutboxItem{
+		Operation:  models.OutboxOperationAccumulatePoints,
+		Points:     points,
+		LocationID: transaction.LocationID,
+	})
 }
 
-func (s *LoyaltyService) EarnPoints(userID string, points int, description string) (*models.Transaction, error) {
-	user, err := s.userStorage.GetUserByID(userID)
+// EarnPointsForOrder credits the points a Square order earns under the loyalty program.
+// Square calculates the points, so location earn multipliers do not apply; each order can be
+// credited only once.
+func (s *LoyaltyService) EarnPointsForOrder(ctx context.Context, userID, orderID, description, locationID string) (*models.Transaction, error) {
+	user, err := s.getActiveUser(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Ensure user has a Square loyalty account
-	if err := s.ensureSquareLoyaltyAccount(user); err != nil {
+	location, err := s.earnLocation(ctx, user, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := s.providerFor(user)
+	if provider == nil {
+		return nil, errors.New("earning points for an order requires Square")
+	}
+	if !provider.Available() {
+		return nil, ErrSquareUnavailable
+	}
+
+	if _, credited := s.transactions.GetEarnTransactionByOrderID(user.MerchantID, orderID); credited {
+		return nil, ErrOrderAlreadyCredited
+	}
+
+	// Points are calculated for the member's Square account, which also decides promotion eligibility
+	if err := s.ensureSquareLoyaltyAccount(ctx, user); err != nil {
 		return nil, fmt.Errorf("failed to ensure Square loyalty account: %w", err)
 	}
 
+	points, err := provider.CalculateLoyaltyPoints(ctx, orderID, user.SquareAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if points <= 0 {
+		return nil, errors.New("order does not earn any points")
+	}
+
+	if description == "" {
+		description = "Points earned for order " + orderID
+	}
+
+	transaction := models.Transaction{
+		ID:          s.generateID(),
+		UserID:      userID,
+		Type:        models.TransactionTypeEarn,
+		Points:      points,
+		Description: description,
+		Source:      models.TransactionSourceLocal,
+		OrderID:     orderID,
+		LocationID:  locationIDOf(location),
+		CreatedAt:   time.Now(),
+	}
+
+	// Square accumulates against the order itself, so the outbox sends the order ID, not the points
+	return s.recordWithOutbox(user, transaction, models.OutboxItem{
+		Operation:  models.OutboxOperationAccumulatePoints,
+		Points:     points,
+		OrderID:    orderID,
+		LocationID: transaction.LocationID,
+	})
+}
+
+// RedeemPoints debits points redeemed at a location, optionally for one of the program's reward
+// tiers, which the location must offer. An empty location ID means the merchant's default
+// location. Square adjustments carry no location, so it is only recorded locally.
+func (s *LoyaltyService) RedeemPoints(ctx context.Context, userID string, points int, description, locationID, rewardTierID string) (*models.Transaction, error) {
+	user, err := s.getActiveUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := s.locations.ResolveLocation(ctx, user.MerchantID, locationID)
+	if err != nil {
+		return nil, err
+	}
+	if location != nil && location.RedeemDisabled {
+		return nil, fmt.Errorf("redeeming points is %w", ErrLocationRuleViolation)
+	}
+	if location != nil && rewardTierID != "" && !location.OffersRewardTier(rewardTierID) {
+		return nil, fmt.Errorf("reward tier %q is %w", rewardTierID, ErrLocationRuleViolation)
+	}
+
 	// Create transaction
 	transaction := models.Transaction{
 		ID:          s.generateID(),
 		UserID:      userID,
-		Type:        "earn",
+		Type:        models.TransactionTypeRedeem,
 		Points:      points,
 		Description: description,
+		Source:      models.TransactionSourceLocal,
+		LocationID:  locationIDOf(location),
+		Reason:      description,
 		CreatedAt:   time.Now(),
 	}
 
-	// If Square service is available, accumulate points in Square
-	if s.squareService != nil {
-		orderID := "order-" + transaction.ID // Create a mock order ID
-		_, err := s.squareService.AccumulateLoyaltyPoints(user.LoyaltyID, points, orderID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to accumulate points in Square: %w", err)
-		}
+	// Record locally; the Square write is delivered by the outbox worker.
+	// Use adjust points to subtract points (negative value)
+	return s.recordWithOutbox(user, transaction, models.OutboxItem{
+		Operation: models.OutboxOperationAdjustPoints,
+		Points:    -points,
+		Reason:    description,
+	})
+}
+
+// ExportLedger returns the complete local ledger of a member of the merchant
+func (s *LoyaltyService) ExportLedger(merchantID, userID string) (*models.LedgerExport, error) {
+	user, err := s.userStorage.GetMerchantUserByID(merchantID, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Update user points locally (for fallback)
-	user.Points += points
-	user.UpdatedAt = time.Now()
+	return &models.LedgerExport{
+		ExportedAt:   time.Now(),
+		MerchantID:   user.MerchantID,
+		UserID:       user.ID,
+		LoyaltyID:    user.LoyaltyID,
+		Balance:      user.Points,
+		Transactions: s.transactions.GetTransactionsByUserID(user.MerchantID, user.ID),
+	}, nil
+}
 
-	// Save updated user
-	if err := s.userStorage.UpdateUser(user); err != nil {
+// earnLocation resolves the location points are earned at and checks earning is allowed there
+func (s *LoyaltyService) earnLocation(ctx context.Context, user *models.User, locationID string) (*models.Location, error) {
+	location, err := s.locations.ResolveLocation(ctx, user.MerchantID, locationID)
+	if err != nil {
 		return nil, err
 	}
+	if location != nil && location.EarnDisabled {
+		return nil, fmt.Errorf("earning points is %w", ErrLocationRuleViolation)
+	}
+	return location, nil
+}
 
-	// Store transaction
-	s.transactions[userID] = append(s.transactions[userID], transaction)
+// locationIDOf returns the ID of a resolved location, or empty when there is none
+func locationIDOf(location *models.Location) string {
+	if location == nil {
+		return ""
+	}
+	return location.ID
+}
 
-	return &transaction, nil
+// GetBalance returns the local balance, which includes writes not yet delivered to Square
+func (s *LoyaltyService) GetBalance(userID string) (*models.BalanceResponse, error) {
+	user, err := s.getActiveUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.BalanceResponse{
+		Points:       user.Points,
+		Transactions: s.transactions.GetTransactionsByUserID(user.MerchantID, userID),
+	}, nil
 }
 
-func (s *LoyaltyService) RedeemPoints(userID string, points int, description string) (*models.Transaction, error) {
-	user, err := s.userStorage.GetUserByID(userID)
+// GetTransactionHistory returns one page of the user's history, newest first. The history comes
+// from Square's loyalty events when Square is enabled and healthy, otherwise from the local ledger.
+// cursor is the NextCursor of the previous page, or empty for the first page.
+func (s *LoyaltyService) GetTransactionHistory(ctx context.Context, userID string, filter models.HistoryFilter, limit int, cursor string) (*models.HistoryPage, error) {
+	user, err := s.getActiveUser(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Ensure user has a Square loyalty account
-	if err := s.ensureSquareLoyaltyAccount(user); err != nil {
-		return nil, fmt.Errorf("failed to ensure Square loyalty account: %w", err)
+	for _, eventType := range filter.Types {
+		if _, err := square.NewLoyaltyEventTypeFromString(eventType); err != nil {
+			return nil, fmt.Errorf("unknown event type %q", eventType)
+		}
 	}
+	if limit <= 0 || limit > maxHistoryPageSize {
+		limit = maxHistoryPageSize
+	}
+
+	source, position, err := decodeHistoryCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	// While Square is unhealthy the history is served from the local ledger. A history that was
+	// started locally keeps paging locally.
+	provider := s.providerFor(user)
+	useSquare := provider != nil && provider.Available() && source != historyCursorLocal
+	if provider != nil && source == historyCursorSquare && !useSquare {
+		return nil, ErrSquareUnavailable
+	}
+
+	// Get transaction history from Square if available
+	if useSquare {
+		// Ensure user has a Square loyalty account
+		if err := s.ensureSquareLoyaltyAccount(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to ensure Square loyalty account: %w", err)
+		}
 
-	// Check balance from Square if available
-	if s.squareService != nil {
-		account, err := s.squareService.GetLoyaltyAccount(user.LoyaltyID)
+		events, nextCursor, err := provider.SearchLoyaltyEvents(ctx, user.SquareAccountID, filter, limit, position)
+		if errors.Is(err, ErrSquareUnavailable) && source == "" {
+			return s.localHistoryPage(user, filter, limit, 0), nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to get Square account balance: %w", err)
+			return nil, fmt.Errorf("failed to get Square transaction history: %w", err)
+		}
+
+		// Convert Square events to our Transaction model
+		transactions := make([]models.Transaction, 0, len(events))
+		for _, event := range events {
+			transaction := s.convertSquareEventToTransaction(event, user)
+			if transaction != nil {
+				transactions = append(transactions, *transaction)
+			}
 		}
 
-		if account.Balance != nil && *account.Balance < points {
-			return nil, errors.New("insufficient points")
+		page := &models.HistoryPage{Transactions: transactions, Count: len(transactions)}
+		if nextCursor != "" {
+			page.NextCursor = encodeHistoryCursor(historyCursorSquare, nextCursor)
 		}
-	} else {
-		// Fallback to local balance check
-		if user.Points < points {
-			return nil, errors.New("insufficient points")
+		return page, nil
+	}
+
+	// Fallback to local transactions
+	if source == historyCursorSquare {
+		return nil, errors.New("invalid cursor")
+	}
+	offset := 0
+	if position != "" {
+		if offset, err = strconv.Atoi(position); err != nil || offset < 0 {
+			return nil, errors.New("invalid cursor")
 		}
 	}
+	return s.localHistoryPage(user, filter, limit, offset), nil
+}
 
-	// Create transaction
-	transaction := models.Transaction{
-		ID:          s.generateID(),
-		UserID:      userID,
-		Type:        "redeem",
-		Points:      points,
-		Description: description,
-		CreatedAt:   time.Now(),
+// localHistoryPage pages through the local ledger, newest first
+func (s *LoyaltyService) localHistoryPage(user *models.User, filter models.HistoryFilter, limit, offset int) *models.HistoryPage {
+	matched := []models.Transaction{}
+	ledger := s.transactions.GetTransactionsByUserID(user.MerchantID, user.ID)
+	for i := len(ledger) - 1; i >= 0; i-- {
+		if transactionMatchesFilter(ledger[i], filter) {
+			matched = append(matched, ledger[i])
+		}
 	}
 
-	// If Square service is available, redeem points in Square
-	if s.squareService != nil {
-		// Use adjust points to subtract points (negative value)
-		_, err := s.squareService.AdjustLoyaltyPoints(user.LoyaltyID, -points, description)
+	page := &models.HistoryPage{Transactions: []models.Transaction{}}
+	if offset < len(matched) {
+		end := offset + limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page.Transactions = matched[offset:end]
+		if end < len(matched) {
+			page.NextCursor = encodeHistoryCursor(historyCursorLocal, strconv.Itoa(end))
+		}
+	}
+	page.Count = len(page.Transactions)
+	return page
+}
+
+// searchAllLoyaltyEvents follows the cursor through every page of an account's Square events
+func (s *LoyaltyService) searchAllLoyaltyEvents(ctx context.Context, provider LoyaltyProvider, accountID string) ([]*square.LoyaltyEvent, error) {
+	all := []*square.LoyaltyEvent{}
+	cursor := ""
+	for {
+		events, nextCursor, err := provider.SearchLoyaltyEvents(ctx, accountID, models.HistoryFilter{}, maxHistoryPageSize, cursor)
 		if err != nil {
-			return nil, fmt.Errorf("failed to redeem points in Square: %w", err)
+			return nil, err
+		}
+		all = append(all, events...)
+
+		if nextCursor == "" || nextCursor == cursor {
+			return all, nil
+		}
+		cursor = nextCursor
+	}
+}
+
+// recordWithOutbox stores a transaction and, when Square is enabled, the Square write that mirrors
+// it, as one step. Debits are checked against the local balance.
+func (s *LoyaltyService) recordWithOutbox(user *models.User, transaction models.Transaction, item models.OutboxItem) (*models.Transaction, error) {
+	s.ledgerMu.Lock()
+	defer s.ledgerMu.Unlock()
+
+	if user.Points+transaction.SignedPoints() < 0 {
+		return nil, errors.New("insufficient points")
+	}
+
+	// Checked again under the lock in case the same order was submitted concurrently
+	if transaction.OrderID != "" {
+		if _, credited := s.transactions.GetEarnTransactionByOrderID(user.MerchantID, transaction.OrderID); credited {
+			return nil, ErrOrderAlreadyCredited
 		}
 	}
 
-	// Update user points locally (for fallback)
-	user.Points -= points
+	recorded, err := s.recordTransactionLocked(user, transaction, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.merchants.UsesSquare(user.MerchantID) {
+		item.TransactionID = transaction.ID
+		item.IdempotencyKey = "txn-" + transaction.ID
+		s.enqueueLocked(user, item)
+	}
+
+	return recorded, nil
+}
+
+// enqueueLocked adds a Square write for a member to the outbox. Callers hold ledgerMu, so the
+// write is queued together with the ledger state it was computed from.
+func (s *LoyaltyService) enqueueLocked(user *models.User, item models.OutboxItem) models.OutboxItem {
+	item.ID = s.generateID()
+	item.MerchantID = user.MerchantID
+	item.UserID = user.ID
+	item.Status = models.OutboxStatusPending
+	item.NextAttemptAt = time.Now()
+	item.CreatedAt = time.Now()
+	s.outbox.AddItem(item)
+	return item
+}
+
+// recordTransaction stores a transaction in the ledger and, if applyToBalance is set, applies it
+// to the user's local balance. A transaction for a Square event that is already in the ledger
+// (for example, delivered by a webhook before the API call returned) is not applied twice;
+// the existing entry is returned.
+func (s *LoyaltyService) recordTransaction(user *models.User, transaction models.Transaction, applyToBalance bool) (*models.Transaction, error) {
+	s.ledgerMu.Lock()
+	defer s.ledgerMu.Unlock()
+
+	return s.recordTransactionLocked(user, transaction, applyToBalance)
+}
+
+// recordTransactionLocked is recordTransaction for callers already holding ledgerMu
+func (s *LoyaltyService) recordTransactionLocked(user *models.User, transaction models.Transaction, applyToBalance bool) (*models.Transaction, error) {
+	if transaction.SquareEventID != "" {
+		if existing, found := s.transactions.GetTransactionBySquareEventID(user.MerchantID, user.ID, transaction.SquareEventID); found {
+			return existing, nil
+		}
+	}
+
+	previousBalance := user.Points
+	if applyToBalance {
+		user.Points += transaction.SignedPoints()
+	}
 	user.UpdatedAt = time.Now()
 
 	// Save updated user
@@ -153,186 +462,437 @@ func (s *LoyaltyService) RedeemPoints(userID string, points int, description str
 		return nil, err
 	}
 
-	// Store transaction
-	s.transactions[userID] = append(s.transactions[userID], transaction)
+	// Store transaction in the member's merchant ledger
+	transaction.MerchantID = user.MerchantID
+	s.transactions.AddTransaction(transaction)
+
+	for _, hook := range s.transactionHooks {
+		hook(*user, transaction, previousBalance)
+	}
 
 	return &transaction, nil
 }
 
-func (s *LoyaltyService) GetBalance(userID string) (*models.BalanceResponse, error) {
-	user, err := s.userStorage.GetUserByID(userID)
-	if err != nil {
-		return nil, err
+// applySquareBalance saves a member with the balance of a Square account snapshot taken at
+// updatedAt. The balance is skipped (the member is still saved) when it is nil, older than the
+// snapshot already applied, or when outbox writes are pending, since Square's balance then lags
+// the local ledger. The outbox is checked under ledgerMu, where new writes are enqueued.
+func (s *LoyaltyService) applySquareBalance(user *models.User, balance *int, updatedAt time.Time) error {
+	s.ledgerMu.Lock()
+	defer s.ledgerMu.Unlock()
+
+	if balance != nil && !s.outbox.HasUndelivered(user.ID) &&
+		(user.SquareBalanceSyncedAt == nil || updatedAt.After(*user.SquareBalanceSyncedAt)) {
+		user.Points = *balance
+		user.SquareBalanceSyncedAt = &updatedAt
 	}
+	user.UpdatedAt = time.Now()
 
-	// Ensure user has a Square loyalty account
-	if err := s.ensureSquareLoyaltyAccount(user); err != nil {
-		return nil, fmt.Errorf("failed to ensure Square loyalty account: %w", err)
-	}
+	return s.userStorage.UpdateUser(user)
+}
 
-	var balance int
-	var transactions []models.Transaction
+// settleClosingAccount zeroes a closing member's balance with a final ledger entry of the given
+// type ("forfeit" or "payout") and drops the Square loyalty account mapping.
+// It returns the number of points settled.
+func (s *LoyaltyService) settleClosingAccount(ctx context.Context, user *models.User, transactionType string) (int, error) {
+	balance := user.Points
 
-	// Get balance from Square if available
-	if s.squareService != nil {
-		account, err := s.squareService.GetLoyaltyAccount(user.LoyaltyID)
+	if provider := s.providerFor(user); provider != nil && user.SquareAccountID != "" {
+		// Square must have caught up with the local ledger before it can be zeroed
+		if s.outbox.HasUndelivered(user.ID) {
+			return 0, errors.New("pending loyalty updates are still being synced, please try again shortly")
+		}
+
+		account, err := provider.GetLoyaltyAccount(ctx, user.SquareAccountID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get Square account balance: %w", err)
+			return 0, fmt.Errorf("failed to get Square account balance: %w", err)
 		}
 
-		if account.Balance != nil {
-			balance = *account.Balance
+		if account.Balance != nil && *account.Balance > 0 {
+			reason := "Account closed: balance " + transactionType
+			idempotencyKey := "close-" + user.ID
+			if _, err := provider.AdjustLoyaltyPoints(ctx, user.SquareAccountID, -*account.Balance, reason, idempotencyKey); err != nil {
+				return 0, fmt.Errorf("failed to zero Square balance: %w", err)
+			}
 		}
+	}
 
-		// Get transaction history from Square
-		squareTransactions, err := s.GetTransactionHistory(userID)
-		if err == nil {
-			transactions = squareTransactions
+	if balance > 0 {
+		transaction := models.Transaction{
+			ID:          s.generateID(),
+			UserID:      user.ID,
+			Type:        transactionType,
+			Points:      balance,
+			Description: "Balance " + transactionType + " on account closure",
+			Source:      models.TransactionSourceLocal,
+			CreatedAt:   time.Now(),
+		}
+		if _, err := s.recordTransaction(user, transaction, true); err != nil {
+			return 0, err
 		}
-	} else {
-		// Fallback to local balance
-		balance = user.Points
-		transactions = s.transactions[userID]
 	}
 
-	if transactions == nil {
-		transactions = []models.Transaction{}
+	if user.SquareAccountID != "" {
+		log.Printf("Square loyalty account mapping %s deactivated for user %s", user.SquareAccountID, user.ID)
+		user.SquareAccountID = ""
+		user.Provisioning.Status = models.ProvisioningStatusDeactivated
 	}
+	user.Points = 0
+	user.UpdatedAt = time.Now()
 
-	return &models.BalanceResponse{
-		Points:       balance,
-		Transactions: transactions,
-	}, nil
+	return balance, nil
+}
+
+// SquareStatus reports the Square integration's health: "disabled" when running without Square,
+// "degraded" while a circuit breaker keeps calls from reaching Square, otherwise "ok"
+func (s *LoyaltyService) SquareStatus() string {
+	return s.merchants.SquareStatus()
 }
 
-func (s *LoyaltyService) GetTransactionHistory(userID string) ([]models.Transaction, error) {
+// getActiveUser retrieves a user, rejecting closed and locked accounts
+func (s *LoyaltyService) getActiveUser(userID string) (*models.User, error) {
 	user, err := s.userStorage.GetUserByID(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Ensure user has a Square loyalty account
-	if err := s.ensureSquareLoyaltyAccount(user); err != nil {
-		return nil, fmt.Errorf("failed to ensure Square loyalty account: %w", err)
+	switch user.Status {
+	case models.AccountStatusClosed:
+		return nil, errors.New("account is closed")
+	case models.AccountStatusLocked:
+		return nil, ErrAccountLocked
 	}
 
-	// Get transaction history from Square if available
-	if s.squareService != nil {
-		events, err := s.squareService.SearchLoyaltyEvents(user.LoyaltyID, 50)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get Square transaction history: %w", err)
-		}
-
-		// Convert Square events to our Transaction model
-		transactions := make([]models.Transaction, 0, len(events))
-		for _, event := range events {
-			transaction := s.convertSquareEventToTransaction(event, userID)
-			if transaction != nil {
-				transactions = append(transactions, *transaction)
-			}
-		}
+	return user, nil
+}
 
-		return transactions, nil
+// getOpenMerchantUser retrieves a member of the merchant for an admin operation, rejecting
+// closed accounts only
+func (s *LoyaltyService) getOpenMerchantUser(merchantID, userID string) (*models.User, error) {
+	user, err := s.userStorage.GetMerchantUserByID(merchantID, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Fallback to local transactions
-	transactions := s.transactions[userID]
-	if transactions == nil {
-		return []models.Transaction{}, nil
+	if user.Status == models.AccountStatusClosed {
+		return nil, errors.New("account is closed")
 	}
 
-	return transactions, nil
+	return user, nil
 }
 
-// ensureSquareLoyaltyAccount ensures the user has a Square loyalty account
-func (s *LoyaltyService) ensureSquareLoyaltyAccount(user *models.User) error {
-	if s.squareService == nil {
+// ensureSquareLoyaltyAccount ensures the user has a Square loyalty account, provisioning it on demand
+func (s *LoyaltyService) ensureSquareLoyaltyAccount(ctx context.Context, user *models.User) error {
+	if s.providerFor(user) == nil {
 		return nil // Skip if Square service is not available
 	}
 
-	if user.LoyaltyID != "" {
+	if user.SquareAccountID != "" {
 		return nil // User already has a loyalty account
 	}
 
-	// Create a phone number for the user (required by Square)
-	// In a real application, you would collect this from the user
-	phoneNumber := "+1555" + strconv.Itoa(int(time.Now().Unix())%10000000) // Generate a mock phone number
+	return s.provisionSquareAccount(ctx, user)
+}
 
-	// Create loyalty account in Square
-	account, err := s.squareService.CreateLoyaltyAccount(phoneNumber, user.FirstName, user.LastName)
+// ProvisionAccount creates or links the user's Square loyalty account and reports the outcome.
+// It is safe to call repeatedly; already provisioned users are left untouched.
+func (s *LoyaltyService) ProvisionAccount(ctx context.Context, userID string) (*models.LoyaltyAccountResponse, error) {
+	user, err := s.getActiveUser(userID)
 	if err != nil {
-		return fmt.Errorf("failed to create Square loyalty account: %w", err)
+		return nil, err
 	}
 
-	// Update user with the loyalty account ID
-	user.LoyaltyID = *account.ID
-	user.UpdatedAt = time.Now()
+	if err := s.ensureSquareLoyaltyAccount(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return s.GetLoyaltyAccount(userID)
+}
+
+// GetLoyaltyAccount reports the user's loyalty number and Square provisioning status
+func (s *LoyaltyService) GetLoyaltyAccount(userID string) (*models.LoyaltyAccountResponse, error) {
+	user, err := s.userStorage.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	provisioning := user.Provisioning
+	if !s.merchants.UsesSquare(user.MerchantID) && user.SquareAccountID == "" && provisioning.Status != models.ProvisioningStatusDeactivated {
+		provisioning.Status = models.ProvisioningStatusNotRequired
+	}
+
+	return &models.LoyaltyAccountResponse{
+		LoyaltyID:       user.LoyaltyID,
+		SquareAccountID: user.SquareAccountID,
+		Provisioning:    provisioning,
+	}, nil
+}
+
+// ListRewards returns the rewards of a user's Square loyalty account, newest first. A user
+// without a Square account has none.
+func (s *LoyaltyService) ListRewards(ctx context.Context, user *models.User) ([]models.MemberReward, error) {
+	rewards := []models.MemberReward{}
+	if user.SquareAccountID == "" {
+		return rewards, nil
+	}
+
+	provider := s.providerFor(user)
+	if provider == nil {
+		return rewards, nil
+	}
+	if !provider.Available() {
+		return nil, ErrSquareUnavailable
+	}
+
+	squareRewards, err := provider.SearchLoyaltyRewards(ctx, user.SquareAccountID)
+	if err != nil {
+		return nil, err
+	}
+	for _, reward := range squareRewards {
+		rewards = append(rewards, convertSquareReward(reward))
+	}
+	return rewards, nil
+}
+
+// convertSquareReward maps a Square loyalty reward to a member reward
+func convertSquareReward(reward *square.LoyaltyReward) models.MemberReward {
+	converted := models.MemberReward{RewardTierID: reward.RewardTierID}
+	if reward.ID != nil {
+		converted.ID = *reward.ID
+	}
+	if reward.Status != nil {
+		converted.Status = string(*reward.Status)
+	}
+	if reward.Points != nil {
+		converted.Points = *reward.Points
+	}
+	if reward.OrderID != nil {
+		converted.OrderID = *reward.OrderID
+	}
+	converted.CreatedAt = parseSquareTime(reward.CreatedAt)
+	converted.RedeemedAt = parseSquareTime(reward.RedeemedAt)
+	return converted
+}
+
+// parseSquareTime parses an optional RFC 3339 timestamp from Square
+func parseSquareTime(value *string) *time.Time {
+	if value == nil || *value == "" {
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+// provisionSquareAccount links or creates the Square loyalty account for a user and records the attempt
+func (s *LoyaltyService) provisionSquareAccount(ctx context.Context, user *models.User) error {
+	// Serialize provisioning so concurrent requests and the background queue
+	// never create two Square accounts for the same member
+	s.provisionMu.Lock()
+	defer s.provisionMu.Unlock()
+
+	if user.SquareAccountID != "" {
+		return nil // Provisioned while waiting for the lock
+	}
+
+	now := time.Now()
+	user.Provisioning.Attempts++
+	user.Provisioning.LastAttemptAt = &now
+
+	account, err := s.linkOrCreateSquareAccount(ctx, s.providerFor(user), user)
+	if err != nil {
+		user.Provisioning.Status = models.ProvisioningStatusFailed
+		user.Provisioning.LastError = err.Error()
+		user.UpdatedAt = now
+		if saveErr := s.userStorage.UpdateUser(user); saveErr != nil {
+			log.Printf("Failed to record provisioning failure for user %s: %v", user.ID, saveErr)
+		}
+		return err
+	}
+
+	// Update user with the Square loyalty account ID
+	user.SquareAccountID = *account.ID
+	user.Provisioning.Status = models.ProvisioningStatusProvisioned
+	user.Provisioning.LastError = ""
+	user.Provisioning.ProvisionedAt = &now
+	user.UpdatedAt = now
 
 	// Save the updated user
 	if err := s.userStorage.UpdateUser(user); err != nil {
-		return fmt.Errorf("failed to save user with loyalty ID: %w", err)
+		return fmt.Errorf("failed to save user with Square account ID: %w", err)
 	}
 
 	return nil
 }
 
-// convertSquareEventToTransaction converts a Square loyalty event to our Transaction model
-func (s *LoyaltyService) convertSquareEventToTransaction(event *square.LoyaltyEvent, userID string) *models.Transaction {
+// linkOrCreateSquareAccount returns the Square loyalty account for the user's phone number,
+// creating one if Square has none
+func (s *LoyaltyService) linkOrCreateSquareAccount(ctx context.Context, provider LoyaltyProvider, user *models.User) (*square.LoyaltyAccount, error) {
+	if provider == nil {
+		return nil, errors.New("the member's merchant is not connected to Square")
+	}
+
+	// Square identifies loyalty accounts by the buyer's phone number
+	if user.Phone == "" {
+		return nil, errors.New("a phone number is required to create a loyalty account, please add one to your profile")
+	}
+
+	// Link to an existing Square account for this phone number before creating a new one
+	account, err := s.findSquareLoyaltyAccount(ctx, provider, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if account != nil {
+		log.Printf("Linked existing Square loyalty account %s to user %s", *account.ID, user.ID)
+		return account, nil
+	}
+
+	account, err = provider.CreateLoyaltyAccount(ctx, user.Phone, user.FirstName, user.LastName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Square loyalty account: %w", err)
+	}
+	log.Printf("Created Square loyalty account %s for user %s", *account.ID, user.ID)
+
+	return account, nil
+}
+
+// findSquareLoyaltyAccount looks up an existing Square loyalty account by the user's phone number.
+// It returns nil when there is none, and an error when the account already belongs to another member.
+func (s *LoyaltyService) findSquareLoyaltyAccount(ctx context.Context, provider LoyaltyProvider, user *models.User) (*square.LoyaltyAccount, error) {
+	accounts, err := provider.SearchLoyaltyAccounts(ctx, user.Phone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search Square loyalty accounts: %w", err)
+	}
+
+	for _, account := range accounts {
+		if account == nil || account.ID == nil {
+			continue
+		}
+
+		if other, err := s.userStorage.GetUserBySquareAccountID(user.MerchantID, *account.ID); err == nil && other.ID != user.ID {
+			return nil, errors.New("this phone number is already linked to another member")
+		}
+
+		return account, nil
+	}
+
+	return nil, nil
+}
+
+// convertSquareEventToTransaction converts a Square loyalty event of a member to our Transaction
+// model, in the member's merchant ledger. It returns nil for events without an ID or of a type
+// Square added after this mapping.
+func (s *LoyaltyService) convertSquareEventToTransaction(event *square.LoyaltyEvent, user *models.User) *models.Transaction {
 	if event == nil || event.ID == "" {
 		return nil
 	}
 
-	var transactionType string
-	var points int
-	var description string
+	transaction := &models.Transaction{
+		ID:            event.ID,
+		MerchantID:    user.MerchantID,
+		UserID:        user.ID,
+		SquareEventID: event.ID,
+		Source:        squareEventSource(event.Source),
+		CreatedAt:     time.Now(),
+	}
+	if event.LocationID != nil {
+		transaction.LocationID = *event.LocationID
+	}
+	if event.CreatedAt != "" {
+		if parsedTime, err := time.Parse(time.RFC3339, event.CreatedAt); err == nil {
+			transaction.CreatedAt = parsedTime
+		}
+	}
 
-	// Determine transaction type and points based on Square event
+	// Square reports some debits as negative points; the type carries the direction
 	switch event.Type {
-	case "ACCUMULATE_POINTS":
-		transactionType = "earn"
-		if event.AccumulatePoints != nil && event.AccumulatePoints.Points != nil {
-			points = *event.AccumulatePoints.Points
-		}
-		description = "Points earned"
-	case "ADJUST_POINTS":
-		if event.AdjustPoints != nil {
-			pointsValue := event.AdjustPoints.Points
-			if pointsValue > 0 {
-				transactionType = "earn"
-				points = pointsValue
-				description = "Points adjustment (earned)"
-			} else {
-				transactionType = "redeem"
-				points = -pointsValue // Make positive for display
-				description = "Points redeemed"
+	case square.LoyaltyEventTypeAccumulatePoints:
+		transaction.Type = models.TransactionTypeEarn
+		transaction.Description = "Points earned"
+		if data := event.AccumulatePoints; data != nil {
+			if data.Points != nil {
+				transaction.Points = abs(*data.Points)
+			}
+			if data.OrderID != nil {
+				transaction.OrderID = *data.OrderID
 			}
 		}
-	case "CREATE_REWARD":
-		transactionType = "redeem"
-		if event.CreateReward != nil {
-			points = event.CreateReward.Points
+	case square.LoyaltyEventTypeAccumulatePromotionPoints:
+		transaction.Type = models.TransactionTypePromotionEarn
+		transaction.Description = "Promotion points earned"
+		if data := event.AccumulatePromotionPoints; data != nil {
+			transaction.Points = abs(data.Points)
+			transaction.OrderID = data.OrderID
+		}
+	case square.LoyaltyEventTypeAdjustPoints:
+		transaction.Type = models.TransactionTypeAdjustCredit
+		transaction.Description = "Points adjusted"
+		if data := event.AdjustPoints; data != nil {
+			if data.Points < 0 {
+				transaction.Type = models.TransactionTypeAdjustDebit
+			}
+			transaction.Points = abs(data.Points)
+			if data.Reason != nil && *data.Reason != "" {
+				transaction.Reason = *data.Reason
+				transaction.Description = *data.Reason
+			}
+		}
+	case square.LoyaltyEventTypeCreateReward:
+		transaction.Type = models.TransactionTypeRewardCreated
+		transaction.Description = "Reward created"
+		if data := event.CreateReward; data != nil {
+			transaction.Points = abs(data.Points)
+		}
+	case square.LoyaltyEventTypeDeleteReward:
+		transaction.Type = models.TransactionTypeRewardDeleted
+		transaction.Description = "Reward deleted, points returned"
+		if data := event.DeleteReward; data != nil {
+			transaction.Points = abs(data.Points)
+		}
+	case square.LoyaltyEventTypeRedeemReward:
+		transaction.Type = models.TransactionTypeRewardRedeemed
+		transaction.Description = "Reward redeemed"
+		if data := event.RedeemReward; data != nil && data.OrderID != nil {
+			transaction.OrderID = *data.OrderID
+		}
+	case square.LoyaltyEventTypeExpirePoints:
+		transaction.Type = models.TransactionTypeExpire
+		transaction.Description = "Points expired"
+		if data := event.ExpirePoints; data != nil {
+			transaction.Points = abs(data.Points)
+		}
+	case square.LoyaltyEventTypeOther:
+		transaction.Type = models.TransactionTypeOtherCredit
+		transaction.Description = "Points changed"
+		if data := event.OtherEvent; data != nil {
+			if data.Points < 0 {
+				transaction.Type = models.TransactionTypeOtherDebit
+			}
+			transaction.Points = abs(data.Points)
 		}
-		description = "Reward created"
 	default:
-		return nil // Skip unknown event types
+		return nil
 	}
 
-	// Parse created date
-	createdAt := time.Now()
-	if event.CreatedAt != "" {
-		if parsedTime, err := time.Parse(time.RFC3339, event.CreatedAt); err == nil {
-			createdAt = parsedTime
-		}
+	return transaction
+}
+
+// squareEventSource maps a Square event source to a transaction source
+func squareEventSource(source square.LoyaltyEventSource) string {
+	if source == square.LoyaltyEventSourceLoyaltyAPI {
+		return models.TransactionSourceLoyaltyAPI
 	}
+	return models.TransactionSourceSquare
+}
 
-	return &models.Transaction{
-		ID:          event.ID,
-		UserID:      userID,
-		Type:        transactionType,
-		Points:      points,
-		Description: description,
-		CreatedAt:   createdAt,
+func abs(value int) int {
+	if value < 0 {
+		return -value
 	}
+	return value
 }
 
 func (s *LoyaltyService) generateID() string {
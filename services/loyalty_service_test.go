@@ -0,0 +1,135 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"loyalty-core/models"
+
+	square "github.com/square/square-go-sdk"
+)
+
+func TestConvertSquareEventToTransaction(t *testing.T) {
+	points := func(n int) *int { return &n }
+	text := func(s string) *string { return &s }
+	user := &models.User{ID: "user-1", MerchantID: "brand-a"}
+
+	tests := []struct {
+		name        string
+		event       square.LoyaltyEvent
+		wantType    string
+		wantPoints  int
+		wantOrderID string
+		wantReason  string
+	}{
+		{
+			name:        "accumulate points",
+			event:       square.LoyaltyEvent{Type: square.LoyaltyEventTypeAccumulatePoints, AccumulatePoints: &square.LoyaltyEventAccumulatePoints{Points: points(12), OrderID: text("order-1")}},
+			wantType:    models.TransactionTypeEarn,
+			wantPoints:  12,
+			wantOrderID: "order-1",
+		},
+		{
+			name:        "accumulate promotion points",
+			event:       square.LoyaltyEvent{Type: square.LoyaltyEventTypeAccumulatePromotionPoints, AccumulatePromotionPoints: &square.LoyaltyEventAccumulatePromotionPoints{Points: 5, OrderID: "order-2"}},
+			wantType:    models.TransactionTypePromotionEarn,
+			wantPoints:  5,
+			wantOrderID: "order-2",
+		},
+		{
+			name:       "adjust points up",
+			event:      square.LoyaltyEvent{Type: square.LoyaltyEventTypeAdjustPoints, AdjustPoints: &square.LoyaltyEventAdjustPoints{Points: 20, Reason: text("Goodwill")}},
+			wantType:   models.TransactionTypeAdjustCredit,
+			wantPoints: 20,
+			wantReason: "Goodwill",
+		},
+		{
+			name:       "adjust points down",
+			event:      square.LoyaltyEvent{Type: square.LoyaltyEventTypeAdjustPoints, AdjustPoints: &square.LoyaltyEventAdjustPoints{Points: -15}},
+			wantType:   models.TransactionTypeAdjustDebit,
+			wantPoints: 15,
+		},
+		{
+			name:       "create reward",
+			event:      square.LoyaltyEvent{Type: square.LoyaltyEventTypeCreateReward, CreateReward: &square.LoyaltyEventCreateReward{Points: -100}},
+			wantType:   models.TransactionTypeRewardCreated,
+			wantPoints: 100,
+		},
+		{
+			name:       "delete reward",
+			event:      square.LoyaltyEvent{Type: square.LoyaltyEventTypeDeleteReward, DeleteReward: &square.LoyaltyEventDeleteReward{Points: 100}},
+			wantType:   models.TransactionTypeRewardDeleted,
+			wantPoints: 100,
+		},
+		{
+			name:        "redeem reward",
+			event:       square.LoyaltyEvent{Type: square.LoyaltyEventTypeRedeemReward, RedeemReward: &square.LoyaltyEventRedeemReward{OrderID: text("order-3")}},
+			wantType:    models.TransactionTypeRewardRedeemed,
+			wantOrderID: "order-3",
+		},
+		{
+			name:       "expire points",
+			event:      square.LoyaltyEvent{Type: square.LoyaltyEventTypeExpirePoints, ExpirePoints: &square.LoyaltyEventExpirePoints{Points: -30}},
+			wantType:   models.TransactionTypeExpire,
+			wantPoints: 30,
+		},
+		{
+			name:       "other credit",
+			event:      square.LoyaltyEvent{Type: square.LoyaltyEventTypeOther, OtherEvent: &square.LoyaltyEventOther{Points: 7}},
+			wantType:   models.TransactionTypeOtherCredit,
+			wantPoints: 7,
+		},
+		{
+			name:       "other debit",
+			event:      square.LoyaltyEvent{Type: square.LoyaltyEventTypeOther, OtherEvent: &square.LoyaltyEventOther{Points: -7}},
+			wantType:   models.TransactionTypeOtherDebit,
+			wantPoints: 7,
+		},
+	}
+
+	service := &LoyaltyService{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := tt.event
+			event.ID = "event-1"
+			event.CreatedAt = "2026-03-01T10:00:00Z"
+			event.LocationID = text("location-1")
+			event.Source = square.LoyaltyEventSourceSquare
+
+			transaction := service.convertSquareEventToTransaction(&event, user)
+			if transaction == nil {
+				t.Fatal("got nil transaction")
+			}
+			if transaction.Type != tt.wantType || transaction.Points != tt.wantPoints {
+				t.Errorf("type %q, points %d; want %q, %d", transaction.Type, transaction.Points, tt.wantType, tt.wantPoints)
+			}
+			if transaction.OrderID != tt.wantOrderID || transaction.Reason != tt.wantReason {
+				t.Errorf("order %q, reason %q; want %q, %q", transaction.OrderID, transaction.Reason, tt.wantOrderID, tt.wantReason)
+			}
+			if transaction.MerchantID != user.MerchantID || transaction.UserID != user.ID {
+				t.Errorf("merchant %q, user %q; want %q, %q", transaction.MerchantID, transaction.UserID, user.MerchantID, user.ID)
+			}
+			if transaction.SquareEventID != "event-1" || transaction.LocationID != "location-1" || transaction.Source != models.TransactionSourceSquare {
+				t.Errorf("event %q, location %q, source %q", transaction.SquareEventID, transaction.LocationID, transaction.Source)
+			}
+			if want := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC); !transaction.CreatedAt.Equal(want) {
+				t.Errorf("created at %s, want %s", transaction.CreatedAt, want)
+			}
+		})
+	}
+}
+
+func TestConvertSquareEventToTransactionSkipsUnknownEvents(t *testing.T) {
+	service := &LoyaltyService{}
+	user := &models.User{ID: "user-1", MerchantID: "brand-a"}
+
+	for name, event := range map[string]*square.LoyaltyEvent{
+		"nil event":    nil,
+		"missing ID":   {Type: square.LoyaltyEventTypeAccumulatePoints},
+		"unknown type": {ID: "event-1", Type: square.LoyaltyEventType("NEW_EVENT_TYPE")},
+	} {
+		if transaction := service.convertSquareEventToTransaction(event, user); transaction != nil {
+			t.Errorf("%s: got %+v, want nil", name, transaction)
+		}
+	}
+}
@@ -0,0 +1,628 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+	"loyalty-core/utils"
+
+	square "github.com/square/square-go-sdk"
+	"github.com/square/square-go-sdk/client"
+	"github.com/square/square-go-sdk/option"
+)
+
+const (
+	// oauthStateTTL is how long a merchant has to complete the Square authorization
+	oauthStateTTL = 10 * time.Minute
+	// tokenRefreshWindow refreshes access tokens this long before they expire. Square tokens
+	// last 30 days and Square recommends refreshing at least every 7 days.
+	tokenRefreshWindow = 23 * 24 * time.Hour
+)
+
+// MerchantService manages the merchants (brands) served by this deployment and their Square
+// connections. Each merchant has its own SquareService, built from the credentials it granted
+// through the Square OAuth authorization-code flow. The default merchant uses the Square access
+// token from the configuration.
+type MerchantService struct {
+	config    *config.Config
+	merchants *storage.MerchantStorage
+	oauth     *client.Client
+
+	providers map[string]LoyaltyProvider // merchantID -> Square client of connected merchants
+	mu        sync.RWMutex
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewMerchantService creates the merchant service and the default merchant, connecting it to
+// Square when an access token is configured
+func NewMerchantService(cfg *config.Config) *MerchantService {
+	var provider LoyaltyProvider
+
+	// Try to initialize Square service, but don't fail if it's not available
+	if cfg.SquareAccessToken != "" && cfg.SquareAccessToken != "your-square-access-token" &&
+		cfg.SquareLocationID != "" && cfg.SquareLocationID != "your-square-location-id" {
+		squareService, err := NewSquareService(cfg)
+		if err != nil {
+			log.Printf("Warning: Square service not available: %v", err)
+			log.Printf("Running in fallback mode with in-memory storage")
+		} else {
+			log.Printf("Square service initialized successfully")
+			provider = squareService
+		}
+	} else {
+		log.Printf("Square credentials not configured, running in fallback mode")
+	}
+
+	return NewMerchantServiceWithProvider(cfg, provider)
+}
+
+// NewMerchantServiceWithProvider creates the merchant service with the given provider for the
+// default merchant. A nil provider runs the default merchant with local storage only.
+func NewMerchantServiceWithProvider(cfg *config.Config, provider LoyaltyProvider) *MerchantService {
+	ms := &MerchantService{
+		config:    cfg,
+		merchants: storage.GetGlobalMerchantStorage(),
+		oauth: client.NewClient(
+			option.WithBaseURL(getBaseURL(cfg)),
+			option.WithHTTPClient(getHTTPClient(cfg)),
+			option.WithMaxAttempts(1),
+		),
+		providers: make(map[string]LoyaltyProvider),
+		stop:      make(chan struct{}),
+	}
+
+	now := time.Now()
+	merchant := &models.Merchant{
+		ID:        models.DefaultMerchantID,
+		Name:      "Default",
+		Hosts:     []string{},
+		Status:    models.MerchantStatusLocal,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if provider != nil {
+		merchant.Status = models.MerchantStatusConnected
+		merchant.SquareLocationID = cfg.SquareLocationID
+		merchant.ConnectedAt = &now
+		ms.providers[models.DefaultMerchantID] = provider
+	}
+	if existing, err := ms.merchants.GetMerchant(models.DefaultMerchantID); err == nil {
+		merchant.Hosts = existing.Hosts
+		merchant.Settings = existing.Settings
+		merchant.CreatedAt = existing.CreatedAt
+		ms.merchants.UpdateMerchant(merchant)
+	} else if err := ms.merchants.CreateMerchant(merchant); err != nil {
+		log.Printf("Failed to create default merchant: %v", err)
+	}
+	if provider != nil {
+		ms.learnDefaultSquareMerchantID(context.Background())
+	}
+
+	return ms
+}
+
+// Start runs the token refresh worker when OAuth is configured
+func (ms *MerchantService) Start() {
+	if !ms.OAuthEnabled() {
+		log.Println("Square OAuth not configured, additional merchants cannot be connected")
+		return
+	}
+
+	interval := time.Duration(ms.config.SquareTokenRefreshIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ms.wg.Add(1)
+	go ms.run(interval)
+	log.Printf("Square token refresh worker started (interval: %s)", interval)
+}
+
+// Stop stops the token refresh worker
+func (ms *MerchantService) Stop() {
+	close(ms.stop)
+	ms.wg.Wait()
+}
+
+// OAuthEnabled reports whether merchants can be connected through Square OAuth
+func (ms *MerchantService) OAuthEnabled() bool {
+	return ms.config.SquareApplicationID != "" && ms.config.SquareApplicationID != "your-square-application-id" &&
+		ms.config.SquareApplicationSecret != "" && ms.config.TokenEncryptionKey != ""
+}
+
+// CreateMerchant adds a merchant waiting to be connected to Square
+func (ms *MerchantService) CreateMerchant(req models.CreateMerchantRequest) (*models.Merchant, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	if err := validateMerchantSettings(req.Settings); err != nil {
+		return nil, err
+	}
+
+	hosts := []string{}
+	for _, host := range req.Hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	now := time.Now()
+	merchant := &models.Merchant{
+		ID:               generateToken(8),
+		Name:             name,
+		Hosts:            hosts,
+		Status:           models.MerchantStatusPending,
+		SquareLocationID: strings.TrimSpace(req.SquareLocationID),
+		Settings:         req.Settings,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if err := ms.merchants.CreateMerchant(merchant); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Merchant created: %s (%s)", merchant.ID, merchant.Name)
+	return ms.GetMerchant(merchant.ID)
+}
+
+// GetMerchant returns a merchant with its current Square health
+func (ms *MerchantService) GetMerchant(merchantID string) (*models.Merchant, error) {
+	merchant, err := ms.merchants.GetMerchant(merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	merchant.SquareStatus = providerStatus(ms.Provider(merchantID))
+	return merchant, nil
+}
+
+// ListMerchants returns all merchants with their current Square health
+func (ms *MerchantService) ListMerchants() []models.Merchant {
+	merchants := ms.merchants.ListMerchants()
+	for i := range merchants {
+		merchants[i].SquareStatus = providerStatus(ms.Provider(merchants[i].ID))
+	}
+	return merchants
+}
+
+// UpdateSettings replaces a merchant's configuration overrides
+func (ms *MerchantService) UpdateSettings(merchantID string, settings models.MerchantSettings) (*models.Merchant, error) {
+	if err := validateMerchantSettings(settings); err != nil {
+		return nil, err
+	}
+
+	merchant, err := ms.merchants.GetMerchant(merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	merchant.Settings = settings
+	merchant.UpdatedAt = time.Now()
+	if err := ms.merchants.UpdateMerchant(merchant); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Settings of merchant %s updated", merchantID)
+	return ms.GetMerchant(merchantID)
+}
+
+// Settings returns a merchant's effective configuration: its overrides, with the configured
+// values filled in for the rest
+func (ms *MerchantService) Settings(merchantID string) models.MerchantSettings {
+	settings := models.MerchantSettings{
+		AccountClosureBalancePolicy: ms.config.AccountClosureBalancePolicy,
+		PointValueCents:             ms.config.PointValueCents,
+	}
+
+	merchant, err := ms.merchants.GetMerchant(models.MerchantIDOrDefault(merchantID))
+	if err != nil {
+		return settings
+	}
+	if merchant.Settings.AccountClosureBalancePolicy != "" {
+		settings.AccountClosureBalancePolicy = merchant.Settings.AccountClosureBalancePolicy
+	}
+	if merchant.Settings.PointValueCents > 0 {
+		settings.PointValueCents = merchant.Settings.PointValueCents
+	}
+	return settings
+}
+
+// MerchantIDForHost returns the merchant serving a request host, or the default merchant
+func (ms *MerchantService) MerchantIDForHost(host string) string {
+	if merchant, err := ms.merchants.GetMerchantByHost(host); err == nil {
+		return merchant.ID
+	}
+	return models.DefaultMerchantID
+}
+
+// MerchantIDForSquareMerchant returns the merchant connected to a Square seller. It reports false
+// for a seller no merchant is connected to.
+func (ms *MerchantService) MerchantIDForSquareMerchant(squareMerchantID string) (string, bool) {
+	if squareMerchantID == "" {
+		return "", false
+	}
+	if merchant, err := ms.merchants.GetMerchantBySquareMerchantID(squareMerchantID); err == nil {
+		return merchant.ID, true
+	}
+
+	// The default merchant's seller is learned from Square, which may not have answered at startup
+	if ms.learnDefaultSquareMerchantID(context.Background()) == squareMerchantID {
+		return models.DefaultMerchantID, true
+	}
+	return "", false
+}
+
+// learnDefaultSquareMerchantID returns the Square seller of the default merchant, which connects
+// with the configured access token rather than OAuth. The seller is looked up once through the
+// merchant's location and stored; it is empty while Square cannot be reached.
+func (ms *MerchantService) learnDefaultSquareMerchantID(ctx context.Context) string {
+	merchant, err := ms.merchants.GetMerchant(models.DefaultMerchantID)
+	if err != nil {
+		return ""
+	}
+	if merchant.SquareMerchantID != "" {
+		return merchant.SquareMerchantID
+	}
+
+	provider := ms.Provider(models.DefaultMerchantID)
+	if provider == nil {
+		return ""
+	}
+	locations, err := provider.ListLocations(ctx)
+	if err != nil {
+		log.Printf("Failed to look up the Square seller of the default merchant: %v", err)
+		return ""
+	}
+	for _, location := range locations {
+		if location != nil && stringValue(location.ID) == provider.DefaultLocationID() && stringValue(location.MerchantID) != "" {
+			merchant.SquareMerchantID = stringValue(location.MerchantID)
+			merchant.UpdatedAt = time.Now()
+			if err := ms.merchants.UpdateMerchant(merchant); err != nil {
+				log.Printf("Failed to update default merchant: %v", err)
+			}
+			return merchant.SquareMerchantID
+		}
+	}
+	return ""
+}
+
+// Provider returns the Square client of a merchant, or nil when the merchant is not connected.
+// An empty merchant ID means the default merchant.
+func (ms *MerchantService) Provider(merchantID string) LoyaltyProvider {
+	merchantID = models.MerchantIDOrDefault(merchantID)
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if provider, exists := ms.providers[merchantID]; exists {
+		return provider
+	}
+	return nil
+}
+
+// Providers returns the Square clients of all connected merchants by merchant ID
+func (ms *MerchantService) Providers() map[string]LoyaltyProvider {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	providers := make(map[string]LoyaltyProvider, len(ms.providers))
+	for merchantID, provider := range ms.providers {
+		providers[merchantID] = provider
+	}
+	return providers
+}
+
+// UsesSquare reports whether a merchant's loyalty data is mirrored to Square: the default
+// merchant when it has a Square access token, and every merchant connected through OAuth, even
+// while it is still waiting for authorization
+func (ms *MerchantService) UsesSquare(merchantID string) bool {
+	if merchantID == "" || merchantID == models.DefaultMerchantID {
+		return ms.Provider(models.DefaultMerchantID) != nil
+	}
+	return true
+}
+
+// SquareEnabled reports whether any merchant may be connected to Square
+func (ms *MerchantService) SquareEnabled() bool {
+	return ms.Provider(models.DefaultMerchantID) != nil || ms.OAuthEnabled() || len(ms.Providers()) > 0
+}
+
+// SquareStatus reports the health of all Square connections: "disabled" when no merchant is
+// connected, "degraded" while any connection's circuit breaker is open, otherwise "ok"
+func (ms *MerchantService) SquareStatus() string {
+	providers := ms.Providers()
+	if len(providers) == 0 {
+		return "disabled"
+	}
+	for _, provider := range providers {
+		if !provider.Available() {
+			return "degraded"
+		}
+	}
+	return "ok"
+}
+
+// ConnectURL starts the Square OAuth authorization of a merchant and returns the URL the
+// merchant's Square account owner must visit
+func (ms *MerchantService) ConnectURL(merchantID string) (*models.ConnectMerchantResponse, error) {
+	if !ms.OAuthEnabled() {
+		return nil, errors.New("Square OAuth is not configured")
+	}
+	if merchantID == models.DefaultMerchantID {
+		return nil, errors.New("the default merchant is connected with SQUARE_ACCESS_TOKEN")
+	}
+	if _, err := ms.merchants.GetMerchant(merchantID); err != nil {
+		return nil, err
+	}
+
+	state := models.OAuthState{
+		State:      generateToken(24),
+		MerchantID: merchantID,
+		ExpiresAt:  time.Now().Add(oauthStateTTL),
+	}
+	ms.merchants.AddOAuthState(state)
+
+	query := url.Values{}
+	query.Set("client_id", ms.config.SquareApplicationID)
+	query.Set("scope", strings.Join(ms.config.SquareOAuthScopes, " "))
+	query.Set("session", "false")
+	query.Set("state", state.State)
+	if ms.config.SquareOAuthRedirectURL != "" {
+		query.Set("redirect_uri", ms.config.SquareOAuthRedirectURL)
+	}
+
+	return &models.ConnectMerchantResponse{
+		AuthorizeURL: getBaseURL(ms.config) + "/oauth2/authorize?" + query.Encode(),
+		ExpiresAt:    state.ExpiresAt.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// HandleOAuthCallback completes a Square OAuth authorization: it exchanges the authorization
+// code for tokens, stores them encrypted and connects the merchant
+func (ms *MerchantService) HandleOAuthCallback(ctx context.Context, state, code string) (*models.Merchant, error) {
+	pending, err := ms.merchants.ConsumeOAuthState(state)
+	if err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, errors.New("authorization code is required")
+	}
+
+	request := &square.ObtainTokenRequest{
+		ClientID:     ms.config.SquareApplicationID,
+		ClientSecret: square.String(ms.config.SquareApplicationSecret),
+		Code:         square.String(code),
+		GrantType:    "authorization_code",
+	}
+	if ms.config.SquareOAuthRedirectURL != "" {
+		request.RedirectURI = square.String(ms.config.SquareOAuthRedirectURL)
+	}
+
+	response, err := ms.oauth.OAuth.ObtainToken(ctx, request)
+	if err != nil {
+		ms.recordError(pending.MerchantID, fmt.Errorf("failed to obtain Square token: %w", err))
+		return nil, fmt.Errorf("failed to obtain Square token: %w", err)
+	}
+
+	if err := ms.connect(ctx, pending.MerchantID, response); err != nil {
+		ms.recordError(pending.MerchantID, err)
+		return nil, err
+	}
+
+	log.Printf("Merchant %s connected to Square", pending.MerchantID)
+	return ms.GetMerchant(pending.MerchantID)
+}
+
+// RefreshToken exchanges a merchant's refresh token for a new access token
+func (ms *MerchantService) RefreshToken(ctx context.Context, merchantID string) error {
+	credentials, err := ms.merchants.GetCredentials(merchantID)
+	if err != nil {
+		return err
+	}
+	refreshToken, err := utils.Decrypt(credentials.EncryptedRefreshToken, ms.config.TokenEncryptionKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt refresh token: %w", err)
+	}
+
+	response, err := ms.oauth.OAuth.ObtainToken(ctx, &square.ObtainTokenRequest{
+		ClientID:     ms.config.SquareApplicationID,
+		ClientSecret: square.String(ms.config.SquareApplicationSecret),
+		RefreshToken: square.String(refreshToken),
+		GrantType:    "refresh_token",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refresh Square token: %w", err)
+	}
+	if response.RefreshToken == nil {
+		// Square keeps the refresh token unless it issues a new one
+		response.RefreshToken = square.String(refreshToken)
+	}
+
+	return ms.connect(ctx, merchantID, response)
+}
+
+// connect stores a merchant's new Square tokens and replaces its Square client
+func (ms *MerchantService) connect(ctx context.Context, merchantID string, token *square.ObtainTokenResponse) error {
+	if token.AccessToken == nil || *token.AccessToken == "" || token.RefreshToken == nil {
+		return errors.New("Square did not return an access and refresh token")
+	}
+	expiresAt, err := time.Parse(time.RFC3339, stringValue(token.ExpiresAt))
+	if err != nil {
+		return fmt.Errorf("invalid token expiry %q", stringValue(token.ExpiresAt))
+	}
+
+	merchant, err := ms.merchants.GetMerchant(merchantID)
+	if err != nil {
+		return err
+	}
+
+	if merchant.SquareLocationID == "" {
+		locationID, err := ms.firstActiveLocation(ctx, *token.AccessToken)
+		if err != nil {
+			return err
+		}
+		merchant.SquareLocationID = locationID
+	}
+
+	provider, err := newSquareService(ms.config, *token.AccessToken, merchant.SquareLocationID)
+	if err != nil {
+		return err
+	}
+
+	encryptedAccess, err := utils.Encrypt(*token.AccessToken, ms.config.TokenEncryptionKey)
+	if err != nil {
+		return err
+	}
+	encryptedRefresh, err := utils.Encrypt(*token.RefreshToken, ms.config.TokenEncryptionKey)
+	if err != nil {
+		return err
+	}
+	ms.merchants.SaveCredentials(models.MerchantCredentials{
+		MerchantID:            merchantID,
+		EncryptedAccessToken:  encryptedAccess,
+		EncryptedRefreshToken: encryptedRefresh,
+		ExpiresAt:             expiresAt,
+	})
+
+	ms.mu.Lock()
+	ms.providers[merchantID] = provider
+	ms.mu.Unlock()
+
+	now := time.Now()
+	if merchant.ConnectedAt == nil {
+		merchant.ConnectedAt = &now
+	} else {
+		merchant.TokenRefreshedAt = &now
+	}
+	merchant.Status = models.MerchantStatusConnected
+	merchant.SquareMerchantID = stringValue(token.MerchantID)
+	merchant.TokenExpiresAt = &expiresAt
+	merchant.LastError = ""
+	merchant.UpdatedAt = now
+	return ms.merchants.UpdateMerchant(merchant)
+}
+
+// firstActiveLocation returns the ID of the seller's first active location
+func (ms *MerchantService) firstActiveLocation(ctx context.Context, accessToken string) (string, error) {
+	sellerClient := client.NewClient(
+		option.WithToken(accessToken),
+		option.WithBaseURL(getBaseURL(ms.config)),
+		option.WithHTTPClient(getHTTPClient(ms.config)),
+	)
+
+	response, err := sellerClient.Locations.List(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list Square locations: %w", err)
+	}
+	for _, location := range response.Locations {
+		if location != nil && location.ID != nil && (location.Status == nil || *location.Status == square.LocationStatusActive) {
+			return *location.ID, nil
+		}
+	}
+	return "", errors.New("the Square seller has no active location")
+}
+
+// recordError marks a merchant's connection as failed
+func (ms *MerchantService) recordError(merchantID string, cause error) {
+	merchant, err := ms.merchants.GetMerchant(merchantID)
+	if err != nil {
+		return
+	}
+
+	merchant.LastError = cause.Error()
+	merchant.UpdatedAt = time.Now()
+	if merchant.Status != models.MerchantStatusConnected || (merchant.TokenExpiresAt != nil && time.Now().After(*merchant.TokenExpiresAt)) {
+		merchant.Status = models.MerchantStatusError
+	}
+	if err := ms.merchants.UpdateMerchant(merchant); err != nil {
+		log.Printf("Failed to record connection error for merchant %s: %v", merchantID, err)
+	}
+}
+
+func (ms *MerchantService) run(interval time.Duration) {
+	defer ms.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ms.stop:
+			return
+		case <-ticker.C:
+			ms.refreshDue()
+		}
+	}
+}
+
+// refreshDue refreshes the tokens of connected merchants that are close to expiry. A merchant
+// whose token expired without a successful refresh is disconnected.
+func (ms *MerchantService) refreshDue() {
+	for _, merchant := range ms.merchants.ListMerchants() {
+		if merchant.ID == models.DefaultMerchantID || merchant.TokenExpiresAt == nil {
+			continue
+		}
+		if time.Until(*merchant.TokenExpiresAt) > tokenRefreshWindow {
+			continue
+		}
+
+		if err := ms.RefreshToken(context.Background(), merchant.ID); err != nil {
+			log.Printf("Failed to refresh Square token of merchant %s: %v", merchant.ID, err)
+			ms.recordError(merchant.ID, err)
+
+			if time.Now().After(*merchant.TokenExpiresAt) {
+				ms.mu.Lock()
+				delete(ms.providers, merchant.ID)
+				ms.mu.Unlock()
+			}
+			continue
+		}
+		log.Printf("Refreshed Square token of merchant %s", merchant.ID)
+	}
+}
+
+// validateMerchantSettings checks configuration overrides before they are stored
+func validateMerchantSettings(settings models.MerchantSettings) error {
+	switch settings.AccountClosureBalancePolicy {
+	case "", ClosureBalanceForfeit, ClosureBalancePayout:
+	default:
+		return errors.New("accountClosureBalancePolicy must be forfeit or payout")
+	}
+	if settings.PointValueCents < 0 {
+		return errors.New("pointValueCents cannot be negative")
+	}
+	return nil
+}
+
+// providerStatus reports a single Square connection's health
+func providerStatus(provider LoyaltyProvider) string {
+	switch {
+	case provider == nil:
+		return "disabled"
+	case !provider.Available():
+		return "degraded"
+	}
+	return "ok"
+}
+
+// generateToken returns n random bytes, hex-encoded
+func generateToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+)
+
+// Notifier delivers messages to members outside the API, such as the token that confirms a new
+// email address
+type Notifier interface {
+	SendEmailVerification(user *models.User, email, token string) error
+}
+
+// NewNotifier returns an SMTP mailer when a mail server is configured. Otherwise, outside
+// production, tokens are only written to the server log; production requires a mail server.
+func NewNotifier(cfg *config.Config) Notifier {
+	if cfg.SMTPHost != "" {
+		return &SMTPMailer{config: cfg}
+	}
+	return &LogNotifier{}
+}
+
+// LogNotifier writes messages to the server log instead of sending them. It is meant for
+// development, where there is no mail server; since the log then holds the tokens, it is
+// never used in production.
+type LogNotifier struct{}
+
+func (n *LogNotifier) SendEmailVerification(user *models.User, email, token string) error {
+	log.Printf("[dev] Email verification for user %s to %s: token %s", user.ID, email, token)
+	return nil
+}
+
+// SMTPMailer sends messages by email through the configured SMTP server
+type SMTPMailer struct {
+	config *config.Config
+}
+
+func (m *SMTPMailer) SendEmailVerification(user *models.User, email, token string) error {
+	body := fmt.Sprintf("Hi %s,\r\n\r\nConfirm this address for your loyalty account with the verification code:\r\n\r\n    %s\r\n\r\nIf you did not ask to change your email, you can ignore this message.\r\n",
+		user.FirstName, token)
+	return m.send(email, "Confirm your new email address", body)
+}
+
+func (m *SMTPMailer) send(to, subject, body string) error {
+	// Addresses were validated, but a newline would still inject headers
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid recipient address")
+	}
+
+	message := "From: " + m.config.EmailFrom + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+
+	var auth smtp.Auth
+	if m.config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", m.config.SMTPUsername, m.config.SMTPPassword, m.config.SMTPHost)
+	}
+	addr := net.JoinHostPort(m.config.SMTPHost, strconv.Itoa(m.config.SMTPPort))
+	if err := smtp.SendMail(addr, auth, m.config.EmailFrom, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
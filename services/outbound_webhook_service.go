@@ -0,0 +1,529 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+	"loyalty-core/utils"
+)
+
+const (
+	// minWebhookSecretLength is the shortest signing secret accepted for a subscription
+	minWebhookSecretLength = 16
+	// maxWebhookResponseBytes bounds how much of a subscriber's response is kept with an attempt
+	maxWebhookResponseBytes = 1024
+)
+
+// OutboundWebhookService notifies other systems, such as a CRM, of loyalty events. Each event is
+// delivered to every active subscription of the merchant that asked for its type, signed with
+// the subscription's secret. Failed deliveries are retried in the background with exponential
+// backoff, every attempt is kept with its delivery, and deliveries can be replayed by hand.
+type OutboundWebhookService struct {
+	config         *config.Config
+	subscriptions  *storage.WebhookSubscriptionStorage
+	deliveries     *storage.WebhookDeliveryStorage
+	programService *ProgramService
+	client         *http.Client
+	pollInterval   time.Duration
+	maxAttempts    int
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+
+	wake chan struct{} // signals new deliveries to the worker
+	stop chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex // serializes attempts between the worker, Flush, replays and webhook.test sends
+}
+
+func NewOutboundWebhookService(cfg *config.Config, programService *ProgramService) *OutboundWebhookService {
+	return &OutboundWebhookService{
+		config:         cfg,
+		subscriptions:  storage.GetGlobalWebhookSubscriptionStorage(),
+		deliveries:     storage.GetGlobalWebhookDeliveryStorage(),
+		programService: programService,
+		client: &http.Client{
+			Timeout: time.Duration(cfg.WebhookTimeoutSeconds) * time.Second,
+			// A redirect is reported as a failed attempt rather than followed with the signed body
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		pollInterval: time.Duration(cfg.WebhookPollIntervalSeconds) * time.Second,
+		maxAttempts:  cfg.WebhookMaxAttempts,
+		baseDelay:    10 * time.Second,
+		maxDelay:     time.Hour,
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+}
+
+// Start runs the background delivery worker
+func (ws *OutboundWebhookService) Start() {
+	if ws.pollInterval <= 0 {
+		ws.pollInterval = 5 * time.Second
+	}
+
+	ws.wg.Add(1)
+	go ws.run()
+	log.Printf("Webhook delivery worker started (poll interval: %s)", ws.pollInterval)
+}
+
+// Stop waits for the in-flight delivery to finish and stops the worker
+func (ws *OutboundWebhookService) Stop() {
+	close(ws.stop)
+	ws.wg.Wait()
+	log.Println("Webhook delivery worker stopped")
+}
+
+// Flush makes a last delivery pass over the due deliveries, until ctx is done. Deliveries are
+// held in memory, so those still pending are lost at exit.
+func (ws *OutboundWebhookService) Flush(ctx context.Context) {
+	ws.deliverDue(ctx.Done())
+
+	if pending := ws.deliveries.CountPending(); pending > 0 {
+		log.Printf("Webhooks flushed with %d deliveries still pending", pending)
+	}
+}
+
+// CreateSubscription adds a subscription to the merchant's events. The returned subscription
+// includes its secret, which is generated when none is given.
+func (ws *OutboundWebhookService) CreateSubscription(merchantID string, req models.WebhookSubscriptionRequest) (*models.WebhookSubscription, error) {
+	if req.URL == "" || len(req.EventTypes) == 0 {
+		return nil, errors.New("url and eventTypes are required")
+	}
+
+	now := time.Now()
+	subscription := models.WebhookSubscription{
+		ID:         ws.generateID(),
+		MerchantID: models.MerchantIDOrDefault(merchantID),
+		Secret:     req.Secret,
+		Active:     true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if subscription.Secret == "" {
+		subscription.Secret = "whsec_" + ws.generateID() + ws.generateID()
+	}
+	if err := ws.applyRequest(&subscription, req); err != nil {
+		return nil, err
+	}
+
+	ws.subscriptions.SaveSubscription(subscription)
+	log.Printf("Webhook subscription %s of merchant %s created for %s", subscription.ID, subscription.MerchantID, subscription.URL)
+	return &subscription, nil
+}
+
+// UpdateSubscription changes a subscription. The secret is returned only when it was replaced.
+func (ws *OutboundWebhookService) UpdateSubscription(merchantID, subscriptionID string, req models.WebhookSubscriptionRequest) (*models.WebhookSubscription, error) {
+	subscription, err := ws.subscriptions.GetSubscription(merchantID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Secret != "" {
+		subscription.Secret = req.Secret
+	}
+	if err := ws.applyRequest(subscription, req); err != nil {
+		return nil, err
+	}
+	subscription.UpdatedAt = time.Now()
+
+	ws.subscriptions.SaveSubscription(*subscription)
+	if req.Secret == "" {
+		subscription.Secret = ""
+	}
+	return subscription, nil
+}
+
+// DeleteSubscription removes a subscription. Its pending deliveries are given up on.
+func (ws *OutboundWebhookService) DeleteSubscription(merchantID, subscriptionID string) error {
+	return ws.subscriptions.DeleteSubscription(merchantID, subscriptionID)
+}
+
+// GetSubscription returns a subscription without its secret
+func (ws *OutboundWebhookService) GetSubscription(merchantID, subscriptionID string) (*models.WebhookSubscription, error) {
+	subscription, err := ws.subscriptions.GetSubscription(merchantID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+// ListSubscriptions returns the merchant's subscriptions without their secrets
+func (ws *OutboundWebhookService) ListSubscriptions(merchantID string) []models.WebhookSubscription {
+	subscriptions := ws.subscriptions.ListSubscriptions(merchantID)
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	return subscriptions
+}
+
+// ListDeliveries returns a subscription's deliveries, newest first, optionally of one status
+func (ws *OutboundWebhookService) ListDeliveries(merchantID, subscriptionID, status string) ([]models.WebhookDelivery, error) {
+	if _, err := ws.subscriptions.GetSubscription(merchantID, subscriptionID); err != nil {
+		return nil, err
+	}
+	return ws.deliveries.ListDeliveries(merchantID, subscriptionID, status), nil
+}
+
+// GetDelivery returns one of a subscription's deliveries with its attempts
+func (ws *OutboundWebhookService) GetDelivery(merchantID, subscriptionID, deliveryID string) (*models.WebhookDelivery, error) {
+	delivery, err := ws.deliveries.GetDelivery(merchantID, deliveryID)
+	if err != nil || delivery.SubscriptionID != subscriptionID {
+		return nil, errors.New("webhook delivery not found")
+	}
+	return delivery, nil
+}
+
+// ReplayDelivery sends a delivery again now, whatever its status, with the same event ID. If the
+// attempt fails, the delivery is retried in the background with a fresh set of attempts.
+func (ws *OutboundWebhookService) ReplayDelivery(merchantID, subscriptionID, deliveryID string) (*models.WebhookDelivery, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	delivery, err := ws.GetDelivery(merchantID, subscriptionID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Status = models.WebhookDeliveryPending
+	delivery.DeliveredAt = nil
+	log.Printf("Replaying webhook delivery %s", delivery.ID)
+
+	ws.attempt(delivery, true)
+	return ws.deliveries.GetDelivery(merchantID, deliveryID)
+}
+
+// SendTest sends a webhook.test event to a subscription now, active or not, and returns the
+// delivery with the outcome of the attempt
+func (ws *OutboundWebhookService) SendTest(merchantID, subscriptionID string) (*models.WebhookDelivery, error) {
+	subscription, err := ws.subscriptions.GetSubscription(merchantID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	event, err := ws.newEvent(subscription.MerchantID, models.WebhookEventTest, map[string]string{"subscriptionId": subscription.ID})
+	if err != nil {
+		return nil, err
+	}
+	delivery := ws.newDelivery(*subscription, event)
+
+	ws.attempt(&delivery, false)
+	return ws.deliveries.GetDelivery(merchantID, delivery.ID)
+}
+
+// HandleSignup publishes member.signup for a new member
+func (ws *OutboundWebhookService) HandleSignup(user *models.User) {
+	ws.publish(user.MerchantID, models.WebhookEventMemberSignup, webhookMember(*user))
+}
+
+// HandleTransaction publishes points.earned or points.redeemed for a new ledger transaction, and
+// member.tier_changed when the balance moves into another reward tier
+func (ws *OutboundWebhookService) HandleTransaction(user models.User, transaction models.Transaction, previousBalance int) {
+	member := webhookMember(user)
+
+	switch transaction.Type {
+	case models.TransactionTypeEarn, models.TransactionTypePromotionEarn:
+		ws.publish(user.MerchantID, models.WebhookEventPointsEarned, models.WebhookPointsData{Member: member, Transaction: transaction.WithoutFreeText(), Balance: user.Points})
+	case models.TransactionTypeRedeem, models.TransactionTypeRewardCreated:
+		ws.publish(user.MerchantID, models.WebhookEventPointsRedeemed, models.WebhookPointsData{Member: member, Transaction: transaction.WithoutFreeText(), Balance: user.Points})
+	}
+
+	if previousBalance == user.Points {
+		return
+	}
+	previousTier, tier := ws.rewardTier(user.MerchantID, previousBalance), ws.rewardTier(user.MerchantID, user.Points)
+	if (previousTier == nil) != (tier == nil) || (tier != nil && previousTier.ID != tier.ID) {
+		ws.publish(user.MerchantID, models.WebhookEventTierChanged, models.WebhookTierData{
+			Member:       member,
+			PreviousTier: previousTier,
+			Tier:         tier,
+			Balance:      user.Points,
+		})
+	}
+}
+
+// rewardTier returns the most expensive reward tier of the merchant's cached program that a
+// balance reaches, or nil. Without a cached program there are no tiers.
+func (ws *OutboundWebhookService) rewardTier(merchantID string, balance int) *models.ProgramRewardTier {
+	program := ws.programService.CachedProgram(merchantID)
+	if program == nil {
+		return nil
+	}
+
+	var best *models.ProgramRewardTier
+	for i, tier := range program.RewardTiers {
+		if tier.Points <= balance && (best == nil || tier.Points > best.Points) {
+			best = &program.RewardTiers[i]
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	copied := *best
+	return &copied
+}
+
+// publish queues an event for every active subscription of the merchant that asked for its type
+func (ws *OutboundWebhookService) publish(merchantID, eventType string, data interface{}) {
+	var event *models.WebhookEvent
+	for _, subscription := range ws.subscriptions.ListSubscriptions(merchantID) {
+		if !subscription.Active || !slices.Contains(subscription.EventTypes, eventType) {
+			continue
+		}
+
+		if event == nil {
+			var err error
+			if event, err = ws.newEvent(merchantID, eventType, data); err != nil {
+				log.Printf("Failed to encode %s webhook event: %v", eventType, err)
+				return
+			}
+		}
+		ws.newDelivery(subscription, event)
+	}
+
+	if event != nil {
+		select {
+		case ws.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (ws *OutboundWebhookService) newEvent(merchantID, eventType string, data interface{}) (*models.WebhookEvent, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &models.WebhookEvent{
+		ID:         "evt_" + ws.generateID(),
+		Type:       eventType,
+		MerchantID: models.MerchantIDOrDefault(merchantID),
+		CreatedAt:  time.Now().UTC(),
+		Data:       encoded,
+	}, nil
+}
+
+// newDelivery stores a pending delivery of an event to a subscription
+func (ws *OutboundWebhookService) newDelivery(subscription models.WebhookSubscription, event *models.WebhookEvent) models.WebhookDelivery {
+	payload, _ := json.Marshal(event)
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		ID:             ws.generateID(),
+		SubscriptionID: subscription.ID,
+		MerchantID:     subscription.MerchantID,
+		EventID:        event.ID,
+		EventType:      event.Type,
+		Payload:        payload,
+		Status:         models.WebhookDeliveryPending,
+		Attempts:       []models.WebhookAttempt{},
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+	}
+	ws.deliveries.SaveDelivery(delivery)
+	return delivery
+}
+
+func (ws *OutboundWebhookService) run() {
+	defer ws.wg.Done()
+
+	ticker := time.NewTicker(ws.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ws.stop:
+			return
+		case <-ticker.C:
+		case <-ws.wake:
+		}
+		ws.deliverDue(ws.stop)
+	}
+}
+
+// deliverDue attempts every delivery whose next attempt is due, until done is closed. Deliveries
+// of inactive subscriptions wait until the subscription is active again.
+func (ws *OutboundWebhookService) deliverDue(done <-chan struct{}) {
+	for _, delivery := range ws.deliveries.DueDeliveries(time.Now()) {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		ws.mu.Lock()
+		// Re-read the delivery in case it was replayed meanwhile
+		current, err := ws.deliveries.GetDelivery(delivery.MerchantID, delivery.ID)
+		if err == nil && current.Status == models.WebhookDeliveryPending && !current.NextAttemptAt.After(time.Now()) {
+			if subscription, err := ws.subscriptions.GetSubscription(current.MerchantID, current.SubscriptionID); err != nil || subscription.Active {
+				ws.attempt(current, false)
+			}
+		}
+		ws.mu.Unlock()
+	}
+}
+
+// attempt sends a delivery once and records the attempt and its outcome
+func (ws *OutboundWebhookService) attempt(delivery *models.WebhookDelivery, replay bool) {
+	attempt := models.WebhookAttempt{
+		Number:      len(delivery.Attempts) + 1,
+		Replay:      replay,
+		AttemptedAt: time.Now(),
+	}
+
+	subscription, err := ws.subscriptions.GetSubscription(delivery.MerchantID, delivery.SubscriptionID)
+	if err != nil {
+		attempt.Error = "subscription was deleted"
+	} else {
+		attempt.StatusCode, attempt.ResponseBody, err = ws.send(subscription, delivery)
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+	}
+	attempt.DurationMs = time.Since(attempt.AttemptedAt).Milliseconds()
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	switch {
+	case attempt.Error == "":
+		now := time.Now()
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.DeliveredAt = &now
+		log.Printf("Webhook delivery %s (%s) attempt %d delivered: %d", delivery.ID, delivery.EventType, attempt.Number, attempt.StatusCode)
+	case subscription == nil || delivery.EventType == models.WebhookEventTest:
+		delivery.Status = models.WebhookDeliveryDead
+		log.Printf("Webhook delivery %s (%s) attempt %d failed, not retrying: %s", delivery.ID, delivery.EventType, attempt.Number, attempt.Error)
+	case failedAttemptsSinceReplay(delivery) >= ws.maxAttempts:
+		delivery.Status = models.WebhookDeliveryDead
+		log.Printf("Webhook delivery %s (%s) moved to dead letter after attempt %d: %s", delivery.ID, delivery.EventType, attempt.Number, attempt.Error)
+	default:
+		delivery.NextAttemptAt = time.Now().Add(ws.backoff(failedAttemptsSinceReplay(delivery)))
+		log.Printf("Webhook delivery %s (%s) attempt %d failed, retrying at %s: %s", delivery.ID, delivery.EventType, attempt.Number, delivery.NextAttemptAt.Format(time.RFC3339), attempt.Error)
+	}
+
+	ws.deliveries.SaveDelivery(*delivery)
+}
+
+// send posts a delivery's payload to the subscription's URL. It returns the response status and
+// the start of the response body; responses other than 2xx are errors.
+func (ws *OutboundWebhookService) send(subscription *models.WebhookSubscription, delivery *models.WebhookDelivery) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "loyalty-core-webhooks/1.0")
+	req.Header.Set("X-Loyalty-Event", delivery.EventType)
+	req.Header.Set("X-Loyalty-Event-ID", delivery.EventID)
+	req.Header.Set("X-Loyalty-Delivery", delivery.ID)
+	req.Header.Set(utils.WebhookSignatureHeader, utils.SignWebhook(subscription.Secret, time.Now(), delivery.Payload))
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseBytes))
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, string(body), fmt.Errorf("subscriber responded %s", resp.Status)
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// applyRequest validates a subscription request and applies its non-empty fields
+func (ws *OutboundWebhookService) applyRequest(subscription *models.WebhookSubscription, req models.WebhookSubscriptionRequest) error {
+	if req.URL != "" {
+		parsed, err := url.Parse(req.URL)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+			return errors.New("url must be an absolute http or https URL")
+		}
+		if parsed.Scheme != "https" && ws.config.IsProduction() {
+			return errors.New("url must use https in production")
+		}
+		subscription.URL = req.URL
+	}
+
+	if len(req.EventTypes) > 0 {
+		eventTypes := []string{}
+		for _, eventType := range req.EventTypes {
+			if !slices.Contains(models.WebhookEventTypes, eventType) {
+				return fmt.Errorf("unknown event type %q, expected one of %s", eventType, strings.Join(models.WebhookEventTypes, ", "))
+			}
+			if !slices.Contains(eventTypes, eventType) {
+				eventTypes = append(eventTypes, eventType)
+			}
+		}
+		subscription.EventTypes = eventTypes
+	}
+
+	if len(subscription.Secret) < minWebhookSecretLength {
+		return fmt.Errorf("secret must be at least %d characters", minWebhookSecretLength)
+	}
+	if req.Description != "" {
+		subscription.Description = req.Description
+	}
+	if req.Active != nil {
+		subscription.Active = *req.Active
+	}
+	return nil
+}
+
+// failedAttemptsSinceReplay counts the failed attempts since the delivery was last replayed,
+// including the replay itself
+func failedAttemptsSinceReplay(delivery *models.WebhookDelivery) int {
+	failed := 0
+	for i := len(delivery.Attempts) - 1; i >= 0; i-- {
+		if delivery.Attempts[i].Error != "" {
+			failed++
+		}
+		if delivery.Attempts[i].Replay {
+			break
+		}
+	}
+	return failed
+}
+
+// backoff returns the delay before the next attempt: exponential with up to 20% jitter
+func (ws *OutboundWebhookService) backoff(attempts int) time.Duration {
+	delay := ws.maxDelay
+	if attempts >= 1 {
+		if shifted := ws.baseDelay << (attempts - 1); shifted > 0 && shifted < ws.maxDelay {
+			delay = shifted
+		}
+	}
+	return delay + time.Duration(mathrand.Int64N(int64(delay)/5+1))
+}
+
+// webhookMember identifies a member in webhook events
+func webhookMember(user models.User) models.WebhookMember {
+	return models.WebhookMember{ID: user.ID, LoyaltyID: user.LoyaltyID}
+}
+
+func (ws *OutboundWebhookService) generateID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/utils"
+)
+
+const testWebhookSecret = "whsec_test_secret_0123456789"
+
+// webhookMerchants keeps the subscriptions and deliveries of different tests apart, since
+// storage is shared
+var webhookMerchants atomic.Int64
+
+// webhookReceiver is a subscriber endpoint that records every request and answers with the
+// queued statuses, then with its default status
+type webhookReceiver struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	requests []receivedWebhook
+	statuses []int
+	status   int
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	receiver := &webhookReceiver{status: http.StatusOK}
+	receiver.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		receiver.mu.Lock()
+		receiver.requests = append(receiver.requests, receivedWebhook{header: r.Header.Clone(), body: body})
+		status := receiver.status
+		if len(receiver.statuses) > 0 {
+			status, receiver.statuses = receiver.statuses[0], receiver.statuses[1:]
+		}
+		receiver.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.server.Close)
+	return receiver
+}
+
+// respond queues the statuses of the next responses
+func (wr *webhookReceiver) respond(statuses ...int) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.statuses = append(wr.statuses, statuses...)
+}
+
+func (wr *webhookReceiver) setStatus(status int) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.status = status
+}
+
+func (wr *webhookReceiver) received() []receivedWebhook {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return append([]receivedWebhook(nil), wr.requests...)
+}
+
+type webhookScenario struct {
+	service      *OutboundWebhookService
+	receiver     *webhookReceiver
+	merchantID   string
+	subscription *models.WebhookSubscription
+}
+
+// newWebhookScenario subscribes a receiver to member.signup events of a new merchant
+func newWebhookScenario(t *testing.T) *webhookScenario {
+	t.Helper()
+
+	cfg := config.Defaults()
+	cfg.WebhookMaxAttempts = 3
+	cfg.WebhookTimeoutSeconds = 2
+
+	sc := &webhookScenario{
+		service:    NewOutboundWebhookService(cfg, nil),
+		receiver:   newWebhookReceiver(t),
+		merchantID: fmt.Sprintf("webhook-merchant-%d", webhookMerchants.Add(1)),
+	}
+	subscription, err := sc.service.CreateSubscription(sc.merchantID, models.WebhookSubscriptionRequest{
+		URL:        sc.receiver.server.URL,
+		Secret:     testWebhookSecret,
+		EventTypes: []string{models.WebhookEventMemberSignup},
+	})
+	if err != nil {
+		t.Fatalf("CreateSubscription: %v", err)
+	}
+	sc.subscription = subscription
+
+	// Give up on what the test left pending, so later tests do not deliver it
+	t.Cleanup(func() {
+		sc.service.DeleteSubscription(sc.merchantID, subscription.ID)
+		for _, delivery := range sc.service.deliveries.ListDeliveries(sc.merchantID, subscription.ID, models.WebhookDeliveryPending) {
+			delivery.Status = models.WebhookDeliveryDead
+			sc.service.deliveries.SaveDelivery(delivery)
+		}
+	})
+	return sc
+}
+
+// signup publishes a member.signup event and returns its delivery
+func (sc *webhookScenario) signup(t *testing.T) *models.WebhookDelivery {
+	t.Helper()
+
+	sc.service.HandleSignup(&models.User{ID: "user-" + sc.merchantID, MerchantID: sc.merchantID, Email: "member@example.com"})
+	deliveries := sc.service.deliveries.ListDeliveries(sc.merchantID, sc.subscription.ID, "")
+	if len(deliveries) != 1 {
+		t.Fatalf("%d deliveries queued, want 1", len(deliveries))
+	}
+	return &deliveries[0]
+}
+
+func (sc *webhookScenario) delivery(t *testing.T, deliveryID string) *models.WebhookDelivery {
+	t.Helper()
+
+	delivery, err := sc.service.deliveries.GetDelivery(sc.merchantID, deliveryID)
+	if err != nil {
+		t.Fatalf("GetDelivery: %v", err)
+	}
+	return delivery
+}
+
+// makeDue moves a delivery's next attempt to now, skipping the backoff
+func (sc *webhookScenario) makeDue(t *testing.T, deliveryID string) {
+	t.Helper()
+
+	delivery := sc.delivery(t, deliveryID)
+	delivery.NextAttemptAt = time.Now()
+	sc.service.deliveries.SaveDelivery(*delivery)
+}
+
+func TestOutboundWebhookIsSigned(t *testing.T) {
+	sc := newWebhookScenario(t)
+	delivery := sc.signup(t)
+
+	sc.service.deliverDue(nil)
+
+	received := sc.receiver.received()
+	if len(received) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(received))
+	}
+	request := received[0]
+
+	signature := request.header.Get(utils.WebhookSignatureHeader)
+	if err := utils.VerifyWebhookSignature(testWebhookSecret, signature, request.body, 5*time.Minute); err != nil {
+		t.Errorf("signature %q does not verify: %v", signature, err)
+	}
+	if err := utils.VerifyWebhookSignature("whsec_another_secret_0123", signature, request.body, 5*time.Minute); err == nil {
+		t.Error("signature verifies with another secret")
+	}
+	if err := utils.VerifyWebhookSignature(testWebhookSecret, signature, append(request.body, ' '), 5*time.Minute); err == nil {
+		t.Error("signature verifies for a changed body")
+	}
+
+	var event models.WebhookEvent
+	if err := json.Unmarshal(request.body, &event); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if event.Type != models.WebhookEventMemberSignup || event.MerchantID != sc.merchantID || event.ID != delivery.EventID {
+		t.Errorf("event %s %s of %s, want %s %s of %s", event.Type, event.ID, event.MerchantID, models.WebhookEventMemberSignup, delivery.EventID, sc.merchantID)
+	}
+	if got := request.header.Get("X-Loyalty-Event-ID"); got != delivery.EventID {
+		t.Errorf("X-Loyalty-Event-ID = %q, want %q", got, delivery.EventID)
+	}
+	if got := request.header.Get("X-Loyalty-Delivery"); got != delivery.ID {
+		t.Errorf("X-Loyalty-Delivery = %q, want %q", got, delivery.ID)
+	}
+
+	if delivered := sc.delivery(t, delivery.ID); delivered.Status != models.WebhookDeliveryDelivered || delivered.DeliveredAt == nil {
+		t.Errorf("delivery status %s, want %s", delivered.Status, models.WebhookDeliveryDelivered)
+	}
+}
+
+func TestOutboundWebhookRetriesWithBackoff(t *testing.T) {
+	sc := newWebhookScenario(t)
+	sc.receiver.respond(http.StatusInternalServerError)
+	delivery := sc.signup(t)
+
+	sc.service.deliverDue(nil)
+
+	failed := sc.delivery(t, delivery.ID)
+	if failed.Status != models.WebhookDeliveryPending || len(failed.Attempts) != 1 {
+		t.Fatalf("after a failure: status %s with %d attempts, want pending with 1", failed.Status, len(failed.Attempts))
+	}
+	if attempt := failed.Attempts[0]; attempt.StatusCode != http.StatusInternalServerError || attempt.Error == "" {
+		t.Errorf("attempt recorded status %d, error %q", attempt.StatusCode, attempt.Error)
+	}
+	// The first retry waits the base delay of 10s plus up to 20% jitter
+	if wait := time.Until(failed.NextAttemptAt); wait < 9*time.Second || wait > 12*time.Second {
+		t.Errorf("next attempt in %s, want 10-12s", wait)
+	}
+
+	// Nothing is sent again before the backoff has passed
+	sc.service.deliverDue(nil)
+	if received := sc.receiver.received(); len(received) != 1 {
+		t.Fatalf("receiver got %d requests during the backoff, want 1", len(received))
+	}
+
+	sc.makeDue(t, delivery.ID)
+	sc.service.deliverDue(nil)
+
+	delivered := sc.delivery(t, delivery.ID)
+	if delivered.Status != models.WebhookDeliveryDelivered || len(delivered.Attempts) != 2 {
+		t.Fatalf("after the retry: status %s with %d attempts, want delivered with 2", delivered.Status, len(delivered.Attempts))
+	}
+	received := sc.receiver.received()
+	if len(received) != 2 || received[0].header.Get("X-Loyalty-Event-ID") != received[1].header.Get("X-Loyalty-Event-ID") {
+		t.Error("the retry was not sent with the same event ID")
+	}
+}
+
+func TestOutboundWebhookBackoff(t *testing.T) {
+	service := &OutboundWebhookService{baseDelay: 10 * time.Second, maxDelay: time.Hour}
+
+	for attempts, want := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		10: time.Hour, // 10s << 9 is past the cap
+		64: time.Hour, // the shift overflows
+		0:  time.Hour,
+	} {
+		if delay := service.backoff(attempts); delay < want || delay > want+want/5 {
+			t.Errorf("backoff(%d) = %s, want %s plus up to 20%%", attempts, delay, want)
+		}
+	}
+}
+
+func TestOutboundWebhookDeadLettersAndReplays(t *testing.T) {
+	sc := newWebhookScenario(t)
+	sc.receiver.setStatus(http.StatusServiceUnavailable)
+	delivery := sc.signup(t)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		sc.makeDue(t, delivery.ID)
+		sc.service.deliverDue(nil)
+	}
+
+	dead := sc.delivery(t, delivery.ID)
+	if dead.Status != models.WebhookDeliveryDead || len(dead.Attempts) != 3 {
+		t.Fatalf("after 3 failures: status %s with %d attempts, want dead with 3", dead.Status, len(dead.Attempts))
+	}
+
+	// A dead delivery is not retried
+	sc.makeDue(t, delivery.ID)
+	sc.service.deliverDue(nil)
+	if received := sc.receiver.received(); len(received) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(received))
+	}
+
+	sc.receiver.setStatus(http.StatusOK)
+	replayed, err := sc.service.ReplayDelivery(sc.merchantID, sc.subscription.ID, delivery.ID)
+	if err != nil {
+		t.Fatalf("ReplayDelivery: %v", err)
+	}
+	if replayed.Status != models.WebhookDeliveryDelivered || len(replayed.Attempts) != 4 || !replayed.Attempts[3].Replay {
+		t.Fatalf("after the replay: status %s with %d attempts, want delivered with a 4th, replayed attempt", replayed.Status, len(replayed.Attempts))
+	}
+	received := sc.receiver.received()
+	if got := received[len(received)-1].header.Get("X-Loyalty-Event-ID"); got != delivery.EventID {
+		t.Errorf("replay sent event %q, want the original %q", got, delivery.EventID)
+	}
+}
+
+func TestOutboundWebhookFailedReplayGetsFreshAttempts(t *testing.T) {
+	sc := newWebhookScenario(t)
+	sc.receiver.setStatus(http.StatusServiceUnavailable)
+	delivery := sc.signup(t)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		sc.makeDue(t, delivery.ID)
+		sc.service.deliverDue(nil)
+	}
+
+	replayed, err := sc.service.ReplayDelivery(sc.merchantID, sc.subscription.ID, delivery.ID)
+	if err != nil {
+		t.Fatalf("ReplayDelivery: %v", err)
+	}
+	if replayed.Status != models.WebhookDeliveryPending {
+		t.Fatalf("after a failed replay: status %s, want pending", replayed.Status)
+	}
+
+	// The replay starts a new count of attempts: two more failures dead-letter it again
+	for attempt := 1; attempt <= 2; attempt++ {
+		sc.makeDue(t, delivery.ID)
+		sc.service.deliverDue(nil)
+	}
+	if dead := sc.delivery(t, delivery.ID); dead.Status != models.WebhookDeliveryDead || len(dead.Attempts) != 6 {
+		t.Errorf("status %s with %d attempts, want dead with 6", dead.Status, len(dead.Attempts))
+	}
+}
+
+func TestOutboundWebhookPayloadsHoldNoPersonalData(t *testing.T) {
+	sc := newWebhookScenario(t)
+	if _, err := sc.service.UpdateSubscription(sc.merchantID, sc.subscription.ID, models.WebhookSubscriptionRequest{
+		EventTypes: []string{models.WebhookEventMemberSignup, models.WebhookEventPointsEarned},
+	}); err != nil {
+		t.Fatalf("UpdateSubscription: %v", err)
+	}
+
+	user := models.User{
+		ID:         "user-" + sc.merchantID,
+		MerchantID: sc.merchantID,
+		LoyaltyID:  "100042",
+		Email:      "jane.doe@example.com",
+		FirstName:  "Jane",
+		LastName:   "Doe",
+		Phone:      "+15550100042",
+		Points:     25,
+	}
+	sc.service.HandleSignup(&user)
+	// The scenario has no program, so the balance is left unchanged to skip tier changes
+	sc.service.HandleTransaction(user, models.Transaction{ID: "txn-1", UserID: user.ID, Type: models.TransactionTypeEarn, Points: 25, Description: "Dinner with Jane Doe"}, user.Points)
+
+	deliveries := sc.service.deliveries.ListDeliveries(sc.merchantID, sc.subscription.ID, "")
+	if len(deliveries) != 2 {
+		t.Fatalf("%d deliveries queued, want 2", len(deliveries))
+	}
+	for _, delivery := range deliveries {
+		payload := string(delivery.Payload)
+		for _, personal := range []string{user.Email, user.FirstName, user.LastName, user.Phone} {
+			if strings.Contains(payload, personal) {
+				t.Errorf("%s payload holds %q: %s", delivery.EventType, personal, payload)
+			}
+		}
+		if !strings.Contains(payload, user.ID) || !strings.Contains(payload, user.LoyaltyID) {
+			t.Errorf("%s payload does not identify the member: %s", delivery.EventType, payload)
+		}
+	}
+}
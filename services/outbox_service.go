@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+
+	square "github.com/square/square-go-sdk"
+)
+
+// OutboxService delivers recorded Square writes in the background, retrying failures with
+// exponential backoff and moving items that keep failing to the dead-letter state
+type OutboxService struct {
+	config         *config.Config
+	outbox         *storage.OutboxStorage
+	userStorage    *storage.UserStorage
+	transactions   *storage.TransactionStorage
+	loyaltyService *LoyaltyService
+	pollInterval   time.Duration
+	maxAttempts    int
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex // serializes delivery between the worker and manual replays
+}
+
+func NewOutboxService(cfg *config.Config, loyaltyService *LoyaltyService) *OutboxService {
+	return &OutboxService{
+		config:         cfg,
+		outbox:         storage.GetGlobalOutboxStorage(),
+		userStorage:    storage.GetGlobalUserStorage(),
+		transactions:   storage.GetGlobalTransactionStorage(),
+		loyaltyService: loyaltyService,
+		pollInterval:   time.Duration(cfg.OutboxPollIntervalSeconds) * time.Second,
+		maxAttempts:    cfg.OutboxMaxAttempts,
+		baseDelay:      5 * time.Second,
+		maxDelay:       time.Hour,
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start runs the background delivery worker
+func (o *OutboxService) Start() {
+	if o.pollInterval <= 0 {
+		o.pollInterval = 5 * time.Second
+	}
+
+	o.wg.Add(1)
+	go o.run()
+	log.Printf("Outbox worker started (poll interval: %s)", o.pollInterval)
+}
+
+// Stop waits for the in-flight delivery to finish and stops the worker
+func (o *OutboxService) Stop() {
+	close(o.stop)
+	o.wg.Wait()
+	log.Println("Outbox worker stopped")
+}
+
+// ListItems returns outbox items, optionally filtered by status
+func (o *OutboxService) ListItems(status string) []models.OutboxItem {
+	return o.outbox.ListItems(status)
+}
+
+// GetItem returns a single outbox item
+func (o *OutboxService) GetItem(itemID string) (*models.OutboxItem, error) {
+	return o.outbox.GetItem(itemID)
+}
+
+// ReplayItem resets a dead or pending item and delivers it immediately
+func (o *OutboxService) ReplayItem(itemID string) (*models.OutboxItem, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	item, err := o.outbox.GetItem(itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.Status == models.OutboxStatusDelivered {
+		return nil, errors.New("outbox item already delivered")
+	}
+
+	item.Status = models.OutboxStatusPending
+	item.Attempts = 0
+	item.NextAttemptAt = time.Now()
+	log.Printf("Replaying outbox item %s", item.ID)
+
+	o.attempt(item)
+	return o.outbox.GetItem(itemID)
+}
+
+func (o *OutboxService) run() {
+	defer o.wg.Done()
+
+	ticker := time.NewTicker(o.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+			o.deliverDue(o.stop)
+		}
+	}
+}
+
+// Flush makes a last delivery pass over the due items, until ctx is done, and logs how many are
+// left pending. It runs at shutdown after Stop, since the outbox is held in memory and
+// undelivered items do not survive a restart.
+func (o *OutboxService) Flush(ctx context.Context) {
+	o.deliverDue(ctx.Done())
+
+	if pending := len(o.outbox.ListItems(models.OutboxStatusPending)); pending > 0 {
+		log.Printf("Outbox flushed with %d items still pending", pending)
+	} else {
+		log.Println("Outbox flushed")
+	}
+}
+
+// deliverDue attempts every item whose next attempt is due, until done is closed. Delivery to a
+// merchant pauses while its Square connection is missing or unhealthy, so items keep their
+// remaining attempts for when it recovers.
+func (o *OutboxService) deliverDue(done <-chan struct{}) {
+	for _, item := range o.outbox.DueItems(time.Now()) {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if user, err := o.userStorage.GetUserByID(item.UserID); err == nil {
+			if provider := o.loyaltyService.providerFor(user); provider == nil || !provider.Available() {
+				continue
+			}
+		}
+
+		o.mu.Lock()
+		// Re-read the item in case it was replayed meanwhile
+		if current, err := o.outbox.GetItem(item.ID); err == nil && current.Status == models.OutboxStatusPending {
+			o.attempt(current)
+		}
+		o.mu.Unlock()
+	}
+}
+
+// attempt delivers an item once and records the outcome
+func (o *OutboxService) attempt(item *models.OutboxItem) {
+	before := *item
+	item.Attempts++
+
+	event, err := o.deliver(item)
+	if err != nil {
+		item.LastError = err.Error()
+		if item.Attempts >= o.maxAttempts {
+			item.Status = models.OutboxStatusDead
+			log.Printf("Outbox item %s moved to dead letter after %d attempts: %v", item.ID, item.Attempts, err)
+		} else {
+			item.NextAttemptAt = time.Now().Add(o.backoff(item.Attempts))
+			log.Printf("Outbox item %s attempt %d failed, retrying at %s: %v", item.ID, item.Attempts, item.NextAttemptAt.Format(time.RFC3339), err)
+		}
+	} else {
+		now := time.Now()
+		item.Status = models.OutboxStatusDelivered
+		item.LastError = ""
+		item.DeliveredAt = &now
+		item.SquareEventID = event.ID
+
+		// Reconciliation corrections have no local transaction
+		if item.TransactionID != "" {
+			if err := o.transactions.SetSquareEventID(item.MerchantID, item.UserID, item.TransactionID, event.ID); err != nil {
+				log.Printf("Failed to link transaction %s to Square event %s: %v", item.TransactionID, event.ID, err)
+			}
+		}
+	}
+
+	if err := o.outbox.UpdateItem(*item); err != nil {
+		log.Printf("Failed to update outbox item %s: %v", item.ID, err)
+		return
+	}
+
+	// Retries are routine; the outcome is what changes the member's Square account
+	actor := models.SystemActor(models.AuditActorOutbox, item.MerchantID)
+	switch item.Status {
+	case models.OutboxStatusDelivered:
+		o.loyaltyService.audit.Record(actor, models.AuditActionOutboxDeliver, models.AuditTargetOutboxItem, item.ID, before, item)
+	case models.OutboxStatusDead:
+		o.loyaltyService.audit.Record(actor, models.AuditActionOutboxDeadLetter, models.AuditTargetOutboxItem, item.ID, before, item)
+	}
+}
+
+// deliver performs the Square write for an item using its stable idempotency key
+func (o *OutboxService) deliver(item *models.OutboxItem) (*square.LoyaltyEvent, error) {
+	user, err := o.userStorage.GetUserByID(item.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	squareService := o.loyaltyService.providerFor(user)
+	if squareService == nil {
+		return nil, errors.New("Square service is not available")
+	}
+
+	// Provision the Square account first if the member does not have one yet
+	ctx := context.Background()
+	if err := o.loyaltyService.ensureSquareLoyaltyAccount(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to ensure Square loyalty account: %w", err)
+	}
+
+	switch item.Operation {
+	case models.OutboxOperationAccumulatePoints:
+		return squareService.AccumulateLoyaltyPoints(ctx, user.SquareAccountID, item.Points, item.OrderID, item.LocationID, item.IdempotencyKey)
+	case models.OutboxOperationAdjustPoints:
+		return squareService.AdjustLoyaltyPoints(ctx, user.SquareAccountID, item.Points, item.Reason, item.IdempotencyKey)
+	default:
+		return nil, fmt.Errorf("unknown outbox operation %q", item.Operation)
+	}
+}
+
+// backoff returns the delay before the next attempt: exponential with up to 20% jitter
+func (o *OutboxService) backoff(attempts int) time.Duration {
+	delay := o.maxDelay
+	if attempts >= 1 {
+		if shifted := o.baseDelay << (attempts - 1); shifted > 0 && shifted < o.maxDelay {
+			delay = shifted
+		}
+	}
+	return delay + time.Duration(rand.Int64N(int64(delay)/5+1))
+}
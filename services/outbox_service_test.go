@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"loyalty-core/models"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	outbox := &OutboxService{baseDelay: 5 * time.Second, maxDelay: time.Hour}
+
+	for attempts, want := range map[int]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		12: time.Hour, // 5s << 11 is past the cap
+		64: time.Hour, // the shift overflows
+		0:  time.Hour,
+	} {
+		if delay := outbox.backoff(attempts); delay < want || delay > want+want/5 {
+			t.Errorf("backoff(%d) = %s, want %s plus up to 20%%", attempts, delay, want)
+		}
+	}
+}
+
+func TestOutboxReplayRefusesDeliveredItems(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	transaction, err := sc.loyalty.EarnPoints(context.Background(), user.ID, 15, "Tea", "")
+	if err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+	outbox.deliverDue(nil)
+
+	item := outboxItemFor(t, transaction.ID)
+	if item.Status != models.OutboxStatusDelivered {
+		t.Fatalf("item = %+v, want delivered", item)
+	}
+	if _, err := outbox.ReplayItem(item.ID); err == nil {
+		t.Fatal("a delivered item was replayed")
+	}
+	if balance := sc.squareBalance(t, user); balance != 15 {
+		t.Fatalf("Square balance = %d, want 15", balance)
+	}
+}
+
+func TestOutboxRetryKeepsIdempotencyKey(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	transaction, err := sc.loyalty.EarnPoints(context.Background(), user.ID, 35, "Sandwich", "")
+	if err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+	key := outboxItemFor(t, transaction.ID).IdempotencyKey
+	if key != "txn-"+transaction.ID {
+		t.Fatalf("idempotency key = %q, want it derived from the transaction", key)
+	}
+
+	sc.fake.FailNext("accumulate", http.StatusBadGateway)
+	outbox.deliverDue(nil)
+	item := outboxItemFor(t, transaction.ID)
+	makeDue(t, item.ID)
+	outbox.deliverDue(nil)
+
+	item = outboxItemFor(t, transaction.ID)
+	if item.Status != models.OutboxStatusDelivered || item.IdempotencyKey != key {
+		t.Fatalf("item = %+v, want delivered with key %s", item, key)
+	}
+}
+
+func TestOutboxFlushStopsWhenContextIsDone(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	transaction, err := sc.loyalty.EarnPoints(context.Background(), user.ID, 10, "Muffin", "")
+	if err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	outbox.Flush(ctx)
+	if item := outboxItemFor(t, transaction.ID); item.Status != models.OutboxStatusPending || item.Attempts != 0 {
+		t.Fatalf("item = %+v, want it untouched after the deadline", item)
+	}
+
+	outbox.Flush(context.Background())
+	if item := outboxItemFor(t, transaction.ID); item.Status != models.OutboxStatusDelivered {
+		t.Fatalf("item = %+v, want delivered by the flush", item)
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"loyalty-core/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords holds the bundled list of common/breached passwords, lowercased
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+// PasswordPolicy describes the rules a password must satisfy
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	HistorySize   int // number of recent passwords, the current one included, that may not be reused
+}
+
+// NewPasswordPolicy builds the password policy from configuration
+func NewPasswordPolicy(cfg *config.Config) *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:     cfg.PasswordMinLength,
+		RequireUpper:  cfg.PasswordRequireUpper,
+		RequireLower:  cfg.PasswordRequireLower,
+		RequireDigit:  cfg.PasswordRequireDigit,
+		RequireSymbol: cfg.PasswordRequireSymbol,
+		HistorySize:   cfg.PasswordHistorySize,
+	}
+}
+
+// Validate checks the password against length, character class and common-password rules
+func (p *PasswordPolicy) Validate(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		return errors.New("password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		return errors.New("password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		return errors.New("password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return errors.New("password must contain a symbol")
+	}
+
+	if isCommonPassword(password) {
+		return errors.New("password is too common, please choose another")
+	}
+
+	return nil
+}
+
+// CheckReuse rejects a password matching the current hash or any of the retired hashes still
+// inside the history window. The current password is always rejected and counts towards
+// HistorySize.
+func (p *PasswordPolicy) CheckReuse(password, currentHash string, history []string) error {
+	if bcrypt.CompareHashAndPassword([]byte(currentHash), []byte(password)) == nil {
+		return errors.New("new password must be different from the current password")
+	}
+
+	for _, hash := range p.recent(history) {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return fmt.Errorf("password must not match any of your last %d passwords", p.HistorySize)
+		}
+	}
+
+	return nil
+}
+
+// AppendHistory records a retired password hash, keeping only the HistorySize-1 hashes that
+// CheckReuse needs besides the current one
+func (p *PasswordPolicy) AppendHistory(history []string, hash string) []string {
+	return p.recent(append(history, hash))
+}
+
+// recent returns the newest HistorySize-1 entries of history
+func (p *PasswordPolicy) recent(history []string) []string {
+	keep := p.HistorySize - 1
+	if keep <= 0 {
+		return nil
+	}
+	if len(history) > keep {
+		return history[len(history)-keep:]
+	}
+	return history
+}
+
+func isCommonPassword(password string) bool {
+	_, found := commonPasswords[strings.ToLower(password)]
+	return found
+}
+
+func loadCommonPasswords(data string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}
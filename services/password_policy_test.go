@@ -0,0 +1,86 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+
+	tests := []struct {
+		password string
+		wantErr  string
+	}{
+		{password: "Zq7#kfLw92pX"},
+		{password: "Zq7#kfLw", wantErr: "at least 10 characters"},
+		{password: "zq7#kflw92px", wantErr: "uppercase"},
+		{password: "ZQ7#KFLW92PX", wantErr: "lowercase"},
+		{password: "Zqx#kfLwabpX", wantErr: "digit"},
+		{password: "Zq7xkfLw92pX", wantErr: "symbol"},
+	}
+
+	for _, tt := range tests {
+		err := policy.Validate(tt.password)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Validate(%q) = %v, want nil", tt.password, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Validate(%q) = %v, want an error about %q", tt.password, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPasswordPolicyCommonPasswordsIgnoreCase(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 1}
+
+	for _, password := range []string{"123456", "PASSWORD", "Qwerty", "Password1234", "P@ssw0rd"} {
+		if err := policy.Validate(password); err == nil {
+			t.Errorf("Validate(%q) accepted a common password", password)
+		}
+	}
+}
+
+func TestPasswordPolicyReuseWindow(t *testing.T) {
+	hash := func(password string) string {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("hash: %v", err)
+		}
+		return string(hashed)
+	}
+	policy := &PasswordPolicy{HistorySize: 3}
+
+	// Passwords one to four were used in turn; four is the current one
+	var history []string
+	for _, password := range []string{"first-1", "second-2", "third-3"} {
+		history = policy.AppendHistory(history, hash(password))
+	}
+	current := hash("fourth-4")
+
+	if len(history) != 2 {
+		t.Fatalf("history keeps %d hashes, want HistorySize-1 = 2", len(history))
+	}
+	for _, password := range []string{"fourth-4", "third-3", "second-2"} {
+		if err := policy.CheckReuse(password, current, history); err == nil {
+			t.Errorf("CheckReuse(%q) accepted one of the last 3 passwords", password)
+		}
+	}
+	if err := policy.CheckReuse("first-1", current, history); err != nil {
+		t.Errorf("CheckReuse(%q) = %v, want it allowed outside the window", "first-1", err)
+	}
+}
+
+func TestPasswordPolicyWithoutHistory(t *testing.T) {
+	policy := &PasswordPolicy{HistorySize: 0}
+	current, _ := bcrypt.GenerateFromPassword([]byte("current-1"), bcrypt.MinCost)
+
+	if history := policy.AppendHistory(nil, "old-hash"); len(history) != 0 {
+		t.Errorf("history = %v, want none kept", history)
+	}
+	if err := policy.CheckReuse("current-1", string(current), nil); err == nil {
+		t.Error("CheckReuse accepted the current password")
+	}
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+
+	square "github.com/square/square-go-sdk"
+)
+
+// ErrProgramUnavailable is returned when there is no loyalty program to describe
+var ErrProgramUnavailable = errors.New("loyalty program is not available")
+
+// ErrUnknownRewardTier is returned for a reward tier the merchant's program does not offer
+var ErrUnknownRewardTier = errors.New("unknown reward tier")
+
+// ProgramService caches each merchant's Square loyalty program definition. The cache is refreshed
+// on a timer and whenever Square reports a program change through a webhook.
+type ProgramService struct {
+	config         *config.Config
+	loyaltyService *LoyaltyService
+	interval       time.Duration
+
+	programs map[string]*models.LoyaltyProgram // merchantID -> program
+	mu       sync.RWMutex
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewProgramService(cfg *config.Config, loyaltyService *LoyaltyService) *ProgramService {
+	return &ProgramService{
+		config:         cfg,
+		loyaltyService: loyaltyService,
+		interval:       time.Duration(cfg.LoyaltyProgramRefreshMinutes) * time.Minute,
+		programs:       make(map[string]*models.LoyaltyProgram),
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start loads the programs and runs the periodic refresh worker
+func (ps *ProgramService) Start() {
+	if !ps.loyaltyService.merchants.SquareEnabled() {
+		log.Println("Loyalty program cache disabled: Square is not available")
+		return
+	}
+	if ps.interval <= 0 {
+		ps.interval = 15 * time.Minute
+	}
+
+	ps.wg.Add(1)
+	go ps.run()
+	log.Printf("Loyalty program refresh worker started (interval: %s)", ps.interval)
+}
+
+// Stop stops the refresh worker
+func (ps *ProgramService) Stop() {
+	close(ps.stop)
+	ps.wg.Wait()
+}
+
+// GetProgram returns a merchant's cached program, loading it from Square if it has not been
+// loaded yet
+func (ps *ProgramService) GetProgram(ctx context.Context, merchantID string) (*models.LoyaltyProgram, error) {
+	merchantID = models.MerchantIDOrDefault(merchantID)
+
+	ps.mu.RLock()
+	program := ps.programs[merchantID]
+	ps.mu.RUnlock()
+
+	if program != nil {
+		return program, nil
+	}
+
+	if err := ps.Refresh(ctx, merchantID); err != nil {
+		return nil, err
+	}
+
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.programs[merchantID], nil
+}
+
+// RewardTier returns a reward tier of a merchant's program
+func (ps *ProgramService) RewardTier(ctx context.Context, merchantID, tierID string) (*models.ProgramRewardTier, error) {
+	program, err := ps.GetProgram(ctx, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, tier := range program.RewardTiers {
+		if tier.ID == tierID {
+			return &tier, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownRewardTier, tierID)
+}
+
+// CachedProgram returns a merchant's cached program without loading it, or nil if it has not
+// been loaded
+func (ps *ProgramService) CachedProgram(merchantID string) *models.LoyaltyProgram {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.programs[models.MerchantIDOrDefault(merchantID)]
+}
+
+// GetProgramAt returns a merchant's program as offered at one of its locations: only the reward
+// tiers available there are listed. An empty location ID returns the whole program.
+func (ps *ProgramService) GetProgramAt(ctx context.Context, merchantID, locationID string) (*models.LoyaltyProgram, error) {
+	program, err := ps.GetProgram(ctx, merchantID)
+	if err != nil || locationID == "" {
+		return program, err
+	}
+
+	location, err := ps.loyaltyService.locations.ResolveLocation(ctx, merchantID, locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	offered := *program
+	offered.RewardTiers = []models.ProgramRewardTier{}
+	for _, tier := range program.RewardTiers {
+		if location.OffersRewardTier(tier.ID) {
+			offered.RewardTiers = append(offered.RewardTiers, tier)
+		}
+	}
+	return &offered, nil
+}
+
+// Refresh reloads a merchant's program from Square. The cached program is kept if the reload fails.
+func (ps *ProgramService) Refresh(ctx context.Context, merchantID string) error {
+	squareService := ps.loyaltyService.merchants.Provider(merchantID)
+	if squareService == nil {
+		return ErrProgramUnavailable
+	}
+
+	program, err := squareService.GetLoyaltyProgram(ctx)
+	if err != nil {
+		return err
+	}
+
+	ps.setProgram(merchantID, program)
+	return nil
+}
+
+// HandleProgramUpdated replaces a merchant's cached program with one received in a webhook
+func (ps *ProgramService) HandleProgramUpdated(merchantID string, program *square.LoyaltyProgram) {
+	ps.setProgram(merchantID, program)
+	log.Printf("Loyalty program of merchant %s updated from webhook", models.MerchantIDOrDefault(merchantID))
+}
+
+// AddRewardTiers adds reward tiers to a merchant's cached program, replacing tiers with the same
+// ID. Without a cached program, a local one is started. It is used to seed development data; a
+// program loaded from Square replaces it on the next refresh.
+func (ps *ProgramService) AddRewardTiers(merchantID string, tiers []models.ProgramRewardTier) {
+	merchantID = models.MerchantIDOrDefault(merchantID)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	program := ps.programs[merchantID]
+	if program == nil {
+		program = &models.LoyaltyProgram{
+			ID:           "local",
+			Status:       "ACTIVE",
+			Terminology:  models.ProgramTerminology{One: "Point", Other: "Points"},
+			RewardTiers:  []models.ProgramRewardTier{},
+			AccrualRules: []models.ProgramAccrualRule{},
+			LocationIDs:  []string{},
+		}
+	} else {
+		copied := *program
+		copied.RewardTiers = append([]models.ProgramRewardTier{}, program.RewardTiers...)
+		program = &copied
+	}
+
+	for _, tier := range tiers {
+		replaced := false
+		for i := range program.RewardTiers {
+			if program.RewardTiers[i].ID == tier.ID {
+				program.RewardTiers[i] = tier
+				replaced = true
+			}
+		}
+		if !replaced {
+			program.RewardTiers = append(program.RewardTiers, tier)
+		}
+	}
+	program.FetchedAt = time.Now()
+	ps.programs[merchantID] = program
+}
+
+// refreshAll reloads the programs of all connected merchants
+func (ps *ProgramService) refreshAll() {
+	for merchantID := range ps.loyaltyService.merchants.Providers() {
+		if err := ps.Refresh(context.Background(), merchantID); err != nil {
+			log.Printf("Failed to refresh loyalty program of merchant %s: %v", merchantID, err)
+		}
+	}
+}
+
+func (ps *ProgramService) run() {
+	defer ps.wg.Done()
+
+	ps.refreshAll()
+
+	ticker := time.NewTicker(ps.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ps.stop:
+			return
+		case <-ticker.C:
+			ps.refreshAll()
+		}
+	}
+}
+
+func (ps *ProgramService) setProgram(merchantID string, program *square.LoyaltyProgram) {
+	converted := convertSquareProgram(program)
+	merchantID = models.MerchantIDOrDefault(merchantID)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	// Ignore a snapshot older than the cached one, e.g. a delayed webhook
+	if cached := ps.programs[merchantID]; cached != nil {
+		cachedAt, cachedErr := time.Parse(time.RFC3339, cached.UpdatedAt)
+		updatedAt, err := time.Parse(time.RFC3339, converted.UpdatedAt)
+		if cachedErr == nil && err == nil && updatedAt.Before(cachedAt) {
+			return
+		}
+	}
+	ps.programs[merchantID] = converted
+}
+
+// convertSquareProgram converts a Square loyalty program to our model
+func convertSquareProgram(program *square.LoyaltyProgram) *models.LoyaltyProgram {
+	converted := &models.LoyaltyProgram{
+		ID:           stringValue(program.ID),
+		Status:       "ACTIVE",
+		RewardTiers:  []models.ProgramRewardTier{},
+		AccrualRules: []models.ProgramAccrualRule{},
+		LocationIDs:  program.LocationIDs,
+		UpdatedAt:    stringValue(program.UpdatedAt),
+		FetchedAt:    time.Now(),
+	}
+	if program.Status != nil {
+		converted.Status = string(*program.Status)
+	}
+	if converted.LocationIDs == nil {
+		converted.LocationIDs = []string{}
+	}
+	if program.Terminology != nil {
+		converted.Terminology = models.ProgramTerminology{One: program.Terminology.One, Other: program.Terminology.Other}
+	}
+	if program.ExpirationPolicy != nil {
+		converted.ExpirationPolicy = &models.ProgramExpirationPolicy{ExpirationDuration: program.ExpirationPolicy.ExpirationDuration}
+	}
+
+	for _, tier := range program.RewardTiers {
+		if tier == nil {
+			continue
+		}
+		converted.RewardTiers = append(converted.RewardTiers, models.ProgramRewardTier{
+			ID:     stringValue(tier.ID),
+			Name:   stringValue(tier.Name),
+			Points: tier.Points,
+		})
+	}
+
+	for _, rule := range program.AccrualRules {
+		if rule == nil {
+			continue
+		}
+		accrualRule := models.ProgramAccrualRule{AccrualType: string(rule.AccrualType)}
+		if rule.Points != nil {
+			accrualRule.Points = *rule.Points
+		}
+		if data := rule.SpendData; data != nil {
+			accrualRule.SpendAmountCents, accrualRule.Currency = moneyValue(data.AmountMoney)
+			accrualRule.TaxMode = string(data.TaxMode)
+		}
+		if data := rule.VisitData; data != nil {
+			accrualRule.MinimumAmountCents, accrualRule.Currency = moneyValue(data.MinimumAmountMoney)
+			accrualRule.TaxMode = string(data.TaxMode)
+		}
+		if data := rule.ItemVariationData; data != nil {
+			accrualRule.ItemVariationID = data.ItemVariationID
+		}
+		if data := rule.CategoryData; data != nil {
+			accrualRule.CategoryID = data.CategoryID
+		}
+		converted.AccrualRules = append(converted.AccrualRules, accrualRule)
+	}
+
+	return converted
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// moneyValue returns the amount in the smallest currency unit and the currency code
+func moneyValue(money *square.Money) (int64, string) {
+	if money == nil {
+		return 0, ""
+	}
+
+	var amount int64
+	if money.Amount != nil {
+		amount = *money.Amount
+	}
+	currency := ""
+	if money.Currency != nil {
+		currency = string(*money.Currency)
+	}
+	return amount, currency
+}
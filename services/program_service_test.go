@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+
+	square "github.com/square/square-go-sdk"
+)
+
+func TestProgramIsCachedAfterFirstLoad(t *testing.T) {
+	sc := newSquareScenario(t)
+	programs := NewProgramService(sc.cfg, sc.loyalty)
+
+	if cached := programs.CachedProgram(models.DefaultMerchantID); cached != nil {
+		t.Fatalf("cached program before loading = %+v", cached)
+	}
+	program, err := programs.GetProgram(context.Background(), "")
+	if err != nil {
+		t.Fatalf("GetProgram: %v", err)
+	}
+	if len(program.RewardTiers) != 2 || program.RewardTiers[0].ID != "tier-coffee" || program.RewardTiers[0].Points != 100 {
+		t.Fatalf("reward tiers = %+v, want the fake's tiers", program.RewardTiers)
+	}
+
+	// Served from the cache, so Square is not called again
+	sc.fake.FailNext("get_program", http.StatusInternalServerError)
+	if cached, err := programs.GetProgram(context.Background(), models.DefaultMerchantID); err != nil || cached != program {
+		t.Fatalf("second GetProgram = %v, %v, want the cached program", cached, err)
+	}
+}
+
+func TestProgramRefreshFailureKeepsCache(t *testing.T) {
+	sc := newSquareScenario(t)
+	programs := NewProgramService(sc.cfg, sc.loyalty)
+	program, err := programs.GetProgram(context.Background(), models.DefaultMerchantID)
+	if err != nil {
+		t.Fatalf("GetProgram: %v", err)
+	}
+
+	sc.fake.FailNext("get_program", http.StatusInternalServerError)
+	if err := programs.Refresh(context.Background(), models.DefaultMerchantID); err == nil {
+		t.Fatal("Refresh succeeded, want the injected failure")
+	}
+	if cached := programs.CachedProgram(models.DefaultMerchantID); cached != program {
+		t.Fatalf("cached program = %+v after a failed refresh, want the previous one", cached)
+	}
+}
+
+func TestProgramWebhookReplacesCacheUnlessOlder(t *testing.T) {
+	sc := newSquareScenario(t)
+	programs := NewProgramService(sc.cfg, sc.loyalty)
+
+	updated := func(updatedAt, tierName string) *square.LoyaltyProgram {
+		id, tierID := "webhook-program", "tier-webhook"
+		return &square.LoyaltyProgram{
+			ID:          &id,
+			UpdatedAt:   &updatedAt,
+			RewardTiers: []*square.LoyaltyProgramRewardTier{{ID: &tierID, Name: &tierName, Points: 250}},
+		}
+	}
+
+	programs.HandleProgramUpdated("", updated("2026-03-02T10:00:00Z", "Dinner"))
+	if program := programs.CachedProgram(models.DefaultMerchantID); program == nil || program.RewardTiers[0].Name != "Dinner" || program.RewardTiers[0].Points != 250 {
+		t.Fatalf("cached program = %+v, want the webhook's program", program)
+	}
+
+	// A delayed notification of an earlier change is ignored
+	programs.HandleProgramUpdated("", updated("2026-03-01T10:00:00Z", "Brunch"))
+	if program := programs.CachedProgram(models.DefaultMerchantID); program.RewardTiers[0].Name != "Dinner" {
+		t.Fatalf("reward tier = %q after an older snapshot, want Dinner", program.RewardTiers[0].Name)
+	}
+}
+
+func TestProgramUnavailableWithoutSquare(t *testing.T) {
+	programs := NewProgramService(config.Defaults(), NewLoyaltyServiceWithProvider(config.Defaults(), nil))
+
+	if _, err := programs.GetProgram(context.Background(), models.DefaultMerchantID); !errors.Is(err, ErrProgramUnavailable) {
+		t.Fatalf("GetProgram error = %v, want ErrProgramUnavailable", err)
+	}
+
+	// Seeded tiers start a local program
+	programs.AddRewardTiers("", []models.ProgramRewardTier{{ID: "tier-local", Name: "Local treat", Points: 50}})
+	program, err := programs.GetProgram(context.Background(), models.DefaultMerchantID)
+	if err != nil || program.ID != "local" || len(program.RewardTiers) != 1 {
+		t.Fatalf("GetProgram = %+v, %v, want the local program", program, err)
+	}
+}
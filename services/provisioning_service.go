@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+)
+
+const (
+	ProvisioningModeEager = "eager" // provision during signup, queue a retry on failure
+	ProvisioningModeLazy  = "lazy"  // provision from the background queue only
+)
+
+// ProvisioningService provisions Square loyalty accounts for new members, either eagerly at
+// signup or through a background queue, retrying failures with exponential backoff
+type ProvisioningService struct {
+	config         *config.Config
+	loyaltyService *LoyaltyService
+	mode           string
+	maxAttempts    int
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+
+	jobs    chan string
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	retries map[string]*time.Timer // userID -> scheduled retry
+}
+
+func NewProvisioningService(cfg *config.Config, loyaltyService *LoyaltyService) *ProvisioningService {
+	mode := cfg.SquareProvisioningMode
+	if mode != ProvisioningModeLazy {
+		mode = ProvisioningModeEager
+	}
+
+	return &ProvisioningService{
+		config:         cfg,
+		loyaltyService: loyaltyService,
+		mode:           mode,
+		maxAttempts:    cfg.SquareProvisioningMaxAttempts,
+		baseDelay:      30 * time.Second,
+		maxDelay:       30 * time.Minute,
+		jobs:           make(chan string, 1000),
+		stop:           make(chan struct{}),
+		retries:        make(map[string]*time.Timer),
+	}
+}
+
+// Start runs the background provisioning worker
+func (ps *ProvisioningService) Start() {
+	ps.wg.Add(1)
+	go ps.run()
+	log.Printf("Provisioning worker started (mode: %s)", ps.mode)
+}
+
+// Stop cancels scheduled retries and waits for the worker to finish its current job
+func (ps *ProvisioningService) Stop() {
+	ps.mu.Lock()
+	for userID, timer := range ps.retries {
+		timer.Stop()
+		delete(ps.retries, userID)
+	}
+	ps.mu.Unlock()
+
+	close(ps.stop)
+	ps.wg.Wait()
+	log.Println("Provisioning worker stopped")
+}
+
+// HandleSignup provisions a newly registered member according to the configured mode
+func (ps *ProvisioningService) HandleSignup(user *models.User) {
+	if !ps.loyaltyService.merchants.UsesSquare(user.MerchantID) {
+		user.Provisioning.Status = models.ProvisioningStatusNotRequired
+		return
+	}
+	if ps.loyaltyService.providerFor(user) == nil {
+		return // provisioned on demand once the merchant has connected Square
+	}
+
+	if ps.mode == ProvisioningModeLazy {
+		ps.Enqueue(user.ID)
+		return
+	}
+
+	if err := ps.loyaltyService.provisionSquareAccount(context.Background(), user); err != nil {
+		log.Printf("Provisioning failed at signup for user %s, will retry: %v", user.ID, err)
+		ps.scheduleRetry(user.ID, user.Provisioning.Attempts)
+	}
+}
+
+// Enqueue schedules a member for provisioning by the background worker
+func (ps *ProvisioningService) Enqueue(userID string) {
+	select {
+	case ps.jobs <- userID:
+	default:
+		// The member is still provisioned on demand by their next loyalty request
+		log.Printf("Provisioning queue full, dropping user %s", userID)
+	}
+}
+
+func (ps *ProvisioningService) run() {
+	defer ps.wg.Done()
+
+	for {
+		select {
+		case <-ps.stop:
+			return
+		case userID := <-ps.jobs:
+			ps.process(userID)
+		}
+	}
+}
+
+// process attempts provisioning once and schedules a retry on failure
+func (ps *ProvisioningService) process(userID string) {
+	user, err := ps.loyaltyService.getActiveUser(userID)
+	if err != nil {
+		log.Printf("Skipping provisioning for user %s: %v", userID, err)
+		return
+	}
+
+	if err := ps.loyaltyService.ensureSquareLoyaltyAccount(context.Background(), user); err != nil {
+		log.Printf("Provisioning attempt %d failed for user %s: %v", user.Provisioning.Attempts, userID, err)
+		ps.scheduleRetry(userID, user.Provisioning.Attempts)
+	}
+}
+
+// scheduleRetry re-queues a member after an exponential backoff, until maxAttempts is reached
+func (ps *ProvisioningService) scheduleRetry(userID string, attempts int) {
+	if attempts >= ps.maxAttempts {
+		log.Printf("Giving up provisioning user %s after %d attempts", userID, attempts)
+		return
+	}
+
+	// A negative shift would panic, so attempts are checked before shifting
+	delay := ps.maxDelay
+	if attempts >= 1 {
+		if shifted := ps.baseDelay << (attempts - 1); shifted > 0 && shifted < ps.maxDelay {
+			delay = shifted
+		}
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if timer, exists := ps.retries[userID]; exists {
+		timer.Stop()
+	}
+	ps.retries[userID] = time.AfterFunc(delay, func() {
+		ps.mu.Lock()
+		delete(ps.retries, userID)
+		ps.mu.Unlock()
+		ps.Enqueue(userID)
+	})
+}
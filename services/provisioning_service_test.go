@@ -0,0 +1,140 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+// nextProvisioningJob waits for the member the provisioning queue hands to its worker
+func nextProvisioningJob(t *testing.T, ps *ProvisioningService) string {
+	t.Helper()
+
+	select {
+	case userID := <-ps.jobs:
+		return userID
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing was queued for provisioning")
+		return ""
+	}
+}
+
+func TestSignupProvisionsSquareAccountSeparateFromLoyaltyID(t *testing.T) {
+	sc := newSquareScenario(t)
+	auth := NewAuthService(sc.cfg)
+	auth.OnSignup(NewProvisioningService(sc.cfg, sc.loyalty).HandleSignup)
+
+	resp, err := auth.SignupUser(models.SignupRequest{
+		Email:     fmt.Sprintf("auth-%d@example.com", authTestUsers.Add(1)),
+		Password:  testPassword,
+		FirstName: "Test",
+		LastName:  "Member",
+		Phone:     fmt.Sprintf("+1666%07d", authTestUsers.Add(1)),
+	})
+	if err != nil {
+		t.Fatalf("SignupUser: %v", err)
+	}
+	user := resp.User
+	t.Cleanup(func() {
+		if stored, err := storage.GetGlobalUserStorage().GetUserByID(user.ID); err == nil {
+			stored.SquareAccountID = ""
+			storage.GetGlobalUserStorage().UpdateUser(stored)
+		}
+	})
+
+	if !strings.HasPrefix(user.LoyaltyID, "LOY") || user.SquareAccountID == "" || user.LoyaltyID == user.SquareAccountID {
+		t.Fatalf("loyalty ID %q, Square account %q; want a local number and the Square account apart", user.LoyaltyID, user.SquareAccountID)
+	}
+	if _, found := sc.fake.Account(user.SquareAccountID); !found {
+		t.Errorf("Square account %s does not exist", user.SquareAccountID)
+	}
+
+	account, err := sc.loyalty.GetLoyaltyAccount(user.ID)
+	if err != nil {
+		t.Fatalf("GetLoyaltyAccount: %v", err)
+	}
+	if account.LoyaltyID != user.LoyaltyID || account.SquareAccountID != user.SquareAccountID || account.Provisioning.Status != models.ProvisioningStatusProvisioned || account.Provisioning.Attempts != 1 {
+		t.Errorf("account = %+v", account)
+	}
+}
+
+func TestLazyProvisioningQueuesSignup(t *testing.T) {
+	sc := newSquareScenario(t)
+	sc.cfg.SquareProvisioningMode = ProvisioningModeLazy
+	provisioning := NewProvisioningService(sc.cfg, sc.loyalty)
+	user := sc.newMember(t)
+	user.Provisioning.Status = models.ProvisioningStatusPending
+
+	provisioning.HandleSignup(user)
+	if user.SquareAccountID != "" || len(sc.fake.Accounts()) != 0 {
+		t.Fatalf("lazy mode provisioned at signup: account %q", user.SquareAccountID)
+	}
+	if account, _ := sc.loyalty.GetLoyaltyAccount(user.ID); account.Provisioning.Status != models.ProvisioningStatusPending {
+		t.Errorf("provisioning = %+v, want pending until the worker runs", account.Provisioning)
+	}
+
+	provisioning.process(nextProvisioningJob(t, provisioning))
+	if user.SquareAccountID == "" || user.Provisioning.Status != models.ProvisioningStatusProvisioned {
+		t.Errorf("after the worker: account %q, provisioning %+v", user.SquareAccountID, user.Provisioning)
+	}
+}
+
+func TestEagerProvisioningRetriesFailures(t *testing.T) {
+	sc := newSquareScenario(t)
+	provisioning := NewProvisioningService(sc.cfg, sc.loyalty)
+	provisioning.baseDelay = time.Millisecond
+	user := sc.newMember(t)
+	sc.fake.FailNext("search_accounts", http.StatusInternalServerError)
+
+	provisioning.HandleSignup(user)
+	if user.SquareAccountID != "" || user.Provisioning.Status != models.ProvisioningStatusFailed || user.Provisioning.LastError == "" {
+		t.Fatalf("provisioning = %+v, want the failed attempt recorded", user.Provisioning)
+	}
+
+	// The retry is queued after the backoff and succeeds
+	provisioning.process(nextProvisioningJob(t, provisioning))
+	if user.SquareAccountID == "" || user.Provisioning.Status != models.ProvisioningStatusProvisioned || user.Provisioning.Attempts != 2 {
+		t.Errorf("after the retry: account %q, provisioning %+v", user.SquareAccountID, user.Provisioning)
+	}
+}
+
+func TestProvisioningRetryLimit(t *testing.T) {
+	sc := newSquareScenario(t)
+	provisioning := NewProvisioningService(sc.cfg, sc.loyalty)
+	provisioning.baseDelay = time.Hour
+	provisioning.maxDelay = time.Hour
+	t.Cleanup(provisioning.Stop)
+
+	provisioning.scheduleRetry("given-up", sc.cfg.SquareProvisioningMaxAttempts)
+	provisioning.scheduleRetry("never-attempted", 0)
+	provisioning.scheduleRetry("retried", 2)
+
+	provisioning.mu.Lock()
+	defer provisioning.mu.Unlock()
+	if _, scheduled := provisioning.retries["given-up"]; scheduled {
+		t.Error("a retry was scheduled after the last attempt")
+	}
+	for _, userID := range []string{"never-attempted", "retried"} {
+		if _, scheduled := provisioning.retries[userID]; !scheduled {
+			t.Errorf("no retry scheduled for %s", userID)
+		}
+	}
+}
+
+func TestProvisioningNotRequiredWithoutSquare(t *testing.T) {
+	cfg := config.Defaults()
+	loyalty := NewLoyaltyServiceWithProvider(cfg, nil)
+	provisioning := NewProvisioningService(cfg, loyalty)
+	user := &models.User{ID: "local-only", MerchantID: models.DefaultMerchantID, Provisioning: models.SquareProvisioning{Status: models.ProvisioningStatusPending}}
+
+	provisioning.HandleSignup(user)
+	if user.Provisioning.Status != models.ProvisioningStatusNotRequired {
+		t.Errorf("provisioning status %q, want %q", user.Provisioning.Status, models.ProvisioningStatusNotRequired)
+	}
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+
+	square "github.com/square/square-go-sdk"
+)
+
+// reconciliationReason starts the reason of every balance correction posted to Square. Square
+// keeps the reason with the event, so corrections are recognized in later runs, also after a
+// restart, and are not reported as events missing from the local ledger.
+const reconciliationReason = "Balance reconciliation"
+
+// ReconciliationService periodically compares each member's local balance and ledger with their
+// Square loyalty account, reports the mismatches and, in auto-correct mode, posts corrections
+type ReconciliationService struct {
+	config         *config.Config
+	userStorage    *storage.UserStorage
+	transactions   *storage.TransactionStorage
+	outbox         *storage.OutboxStorage
+	reports        *storage.ReconciliationStorage
+	loyaltyService *LoyaltyService
+	interval       time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex // one run at a time
+}
+
+func NewReconciliationService(cfg *config.Config, loyaltyService *LoyaltyService) *ReconciliationService {
+	return &ReconciliationService{
+		config:         cfg,
+		userStorage:    storage.GetGlobalUserStorage(),
+		transactions:   storage.GetGlobalTransactionStorage(),
+		outbox:         storage.GetGlobalOutboxStorage(),
+		reports:        storage.GetGlobalReconciliationStorage(),
+		loyaltyService: loyaltyService,
+		interval:       time.Duration(cfg.ReconciliationIntervalMinutes) * time.Minute,
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start runs the periodic reconciliation worker. It does nothing when the interval is not
+// positive or Square is not available.
+func (r *ReconciliationService) Start() {
+	if r.interval <= 0 || !r.loyaltyService.merchants.SquareEnabled() {
+		log.Println("Reconciliation worker disabled")
+		return
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	log.Printf("Reconciliation worker started (interval: %s, mode: %s)", r.interval, r.config.ReconciliationMode)
+}
+
+// Stop waits for an in-progress run to finish and stops the worker
+func (r *ReconciliationService) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// ListReports returns all reconciliation reports, newest first
+func (r *ReconciliationService) ListReports() []models.ReconciliationReport {
+	return r.reports.ListReports()
+}
+
+// GetReport returns a single reconciliation report
+func (r *ReconciliationService) GetReport(reportID string) (*models.ReconciliationReport, error) {
+	return r.reports.GetReport(reportID)
+}
+
+func (r *ReconciliationService) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			log.Printf("Scheduled reconciliation (%s) started", r.config.ReconciliationMode)
+			if _, err := r.Run(context.Background(), r.config.ReconciliationMode); err != nil {
+				log.Printf("Reconciliation run failed: %v", err)
+			}
+		}
+	}
+}
+
+// Run reconciles every provisioned member of a connected merchant and stores the report. It does
+// not run while every Square connection is unhealthy, since every member would fail.
+func (r *ReconciliationService) Run(ctx context.Context, mode string) (*models.ReconciliationReport, error) {
+	if mode == "" {
+		mode = models.ReconciliationModeDryRun
+	}
+	if mode != models.ReconciliationModeDryRun && mode != models.ReconciliationModeAutoCorrect {
+		return nil, errors.New("mode must be dry_run or auto_correct")
+	}
+	providers := r.loyaltyService.merchants.Providers()
+	if len(providers) == 0 {
+		return nil, errors.New("Square service is not available")
+	}
+	available := false
+	for _, provider := range providers {
+		available = available || provider.Available()
+	}
+	if !available {
+		return nil, ErrSquareUnavailable
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := models.ReconciliationReport{
+		ID:         r.loyaltyService.generateID(),
+		Mode:       mode,
+		StartedAt:  time.Now(),
+		Mismatches: []models.ReconciliationMismatch{},
+	}
+
+	users := r.userStorage.GetAllUsers()
+	userIDs := make([]string, 0, len(users))
+	for userID := range users {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	for _, userID := range userIDs {
+		if err := ctx.Err(); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("run interrupted: %v", err))
+			break
+		}
+
+		user := users[userID]
+		if user.SquareAccountID == "" || user.Status == models.AccountStatusClosed {
+			continue
+		}
+
+		mismatches, skipped, err := r.reconcileUser(ctx, user, &report)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("user %s: %v", user.ID, err))
+			continue
+		}
+		if skipped {
+			report.UsersSkipped++
+			continue
+		}
+
+		report.UsersChecked++
+		report.Mismatches = append(report.Mismatches, mismatches...)
+	}
+
+	for _, mismatch := range report.Mismatches {
+		if mismatch.Corrected {
+			report.Corrections++
+		}
+	}
+	report.CompletedAt = time.Now()
+
+	r.reports.AddReport(report)
+	r.writeReportFile(report)
+	log.Printf("Reconciliation %s (%s): %d checked, %d skipped, %d mismatches, %d corrected, %d errors",
+		report.ID, report.Mode, report.UsersChecked, report.UsersSkipped, len(report.Mismatches), report.Corrections, len(report.Errors))
+
+	return &report, nil
+}
+
+// reconcileUser compares one member with their merchant's Square account. Members with Square
+// writes still in flight are skipped, since Square's balance lags the local ledger until the
+// outbox delivers them, and so are members whose merchant is not connected.
+func (r *ReconciliationService) reconcileUser(ctx context.Context, user *models.User, report *models.ReconciliationReport) ([]models.ReconciliationMismatch, bool, error) {
+	squareService := r.loyaltyService.providerFor(user)
+	if squareService == nil || r.hasPendingWrites(user.ID) {
+		return nil, true, nil
+	}
+	if !squareService.Available() {
+		return nil, false, ErrSquareUnavailable
+	}
+	ledgerSize := len(r.transactions.GetTransactionsByUserID(user.MerchantID, user.ID))
+
+	account, err := squareService.GetLoyaltyAccount(ctx, user.SquareAccountID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get Square account: %w", err)
+	}
+	events, err := r.loyaltyService.searchAllLoyaltyEvents(ctx, squareService, user.SquareAccountID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to search Square events: %w", err)
+	}
+
+	squareBalance := 0
+	if account.Balance != nil {
+		squareBalance = *account.Balance
+	}
+
+	mismatches, skipped := r.compareUser(user, ledgerSize, squareBalance, events, report)
+	if skipped {
+		return nil, true, nil
+	}
+
+	return mismatches, false, nil
+}
+
+// compareUser compares a member's ledger and balance with Square's events and balance under the
+// ledger lock and, in auto-correct mode, imports missing Square events and queues the balance
+// correction. Members whose ledger changed while Square was being read are skipped.
+func (r *ReconciliationService) compareUser(user *models.User, ledgerSize, squareBalance int, events []*square.LoyaltyEvent, report *models.ReconciliationReport) ([]models.ReconciliationMismatch, bool) {
+	r.loyaltyService.ledgerMu.Lock()
+	defer r.loyaltyService.ledgerMu.Unlock()
+
+	// The ledger must not have changed while Square was being read
+	if r.hasPendingWrites(user.ID) || len(r.transactions.GetTransactionsByUserID(user.MerchantID, user.ID)) != ledgerSize {
+		return nil, true
+	}
+
+	mismatches := []models.ReconciliationMismatch{}
+	newMismatch := func(mismatchType string) models.ReconciliationMismatch {
+		return models.ReconciliationMismatch{
+			UserID:          user.ID,
+			SquareAccountID: user.SquareAccountID,
+			Type:            mismatchType,
+			LocalBalance:    user.Points,
+			SquareBalance:   squareBalance,
+		}
+	}
+
+	// Square events the local ledger does not know about
+	remoteEventIDs := map[string]bool{}
+	for _, event := range events {
+		transaction := r.loyaltyService.convertSquareEventToTransaction(event, user)
+		if transaction == nil {
+			continue
+		}
+		remoteEventIDs[event.ID] = true
+
+		// Earlier corrections are not in the ledger by design, and the promotion points of an
+		// order are part of the order's earn transaction
+		if _, found := r.transactions.GetTransactionBySquareEventID(user.MerchantID, user.ID, event.ID); found || isReconciliationCorrection(event) || r.isOrderPromotion(user, event) {
+			continue
+		}
+
+		mismatch := newMismatch(models.MismatchMissingLocalEvent)
+		mismatch.SquareEventID = event.ID
+		mismatch.Points = transaction.SignedPoints()
+		mismatch.Detail = fmt.Sprintf("Square %s event is not in the local ledger", event.Type)
+
+		if report.Mode == models.ReconciliationModeAutoCorrect {
+			r.importEvent(user, event, transaction, &mismatch)
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+
+	// Local transactions Square does not know about
+	deadWrites := false
+	for _, item := range r.outbox.ListItems(models.OutboxStatusDead) {
+		if item.UserID != user.ID {
+			continue
+		}
+		deadWrites = true
+
+		mismatch := newMismatch(models.MismatchMissingRemoteEvent)
+		mismatch.TransactionID = item.TransactionID
+		mismatch.Points = item.Points
+		mismatch.Detail = fmt.Sprintf("Square write failed permanently (outbox item %s), replay it from the admin API", item.ID)
+		mismatches = append(mismatches, mismatch)
+	}
+	for _, transaction := range r.transactions.GetTransactionsByUserID(user.MerchantID, user.ID) {
+		if transaction.SquareEventID == "" || remoteEventIDs[transaction.SquareEventID] {
+			continue
+		}
+
+		mismatch := newMismatch(models.MismatchMissingRemoteEvent)
+		mismatch.TransactionID = transaction.ID
+		mismatch.SquareEventID = transaction.SquareEventID
+		mismatch.Points = transaction.SignedPoints()
+		mismatch.Detail = "linked Square event was not found in the account's event history"
+		mismatches = append(mismatches, mismatch)
+	}
+
+	// Balance drift, after any imported events
+	if drift := user.Points - squareBalance; drift != 0 {
+		mismatch := newMismatch(models.MismatchAmountDrift)
+		mismatch.Points = drift
+		mismatch.Detail = fmt.Sprintf("local balance %d differs from Square balance %d", user.Points, squareBalance)
+
+		if report.Mode == models.ReconciliationModeAutoCorrect {
+			if deadWrites {
+				mismatch.CorrectionError = "not corrected while dead outbox items are waiting to be replayed"
+			} else {
+				r.queueCorrection(user, report.ID, &mismatch)
+			}
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+
+	return mismatches, false
+}
+
+// importEvent records a Square event missing from the local ledger. Events created through the
+// Loyalty API are this application's own writes, whose local transaction already exists, so
+// they are left to the balance adjustment instead.
+func (r *ReconciliationService) importEvent(user *models.User, event *square.LoyaltyEvent, transaction *models.Transaction, mismatch *models.ReconciliationMismatch) {
+	if event.Source == square.LoyaltyEventSourceLoyaltyAPI {
+		mismatch.CorrectionError = "event was created by this application and is not imported"
+		return
+	}
+
+	transaction.ID = r.loyaltyService.generateID()
+	transaction.SquareEventID = event.ID
+	recorded, err := r.loyaltyService.recordTransactionLocked(user, *transaction, true)
+	if err != nil {
+		mismatch.CorrectionError = err.Error()
+		return
+	}
+
+	mismatch.Corrected = true
+	r.loyaltyService.audit.Record(models.SystemActor(models.AuditActorReconciliation, user.MerchantID), models.AuditActionSquareEventImport,
+		models.AuditTargetTransaction, recorded.ID, nil, recorded)
+	log.Printf("Reconciliation imported Square event %s for user %s", event.ID, user.ID)
+}
+
+// queueCorrection queues the Square adjustment that brings Square's balance in line with the
+// local balance, which is the source of truth. It is computed and queued under the ledger lock,
+// so writes recorded afterwards are queued behind it and the drift cannot go stale; the outbox
+// delivers it like any other write.
+func (r *ReconciliationService) queueCorrection(user *models.User, reportID string, mismatch *models.ReconciliationMismatch) {
+	item := r.loyaltyService.enqueueLocked(user, models.OutboxItem{
+		Operation:      models.OutboxOperationAdjustPoints,
+		Points:         mismatch.Points,
+		Reason:         reconciliationReason + " (report " + reportID + ")",
+		IdempotencyKey: "reconcile-" + reportID + "-" + user.ID,
+	})
+
+	mismatch.OutboxItemID = item.ID
+	mismatch.Corrected = true
+	r.loyaltyService.audit.Record(models.SystemActor(models.AuditActorReconciliation, user.MerchantID), models.AuditActionReconciliationCorrection,
+		models.AuditTargetMember, user.ID, nil, mismatch)
+	log.Printf("Reconciliation queued a Square adjustment of %d points for user %s", mismatch.Points, user.ID)
+}
+
+// isReconciliationCorrection reports whether a Square event is a balance correction queued by
+// queueCorrection
+func isReconciliationCorrection(event *square.LoyaltyEvent) bool {
+	return event.Type == square.LoyaltyEventTypeAdjustPoints && event.Source == square.LoyaltyEventSourceLoyaltyAPI &&
+		event.AdjustPoints != nil && event.AdjustPoints.Reason != nil && strings.HasPrefix(*event.AdjustPoints.Reason, reconciliationReason)
+}
+
+// isOrderPromotion reports whether a Square event holds the promotion points this application
+// accumulated for an order of the member. The order's earn transaction includes them, and is
+// linked to the order's ACCUMULATE_POINTS event.
+func (r *ReconciliationService) isOrderPromotion(user *models.User, event *square.LoyaltyEvent) bool {
+	if event.Type != square.LoyaltyEventTypeAccumulatePromotionPoints || event.Source != square.LoyaltyEventSourceLoyaltyAPI ||
+		event.AccumulatePromotionPoints == nil || event.AccumulatePromotionPoints.OrderID == "" {
+		return false
+	}
+
+	transaction, found := r.transactions.GetEarnTransactionByOrderID(user.MerchantID, event.AccumulatePromotionPoints.OrderID)
+	return found && transaction.UserID == user.ID
+}
+
+// hasPendingWrites reports whether the outbox still has writes on their way to Square for a user
+func (r *ReconciliationService) hasPendingWrites(userID string) bool {
+	for _, item := range r.outbox.ListItems(models.OutboxStatusPending) {
+		if item.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// writeReportFile saves the report as JSON when a report directory is configured
+func (r *ReconciliationService) writeReportFile(report models.ReconciliationReport) {
+	if r.config.ReconciliationReportDir == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode reconciliation report %s: %v", report.ID, err)
+		return
+	}
+
+	name := fmt.Sprintf("reconciliation-%s-%s.json", report.StartedAt.UTC().Format("20060102T150405Z"), report.ID)
+	if err := os.MkdirAll(r.config.ReconciliationReportDir, 0o755); err != nil {
+		log.Printf("Failed to create reconciliation report directory: %v", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(r.config.ReconciliationReportDir, name), data, 0o644); err != nil {
+		log.Printf("Failed to write reconciliation report %s: %v", report.ID, err)
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+func TestReconciliationCorrectionIsNotReportedAgain(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+	user.Points = 50 // drift: the local balance is the source of truth
+
+	report, err := NewReconciliationService(sc.cfg, sc.loyalty).Run(context.Background(), models.ReconciliationModeAutoCorrect)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].Type != models.MismatchAmountDrift || !report.Mismatches[0].Corrected {
+		t.Fatalf("mismatches = %+v, want one corrected amount drift", report.Mismatches)
+	}
+	item, err := storage.GetGlobalOutboxStorage().GetItem(report.Mismatches[0].OutboxItemID)
+	if err != nil {
+		t.Fatalf("correction outbox item: %v", err)
+	}
+	if item.Operation != models.OutboxOperationAdjustPoints || item.Points != 50 || item.TransactionID != "" {
+		t.Fatalf("correction item = %+v, want an adjustment of 50 points", item)
+	}
+
+	outbox.deliverDue(nil)
+	if balance := sc.squareBalance(t, user); balance != 50 {
+		t.Fatalf("Square balance = %d after the correction, want 50", balance)
+	}
+
+	// A new service, as after a restart, recognizes the correction by its reason
+	report, err = NewReconciliationService(sc.cfg, sc.loyalty).Run(context.Background(), models.ReconciliationModeDryRun)
+	if err != nil {
+		t.Fatalf("Run after restart: %v", err)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Fatalf("mismatches after the correction = %+v, want none", report.Mismatches)
+	}
+}
+
+func TestReconciliationCorrectionIsQueuedBeforeLaterWrites(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+	user.Points = 30
+
+	if _, err := NewReconciliationService(sc.cfg, sc.loyalty).Run(context.Background(), models.ReconciliationModeAutoCorrect); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// An earn recorded after the run is delivered on top of the correction, not folded into it
+	if _, err := sc.loyalty.EarnPoints(context.Background(), user.ID, 20, "After the run", ""); err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+	outbox.deliverDue(nil)
+	if balance := sc.squareBalance(t, user); balance != user.Points || balance != 50 {
+		t.Fatalf("Square balance = %d, local %d, want both 50", balance, user.Points)
+	}
+}
+
+func TestReconciliationSkipsMembersWithPendingWrites(t *testing.T) {
+	sc := newSquareScenario(t)
+	user := sc.provisionedMember(t)
+
+	// Not delivered yet
+	if _, err := sc.loyalty.EarnPoints(context.Background(), user.ID, 10, "Pending", ""); err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+
+	report, err := NewReconciliationService(sc.cfg, sc.loyalty).Run(context.Background(), models.ReconciliationModeAutoCorrect)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	for _, mismatch := range report.Mismatches {
+		if mismatch.UserID == user.ID {
+			t.Fatalf("member with a pending write was reconciled: %+v", mismatch)
+		}
+	}
+}
+
+func TestReconciliationImportsPOSEvents(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	event, err := sc.fake.RecordPOSEvent(user.SquareAccountID, 40)
+	if err != nil {
+		t.Fatalf("RecordPOSEvent: %v", err)
+	}
+
+	report, err := NewReconciliationService(sc.cfg, sc.loyalty).Run(context.Background(), models.ReconciliationModeAutoCorrect)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].Type != models.MismatchMissingLocalEvent || !report.Mismatches[0].Corrected {
+		t.Fatalf("mismatches = %+v, want the imported POS event only", report.Mismatches)
+	}
+	if _, found := storage.GetGlobalTransactionStorage().GetTransactionBySquareEventID(user.MerchantID, user.ID, event.ID); !found || user.Points != 40 {
+		t.Fatalf("POS event imported = %v, balance %d, want 40", found, user.Points)
+	}
+	for _, item := range outbox.ListItems(models.OutboxStatusPending) {
+		if item.UserID == user.ID {
+			t.Fatalf("import queued a Square write: %+v", item)
+		}
+	}
+}
+
+func TestReconciliationMatchesOrderPromotionPoints(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	for orderID, order := range map[string]struct{ cents, promotion int }{
+		"promo-" + user.ID:      {1250, 5},
+		"promo-only-" + user.ID: {50, 3}, // under a dollar: promotion points only
+	} {
+		sc.fake.AddOrder(orderID, order.cents)
+		sc.fake.AddOrderPromotion(orderID, order.promotion)
+		if _, err := sc.loyalty.EarnPointsForOrder(context.Background(), user.ID, orderID, "", ""); err != nil {
+			t.Fatalf("EarnPointsForOrder(%s): %v", orderID, err)
+		}
+	}
+	outbox.deliverDue(nil)
+
+	if user.Points != 20 || sc.squareBalance(t, user) != 20 {
+		t.Fatalf("local balance %d, Square balance %d, want both 20", user.Points, sc.squareBalance(t, user))
+	}
+	for _, transaction := range storage.GetGlobalTransactionStorage().GetTransactionsByUserID(user.MerchantID, user.ID) {
+		if transaction.SquareEventID == "" {
+			t.Errorf("transaction %s for order %s is not linked to a Square event", transaction.ID, transaction.OrderID)
+		}
+	}
+
+	report, err := NewReconciliationService(sc.cfg, sc.loyalty).Run(context.Background(), models.ReconciliationModeDryRun)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	for _, mismatch := range report.Mismatches {
+		if mismatch.UserID == user.ID {
+			t.Errorf("mismatch %+v, want the promotion events matched to their orders", mismatch)
+		}
+	}
+}
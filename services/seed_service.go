@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+// DemoMember is the member created by the demo data of the development profile
+var DemoMember = models.FixtureMember{
+	Email:     "demo@loyalty.com",
+	Password:  "LoyaltyDemo24",
+	FirstName: "Demo",
+	LastName:  "User",
+	Phone:     "+15555550100",
+}
+
+// SeedService loads fixtures through the regular services, so seeded data passes the same checks
+// as live traffic: password policy, balances and location rules
+type SeedService struct {
+	config         *config.Config
+	authService    *AuthService
+	loyaltyService *LoyaltyService
+	programService *ProgramService
+	userStorage    *storage.UserStorage
+}
+
+func NewSeedService(cfg *config.Config, authService *AuthService, loyaltyService *LoyaltyService, programService *ProgramService) *SeedService {
+	return &SeedService{
+		config:         cfg,
+		authService:    authService,
+		loyaltyService: loyaltyService,
+		programService: programService,
+		userStorage:    storage.GetGlobalUserStorage(),
+	}
+}
+
+// Seed applies fixtures: reward tiers first, then members, then transactions in order. Members
+// that already exist are skipped along with their transactions, so seeding the same fixtures
+// again changes nothing; any other failure stops the run.
+func (ss *SeedService) Seed(ctx context.Context, fixtures models.Fixtures) (*models.SeedReport, error) {
+	report := &models.SeedReport{}
+
+	tiers := map[string][]models.ProgramRewardTier{}
+	for i, reward := range fixtures.Rewards {
+		if reward.ID == "" || reward.Name == "" || reward.Points <= 0 {
+			return report, fmt.Errorf("reward %d: id, name and positive points are required", i+1)
+		}
+		merchantID := models.MerchantIDOrDefault(reward.MerchantID)
+		tiers[merchantID] = append(tiers[merchantID], models.ProgramRewardTier{ID: reward.ID, Name: reward.Name, Points: reward.Points})
+	}
+	for merchantID, merchantTiers := range tiers {
+		ss.programService.AddRewardTiers(merchantID, merchantTiers)
+		report.Rewards += len(merchantTiers)
+	}
+
+	existing := map[string]bool{} // merchantID/email of skipped members
+	for _, member := range fixtures.Members {
+		response, err := ss.authService.SignupUser(models.SignupRequest{
+			Email:      member.Email,
+			Password:   member.Password,
+			FirstName:  member.FirstName,
+			LastName:   member.LastName,
+			Phone:      member.Phone,
+			MerchantID: member.MerchantID,
+		})
+		if err != nil {
+			if err.Error() == "user already exists" {
+				report.Skipped = append(report.Skipped, member.Email)
+				existing[fixtureMemberKey(member.MerchantID, member.Email)] = true
+				continue
+			}
+			return report, fmt.Errorf("member %s: %w", member.Email, err)
+		}
+		ss.loyaltyService.audit.Record(models.SystemActor(models.AuditActorSeed, response.User.MerchantID), models.AuditActionSignup,
+			models.AuditTargetMember, response.User.ID, nil, response.User)
+		report.Members++
+	}
+
+	for i, fixture := range fixtures.Transactions {
+		if existing[fixtureMemberKey(fixture.MerchantID, fixture.Member)] {
+			continue
+		}
+		transaction, err := ss.seedTransaction(ctx, fixture)
+		if err != nil {
+			return report, fmt.Errorf("transaction %d (%s): %w", i+1, fixture.Member, err)
+		}
+		action := models.AuditActionEarn
+		if transaction.Type == models.TransactionTypeRedeem {
+			action = models.AuditActionRedeem
+		}
+		ss.loyaltyService.audit.Record(models.SystemActor(models.AuditActorSeed, transaction.MerchantID), action,
+			models.AuditTargetTransaction, transaction.ID, nil, transaction)
+		report.Transactions++
+	}
+
+	log.Printf("Seeded %d rewards, %d members and %d transactions", report.Rewards, report.Members, report.Transactions)
+	return report, nil
+}
+
+// fixtureMemberKey identifies a fixture member across the members and transactions of a file
+func fixtureMemberKey(merchantID, email string) string {
+	return models.MerchantIDOrDefault(merchantID) + "/" + strings.ToLower(email)
+}
+
+func (ss *SeedService) seedTransaction(ctx context.Context, fixture models.FixtureTransaction) (*models.Transaction, error) {
+	merchantID := models.MerchantIDOrDefault(fixture.MerchantID)
+	user, err := ss.userStorage.GetUserByEmail(merchantID, fixture.Member)
+	if err != nil {
+		return nil, errors.New("member not found")
+	}
+
+	switch fixture.Type {
+	case "earn":
+		if fixture.OrderID != "" {
+			return ss.loyaltyService.EarnPointsForOrder(ctx, user.ID, fixture.OrderID, fixture.Description, fixture.LocationID)
+		}
+		if fixture.Points > 0 {
+			return ss.loyaltyService.EarnPoints(ctx, user.ID, fixture.Points, fixture.Description, fixture.LocationID)
+		}
+		return nil, errors.New("earn needs positive points or an orderId")
+	case "redeem":
+		points, description := fixture.Points, fixture.Description
+		if fixture.Reward != "" {
+			tier, err := ss.programService.RewardTier(ctx, merchantID, fixture.Reward)
+			if err != nil {
+				return nil, fmt.Errorf("reward %q: %w", fixture.Reward, err)
+			}
+			if points == 0 {
+				points = tier.Points
+			}
+			if description == "" {
+				description = "Redeemed " + tier.Name
+			}
+		}
+		if points <= 0 {
+			return nil, errors.New("redeem needs positive points or a reward")
+		}
+		return ss.loyaltyService.RedeemPoints(ctx, user.ID, points, description, fixture.LocationID, fixture.Reward)
+	default:
+		return nil, fmt.Errorf("unknown transaction type %q, expected earn or redeem", fixture.Type)
+	}
+}
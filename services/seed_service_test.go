@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+)
+
+func newTestSeedService() *SeedService {
+	cfg := config.Defaults()
+	loyalty := NewLoyaltyServiceWithProvider(cfg, nil)
+	return NewSeedService(cfg, NewAuthService(cfg), loyalty, NewProgramService(cfg, loyalty))
+}
+
+// seedFixtures is a member who earns points and redeems a reward with them
+func seedFixtures(email string) models.Fixtures {
+	return models.Fixtures{
+		Rewards: []models.FixtureReward{{ID: "seed-coffee", Name: "Free coffee", Points: 100}},
+		Members: []models.FixtureMember{{Email: email, Password: testPassword, FirstName: "Seed", LastName: "Member"}},
+		Transactions: []models.FixtureTransaction{
+			{Member: email, Type: "earn", Points: 250, Description: "Opening bonus"},
+			{Member: email, Type: "redeem", Reward: "seed-coffee"},
+		},
+	}
+}
+
+func TestSeedIsIdempotent(t *testing.T) {
+	seeds := newTestSeedService()
+	email := fmt.Sprintf("seed-%d@example.com", scenarioMembers.Add(1))
+	ctx := context.Background()
+
+	report, err := seeds.Seed(ctx, seedFixtures(email))
+	if err != nil {
+		t.Fatalf("Seed: %v", err)
+	}
+	if report.Rewards != 1 || report.Members != 1 || report.Transactions != 2 || len(report.Skipped) != 0 {
+		t.Fatalf("report = %+v, want 1 reward, 1 member and 2 transactions", report)
+	}
+	user, err := storage.GetGlobalUserStorage().GetUserByEmail(models.DefaultMerchantID, email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	transactions := storage.GetGlobalTransactionStorage().GetTransactionsByUserID(user.MerchantID, user.ID)
+	if user.Points != 150 || len(transactions) != 2 {
+		t.Fatalf("member has %d points and %d transactions, want 150 and 2", user.Points, len(transactions))
+	}
+	for _, transaction := range transactions {
+		if transaction.Type == models.TransactionTypeRedeem && (transaction.Points != 100 || transaction.Description != "Redeemed Free coffee") {
+			t.Errorf("redemption = %+v, want the reward's 100 points", transaction)
+		}
+	}
+
+	// Seeding the same fixtures again leaves the member as it was
+	report, err = seeds.Seed(ctx, seedFixtures(email))
+	if err != nil {
+		t.Fatalf("Seed again: %v", err)
+	}
+	if report.Members != 0 || report.Transactions != 0 || len(report.Skipped) != 1 || report.Skipped[0] != email {
+		t.Fatalf("second report = %+v, want the member and its transactions skipped", report)
+	}
+	if transactions := storage.GetGlobalTransactionStorage().GetTransactionsByUserID(user.MerchantID, user.ID); user.Points != 150 || len(transactions) != 2 {
+		t.Fatalf("after a second seed the member has %d points and %d transactions, want 150 and 2", user.Points, len(transactions))
+	}
+}
+
+func TestSeedStopsAtInvalidFixtures(t *testing.T) {
+	seeds := newTestSeedService()
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name   string
+		change func(*models.Fixtures)
+		want   string
+	}{
+		{"reward without points", func(f *models.Fixtures) { f.Rewards[0].Points = 0 }, "reward 1"},
+		{"weak password", func(f *models.Fixtures) { f.Members[0].Password = "password" }, "member "},
+		{"unknown member", func(f *models.Fixtures) { f.Transactions[0].Member = "nobody@example.com" }, "member not found"},
+		{"unknown reward", func(f *models.Fixtures) { f.Transactions[1].Reward = "seed-yacht" }, "unknown reward tier"},
+		{"unknown type", func(f *models.Fixtures) { f.Transactions[0].Type = "gift" }, "unknown transaction type"},
+		{"redeem beyond the balance", func(f *models.Fixtures) { f.Transactions[0].Points = 50 }, "transaction 2"},
+	} {
+		fixtures := seedFixtures(fmt.Sprintf("seed-%d@example.com", scenarioMembers.Add(1)))
+		tc.change(&fixtures)
+		if _, err := seeds.Seed(ctx, fixtures); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Seed = %v, want an error about %q", tc.name, err, tc.want)
+		}
+	}
+}
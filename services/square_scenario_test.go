@@ -0,0 +1,423 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/squarefake"
+	"loyalty-core/storage"
+)
+
+// squareScenario is a loyalty service whose default merchant is connected to its own squarefake
+// server. Storage is global, so members are created with unique IDs and detached from the fake
+// when the test ends; the fake numbers its accounts from 1 like every other fake.
+type squareScenario struct {
+	fake    *squarefake.Server
+	cfg     *config.Config
+	square  *SquareService
+	loyalty *LoyaltyService
+}
+
+var scenarioMembers atomic.Int64
+
+func newSquareScenario(t *testing.T) *squareScenario {
+	t.Helper()
+
+	fake := squarefake.NewServer()
+	server := fake.Start()
+	t.Cleanup(server.Close)
+
+	cfg := config.Defaults()
+	cfg.SquareBaseURL = server.URL
+	cfg.SquareAccessToken = "fake-token"
+	cfg.SquareLocationID = "fake-location"
+	cfg.SquareTimeoutSeconds = 2
+	cfg.SquareMaxRetries = 0
+	cfg.SquareBreakerFailureThreshold = 5
+	cfg.SquareBreakerCooldownSeconds = 60
+	cfg.OutboxMaxAttempts = 3
+
+	squareService, err := NewSquareService(cfg)
+	if err != nil {
+		t.Fatalf("NewSquareService: %v", err)
+	}
+
+	return &squareScenario{
+		fake:    fake,
+		cfg:     cfg,
+		square:  squareService,
+		loyalty: NewLoyaltyServiceWithProvider(cfg, squareService),
+	}
+}
+
+// newMember stores an active member of the default merchant with a unique email and phone number
+func (sc *squareScenario) newMember(t *testing.T) *models.User {
+	t.Helper()
+
+	n := scenarioMembers.Add(1)
+	now := time.Now()
+	user := &models.User{
+		ID:         fmt.Sprintf("scenario-user-%d", n),
+		MerchantID: models.DefaultMerchantID,
+		Email:      fmt.Sprintf("scenario-%d@example.com", n),
+		FirstName:  "Scenario",
+		LastName:   "Member",
+		Phone:      fmt.Sprintf("+1555%07d", n),
+		LoyaltyID:  fmt.Sprintf("SC%08d", n),
+		Role:       models.RoleMember,
+		Status:     models.AccountStatusActive,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := storage.GetGlobalUserStorage().CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	t.Cleanup(func() {
+		// Square account IDs are only unique within one fake, and undelivered writes must not
+		// reach the next test's fake
+		user.SquareAccountID = ""
+		storage.GetGlobalUserStorage().UpdateUser(user)
+		outbox := storage.GetGlobalOutboxStorage()
+		for _, item := range outbox.ListItems(models.OutboxStatusPending) {
+			if item.UserID == user.ID {
+				item.Status = models.OutboxStatusDead
+				outbox.UpdateItem(item)
+			}
+		}
+	})
+	return user
+}
+
+// provisionedMember is newMember with a Square loyalty account
+func (sc *squareScenario) provisionedMember(t *testing.T) *models.User {
+	t.Helper()
+
+	user := sc.newMember(t)
+	if _, err := sc.loyalty.ProvisionAccount(context.Background(), user.ID); err != nil {
+		t.Fatalf("ProvisionAccount: %v", err)
+	}
+	return user
+}
+
+// outboxItemFor returns the outbox item mirroring a transaction
+func outboxItemFor(t *testing.T, transactionID string) models.OutboxItem {
+	t.Helper()
+
+	for _, item := range storage.GetGlobalOutboxStorage().ListItems("") {
+		if item.TransactionID == transactionID {
+			return item
+		}
+	}
+	t.Fatalf("no outbox item for transaction %s", transactionID)
+	return models.OutboxItem{}
+}
+
+// makeDue moves an item's next attempt to now, skipping its backoff
+func makeDue(t *testing.T, itemID string) {
+	t.Helper()
+
+	outbox := storage.GetGlobalOutboxStorage()
+	item, err := outbox.GetItem(itemID)
+	if err != nil {
+		t.Fatalf("GetItem: %v", err)
+	}
+	item.NextAttemptAt = time.Now()
+	if err := outbox.UpdateItem(*item); err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+}
+
+func (sc *squareScenario) squareBalance(t *testing.T, user *models.User) int {
+	t.Helper()
+
+	account, found := sc.fake.Account(user.SquareAccountID)
+	if !found {
+		t.Fatalf("Square account %q not found", user.SquareAccountID)
+	}
+	return account.Balance
+}
+
+func TestSquareProvisioningCreatesAccount(t *testing.T) {
+	sc := newSquareScenario(t)
+	provisioning := NewProvisioningService(sc.cfg, sc.loyalty)
+	user := sc.newMember(t)
+
+	provisioning.HandleSignup(user)
+
+	if user.Provisioning.Status != models.ProvisioningStatusProvisioned || user.SquareAccountID == "" {
+		t.Fatalf("provisioning = %+v, account %q; want provisioned", user.Provisioning, user.SquareAccountID)
+	}
+	account, found := sc.fake.Account(user.SquareAccountID)
+	if !found || account.PhoneNumber != user.Phone {
+		t.Fatalf("Square account = %+v, found %t; want one for %s", account, found, user.Phone)
+	}
+}
+
+func TestSquareProvisioningLinksExistingAccount(t *testing.T) {
+	sc := newSquareScenario(t)
+	provisioning := NewProvisioningService(sc.cfg, sc.loyalty)
+	user := sc.newMember(t)
+	existing := sc.fake.SeedAccount(user.Phone, 40)
+
+	provisioning.HandleSignup(user)
+
+	if user.SquareAccountID != existing.ID {
+		t.Fatalf("linked account %q, want the POS account %q", user.SquareAccountID, existing.ID)
+	}
+	if accounts := sc.fake.Accounts(); len(accounts) != 1 {
+		t.Fatalf("fake has %d accounts, want 1", len(accounts))
+	}
+}
+
+func TestSquareProvisioningRefusesAccountOfAnotherMember(t *testing.T) {
+	sc := newSquareScenario(t)
+	owner := sc.provisionedMember(t)
+	user := sc.newMember(t)
+	user.Phone = owner.Phone
+
+	if _, err := sc.loyalty.ProvisionAccount(context.Background(), user.ID); err == nil {
+		t.Fatal("ProvisionAccount linked a Square account that belongs to another member")
+	}
+	if user.SquareAccountID != "" || user.Provisioning.Status != models.ProvisioningStatusFailed {
+		t.Errorf("account %q, provisioning %+v; want a failed attempt", user.SquareAccountID, user.Provisioning)
+	}
+	if accounts := sc.fake.Accounts(); len(accounts) != 1 {
+		t.Errorf("fake has %d accounts, want only the owner's", len(accounts))
+	}
+}
+
+func TestSquareProvisioningRequiresPhone(t *testing.T) {
+	sc := newSquareScenario(t)
+	user := sc.newMember(t)
+	user.Phone = ""
+
+	if _, err := sc.loyalty.ProvisionAccount(context.Background(), user.ID); err == nil {
+		t.Fatal("ProvisionAccount created an account without a phone number")
+	}
+	if accounts := sc.fake.Accounts(); len(accounts) != 0 {
+		t.Errorf("fake has %d accounts, want none", len(accounts))
+	}
+}
+
+func TestSquareProvisioningRecordsFailureAndRetries(t *testing.T) {
+	sc := newSquareScenario(t)
+	user := sc.newMember(t)
+	sc.fake.FailNext("search_accounts", http.StatusInternalServerError)
+
+	if _, err := sc.loyalty.ProvisionAccount(context.Background(), user.ID); err == nil {
+		t.Fatal("ProvisionAccount succeeded, want the injected failure")
+	}
+	if user.Provisioning.Status != models.ProvisioningStatusFailed || user.Provisioning.Attempts != 1 || user.Provisioning.LastError == "" {
+		t.Fatalf("provisioning = %+v, want one failed attempt", user.Provisioning)
+	}
+
+	account, err := sc.loyalty.ProvisionAccount(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("ProvisionAccount retry: %v", err)
+	}
+	if account.Provisioning.Status != models.ProvisioningStatusProvisioned || account.Provisioning.Attempts != 2 || account.SquareAccountID == "" {
+		t.Fatalf("account = %+v, want provisioned on the second attempt", account)
+	}
+}
+
+func TestSquareOutboxRetriesFailedDelivery(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	transaction, err := sc.loyalty.EarnPoints(context.Background(), user.ID, 25, "Coffee", "")
+	if err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+	if user.Points != 25 {
+		t.Fatalf("local balance = %d, want 25 before delivery", user.Points)
+	}
+
+	sc.fake.FailNext("accumulate", http.StatusServiceUnavailable)
+	outbox.deliverDue(nil)
+
+	item := outboxItemFor(t, transaction.ID)
+	if item.Status != models.OutboxStatusPending || item.Attempts != 1 || item.LastError == "" {
+		t.Fatalf("item after failure = %+v, want pending with one attempt", item)
+	}
+	if !item.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("next attempt %s is not backed off", item.NextAttemptAt)
+	}
+	if balance := sc.squareBalance(t, user); balance != 0 {
+		t.Fatalf("Square balance = %d after a failed delivery, want 0", balance)
+	}
+
+	makeDue(t, item.ID)
+	outbox.deliverDue(nil)
+
+	item = outboxItemFor(t, transaction.ID)
+	if item.Status != models.OutboxStatusDelivered || item.Attempts != 2 || item.SquareEventID == "" {
+		t.Fatalf("item after retry = %+v, want delivered on attempt 2", item)
+	}
+	if balance := sc.squareBalance(t, user); balance != 25 {
+		t.Fatalf("Square balance = %d, want 25", balance)
+	}
+	if _, linked := storage.GetGlobalTransactionStorage().GetTransactionBySquareEventID(user.MerchantID, user.ID, item.SquareEventID); !linked {
+		t.Fatal("transaction is not linked to the Square event")
+	}
+}
+
+func TestSquareOutboxDeadLettersAndReplays(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	transaction, err := sc.loyalty.EarnPoints(context.Background(), user.ID, 40, "Lunch", "")
+	if err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+
+	var item models.OutboxItem
+	for attempt := 1; attempt <= sc.cfg.OutboxMaxAttempts; attempt++ {
+		sc.fake.FailNext("accumulate", http.StatusInternalServerError)
+		makeDue(t, outboxItemFor(t, transaction.ID).ID)
+		outbox.deliverDue(nil)
+		item = outboxItemFor(t, transaction.ID)
+	}
+	if item.Status != models.OutboxStatusDead || item.Attempts != sc.cfg.OutboxMaxAttempts {
+		t.Fatalf("item = %+v, want dead after %d attempts", item, sc.cfg.OutboxMaxAttempts)
+	}
+
+	// Dead items are left alone by the worker
+	makeDue(t, item.ID)
+	outbox.deliverDue(nil)
+	if current := outboxItemFor(t, transaction.ID); current.Attempts != item.Attempts {
+		t.Fatalf("dead item was attempted again: %+v", current)
+	}
+
+	replayed, err := outbox.ReplayItem(item.ID)
+	if err != nil {
+		t.Fatalf("ReplayItem: %v", err)
+	}
+	if replayed.Status != models.OutboxStatusDelivered {
+		t.Fatalf("replayed item = %+v, want delivered", replayed)
+	}
+	if balance := sc.squareBalance(t, user); balance != 40 {
+		t.Fatalf("Square balance = %d, want 40", balance)
+	}
+}
+
+// loyaltyEventWebhook builds a loyalty.event.created notification for an event of the fake
+func loyaltyEventWebhook(t *testing.T, notificationID string, event *squarefake.Event) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"merchant_id": squarefake.MerchantID,
+		"type":        "loyalty.event.created",
+		"event_id":    notificationID,
+		"created_at":  event.CreatedAt.Format(time.RFC3339),
+		"data": map[string]interface{}{
+			"type": "loyalty_event",
+			"id":   event.ID,
+			"object": map[string]interface{}{
+				"loyalty_event": map[string]interface{}{
+					"id":                 event.ID,
+					"type":               event.Type,
+					"created_at":         event.CreatedAt.Format(time.RFC3339),
+					"loyalty_account_id": event.AccountID,
+					"source":             event.Source,
+					"location_id":        event.LocationID,
+					"accumulate_points": map[string]interface{}{
+						"loyalty_program_id": squarefake.ProgramID,
+						"points":             event.Points,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal webhook: %v", err)
+	}
+	return body
+}
+
+func TestSquareWebhookEventsAreAppliedOnce(t *testing.T) {
+	sc := newSquareScenario(t)
+	webhooks := NewSquareWebhookService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	event, err := sc.fake.RecordPOSEvent(user.SquareAccountID, 30)
+	if err != nil {
+		t.Fatalf("RecordPOSEvent: %v", err)
+	}
+	notificationID := "notification-" + user.ID
+	body := loyaltyEventWebhook(t, notificationID, event)
+
+	if err := webhooks.HandleEvent(body); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	if user.Points != 30 {
+		t.Fatalf("balance = %d, want 30", user.Points)
+	}
+
+	// Square redelivers notifications it has no acknowledgement for
+	if err := webhooks.HandleEvent(body); !errors.Is(err, ErrDuplicateWebhookEvent) {
+		t.Fatalf("redelivery error = %v, want ErrDuplicateWebhookEvent", err)
+	}
+
+	// A second notification of the same loyalty event is not applied twice either
+	if err := webhooks.HandleEvent(loyaltyEventWebhook(t, notificationID+"-2", event)); err != nil {
+		t.Fatalf("HandleEvent of a second notification: %v", err)
+	}
+
+	if user.Points != 30 {
+		t.Fatalf("balance = %d after redelivery, want 30", user.Points)
+	}
+	if ledger := storage.GetGlobalTransactionStorage().GetTransactionsByUserID(user.MerchantID, user.ID); len(ledger) != 1 {
+		t.Fatalf("ledger has %d entries, want 1", len(ledger))
+	}
+}
+
+func TestSquareCircuitBreakerDegradesToLocalLedger(t *testing.T) {
+	sc := newSquareScenario(t)
+	outbox := NewOutboxService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+	sc.fake.AddOrder("order-"+user.ID, 1250)
+
+	for i := 0; i < sc.cfg.SquareBreakerFailureThreshold; i++ {
+		sc.fake.FailNext("calculate", http.StatusServiceUnavailable)
+		if _, err := sc.square.CalculateLoyaltyPoints(context.Background(), "order-"+user.ID, user.SquareAccountID); err == nil {
+			t.Fatal("CalculateLoyaltyPoints succeeded, want the injected failure")
+		}
+	}
+	if sc.square.Available() || sc.square.BreakerState() != breakerOpen {
+		t.Fatalf("breaker %s, want open after %d failures", sc.square.BreakerState(), sc.cfg.SquareBreakerFailureThreshold)
+	}
+
+	// Calls needing Square fail fast
+	if _, err := sc.loyalty.EarnPointsForOrder(context.Background(), user.ID, "order-"+user.ID, "", ""); !errors.Is(err, ErrSquareUnavailable) {
+		t.Fatalf("EarnPointsForOrder error = %v, want ErrSquareUnavailable", err)
+	}
+
+	// Earning is recorded locally and delivery waits for Square to recover
+	transaction, err := sc.loyalty.EarnPoints(context.Background(), user.ID, 10, "Offline earn", "")
+	if err != nil {
+		t.Fatalf("EarnPoints while Square is down: %v", err)
+	}
+	outbox.deliverDue(nil)
+	if item := outboxItemFor(t, transaction.ID); item.Status != models.OutboxStatusPending || item.Attempts != 0 {
+		t.Fatalf("item = %+v, want pending without attempts while the breaker is open", item)
+	}
+
+	page, err := sc.loyalty.GetTransactionHistory(context.Background(), user.ID, models.HistoryFilter{}, 10, "")
+	if err != nil {
+		t.Fatalf("GetTransactionHistory: %v", err)
+	}
+	if page.Count != 1 || page.Transactions[0].ID != transaction.ID {
+		t.Fatalf("history = %+v, want the local ledger", page)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"time"
 
 	"loyalty-core/config"
+	"loyalty-core/models"
 
 	square "github.com/square/square-go-sdk"
 	"github.com/square/square-go-sdk/client"
@@ -21,6 +24,10 @@ type SquareService struct {
 	client     *client.Client
 	programID  string
 	locationID string
+
+	timeout    time.Duration // per attempt
+	maxRetries int
+	breaker    *circuitBreaker
 }
 
 // NewSquareService creates a new Square service instance
@@ -34,53 +41,139 @@ func NewSquareService(cfg *config.Config) (*SquareService, error) {
 		return nil, errors.New("Square location ID is required")
 	}
 
-	// Initialize Square client
+	return newSquareService(cfg, cfg.SquareAccessToken, cfg.SquareLocationID)
+}
+
+// newSquareService creates a Square service acting for the seller that granted accessToken
+func newSquareService(cfg *config.Config, accessToken, locationID string) (*SquareService, error) {
+	// Initialize Square client. Retries are done by call, not the SDK.
 	squareClient := client.NewClient(
-		option.WithToken(cfg.SquareAccessToken),
-		option.WithHTTPClient(getHTTPClient(cfg.SquareEnvironment)),
+		option.WithToken(accessToken),
+		option.WithBaseURL(getBaseURL(cfg)),
+		option.WithHTTPClient(getHTTPClient(cfg)),
+		option.WithMaxAttempts(1),
 	)
 
+	timeout := time.Duration(cfg.SquareTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	maxRetries := cfg.SquareMaxRetries
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+
 	service := &SquareService{
 		config:     cfg,
 		client:     squareClient,
-		locationID: cfg.SquareLocationID,
+		locationID: locationID,
+		timeout:    timeout,
+		maxRetries: maxRetries,
+		breaker:    newCircuitBreaker(cfg.SquareBreakerFailureThreshold, time.Duration(cfg.SquareBreakerCooldownSeconds)*time.Second),
 	}
 
 	// Get the loyalty program ID
-	programID, err := service.getLoyaltyProgramID()
+	program, err := service.GetLoyaltyProgram(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get loyalty program ID: %w", err)
 	}
-	service.programID = programID
+	service.programID = *program.ID
 
 	return service, nil
 }
 
-// getLoyaltyProgramID retrieves the main loyalty program ID
-func (s *SquareService) getLoyaltyProgramID() (string, error) {
-	ctx := context.Background()
+// Available reports whether Square calls are currently let through by the circuit breaker
+func (s *SquareService) Available() bool {
+	return s.breaker.available()
+}
+
+// BreakerState returns the circuit breaker state: closed, open or half_open
+func (s *SquareService) BreakerState() string {
+	return s.breaker.currentState()
+}
+
+// call runs a Square API call through the circuit breaker with a per-attempt timeout, retrying
+// transient failures with exponential backoff. Every write carries an idempotency key that is
+// chosen before call, so retrying a write never applies it twice.
+func (s *SquareService) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !s.breaker.allow() {
+		return ErrSquareUnavailable
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		err = fn(attemptCtx)
+		cancel()
+
+		if err == nil || !isTransientSquareError(err) || ctx.Err() != nil || attempt >= s.maxRetries {
+			break
+		}
+
+		backoff := time.Duration(1<<attempt) * 200 * time.Millisecond
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	switch {
+	case err == nil:
+		s.breaker.success()
+	case ctx.Err() != nil:
+		// The caller gave up, which says nothing about Square's health
+		s.breaker.release()
+	case isTransientSquareError(err):
+		s.breaker.failure()
+	default:
+		// An error Square returned deliberately, e.g. a 4xx, means it is up
+		s.breaker.success()
+	}
+	return err
+}
+
+// isTransientSquareError reports whether a failed call may succeed if retried: network errors,
+// timeouts, rate limiting and server errors
+func isTransientSquareError(err error) bool {
+	var apiErr *core.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
 
+// GetLoyaltyProgram retrieves the seller's loyalty program (Square allows only one, called "main")
+func (s *SquareService) GetLoyaltyProgram(ctx context.Context) (*square.LoyaltyProgram, error) {
 	// Use "main" as the program ID to get the default loyalty program
 	request := &loyalty.GetProgramsRequest{
 		ProgramID: "main",
 	}
 
-	response, err := s.client.Loyalty.Programs.Get(ctx, request)
+	var response *square.GetLoyaltyProgramResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Loyalty.Programs.Get(ctx, request)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to retrieve loyalty program: %w", err)
+		return nil, fmt.Errorf("failed to retrieve loyalty program: %w", err)
 	}
 
-	if response.Program == nil {
-		return "", errors.New("no loyalty program found")
+	if response.Program == nil || response.Program.ID == nil {
+		return nil, errors.New("no loyalty program found")
 	}
 
-	return *response.Program.ID, nil
+	return response.Program, nil
 }
 
 // CreateLoyaltyAccount creates a loyalty account in Square
-func (s *SquareService) CreateLoyaltyAccount(phoneNumber, givenName, familyName string) (*square.LoyaltyAccount, error) {
-	ctx := context.Background()
-
+func (s *SquareService) CreateLoyaltyAccount(ctx context.Context, phoneNumber, givenName, familyName string) (*square.LoyaltyAccount, error) {
 	// Generate idempotency key
 	idempotencyKey := fmt.Sprintf("create-loyalty-%d", time.Now().UnixNano())
 
@@ -95,7 +188,11 @@ func (s *SquareService) CreateLoyaltyAccount(phoneNumber, givenName, familyName
 		IdempotencyKey: idempotencyKey,
 	}
 
-	response, err := s.client.Loyalty.Accounts.Create(ctx, request)
+	var response *square.CreateLoyaltyAccountResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Loyalty.Accounts.Create(ctx, request)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create loyalty account: %w", err)
 	}
@@ -107,40 +204,114 @@ func (s *SquareService) CreateLoyaltyAccount(phoneNumber, givenName, familyName
 	return response.LoyaltyAccount, nil
 }
 
-// AccumulateLoyaltyPoints adds points to a loyalty account
-func (s *SquareService) AccumulateLoyaltyPoints(accountID string, points int, orderID string) (*square.LoyaltyEvent, error) {
-	ctx := context.Background()
-
-	// Generate idempotency key
-	idempotencyKey := fmt.Sprintf("accumulate-points-%s-%d", accountID, time.Now().UnixNano())
+// AccumulateLoyaltyPoints adds points to a loyalty account at a location, or at the service's
+// default location when locationID is empty. With an order ID, Square calculates the points from
+// the order (including any promotion points) and points is ignored.
+// Retrying with the same idempotency key never accumulates twice.
+func (s *SquareService) AccumulateLoyaltyPoints(ctx context.Context, accountID string, points int, orderID, locationID, idempotencyKey string) (*square.LoyaltyEvent, error) {
+	accumulatePoints := &square.LoyaltyEventAccumulatePoints{
+		LoyaltyProgramID: &s.programID,
+	}
+	if orderID != "" {
+		accumulatePoints.OrderID = &orderID
+	} else {
+		accumulatePoints.Points = &points
+	}
+	if locationID == "" {
+		locationID = s.locationID
+	}
 
 	request := &loyalty.AccumulateLoyaltyPointsRequest{
-		AccountID: accountID,
-		AccumulatePoints: &square.LoyaltyEventAccumulatePoints{
-			LoyaltyProgramID: &s.programID,
-			Points:           &points,
-			OrderID:          &orderID,
-		},
-		IdempotencyKey: idempotencyKey,
-		LocationID:     s.locationID,
+		AccountID:        accountID,
+		AccumulatePoints: accumulatePoints,
+		IdempotencyKey:   idempotencyKey,
+		LocationID:       locationID,
 	}
 
-	response, err := s.client.Loyalty.Accounts.AccumulatePoints(ctx, request)
+	var response *square.AccumulateLoyaltyPointsResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Loyalty.Accounts.AccumulatePoints(ctx, request)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to accumulate loyalty points: %w", err)
 	}
 
-	if response.Event == nil {
-		return nil, errors.New("no loyalty event returned from Square")
+	if response.Event != nil {
+		return response.Event, nil
 	}
 
-	return response.Event, nil
+	// Accumulating for an order returns a list of events, one for the program and one per
+	// promotion. The program's event is the one linked to the local transaction; reconciliation
+	// matches the promotion events by their order. An order may earn only promotion points.
+	var first *square.LoyaltyEvent
+	for _, event := range response.Events {
+		if event == nil {
+			continue
+		}
+		if event.Type == square.LoyaltyEventTypeAccumulatePoints {
+			return event, nil
+		}
+		if first == nil {
+			first = event
+		}
+	}
+	if first != nil {
+		return first, nil
+	}
+
+	return nil, errors.New("no loyalty event returned from Square")
 }
 
-// CreateLoyaltyReward creates a loyalty reward (redeems points)
-func (s *SquareService) CreateLoyaltyReward(accountID string, rewardTierID string, orderID string) (*square.LoyaltyReward, error) {
-	ctx := context.Background()
+// ListLocations returns the seller's locations
+func (s *SquareService) ListLocations(ctx context.Context) ([]*square.Location, error) {
+	var response *square.ListLocationsResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Locations.List(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Square locations: %w", err)
+	}
 
+	return response.Locations, nil
+}
+
+// DefaultLocationID returns the location writes are made at when no location is given
+func (s *SquareService) DefaultLocationID() string {
+	return s.locationID
+}
+
+// CalculateLoyaltyPoints asks the loyalty program how many points an order earns for an account,
+// including promotion points
+func (s *SquareService) CalculateLoyaltyPoints(ctx context.Context, orderID, accountID string) (int, error) {
+	request := &loyalty.CalculateLoyaltyPointsRequest{
+		ProgramID:        s.programID,
+		OrderID:          &orderID,
+		LoyaltyAccountID: &accountID,
+	}
+
+	var response *square.CalculateLoyaltyPointsResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Loyalty.Programs.Calculate(ctx, request)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to calculate loyalty points: %w", err)
+	}
+
+	points := 0
+	if response.Points != nil {
+		points += *response.Points
+	}
+	if response.PromotionPoints != nil {
+		points += *response.PromotionPoints
+	}
+	return points, nil
+}
+
+// CreateLoyaltyReward creates a loyalty reward (redeems points)
+func (s *SquareService) CreateLoyaltyReward(ctx context.Context, accountID string, rewardTierID string, orderID string) (*square.LoyaltyReward, error) {
 	// Generate idempotency key
 	idempotencyKey := fmt.Sprintf("create-reward-%s-%d", accountID, time.Now().UnixNano())
 
@@ -153,7 +324,11 @@ func (s *SquareService) CreateLoyaltyReward(accountID string, rewardTierID strin
 		IdempotencyKey: idempotencyKey,
 	}
 
-	response, err := s.client.Loyalty.Rewards.Create(ctx, request)
+	var response *square.CreateLoyaltyRewardResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Loyalty.Rewards.Create(ctx, request)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create loyalty reward: %w", err)
 	}
@@ -165,15 +340,46 @@ func (s *SquareService) CreateLoyaltyReward(accountID string, rewardTierID strin
 	return response.Reward, nil
 }
 
-// GetLoyaltyAccount retrieves a loyalty account by ID
-func (s *SquareService) GetLoyaltyAccount(accountID string) (*square.LoyaltyAccount, error) {
-	ctx := context.Background()
+// SearchLoyaltyRewards returns all rewards of a loyalty account, in every status
+func (s *SquareService) SearchLoyaltyRewards(ctx context.Context, accountID string) ([]*square.LoyaltyReward, error) {
+	var rewards []*square.LoyaltyReward
+	cursor := ""
+	for {
+		request := &loyalty.SearchLoyaltyRewardsRequest{
+			Query: &square.SearchLoyaltyRewardsRequestLoyaltyRewardQuery{LoyaltyAccountID: accountID},
+		}
+		if cursor != "" {
+			request.Cursor = &cursor
+		}
+
+		var response *square.SearchLoyaltyRewardsResponse
+		err := s.call(ctx, func(ctx context.Context) (err error) {
+			response, err = s.client.Loyalty.Rewards.Search(ctx, request)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to search loyalty rewards: %w", err)
+		}
+
+		rewards = append(rewards, response.Rewards...)
+		if response.Cursor == nil || *response.Cursor == "" {
+			return rewards, nil
+		}
+		cursor = *response.Cursor
+	}
+}
 
+// GetLoyaltyAccount retrieves a loyalty account by ID
+func (s *SquareService) GetLoyaltyAccount(ctx context.Context, accountID string) (*square.LoyaltyAccount, error) {
 	request := &loyalty.GetAccountsRequest{
 		AccountID: accountID,
 	}
 
-	response, err := s.client.Loyalty.Accounts.Get(ctx, request)
+	var response *square.GetLoyaltyAccountResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Loyalty.Accounts.Get(ctx, request)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve loyalty account: %w", err)
 	}
@@ -186,9 +392,7 @@ func (s *SquareService) GetLoyaltyAccount(accountID string) (*square.LoyaltyAcco
 }
 
 // SearchLoyaltyAccounts searches for loyalty accounts by phone number
-func (s *SquareService) SearchLoyaltyAccounts(phoneNumber string) ([]*square.LoyaltyAccount, error) {
-	ctx := context.Background()
-
+func (s *SquareService) SearchLoyaltyAccounts(ctx context.Context, phoneNumber string) ([]*square.LoyaltyAccount, error) {
 	request := &loyalty.SearchLoyaltyAccountsRequest{
 		Query: &square.SearchLoyaltyAccountsRequestLoyaltyAccountQuery{
 			Mappings: []*square.LoyaltyAccountMapping{
@@ -199,7 +403,11 @@ func (s *SquareService) SearchLoyaltyAccounts(phoneNumber string) ([]*square.Loy
 		},
 	}
 
-	response, err := s.client.Loyalty.Accounts.Search(ctx, request)
+	var response *square.SearchLoyaltyAccountsResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Loyalty.Accounts.Search(ctx, request)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to search loyalty accounts: %w", err)
 	}
@@ -207,36 +415,62 @@ func (s *SquareService) SearchLoyaltyAccounts(phoneNumber string) ([]*square.Loy
 	return response.LoyaltyAccounts, nil
 }
 
-// SearchLoyaltyEvents searches for loyalty events (transaction history)
-func (s *SquareService) SearchLoyaltyEvents(accountID string, limit int) ([]*square.LoyaltyEvent, error) {
-	ctx := context.Background()
+// SearchLoyaltyEvents returns one page of an account's loyalty events (transaction history), newest
+// first, and the cursor of the next page (empty on the last page)
+func (s *SquareService) SearchLoyaltyEvents(ctx context.Context, accountID string, filter models.HistoryFilter, limit int, cursor string) ([]*square.LoyaltyEvent, string, error) {
+	eventFilter := &square.LoyaltyEventFilter{
+		LoyaltyAccountFilter: &square.LoyaltyEventLoyaltyAccountFilter{
+			LoyaltyAccountID: accountID,
+		},
+	}
+	if len(filter.Types) > 0 {
+		types := make([]square.LoyaltyEventType, 0, len(filter.Types))
+		for _, eventType := range filter.Types {
+			types = append(types, square.LoyaltyEventType(eventType))
+		}
+		eventFilter.TypeFilter = &square.LoyaltyEventTypeFilter{Types: types}
+	}
+	if filter.From != nil || filter.To != nil {
+		timeRange := &square.TimeRange{}
+		if filter.From != nil {
+			timeRange.StartAt = square.String(filter.From.UTC().Format(time.RFC3339))
+		}
+		if filter.To != nil {
+			timeRange.EndAt = square.String(filter.To.UTC().Format(time.RFC3339))
+		}
+		eventFilter.DateTimeFilter = &square.LoyaltyEventDateTimeFilter{CreatedAt: timeRange}
+	}
+	if len(filter.LocationIDs) > 0 {
+		eventFilter.LocationFilter = &square.LoyaltyEventLocationFilter{LocationIDs: filter.LocationIDs}
+	}
 
 	request := &square.SearchLoyaltyEventsRequest{
-		Query: &square.LoyaltyEventQuery{
-			Filter: &square.LoyaltyEventFilter{
-				LoyaltyAccountFilter: &square.LoyaltyEventLoyaltyAccountFilter{
-					LoyaltyAccountID: accountID,
-				},
-			},
-		},
+		Query: &square.LoyaltyEventQuery{Filter: eventFilter},
 		Limit: &limit,
 	}
+	if cursor != "" {
+		request.Cursor = &cursor
+	}
 
-	response, err := s.client.Loyalty.SearchEvents(ctx, request)
+	var response *square.SearchLoyaltyEventsResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Loyalty.SearchEvents(ctx, request)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to search loyalty events: %w", err)
+		return nil, "", fmt.Errorf("failed to search loyalty events: %w", err)
 	}
 
-	return response.Events, nil
+	nextCursor := ""
+	if response.Cursor != nil {
+		nextCursor = *response.Cursor
+	}
+	return response.Events, nextCursor, nil
 }
 
-// AdjustLoyaltyPoints adjusts points in a loyalty account (for manual point redemption)
-func (s *SquareService) AdjustLoyaltyPoints(accountID string, points int, reason string) (*square.LoyaltyEvent, error) {
-	ctx := context.Background()
-
-	// Generate idempotency key
-	idempotencyKey := fmt.Sprintf("adjust-points-%s-%d", accountID, time.Now().UnixNano())
-
+// AdjustLoyaltyPoints adjusts points in a loyalty account (for manual point redemption).
+// Retrying with the same idempotency key never adjusts twice.
+func (s *SquareService) AdjustLoyaltyPoints(ctx context.Context, accountID string, points int, reason, idempotencyKey string) (*square.LoyaltyEvent, error) {
 	request := &loyalty.AdjustLoyaltyPointsRequest{
 		AccountID: accountID,
 		AdjustPoints: &square.LoyaltyEventAdjustPoints{
@@ -247,7 +481,11 @@ func (s *SquareService) AdjustLoyaltyPoints(accountID string, points int, reason
 		IdempotencyKey: idempotencyKey,
 	}
 
-	response, err := s.client.Loyalty.Accounts.Adjust(ctx, request)
+	var response *square.AdjustLoyaltyPointsResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		response, err = s.client.Loyalty.Accounts.Adjust(ctx, request)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to adjust loyalty points: %w", err)
 	}
@@ -259,9 +497,32 @@ func (s *SquareService) AdjustLoyaltyPoints(accountID string, points int, reason
 	return response.Event, nil
 }
 
-// getHTTPClient returns the appropriate HTTP client based on environment
-func getHTTPClient(_ string) core.HTTPClient {
-	// Return default HTTP client
-	// In production, you might want to configure timeout, retry logic, etc.
-	return nil // This will use the default HTTP client
+// getBaseURL returns the Square API base URL: the configured override (for example, a local
+// fake server), otherwise the production or sandbox URL for the environment
+func getBaseURL(cfg *config.Config) string {
+	if cfg.SquareBaseURL != "" {
+		return cfg.SquareBaseURL
+	}
+	if cfg.SquareEnvironment == "production" {
+		return square.Environments.Production
+	}
+	return square.Environments.Sandbox
+}
+
+// getHTTPClient returns an HTTP client with a connection pool sized for Square. Timeouts are
+// applied per call through the request context.
+func getHTTPClient(cfg *config.Config) core.HTTPClient {
+	maxIdleConns := cfg.SquareMaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = 20
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConns
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = time.Duration(cfg.SquareTimeoutSeconds) * time.Second
+
+	return &http.Client{Transport: transport}
 }
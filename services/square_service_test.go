@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/square/square-go-sdk/core"
+)
+
+func TestIsTransientSquareError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{core.NewAPIError(http.StatusTooManyRequests, errors.New("rate limited")), true},
+		{core.NewAPIError(http.StatusServiceUnavailable, errors.New("unavailable")), true},
+		{fmt.Errorf("wrapped: %w", core.NewAPIError(http.StatusInternalServerError, errors.New("boom"))), true},
+		{core.NewAPIError(http.StatusBadRequest, errors.New("bad request")), false},
+		{core.NewAPIError(http.StatusNotFound, errors.New("not found")), false},
+		{context.DeadlineExceeded, true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{context.Canceled, false},
+		{errors.New("no loyalty event returned from Square"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransientSquareError(tt.err); got != tt.want {
+			t.Errorf("isTransientSquareError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSquareCallRetriesTransientFailures(t *testing.T) {
+	sc := newSquareScenario(t)
+	sc.cfg.SquareMaxRetries = 1
+	squareService, err := NewSquareService(sc.cfg)
+	if err != nil {
+		t.Fatalf("NewSquareService: %v", err)
+	}
+	account := sc.fake.SeedAccount("+15550000391", 10)
+
+	sc.fake.FailNext("get_account", http.StatusServiceUnavailable)
+	if _, err := squareService.GetLoyaltyAccount(context.Background(), account.ID); err != nil {
+		t.Fatalf("GetLoyaltyAccount after one transient failure: %v", err)
+	}
+
+	// Client errors are not retried and do not count against Square's health
+	sc.fake.FailNext("get_account", http.StatusBadRequest)
+	if _, err := squareService.GetLoyaltyAccount(context.Background(), account.ID); err == nil {
+		t.Fatal("GetLoyaltyAccount succeeded, want the 400")
+	}
+	if squareService.breaker.failures != 0 || squareService.BreakerState() != breakerClosed {
+		t.Fatalf("breaker %s with %d failures after a 400, want closed", squareService.BreakerState(), squareService.breaker.failures)
+	}
+}
+
+func TestSquareCallTimesOutEachAttempt(t *testing.T) {
+	sc := newSquareScenario(t)
+	sc.square.timeout = 10 * time.Millisecond
+
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if err := sc.square.call(context.Background(), hang); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("call error = %v, want the attempt's deadline", err)
+	}
+	if sc.square.breaker.failures != 1 {
+		t.Fatalf("breaker failures = %d, want the timeout counted", sc.square.breaker.failures)
+	}
+
+	// A caller that gives up says nothing about Square's health
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sc.square.call(ctx, hang); !errors.Is(err, context.Canceled) {
+		t.Fatalf("call error = %v, want the caller's cancellation", err)
+	}
+	if sc.square.breaker.failures != 1 {
+		t.Fatalf("breaker failures = %d after a canceled call, want 1", sc.square.breaker.failures)
+	}
+}
+
+func TestSquareCallFailsFastWhileBreakerIsOpen(t *testing.T) {
+	sc := newSquareScenario(t)
+	for i := 0; i < sc.cfg.SquareBreakerFailureThreshold; i++ {
+		sc.square.breaker.failure()
+	}
+
+	called := false
+	err := sc.square.call(context.Background(), func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, ErrSquareUnavailable) || called {
+		t.Fatalf("call = %v (called: %v), want ErrSquareUnavailable without calling Square", err, called)
+	}
+}
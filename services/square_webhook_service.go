@@ -0,0 +1,283 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"loyalty-core/config"
+	"loyalty-core/models"
+	"loyalty-core/storage"
+
+	square "github.com/square/square-go-sdk"
+)
+
+// ErrDuplicateWebhookEvent is returned for an event that has already been processed
+var ErrDuplicateWebhookEvent = errors.New("duplicate webhook event")
+
+// SquareWebhookService verifies and applies Square webhook notifications to the local ledger
+type SquareWebhookService struct {
+	config         *config.Config
+	userStorage    *storage.UserStorage
+	events         *storage.WebhookEventStorage
+	loyaltyService *LoyaltyService
+
+	programHooks []func(merchantID string, program *square.LoyaltyProgram)
+}
+
+func NewSquareWebhookService(cfg *config.Config, loyaltyService *LoyaltyService) *SquareWebhookService {
+	return &SquareWebhookService{
+		config:         cfg,
+		userStorage:    storage.GetGlobalUserStorage(),
+		events:         storage.GetGlobalWebhookEventStorage(),
+		loyaltyService: loyaltyService,
+	}
+}
+
+// OnProgramUpdated registers a hook that runs when Square reports a change to a merchant's
+// loyalty program
+func (s *SquareWebhookService) OnProgramUpdated(hook func(merchantID string, program *square.LoyaltyProgram)) {
+	s.programHooks = append(s.programHooks, hook)
+}
+
+// Enabled reports whether a webhook signature key is configured
+func (s *SquareWebhookService) Enabled() bool {
+	return s.config.SquareWebhookSignatureKey != ""
+}
+
+// VerifySignature checks Square's x-square-hmacsha256-signature header, an HMAC-SHA256 of the
+// notification URL followed by the raw request body, keyed with the subscription's signature key
+func (s *SquareWebhookService) VerifySignature(body []byte, signature string) bool {
+	if !s.Enabled() || signature == "" {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.config.SquareWebhookSignatureKey))
+	mac.Write([]byte(s.config.SquareWebhookNotificationURL))
+	mac.Write(body)
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// HandleEvent applies a verified webhook payload. Each event ID is applied at most once;
+// redeliveries return ErrDuplicateWebhookEvent.
+func (s *SquareWebhookService) HandleEvent(body []byte) error {
+	var event models.SquareWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("invalid webhook payload: %w", err)
+	}
+
+	if event.EventID == "" || event.Type == "" {
+		return errors.New("webhook event is missing event_id or type")
+	}
+
+	if !s.events.Claim(event.EventID, event.Type) {
+		return ErrDuplicateWebhookEvent
+	}
+
+	// Events only ever touch the data of the merchant whose Square seller sent them. Events of
+	// sellers no merchant is connected to are acknowledged so Square does not redeliver them.
+	merchantID, known := s.loyaltyService.merchants.MerchantIDForSquareMerchant(event.MerchantID)
+	if !known {
+		log.Printf("Ignoring Square webhook event %s from unknown seller %q", event.EventID, event.MerchantID)
+		return nil
+	}
+
+	var err error
+	switch event.Type {
+	case "loyalty.event.created":
+		err = s.handleLoyaltyEventCreated(merchantID, event)
+	case "loyalty.account.created", "loyalty.account.updated":
+		err = s.handleLoyaltyAccountUpdated(merchantID, event)
+	case "loyalty.account.deleted":
+		err = s.handleLoyaltyAccountDeleted(merchantID, event)
+	case "loyalty.program.created", "loyalty.program.updated":
+		err = s.handleLoyaltyProgramUpdated(merchantID, event)
+	default:
+		log.Printf("Ignoring Square webhook event %s of type %s", event.EventID, event.Type)
+	}
+
+	if err != nil {
+		// Let Square's redelivery try again
+		s.events.Release(event.EventID)
+		return err
+	}
+
+	return nil
+}
+
+// handleLoyaltyEventCreated records a loyalty event (for example, points earned at a Square POS)
+// in the member's local ledger and applies it to their balance
+func (s *SquareWebhookService) handleLoyaltyEventCreated(merchantID string, event models.SquareWebhookEvent) error {
+	var object struct {
+		LoyaltyEvent *square.LoyaltyEvent `json:"loyalty_event"`
+	}
+	if err := json.Unmarshal(event.Data.Object, &object); err != nil {
+		return fmt.Errorf("invalid loyalty event payload: %w", err)
+	}
+	if object.LoyaltyEvent == nil {
+		return errors.New("webhook payload has no loyalty_event")
+	}
+
+	// Events created through the Loyalty API are this application's own outbox deliveries,
+	// which are already in the local ledger
+	if object.LoyaltyEvent.Source == square.LoyaltyEventSourceLoyaltyAPI {
+		return nil
+	}
+
+	user, err := s.userStorage.GetUserBySquareAccountID(merchantID, object.LoyaltyEvent.LoyaltyAccountID)
+	if err != nil {
+		log.Printf("Ignoring loyalty event %s for unknown account %s", object.LoyaltyEvent.ID, object.LoyaltyEvent.LoyaltyAccountID)
+		return nil
+	}
+
+	transaction := s.loyaltyService.convertSquareEventToTransaction(object.LoyaltyEvent, user)
+	if transaction == nil {
+		return nil // Event type not tracked in the ledger
+	}
+	transaction.ID = s.loyaltyService.generateID()
+	transaction.SquareEventID = object.LoyaltyEvent.ID
+
+	// A balance snapshot taken after the event already includes it
+	applyToBalance := user.SquareBalanceSyncedAt == nil || transaction.CreatedAt.After(*user.SquareBalanceSyncedAt)
+
+	recorded, err := s.loyaltyService.recordTransaction(user, *transaction, applyToBalance)
+	if err != nil {
+		return fmt.Errorf("failed to record loyalty event: %w", err)
+	}
+
+	// An event reconciliation imported first is already in the ledger and its audit log
+	if recorded.ID == transaction.ID {
+		s.loyaltyService.audit.Record(models.SystemActor(models.AuditActorSquareWebhook, merchantID), models.AuditActionSquareEventImport,
+			models.AuditTargetTransaction, recorded.ID, nil, recorded)
+	}
+	return nil
+}
+
+// handleLoyaltyAccountUpdated syncs the member's balance with the Square account and links
+// newly created Square accounts to the member with the same phone number
+func (s *SquareWebhookService) handleLoyaltyAccountUpdated(merchantID string, event models.SquareWebhookEvent) error {
+	account, err := s.decodeLoyaltyAccount(event)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userStorage.GetUserBySquareAccountID(merchantID, *account.ID)
+	linking := err != nil
+	if linking {
+		user = s.findUnlinkedUserByPhone(merchantID, account)
+		if user == nil {
+			log.Printf("Ignoring %s for unknown account %s", event.Type, *account.ID)
+			return nil
+		}
+	}
+	before := *user
+
+	if linking {
+		now := time.Now()
+		user.SquareAccountID = *account.ID
+		user.Provisioning.Status = models.ProvisioningStatusProvisioned
+		user.Provisioning.LastError = ""
+		user.Provisioning.ProvisionedAt = &now
+		log.Printf("Linked Square loyalty account %s to user %s from webhook", *account.ID, user.ID)
+	}
+
+	// The snapshot time orders balance updates that arrive out of order
+	updatedAt := time.Now()
+	if account.UpdatedAt != nil {
+		if parsed, err := time.Parse(time.RFC3339, *account.UpdatedAt); err == nil {
+			updatedAt = parsed
+		}
+	}
+
+	if err := s.loyaltyService.applySquareBalance(user, account.Balance, updatedAt); err != nil {
+		return err
+	}
+
+	actor := models.SystemActor(models.AuditActorSquareWebhook, merchantID)
+	if linking {
+		s.loyaltyService.audit.Record(actor, models.AuditActionSquareAccountLink, models.AuditTargetMember, user.ID, before, user)
+	} else if user.Points != before.Points {
+		s.loyaltyService.audit.Record(actor, models.AuditActionSquareBalanceSync, models.AuditTargetMember, user.ID, before, user)
+	}
+	return nil
+}
+
+// handleLoyaltyAccountDeleted removes the mapping to a Square account that no longer exists
+func (s *SquareWebhookService) handleLoyaltyAccountDeleted(merchantID string, event models.SquareWebhookEvent) error {
+	account, err := s.decodeLoyaltyAccount(event)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userStorage.GetUserBySquareAccountID(merchantID, *account.ID)
+	if err != nil {
+		return nil
+	}
+
+	before := *user
+	user.SquareAccountID = ""
+	user.Provisioning.Status = models.ProvisioningStatusDeactivated
+	user.UpdatedAt = time.Now()
+	log.Printf("Square loyalty account %s deleted, unlinked from user %s", *account.ID, user.ID)
+
+	if err := s.userStorage.UpdateUser(user); err != nil {
+		return err
+	}
+	s.loyaltyService.audit.Record(models.SystemActor(models.AuditActorSquareWebhook, merchantID), models.AuditActionSquareAccountUnlink,
+		models.AuditTargetMember, user.ID, before, user)
+	return nil
+}
+
+// handleLoyaltyProgramUpdated passes the new program definition to the registered hooks
+func (s *SquareWebhookService) handleLoyaltyProgramUpdated(merchantID string, event models.SquareWebhookEvent) error {
+	var object struct {
+		LoyaltyProgram *square.LoyaltyProgram `json:"loyalty_program"`
+	}
+	if err := json.Unmarshal(event.Data.Object, &object); err != nil {
+		return fmt.Errorf("invalid loyalty program payload: %w", err)
+	}
+	if object.LoyaltyProgram == nil || object.LoyaltyProgram.ID == nil {
+		return errors.New("webhook payload has no loyalty_program")
+	}
+
+	for _, hook := range s.programHooks {
+		hook(merchantID, object.LoyaltyProgram)
+	}
+	return nil
+}
+
+func (s *SquareWebhookService) decodeLoyaltyAccount(event models.SquareWebhookEvent) (*square.LoyaltyAccount, error) {
+	var object struct {
+		LoyaltyAccount *square.LoyaltyAccount `json:"loyalty_account"`
+	}
+	if err := json.Unmarshal(event.Data.Object, &object); err != nil {
+		return nil, fmt.Errorf("invalid loyalty account payload: %w", err)
+	}
+	if object.LoyaltyAccount == nil || object.LoyaltyAccount.ID == nil {
+		return nil, errors.New("webhook payload has no loyalty_account")
+	}
+	return object.LoyaltyAccount, nil
+}
+
+// findUnlinkedUserByPhone finds an active member of the merchant without a Square account whose
+// phone number matches the account's mapping
+func (s *SquareWebhookService) findUnlinkedUserByPhone(merchantID string, account *square.LoyaltyAccount) *models.User {
+	if account.Mapping == nil || account.Mapping.PhoneNumber == nil {
+		return nil
+	}
+
+	for _, user := range s.userStorage.GetMerchantUsers(merchantID) {
+		if user.SquareAccountID == "" && user.Status != models.AccountStatusClosed &&
+			user.Phone == *account.Mapping.PhoneNumber {
+			return user
+		}
+	}
+	return nil
+}
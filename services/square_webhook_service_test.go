@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"loyalty-core/models"
+	"loyalty-core/squarefake"
+)
+
+// loyaltyAccountWebhook builds a loyalty.account.updated notification for an account snapshot
+func loyaltyAccountWebhook(t *testing.T, notificationID, accountID, phone string, balance int, updatedAt time.Time) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"merchant_id": squarefake.MerchantID,
+		"type":        "loyalty.account.updated",
+		"event_id":    notificationID,
+		"created_at":  updatedAt.Format(time.RFC3339),
+		"data": map[string]interface{}{
+			"type": "loyalty_account",
+			"id":   accountID,
+			"object": map[string]interface{}{
+				"loyalty_account": map[string]interface{}{
+					"id":         accountID,
+					"program_id": squarefake.ProgramID,
+					"balance":    balance,
+					"mapping":    map[string]interface{}{"phone_number": phone},
+					"updated_at": updatedAt.Format(time.RFC3339),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal webhook: %v", err)
+	}
+	return body
+}
+
+func TestSquareWebhookSignature(t *testing.T) {
+	sc := newSquareScenario(t)
+	sc.cfg.SquareWebhookSignatureKey = "webhook-key"
+	sc.cfg.SquareWebhookNotificationURL = "https://loyalty.example.com/api/webhooks/square"
+	webhooks := NewSquareWebhookService(sc.cfg, sc.loyalty)
+
+	body := []byte(`{"event_id":"signed","type":"loyalty.account.updated"}`)
+	mac := hmac.New(sha256.New, []byte("webhook-key"))
+	mac.Write([]byte(sc.cfg.SquareWebhookNotificationURL))
+	mac.Write(body)
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	if !webhooks.VerifySignature(body, signature) {
+		t.Error("valid signature rejected")
+	}
+	if webhooks.VerifySignature([]byte(`{"event_id":"forged","type":"loyalty.account.updated"}`), signature) {
+		t.Error("signature accepted for a different body")
+	}
+	if webhooks.VerifySignature(body, "") {
+		t.Error("missing signature accepted")
+	}
+
+	sc.cfg.SquareWebhookSignatureKey = ""
+	if webhooks.VerifySignature(body, signature) {
+		t.Error("signature accepted without a signature key")
+	}
+}
+
+func TestSquareWebhookAppliesNewerBalanceSnapshots(t *testing.T) {
+	sc := newSquareScenario(t)
+	webhooks := NewSquareWebhookService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+	snapshot := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	if err := webhooks.HandleEvent(loyaltyAccountWebhook(t, "balance-new-"+user.ID, user.SquareAccountID, user.Phone, 70, snapshot)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	if user.Points != 70 || user.SquareBalanceSyncedAt == nil || !user.SquareBalanceSyncedAt.Equal(snapshot) {
+		t.Fatalf("balance %d synced at %v, want 70 at %v", user.Points, user.SquareBalanceSyncedAt, snapshot)
+	}
+
+	// Square does not deliver notifications in order
+	if err := webhooks.HandleEvent(loyaltyAccountWebhook(t, "balance-old-"+user.ID, user.SquareAccountID, user.Phone, 10, snapshot.Add(-time.Minute))); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	if user.Points != 70 {
+		t.Fatalf("balance = %d after an older snapshot, want 70", user.Points)
+	}
+}
+
+func TestSquareWebhookSkipsBalanceWhileOutboxIsPending(t *testing.T) {
+	sc := newSquareScenario(t)
+	webhooks := NewSquareWebhookService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	if _, err := sc.loyalty.EarnPoints(context.Background(), user.ID, 20, "Pending earn", ""); err != nil {
+		t.Fatalf("EarnPoints: %v", err)
+	}
+
+	// Square has not seen the earn yet, so its balance of 0 is behind the ledger
+	if err := webhooks.HandleEvent(loyaltyAccountWebhook(t, "balance-pending-"+user.ID, user.SquareAccountID, user.Phone, 0, time.Now())); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	if user.Points != 20 || user.SquareBalanceSyncedAt != nil {
+		t.Fatalf("balance %d synced at %v, want the local 20 kept", user.Points, user.SquareBalanceSyncedAt)
+	}
+}
+
+func TestSquareWebhookLinksNewAccountByPhone(t *testing.T) {
+	sc := newSquareScenario(t)
+	webhooks := NewSquareWebhookService(sc.cfg, sc.loyalty)
+	user := sc.newMember(t)
+	account := sc.fake.SeedAccount(user.Phone, 45)
+
+	if err := webhooks.HandleEvent(loyaltyAccountWebhook(t, "link-"+user.ID, account.ID, user.Phone, 45, time.Now())); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	if user.SquareAccountID != account.ID || user.Provisioning.Status != models.ProvisioningStatusProvisioned || user.Points != 45 {
+		t.Fatalf("member linked to %q (%s) with %d points, want %s with 45", user.SquareAccountID, user.Provisioning.Status, user.Points, account.ID)
+	}
+}
+
+func TestSquareWebhookFailureAllowsRedelivery(t *testing.T) {
+	sc := newSquareScenario(t)
+	webhooks := NewSquareWebhookService(sc.cfg, sc.loyalty)
+	body := []byte(`{"merchant_id":"` + squarefake.MerchantID + `","event_id":"broken-account-event","type":"loyalty.account.updated","data":{"object":{}}}`)
+
+	if err := webhooks.HandleEvent(body); err == nil || errors.Is(err, ErrDuplicateWebhookEvent) {
+		t.Fatalf("HandleEvent = %v, want a payload error", err)
+	}
+	// The event was not applied, so Square's redelivery is processed rather than acknowledged
+	if err := webhooks.HandleEvent(body); errors.Is(err, ErrDuplicateWebhookEvent) {
+		t.Fatal("redelivery of a failed event reported as a duplicate")
+	}
+
+	if err := webhooks.HandleEvent([]byte(`{"type":"loyalty.account.updated"}`)); err == nil {
+		t.Error("event without an event_id accepted")
+	}
+}
+
+func TestSquareWebhookDropsEventsOfUnknownSellers(t *testing.T) {
+	sc := newSquareScenario(t)
+	webhooks := NewSquareWebhookService(sc.cfg, sc.loyalty)
+	user := sc.provisionedMember(t)
+
+	if merchantID, known := sc.loyalty.merchants.MerchantIDForSquareMerchant(squarefake.MerchantID); !known || merchantID != models.DefaultMerchantID {
+		t.Fatalf("seller of the default merchant = %q, %v, want the default merchant", merchantID, known)
+	}
+
+	event, err := sc.fake.RecordPOSEvent(user.SquareAccountID, 25)
+	if err != nil {
+		t.Fatalf("RecordPOSEvent: %v", err)
+	}
+	var notification map[string]interface{}
+	if err := json.Unmarshal(loyaltyEventWebhook(t, "stranger-"+user.ID, event), &notification); err != nil {
+		t.Fatalf("unmarshal webhook: %v", err)
+	}
+	notification["merchant_id"] = "another-seller"
+	body, err := json.Marshal(notification)
+	if err != nil {
+		t.Fatalf("marshal webhook: %v", err)
+	}
+
+	// Acknowledged, so Square stops redelivering, but not applied to any merchant
+	if err := webhooks.HandleEvent(body); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	if user.Points != 0 {
+		t.Fatalf("balance = %d, want the event of another seller dropped", user.Points)
+	}
+	if err := webhooks.HandleEvent(body); !errors.Is(err, ErrDuplicateWebhookEvent) {
+		t.Fatalf("redelivery error = %v, want ErrDuplicateWebhookEvent", err)
+	}
+}
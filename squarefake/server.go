@@ -0,0 +1,901 @@
+// Package squarefake is an in-process fake of the Square Loyalty, Locations and OAuth API
+// endpoints used by loyalty-core. It keeps all state in memory and can be served with httptest for tests or
+// run standalone (cmd/squarefake) for offline development.
+package squarefake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgramID is the ID of the single loyalty program served by the fake
+const ProgramID = "fake-program"
+
+// PromotionID is the loyalty promotion that promotion points of orders are earned under
+const PromotionID = "fake-promotion"
+
+// MerchantID is the Square seller every OAuth authorization of the fake connects
+const MerchantID = "fake-merchant"
+
+// Account is a loyalty account held by the fake
+type Account struct {
+	ID             string
+	PhoneNumber    string
+	Balance        int
+	LifetimePoints int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Event is a loyalty event recorded by the fake
+type Event struct {
+	ID         string
+	Type       string // ACCUMULATE_POINTS, ADJUST_POINTS, CREATE_REWARD, ...
+	AccountID  string
+	Points     int // signed effect on the balance
+	OrderID    string
+	Reason     string
+	RewardID   string
+	LocationID string
+	Source     string // SQUARE or LOYALTY_API
+	CreatedAt  time.Time
+}
+
+// Reward is a loyalty reward issued by the fake
+type Reward struct {
+	ID           string
+	AccountID    string
+	RewardTierID string
+	Points       int
+	OrderID      string
+	Status       string // ISSUED, REDEEMED or DELETED
+	CreatedAt    time.Time
+}
+
+// RewardTier is a reward offered by the fake program
+type RewardTier struct {
+	ID     string
+	Name   string
+	Points int
+}
+
+// Server is the fake Square Loyalty API. It implements http.Handler.
+type Server struct {
+	mu          sync.Mutex
+	accounts    map[string]*Account
+	events      []*Event
+	rewards     map[string]*Reward
+	rewardTiers []RewardTier
+	locationIDs []string
+	orders      map[string]int            // order ID -> total in cents
+	promotions  map[string]int            // order ID -> promotion points earned on top of the program
+	credited    map[string]bool           // order IDs points were accumulated for
+	idempotency map[string]cachedResponse // idempotency key -> first response
+	failures    map[string][]int          // operation -> queued HTTP status codes to fail with
+	authCodes   map[string]bool           // unused OAuth authorization codes
+	refreshes   map[string]bool           // valid OAuth refresh tokens
+	nextID      int
+	now         func() time.Time
+}
+
+type cachedResponse struct {
+	status int
+	body   interface{}
+}
+
+// NewServer creates an empty fake with a default program
+func NewServer() *Server {
+	return &Server{
+		accounts: make(map[string]*Account),
+		rewards:  make(map[string]*Reward),
+		rewardTiers: []RewardTier{
+			{ID: "tier-coffee", Name: "Free coffee", Points: 100},
+			{ID: "tier-lunch", Name: "Free lunch", Points: 500},
+		},
+		locationIDs: []string{"fake-location"},
+		orders:      make(map[string]int),
+		promotions:  make(map[string]int),
+		credited:    make(map[string]bool),
+		idempotency: make(map[string]cachedResponse),
+		failures:    make(map[string][]int),
+		authCodes:   make(map[string]bool),
+		refreshes:   make(map[string]bool),
+		now:         time.Now,
+	}
+}
+
+// Start serves the fake on a local httptest server. Close the returned server when done.
+func (s *Server) Start() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+// FailNext makes the next call of an operation fail with the given HTTP status.
+// Operations: get_program, calculate, create_account, get_account, search_accounts, accumulate, adjust,
+// create_reward, search_rewards, redeem_reward, delete_reward, search_events, list_locations, obtain_token.
+func (s *Server) FailNext(operation string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[operation] = append(s.failures[operation], status)
+}
+
+// AddOrder makes an order known to the fake so points can be calculated and accumulated for it.
+// The program earns 1 point per whole dollar.
+func (s *Server) AddOrder(orderID string, totalCents int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orders[orderID] = totalCents
+}
+
+// AddOrderPromotion makes an order earn promotion points on top of the program's points. Like
+// Square, accumulating for the order then creates an ACCUMULATE_PROMOTION_POINTS event as well.
+func (s *Server) AddOrderPromotion(orderID string, points int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.promotions[orderID] = points
+}
+
+// AddLocation adds an active location to the seller. The fake starts with "fake-location".
+func (s *Server) AddLocation(locationID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !contains(s.locationIDs, locationID) {
+		s.locationIDs = append(s.locationIDs, locationID)
+	}
+}
+
+// SeedAccount creates an account as if the buyer enrolled at a Square POS
+func (s *Server) SeedAccount(phoneNumber string, balance int) *Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account := s.createAccountLocked(phoneNumber)
+	account.Balance = balance
+	account.LifetimePoints = balance
+	copied := *account
+	return &copied
+}
+
+// RecordPOSEvent simulates points earned at a Square POS for an account
+func (s *Server) RecordPOSEvent(accountID string, points int) (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, exists := s.accounts[accountID]
+	if !exists {
+		return nil, fmt.Errorf("account %s not found", accountID)
+	}
+
+	event := s.addEventLocked(account, &Event{Type: "ACCUMULATE_POINTS", Points: points, Source: "SQUARE"})
+	copied := *event
+	return &copied, nil
+}
+
+// Account returns a copy of an account
+func (s *Server) Account(accountID string) (*Account, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, exists := s.accounts[accountID]
+	if !exists {
+		return nil, false
+	}
+	copied := *account
+	return &copied, true
+}
+
+// Accounts returns copies of all accounts
+func (s *Server) Accounts() []Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts := make([]Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, *account)
+	}
+	return accounts
+}
+
+// Events returns copies of all events, oldest first
+func (s *Server) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]Event, 0, len(s.events))
+	for _, event := range s.events {
+		events = append(events, *event)
+	}
+	return events
+}
+
+// ServeHTTP routes Square API requests to the fake handlers
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Not part of the Square API: lets offline clients create orders to earn points for
+	if r.URL.Path == "/fake/orders" && r.Method == http.MethodPost {
+		var order struct {
+			OrderID         string `json:"order_id"`
+			TotalCents      int    `json:"total_cents"`
+			PromotionPoints int    `json:"promotion_points"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&order); err != nil || order.OrderID == "" {
+			writeError(w, http.StatusBadRequest, "BAD_REQUEST", "order_id and total_cents are required")
+			return
+		}
+		s.orders[order.OrderID] = order.TotalCents
+		s.promotions[order.OrderID] = order.PromotionPoints
+		writeJSON(w, http.StatusOK, map[string]interface{}{"order_id": order.OrderID, "total_cents": order.TotalCents, "promotion_points": order.PromotionPoints})
+		return
+	}
+
+	// OAuth: the authorization page approves at once and redirects back with a code
+	switch {
+	case r.URL.Path == "/oauth2/authorize" && r.Method == http.MethodGet:
+		s.authorize(w, r)
+		return
+	case r.URL.Path == "/oauth2/token" && r.Method == http.MethodPost:
+		s.handle(w, r, "obtain_token", s.obtainToken)
+		return
+	case r.URL.Path == "/v2/locations" && r.Method == http.MethodGet:
+		s.handle(w, r, "list_locations", s.listLocations)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "v2" || parts[1] != "loyalty" {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "unknown endpoint "+r.URL.Path)
+		return
+	}
+
+	route := r.Method + " " + strings.Join(parts[2:], "/")
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[2] == "programs":
+		s.handle(w, r, "get_program", s.getProgram)
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[2] == "programs" && parts[4] == "calculate":
+		s.handle(w, r, "calculate", s.calculate)
+	case route == "POST accounts":
+		s.handle(w, r, "create_account", s.createAccount)
+	case route == "POST accounts/search":
+		s.handle(w, r, "search_accounts", s.searchAccounts)
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[2] == "accounts":
+		s.handle(w, r, "get_account", s.getAccount)
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[2] == "accounts" && parts[4] == "accumulate":
+		s.handle(w, r, "accumulate", s.accumulate)
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[2] == "accounts" && parts[4] == "adjust":
+		s.handle(w, r, "adjust", s.adjust)
+	case route == "POST rewards":
+		s.handle(w, r, "create_reward", s.createReward)
+	case route == "POST rewards/search":
+		s.handle(w, r, "search_rewards", s.searchRewards)
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[2] == "rewards" && parts[4] == "redeem":
+		s.handle(w, r, "redeem_reward", s.redeemReward)
+	case r.Method == http.MethodDelete && len(parts) == 4 && parts[2] == "rewards":
+		s.handle(w, r, "delete_reward", s.deleteReward)
+	case route == "POST events/search":
+		s.handle(w, r, "search_events", s.searchEvents)
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "unknown endpoint "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// handlerFunc handles a decoded request and returns the HTTP status and response body
+type handlerFunc func(r *http.Request, parts []string, body map[string]interface{}) (int, interface{})
+
+// handle applies failure injection and idempotency around an operation handler
+func (s *Server) handle(w http.ResponseWriter, r *http.Request, operation string, handler handlerFunc) {
+	if queued := s.failures[operation]; len(queued) > 0 {
+		s.failures[operation] = queued[1:]
+		writeError(w, queued[0], "INTERNAL_SERVER_ERROR", "injected failure for "+operation)
+		return
+	}
+
+	body := map[string]interface{}{}
+	if r.Body != nil && r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid JSON body")
+			return
+		}
+	}
+
+	key, _ := body["idempotency_key"].(string)
+	if key != "" {
+		if cached, exists := s.idempotency[operation+":"+key]; exists {
+			writeJSON(w, cached.status, cached.body)
+			return
+		}
+	}
+
+	status, response := handler(r, strings.Split(strings.Trim(r.URL.Path, "/"), "/"), body)
+	if key != "" && status < 300 {
+		s.idempotency[operation+":"+key] = cachedResponse{status: status, body: response}
+	}
+	writeJSON(w, status, response)
+}
+
+// authorize redirects to redirect_uri with a new authorization code and the caller's state
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("client_id") == "" || redirectURI == "" {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "client_id and redirect_uri are required")
+		return
+	}
+
+	code := s.newID("code")
+	s.authCodes[code] = true
+
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid redirect_uri")
+		return
+	}
+	values := target.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	target.RawQuery = values.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (s *Server) obtainToken(_ *http.Request, _ []string, body map[string]interface{}) (int, interface{}) {
+	switch stringAt(body, "grant_type") {
+	case "authorization_code":
+		code := stringAt(body, "code")
+		if !s.authCodes[code] {
+			return errorBody(http.StatusUnauthorized, "UNAUTHORIZED", "invalid authorization code")
+		}
+		delete(s.authCodes, code)
+	case "refresh_token":
+		if !s.refreshes[stringAt(body, "refresh_token")] {
+			return errorBody(http.StatusUnauthorized, "UNAUTHORIZED", "invalid refresh token")
+		}
+	default:
+		return errorBody(http.StatusBadRequest, "BAD_REQUEST", "unsupported grant_type")
+	}
+
+	refreshToken := stringAt(body, "refresh_token")
+	if refreshToken == "" {
+		refreshToken = s.newID("refresh")
+		s.refreshes[refreshToken] = true
+	}
+	return http.StatusOK, map[string]interface{}{
+		"access_token":  s.newID("access"),
+		"token_type":    "bearer",
+		"expires_at":    s.now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339),
+		"merchant_id":   MerchantID,
+		"refresh_token": refreshToken,
+	}
+}
+
+func (s *Server) listLocations(_ *http.Request, _ []string, _ map[string]interface{}) (int, interface{}) {
+	locations := []interface{}{}
+	for _, locationID := range s.locationIDs {
+		locations = append(locations, map[string]interface{}{
+			"id":          locationID,
+			"name":        locationID,
+			"status":      "ACTIVE",
+			"merchant_id": MerchantID,
+		})
+	}
+	return http.StatusOK, map[string]interface{}{"locations": locations}
+}
+
+func (s *Server) getProgram(_ *http.Request, parts []string, _ map[string]interface{}) (int, interface{}) {
+	if parts[3] != "main" && parts[3] != ProgramID {
+		return errorBody(http.StatusNotFound, "NOT_FOUND", "program not found")
+	}
+	return http.StatusOK, map[string]interface{}{"program": s.programJSON()}
+}
+
+func (s *Server) calculate(_ *http.Request, _ []string, body map[string]interface{}) (int, interface{}) {
+	orderID := stringAt(body, "order_id")
+	total, exists := s.orders[orderID]
+	if !exists {
+		return errorBody(http.StatusNotFound, "NOT_FOUND", "order not found")
+	}
+	return http.StatusOK, map[string]interface{}{"points": total / 100, "promotion_points": s.promotions[orderID]}
+}
+
+func (s *Server) createAccount(_ *http.Request, _ []string, body map[string]interface{}) (int, interface{}) {
+	phone := stringAt(body, "loyalty_account", "mapping", "phone_number")
+	if phone == "" {
+		return errorBody(http.StatusBadRequest, "MISSING_REQUIRED_PARAMETER", "loyalty_account.mapping.phone_number is required")
+	}
+
+	for _, account := range s.accounts {
+		if account.PhoneNumber == phone {
+			return errorBody(http.StatusConflict, "CONFLICT", "a loyalty account with this phone number already exists")
+		}
+	}
+
+	account := s.createAccountLocked(phone)
+	return http.StatusOK, map[string]interface{}{"loyalty_account": s.accountJSON(account)}
+}
+
+func (s *Server) searchAccounts(_ *http.Request, _ []string, body map[string]interface{}) (int, interface{}) {
+	phones := map[string]bool{}
+	if query, ok := body["query"].(map[string]interface{}); ok {
+		if mappings, ok := query["mappings"].([]interface{}); ok {
+			for _, mapping := range mappings {
+				if m, ok := mapping.(map[string]interface{}); ok {
+					if phone, ok := m["phone_number"].(string); ok {
+						phones[phone] = true
+					}
+				}
+			}
+		}
+	}
+
+	accounts := []interface{}{}
+	for _, account := range s.accounts {
+		if len(phones) == 0 || phones[account.PhoneNumber] {
+			accounts = append(accounts, s.accountJSON(account))
+		}
+	}
+	return http.StatusOK, map[string]interface{}{"loyalty_accounts": accounts}
+}
+
+func (s *Server) getAccount(_ *http.Request, parts []string, _ map[string]interface{}) (int, interface{}) {
+	account, exists := s.accounts[parts[3]]
+	if !exists {
+		return errorBody(http.StatusNotFound, "NOT_FOUND", "loyalty account not found")
+	}
+	return http.StatusOK, map[string]interface{}{"loyalty_account": s.accountJSON(account)}
+}
+
+func (s *Server) accumulate(_ *http.Request, parts []string, body map[string]interface{}) (int, interface{}) {
+	account, exists := s.accounts[parts[3]]
+	if !exists {
+		return errorBody(http.StatusNotFound, "NOT_FOUND", "loyalty account not found")
+	}
+
+	points := intAt(body, "accumulate_points", "points")
+	orderID := stringAt(body, "accumulate_points", "order_id")
+	if orderID != "" {
+		// Like Square, points are calculated from the order and returned as a list of events
+		if valueAt(body, "accumulate_points", "points") != nil {
+			return errorBody(http.StatusBadRequest, "BAD_REQUEST", "provide either points or order_id, not both")
+		}
+		total, exists := s.orders[orderID]
+		if !exists {
+			return errorBody(http.StatusNotFound, "NOT_FOUND", "order not found")
+		}
+		if s.credited[orderID] {
+			return errorBody(http.StatusBadRequest, "BAD_REQUEST", "points were already accumulated for this order")
+		}
+		if total/100 <= 0 && s.promotions[orderID] <= 0 {
+			return errorBody(http.StatusBadRequest, "BAD_REQUEST", "order does not earn any points")
+		}
+
+		s.credited[orderID] = true
+		events := []interface{}{}
+		if total/100 > 0 {
+			event := s.addEventLocked(account, &Event{
+				Type:       "ACCUMULATE_POINTS",
+				Points:     total / 100,
+				OrderID:    orderID,
+				LocationID: stringAt(body, "location_id"),
+				Source:     "LOYALTY_API",
+			})
+			events = append(events, s.eventJSON(event))
+		}
+		if promotion := s.promotions[orderID]; promotion > 0 {
+			event := s.addEventLocked(account, &Event{
+				Type:       "ACCUMULATE_PROMOTION_POINTS",
+				Points:     promotion,
+				OrderID:    orderID,
+				LocationID: stringAt(body, "location_id"),
+				Source:     "LOYALTY_API",
+			})
+			events = append(events, s.eventJSON(event))
+		}
+		return http.StatusOK, map[string]interface{}{"events": events}
+	}
+	if points <= 0 {
+		return errorBody(http.StatusBadRequest, "INVALID_VALUE", "accumulate_points.points must be positive")
+	}
+
+	event := s.addEventLocked(account, &Event{
+		Type:       "ACCUMULATE_POINTS",
+		Points:     points,
+		LocationID: stringAt(body, "location_id"),
+		Source:     "LOYALTY_API",
+	})
+	return http.StatusOK, map[string]interface{}{"event": s.eventJSON(event)}
+}
+
+func (s *Server) adjust(_ *http.Request, parts []string, body map[string]interface{}) (int, interface{}) {
+	account, exists := s.accounts[parts[3]]
+	if !exists {
+		return errorBody(http.StatusNotFound, "NOT_FOUND", "loyalty account not found")
+	}
+
+	points := intAt(body, "adjust_points", "points")
+	if points == 0 {
+		return errorBody(http.StatusBadRequest, "INVALID_VALUE", "adjust_points.points must not be zero")
+	}
+	if account.Balance+points < 0 && body["allow_negative_balance"] != true {
+		return errorBody(http.StatusBadRequest, "INSUFFICIENT_POINTS", "adjustment would make the balance negative")
+	}
+
+	event := s.addEventLocked(account, &Event{
+		Type:   "ADJUST_POINTS",
+		Points: points,
+		Reason: stringAt(body, "adjust_points", "reason"),
+		Source: "LOYALTY_API",
+	})
+	return http.StatusOK, map[string]interface{}{"event": s.eventJSON(event)}
+}
+
+func (s *Server) createReward(_ *http.Request, _ []string, body map[string]interface{}) (int, interface{}) {
+	account, exists := s.accounts[stringAt(body, "reward", "loyalty_account_id")]
+	if !exists {
+		return errorBody(http.StatusNotFound, "NOT_FOUND", "loyalty account not found")
+	}
+
+	tierID := stringAt(body, "reward", "reward_tier_id")
+	var tier *RewardTier
+	for i := range s.rewardTiers {
+		if s.rewardTiers[i].ID == tierID {
+			tier = &s.rewardTiers[i]
+		}
+	}
+	if tier == nil {
+		return errorBody(http.StatusNotFound, "NOT_FOUND", "reward tier not found")
+	}
+	if account.Balance < tier.Points {
+		return errorBody(http.StatusBadRequest, "INSUFFICIENT_POINTS", "not enough points for this reward")
+	}
+
+	reward := &Reward{
+		ID:           s.newID("reward"),
+		AccountID:    account.ID,
+		RewardTierID: tier.ID,
+		Points:       tier.Points,
+		OrderID:      stringAt(body, "reward", "order_id"),
+		Status:       "ISSUED",
+		CreatedAt:    s.now().UTC(),
+	}
+	s.rewards[reward.ID] = reward
+	s.addEventLocked(account, &Event{Type: "CREATE_REWARD", Points: -tier.Points, RewardID: reward.ID, Source: "LOYALTY_API"})
+
+	return http.StatusOK, map[string]interface{}{"reward": rewardJSON(reward)}
+}
+
+func (s *Server) searchRewards(_ *http.Request, _ []string, body map[string]interface{}) (int, interface{}) {
+	accountID := stringAt(body, "query", "loyalty_account_id")
+	status := stringAt(body, "query", "status")
+
+	// Square returns rewards by descending updated_at; the fake never updates the timestamp
+	rewards := []interface{}{}
+	for _, reward := range s.rewards {
+		if (accountID == "" || reward.AccountID == accountID) && (status == "" || reward.Status == status) {
+			rewards = append(rewards, rewardJSON(reward))
+		}
+	}
+	sort.Slice(rewards, func(i, j int) bool {
+		a, b := rewards[i].(map[string]interface{}), rewards[j].(map[string]interface{})
+		return a["id"].(string) > b["id"].(string)
+	})
+	return http.StatusOK, map[string]interface{}{"rewards": rewards}
+}
+
+func (s *Server) redeemReward(_ *http.Request, parts []string, body map[string]interface{}) (int, interface{}) {
+	reward, exists := s.rewards[parts[3]]
+	if !exists {
+		return errorBody(http.StatusNotFound, "NOT_FOUND", "reward not found")
+	}
+	if reward.Status != "ISSUED" {
+		return errorBody(http.StatusBadRequest, "BAD_REQUEST", "reward is not in ISSUED state")
+	}
+
+	reward.Status = "REDEEMED"
+	event := s.addEventLocked(s.accounts[reward.AccountID], &Event{
+		Type:       "REDEEM_REWARD",
+		RewardID:   reward.ID,
+		OrderID:    reward.OrderID,
+		LocationID: stringAt(body, "location_id"),
+		Source:     "LOYALTY_API",
+	})
+	return http.StatusOK, map[string]interface{}{"event": s.eventJSON(event)}
+}
+
+func (s *Server) deleteReward(_ *http.Request, parts []string, _ map[string]interface{}) (int, interface{}) {
+	reward, exists := s.rewards[parts[3]]
+	if !exists {
+		return errorBody(http.StatusNotFound, "NOT_FOUND", "reward not found")
+	}
+	if reward.Status != "ISSUED" {
+		return errorBody(http.StatusBadRequest, "BAD_REQUEST", "reward is not in ISSUED state")
+	}
+
+	reward.Status = "DELETED"
+	s.addEventLocked(s.accounts[reward.AccountID], &Event{Type: "DELETE_REWARD", Points: reward.Points, RewardID: reward.ID, Source: "LOYALTY_API"})
+	return http.StatusOK, map[string]interface{}{}
+}
+
+func (s *Server) searchEvents(_ *http.Request, _ []string, body map[string]interface{}) (int, interface{}) {
+	accountID := stringAt(body, "query", "filter", "loyalty_account_filter", "loyalty_account_id")
+	types := stringsAt(body, "query", "filter", "type_filter", "types")
+	locationIDs := stringsAt(body, "query", "filter", "location_filter", "location_ids")
+	var startAt, endAt time.Time
+	if value := stringAt(body, "query", "filter", "date_time_filter", "created_at", "start_at"); value != "" {
+		startAt, _ = time.Parse(time.RFC3339, value)
+	}
+	if value := stringAt(body, "query", "filter", "date_time_filter", "created_at", "end_at"); value != "" {
+		endAt, _ = time.Parse(time.RFC3339, value)
+	}
+
+	matched := []*Event{}
+	for _, event := range s.events {
+		if accountID != "" && event.AccountID != accountID {
+			continue
+		}
+		if len(types) > 0 && !contains(types, event.Type) {
+			continue
+		}
+		if len(locationIDs) > 0 && !contains(locationIDs, event.LocationID) {
+			continue
+		}
+		if (!startAt.IsZero() && event.CreatedAt.Before(startAt)) || (!endAt.IsZero() && !event.CreatedAt.Before(endAt)) {
+			continue
+		}
+		matched = append(matched, event)
+	}
+
+	// Square returns the newest events first
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+
+	limit := intAt(body, "limit")
+	if limit <= 0 || limit > 30 {
+		limit = 30
+	}
+	offset := 0
+	if cursor, ok := body["cursor"].(string); ok && cursor != "" {
+		parsed, err := strconv.Atoi(cursor)
+		if err != nil || parsed < 0 {
+			return errorBody(http.StatusBadRequest, "INVALID_CURSOR", "invalid cursor")
+		}
+		offset = parsed
+	}
+
+	response := map[string]interface{}{}
+	events := []interface{}{}
+	for i := offset; i < len(matched) && i < offset+limit; i++ {
+		events = append(events, s.eventJSON(matched[i]))
+	}
+	response["events"] = events
+	if offset+limit < len(matched) {
+		response["cursor"] = strconv.Itoa(offset + limit)
+	}
+	return http.StatusOK, response
+}
+
+func (s *Server) createAccountLocked(phoneNumber string) *Account {
+	now := s.now().UTC()
+	account := &Account{
+		ID:          s.newID("account"),
+		PhoneNumber: phoneNumber,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	s.accounts[account.ID] = account
+	return account
+}
+
+// addEventLocked records an event and applies it to the account balance
+func (s *Server) addEventLocked(account *Account, event *Event) *Event {
+	event.ID = s.newID("event")
+	event.AccountID = account.ID
+	event.CreatedAt = s.now().UTC()
+	if event.LocationID == "" && event.Source == "SQUARE" {
+		event.LocationID = s.locationIDs[0]
+	}
+
+	account.Balance += event.Points
+	if event.Points > 0 && event.Type != "DELETE_REWARD" {
+		account.LifetimePoints += event.Points
+	}
+	account.UpdatedAt = event.CreatedAt
+
+	s.events = append(s.events, event)
+	return event
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%06d", prefix, s.nextID)
+}
+
+func (s *Server) programJSON() map[string]interface{} {
+	tiers := []interface{}{}
+	for _, tier := range s.rewardTiers {
+		tiers = append(tiers, map[string]interface{}{
+			"id":     tier.ID,
+			"name":   tier.Name,
+			"points": tier.Points,
+		})
+	}
+
+	return map[string]interface{}{
+		"id":           ProgramID,
+		"status":       "ACTIVE",
+		"reward_tiers": tiers,
+		"terminology":  map[string]interface{}{"one": "Point", "other": "Points"},
+		"location_ids": s.locationIDs,
+		"accrual_rules": []interface{}{
+			map[string]interface{}{
+				"accrual_type": "SPEND",
+				"points":       1,
+				"spend_data": map[string]interface{}{
+					"amount_money": map[string]interface{}{"amount": 100, "currency": "USD"},
+					"tax_mode":     "BEFORE_TAX",
+				},
+			},
+		},
+		"expiration_policy": map[string]interface{}{"expiration_duration": "P365D"},
+		"created_at":        "2024-01-01T00:00:00Z",
+		"updated_at":        "2024-01-01T00:00:00Z",
+	}
+}
+
+func (s *Server) accountJSON(account *Account) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              account.ID,
+		"program_id":      ProgramID,
+		"balance":         account.Balance,
+		"lifetime_points": account.LifetimePoints,
+		"mapping": map[string]interface{}{
+			"id":           "mapping-" + account.ID,
+			"phone_number": account.PhoneNumber,
+			"created_at":   account.CreatedAt.Format(time.RFC3339),
+		},
+		"enrolled_at": account.CreatedAt.Format(time.RFC3339),
+		"created_at":  account.CreatedAt.Format(time.RFC3339),
+		"updated_at":  account.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+func (s *Server) eventJSON(event *Event) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":                 event.ID,
+		"type":               event.Type,
+		"created_at":         event.CreatedAt.Format(time.RFC3339),
+		"loyalty_account_id": event.AccountID,
+		"source":             event.Source,
+	}
+	if event.LocationID != "" {
+		result["location_id"] = event.LocationID
+	}
+
+	switch event.Type {
+	case "ACCUMULATE_POINTS":
+		data := map[string]interface{}{"loyalty_program_id": ProgramID, "points": event.Points}
+		if event.OrderID != "" {
+			data["order_id"] = event.OrderID
+		}
+		result["accumulate_points"] = data
+	case "ACCUMULATE_PROMOTION_POINTS":
+		result["accumulate_promotion_points"] = map[string]interface{}{
+			"loyalty_program_id":   ProgramID,
+			"loyalty_promotion_id": PromotionID,
+			"points":               event.Points,
+			"order_id":             event.OrderID,
+		}
+	case "ADJUST_POINTS":
+		data := map[string]interface{}{"loyalty_program_id": ProgramID, "points": event.Points}
+		if event.Reason != "" {
+			data["reason"] = event.Reason
+		}
+		result["adjust_points"] = data
+	case "CREATE_REWARD":
+		result["create_reward"] = map[string]interface{}{"loyalty_program_id": ProgramID, "reward_id": event.RewardID, "points": event.Points}
+	case "REDEEM_REWARD":
+		data := map[string]interface{}{"loyalty_program_id": ProgramID, "reward_id": event.RewardID}
+		if event.OrderID != "" {
+			data["order_id"] = event.OrderID
+		}
+		result["redeem_reward"] = data
+	case "DELETE_REWARD":
+		result["delete_reward"] = map[string]interface{}{"loyalty_program_id": ProgramID, "reward_id": event.RewardID, "points": event.Points}
+	}
+	return result
+}
+
+func rewardJSON(reward *Reward) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":                 reward.ID,
+		"status":             reward.Status,
+		"loyalty_account_id": reward.AccountID,
+		"reward_tier_id":     reward.RewardTierID,
+		"points":             reward.Points,
+		"created_at":         reward.CreatedAt.Format(time.RFC3339),
+		"updated_at":         reward.CreatedAt.Format(time.RFC3339),
+	}
+	if reward.OrderID != "" {
+		result["order_id"] = reward.OrderID
+	}
+	return result
+}
+
+// stringAt reads a nested string value from a decoded JSON object
+func stringAt(body map[string]interface{}, path ...string) string {
+	value, _ := valueAt(body, path...).(string)
+	return value
+}
+
+// stringsAt reads a nested array of strings from a decoded JSON object
+func stringsAt(body map[string]interface{}, path ...string) []string {
+	values, _ := valueAt(body, path...).([]interface{})
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// intAt reads a nested number value from a decoded JSON object
+func intAt(body map[string]interface{}, path ...string) int {
+	value, _ := valueAt(body, path...).(float64)
+	return int(value)
+}
+
+func valueAt(body map[string]interface{}, path ...string) interface{} {
+	var current interface{} = body
+	for _, key := range path {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[key]
+	}
+	return current
+}
+
+func errorBody(status int, code, detail string) (int, interface{}) {
+	return status, map[string]interface{}{
+		"errors": []interface{}{
+			map[string]interface{}{
+				"category": "INVALID_REQUEST_ERROR",
+				"code":     code,
+				"detail":   detail,
+			},
+		},
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, detail string) {
+	_, body := errorBody(status, code, detail)
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
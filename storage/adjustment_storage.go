@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"errors"
+	"loyalty-core/models"
+	"sort"
+	"sync"
+)
+
+// AdjustmentStorage provides in-memory storage of admin point adjustments
+type AdjustmentStorage struct {
+	adjustments map[string]*models.Adjustment // adjustmentID -> adjustment
+	mu          sync.RWMutex
+}
+
+// NewAdjustmentStorage creates a new adjustment storage instance
+func NewAdjustmentStorage() *AdjustmentStorage {
+	return &AdjustmentStorage{
+		adjustments: make(map[string]*models.Adjustment),
+	}
+}
+
+// SaveAdjustment adds or replaces an adjustment
+func (as *AdjustmentStorage) SaveAdjustment(adjustment models.Adjustment) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	adjustment.MerchantID = models.MerchantIDOrDefault(adjustment.MerchantID)
+	as.adjustments[adjustment.ID] = &adjustment
+}
+
+// GetAdjustment retrieves a copy of a merchant's adjustment
+func (as *AdjustmentStorage) GetAdjustment(merchantID, adjustmentID string) (*models.Adjustment, error) {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	adjustment, exists := as.adjustments[adjustmentID]
+	if !exists || adjustment.MerchantID != models.MerchantIDOrDefault(merchantID) {
+		return nil, errors.New("adjustment not found")
+	}
+
+	copied := *adjustment
+	return &copied, nil
+}
+
+// ListAdjustments returns copies of a merchant's adjustments, newest first. Empty userID and
+// status match all.
+func (as *AdjustmentStorage) ListAdjustments(merchantID, userID, status string) []models.Adjustment {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	merchantID = models.MerchantIDOrDefault(merchantID)
+	adjustments := []models.Adjustment{}
+	for _, adjustment := range as.adjustments {
+		if adjustment.MerchantID != merchantID ||
+			(userID != "" && adjustment.UserID != userID) ||
+			(status != "" && adjustment.Status != status) {
+			continue
+		}
+		adjustments = append(adjustments, *adjustment)
+	}
+
+	sort.Slice(adjustments, func(i, j int) bool {
+		return adjustments[i].RequestedAt.After(adjustments[j].RequestedAt)
+	})
+	return adjustments
+}
+
+// Global adjustment storage instance
+var globalAdjustmentStorage *AdjustmentStorage
+
+// GetGlobalAdjustmentStorage returns the global adjustment storage instance
+func GetGlobalAdjustmentStorage() *AdjustmentStorage {
+	if globalAdjustmentStorage == nil {
+		globalAdjustmentStorage = NewAdjustmentStorage()
+	}
+	return globalAdjustmentStorage
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"loyalty-core/models"
+	"sync"
+)
+
+// AuditStorage provides in-memory, append-only storage of the audit log. Entries can be added
+// and read but never changed or removed.
+type AuditStorage struct {
+	entries []models.AuditEntry // oldest first
+	mu      sync.RWMutex
+}
+
+// NewAuditStorage creates a new audit storage instance
+func NewAuditStorage() *AuditStorage {
+	return &AuditStorage{}
+}
+
+// Append links an entry to the end of the chain, setting its sequence, previous hash and hash,
+// and returns it as stored
+func (as *AuditStorage) Append(entry models.AuditEntry) models.AuditEntry {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	entry.Sequence = int64(len(as.entries)) + 1
+	entry.PrevHash = ""
+	if len(as.entries) > 0 {
+		entry.PrevHash = as.entries[len(as.entries)-1].Hash
+	}
+	entry.Hash = entry.ComputeHash()
+
+	as.entries = append(as.entries, entry)
+	return entry
+}
+
+// Restore appends entries read back from the audit log file as they are, without relinking
+// them. It is only used at startup, before any entry is appended.
+func (as *AuditStorage) Restore(entries []models.AuditEntry) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	as.entries = append(as.entries, entries...)
+}
+
+// Entries returns a copy of all entries, oldest first
+func (as *AuditStorage) Entries() []models.AuditEntry {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	entries := make([]models.AuditEntry, len(as.entries))
+	copy(entries, as.entries)
+	return entries
+}
+
+// Global audit storage instance
+var globalAuditStorage *AuditStorage
+
+// GetGlobalAuditStorage returns the global audit storage instance
+func GetGlobalAuditStorage() *AuditStorage {
+	if globalAuditStorage == nil {
+		globalAuditStorage = NewAuditStorage()
+	}
+	return globalAuditStorage
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"errors"
+	"loyalty-core/models"
+	"sort"
+	"sync"
+)
+
+// LocationStorage provides in-memory storage of each merchant's locations and their rules
+type LocationStorage struct {
+	locations map[string]map[string]*models.Location // merchantID -> locationID -> location
+	mu        sync.RWMutex
+}
+
+// NewLocationStorage creates a new location storage instance
+func NewLocationStorage() *LocationStorage {
+	return &LocationStorage{
+		locations: make(map[string]map[string]*models.Location),
+	}
+}
+
+// SaveLocation adds or replaces a location of the location's merchant
+func (ls *LocationStorage) SaveLocation(location models.Location) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	location.MerchantID = models.MerchantIDOrDefault(location.MerchantID)
+	if ls.locations[location.MerchantID] == nil {
+		ls.locations[location.MerchantID] = make(map[string]*models.Location)
+	}
+	ls.locations[location.MerchantID][location.ID] = &location
+}
+
+// GetLocation retrieves a copy of a merchant's location
+func (ls *LocationStorage) GetLocation(merchantID, locationID string) (*models.Location, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	location, exists := ls.locations[models.MerchantIDOrDefault(merchantID)][locationID]
+	if !exists {
+		return nil, errors.New("location not found")
+	}
+
+	copied := *location
+	return &copied, nil
+}
+
+// ListLocations returns copies of a merchant's locations, sorted by ID
+func (ls *LocationStorage) ListLocations(merchantID string) []models.Location {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	locations := []models.Location{}
+	for _, location := range ls.locations[models.MerchantIDOrDefault(merchantID)] {
+		locations = append(locations, *location)
+	}
+
+	sort.Slice(locations, func(i, j int) bool {
+		return locations[i].ID < locations[j].ID
+	})
+	return locations
+}
+
+// Global location storage instance
+var globalLocationStorage *LocationStorage
+
+// GetGlobalLocationStorage returns the global location storage instance
+func GetGlobalLocationStorage() *LocationStorage {
+	if globalLocationStorage == nil {
+		globalLocationStorage = NewLocationStorage()
+	}
+	return globalLocationStorage
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"errors"
+	"loyalty-core/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MerchantStorage provides in-memory storage of merchants, their encrypted Square credentials
+// and pending OAuth authorizations
+type MerchantStorage struct {
+	merchants   map[string]*models.Merchant            // merchantID -> merchant
+	credentials map[string]*models.MerchantCredentials // merchantID -> credentials
+	states      map[string]*models.OAuthState          // state -> authorization request
+	mu          sync.RWMutex
+}
+
+// NewMerchantStorage creates a new merchant storage instance
+func NewMerchantStorage() *MerchantStorage {
+	return &MerchantStorage{
+		merchants:   make(map[string]*models.Merchant),
+		credentials: make(map[string]*models.MerchantCredentials),
+		states:      make(map[string]*models.OAuthState),
+	}
+}
+
+// CreateMerchant stores a new merchant. Hosts must not belong to another merchant.
+func (ms *MerchantStorage) CreateMerchant(merchant *models.Merchant) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, exists := ms.merchants[merchant.ID]; exists {
+		return errors.New("merchant already exists")
+	}
+	for _, host := range merchant.Hosts {
+		if ms.merchantByHostLocked(host) != nil {
+			return errors.New("host " + host + " already belongs to another merchant")
+		}
+	}
+
+	copied := *merchant
+	ms.merchants[merchant.ID] = &copied
+	return nil
+}
+
+// GetMerchant retrieves a copy of a merchant by ID
+func (ms *MerchantStorage) GetMerchant(merchantID string) (*models.Merchant, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	merchant, exists := ms.merchants[merchantID]
+	if !exists {
+		return nil, errors.New("merchant not found")
+	}
+
+	copied := *merchant
+	return &copied, nil
+}
+
+// GetMerchantByHost retrieves a copy of the merchant serving a request host (port ignored)
+func (ms *MerchantStorage) GetMerchantByHost(host string) (*models.Merchant, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	merchant := ms.merchantByHostLocked(host)
+	if merchant == nil {
+		return nil, errors.New("merchant not found")
+	}
+
+	copied := *merchant
+	return &copied, nil
+}
+
+// GetMerchantBySquareMerchantID retrieves a copy of the merchant connected to a Square seller
+func (ms *MerchantStorage) GetMerchantBySquareMerchantID(squareMerchantID string) (*models.Merchant, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, merchant := range ms.merchants {
+		if squareMerchantID != "" && merchant.SquareMerchantID == squareMerchantID {
+			copied := *merchant
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("merchant not found")
+}
+
+// ListMerchants returns copies of all merchants, oldest first
+func (ms *MerchantStorage) ListMerchants() []models.Merchant {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	merchants := []models.Merchant{}
+	for _, merchant := range ms.merchants {
+		merchants = append(merchants, *merchant)
+	}
+
+	sort.Slice(merchants, func(i, j int) bool {
+		return merchants[i].CreatedAt.Before(merchants[j].CreatedAt)
+	})
+	return merchants
+}
+
+// UpdateMerchant replaces an existing merchant
+func (ms *MerchantStorage) UpdateMerchant(merchant *models.Merchant) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, exists := ms.merchants[merchant.ID]; !exists {
+		return errors.New("merchant not found")
+	}
+
+	copied := *merchant
+	ms.merchants[merchant.ID] = &copied
+	return nil
+}
+
+// SaveCredentials stores a merchant's encrypted Square credentials
+func (ms *MerchantStorage) SaveCredentials(credentials models.MerchantCredentials) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.credentials[credentials.MerchantID] = &credentials
+}
+
+// GetCredentials retrieves a copy of a merchant's encrypted Square credentials
+func (ms *MerchantStorage) GetCredentials(merchantID string) (*models.MerchantCredentials, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	credentials, exists := ms.credentials[merchantID]
+	if !exists {
+		return nil, errors.New("merchant credentials not found")
+	}
+
+	copied := *credentials
+	return &copied, nil
+}
+
+// AddOAuthState records a pending OAuth authorization
+func (ms *MerchantStorage) AddOAuthState(state models.OAuthState) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.states[state.State] = &state
+}
+
+// ConsumeOAuthState removes and returns a pending OAuth authorization. Each state can be used
+// once; expired states are rejected.
+func (ms *MerchantStorage) ConsumeOAuthState(state string) (*models.OAuthState, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	pending, exists := ms.states[state]
+	if !exists {
+		return nil, errors.New("unknown or already used OAuth state")
+	}
+	delete(ms.states, state)
+
+	if time.Now().After(pending.ExpiresAt) {
+		return nil, errors.New("OAuth authorization has expired")
+	}
+	return pending, nil
+}
+
+func (ms *MerchantStorage) merchantByHostLocked(host string) *models.Merchant {
+	host = normalizeHost(host)
+	for _, merchant := range ms.merchants {
+		for _, merchantHost := range merchant.Hosts {
+			if host != "" && normalizeHost(merchantHost) == host {
+				return merchant
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeHost lowercases a host and strips its port
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	return host
+}
+
+// Global merchant storage instance
+var globalMerchantStorage *MerchantStorage
+
+// GetGlobalMerchantStorage returns the global merchant storage instance
+func GetGlobalMerchantStorage() *MerchantStorage {
+	if globalMerchantStorage == nil {
+		globalMerchantStorage = NewMerchantStorage()
+	}
+	return globalMerchantStorage
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"errors"
+	"loyalty-core/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutboxStorage provides in-memory storage for pending Square writes
+type OutboxStorage struct {
+	items map[string]*models.OutboxItem // itemID -> item
+	mu    sync.RWMutex
+}
+
+// NewOutboxStorage creates a new outbox storage instance
+func NewOutboxStorage() *OutboxStorage {
+	return &OutboxStorage{
+		items: make(map[string]*models.OutboxItem),
+	}
+}
+
+// AddItem stores a new outbox item
+func (ob *OutboxStorage) AddItem(item models.OutboxItem) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.items[item.ID] = &item
+}
+
+// GetItem retrieves a copy of an outbox item by ID
+func (ob *OutboxStorage) GetItem(itemID string) (*models.OutboxItem, error) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	item, exists := ob.items[itemID]
+	if !exists {
+		return nil, errors.New("outbox item not found")
+	}
+
+	copied := *item
+	return &copied, nil
+}
+
+// UpdateItem replaces an existing outbox item
+func (ob *OutboxStorage) UpdateItem(item models.OutboxItem) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if _, exists := ob.items[item.ID]; !exists {
+		return errors.New("outbox item not found")
+	}
+
+	ob.items[item.ID] = &item
+	return nil
+}
+
+// ListItems returns copies of all items with the given status (all items if status is empty),
+// oldest first
+func (ob *OutboxStorage) ListItems(status string) []models.OutboxItem {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	items := []models.OutboxItem{}
+	for _, item := range ob.items {
+		if status == "" || item.Status == status {
+			items = append(items, *item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreatedAt.Before(items[j].CreatedAt)
+	})
+	return items
+}
+
+// DueItems returns pending items whose next attempt is due, oldest first
+func (ob *OutboxStorage) DueItems(now time.Time) []models.OutboxItem {
+	due := []models.OutboxItem{}
+	for _, item := range ob.ListItems(models.OutboxStatusPending) {
+		if !item.NextAttemptAt.After(now) {
+			due = append(due, item)
+		}
+	}
+	return due
+}
+
+// HasUndelivered reports whether a user has outbox items that have not reached Square
+func (ob *OutboxStorage) HasUndelivered(userID string) bool {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	for _, item := range ob.items {
+		if item.UserID == userID && item.Status != models.OutboxStatusDelivered {
+			return true
+		}
+	}
+	return false
+}
+
+// Global outbox storage instance
+var globalOutboxStorage *OutboxStorage
+
+// GetGlobalOutboxStorage returns the global outbox storage instance
+func GetGlobalOutboxStorage() *OutboxStorage {
+	if globalOutboxStorage == nil {
+		globalOutboxStorage = NewOutboxStorage()
+	}
+	return globalOutboxStorage
+}
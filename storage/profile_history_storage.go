@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"loyalty-core/models"
+	"sync"
+)
+
+// ProfileHistoryStorage provides in-memory, append-only storage for profile changes
+type ProfileHistoryStorage struct {
+	changes map[string][]models.ProfileChange // userID -> changes, oldest first
+	mu      sync.RWMutex
+}
+
+// NewProfileHistoryStorage creates a new profile history storage instance
+func NewProfileHistoryStorage() *ProfileHistoryStorage {
+	return &ProfileHistoryStorage{
+		changes: make(map[string][]models.ProfileChange),
+	}
+}
+
+// Append records profile changes for a user
+func (ps *ProfileHistoryStorage) Append(changes ...models.ProfileChange) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, change := range changes {
+		ps.changes[change.UserID] = append(ps.changes[change.UserID], change)
+	}
+}
+
+// GetByUserID returns a copy of a user's profile changes, oldest first
+func (ps *ProfileHistoryStorage) GetByUserID(userID string) []models.ProfileChange {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	changes := make([]models.ProfileChange, len(ps.changes[userID]))
+	copy(changes, ps.changes[userID])
+	return changes
+}
+
+// Redact replaces the old and new values of every change recorded for a user
+func (ps *ProfileHistoryStorage) Redact(userID, replacement string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for i := range ps.changes[userID] {
+		ps.changes[userID][i].OldValue = replacement
+		ps.changes[userID][i].NewValue = replacement
+	}
+}
+
+// Global profile history storage instance
+var globalProfileHistoryStorage *ProfileHistoryStorage
+
+// GetGlobalProfileHistoryStorage returns the global profile history storage instance
+func GetGlobalProfileHistoryStorage() *ProfileHistoryStorage {
+	if globalProfileHistoryStorage == nil {
+		globalProfileHistoryStorage = NewProfileHistoryStorage()
+	}
+	return globalProfileHistoryStorage
+}
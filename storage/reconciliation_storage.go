@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"loyalty-core/models"
+	"sort"
+	"sync"
+)
+
+// ReconciliationStorage provides in-memory storage of reconciliation reports
+type ReconciliationStorage struct {
+	reports map[string]*models.ReconciliationReport // reportID -> report
+	mu      sync.RWMutex
+}
+
+// NewReconciliationStorage creates a new reconciliation storage instance
+func NewReconciliationStorage() *ReconciliationStorage {
+	return &ReconciliationStorage{
+		reports: make(map[string]*models.ReconciliationReport),
+	}
+}
+
+// AddReport stores a completed report
+func (rs *ReconciliationStorage) AddReport(report models.ReconciliationReport) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.reports[report.ID] = &report
+}
+
+// GetReport retrieves a copy of a report by ID
+func (rs *ReconciliationStorage) GetReport(reportID string) (*models.ReconciliationReport, error) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	report, exists := rs.reports[reportID]
+	if !exists {
+		return nil, errors.New("reconciliation report not found")
+	}
+
+	copied := *report
+	return &copied, nil
+}
+
+// ListReports returns copies of all reports, newest first
+func (rs *ReconciliationStorage) ListReports() []models.ReconciliationReport {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	reports := []models.ReconciliationReport{}
+	for _, report := range rs.reports {
+		reports = append(reports, *report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].StartedAt.After(reports[j].StartedAt)
+	})
+	return reports
+}
+
+// Global reconciliation storage instance
+var globalReconciliationStorage *ReconciliationStorage
+
+// GetGlobalReconciliationStorage returns the global reconciliation storage instance
+func GetGlobalReconciliationStorage() *ReconciliationStorage {
+	if globalReconciliationStorage == nil {
+		globalReconciliationStorage = NewReconciliationStorage()
+	}
+	return globalReconciliationStorage
+}
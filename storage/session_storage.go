@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"loyalty-core/models"
+	"sync"
+	"time"
+)
+
+// SessionStorage provides in-memory storage for login sessions
+type SessionStorage struct {
+	sessions map[string]*models.Session // sessionID -> Session
+	mu       sync.RWMutex
+}
+
+// NewSessionStorage creates a new session storage instance
+func NewSessionStorage() *SessionStorage {
+	return &SessionStorage{
+		sessions: make(map[string]*models.Session),
+	}
+}
+
+// CreateSession records a new session
+func (ss *SessionStorage) CreateSession(session *models.Session) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.sessions[session.ID] = session
+}
+
+// GetSession retrieves a session by ID
+func (ss *SessionStorage) GetSession(sessionID string) (*models.Session, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	session, exists := ss.sessions[sessionID]
+	if !exists {
+		return nil, errors.New("session not found")
+	}
+
+	copied := *session
+	return &copied, nil
+}
+
+// GetSessionsByUserID returns copies of all sessions belonging to a user
+func (ss *SessionStorage) GetSessionsByUserID(userID string) []models.Session {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	sessions := []models.Session{}
+	for _, session := range ss.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions
+}
+
+// RevokeUserSessions marks every active session of a user as revoked
+func (ss *SessionStorage) RevokeUserSessions(userID string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	now := time.Now()
+	for _, session := range ss.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+}
+
+// RevokeOtherUserSessions marks every active session of a user except keepSessionID as revoked
+func (ss *SessionStorage) RevokeOtherUserSessions(userID, keepSessionID string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	now := time.Now()
+	for _, session := range ss.sessions {
+		if session.UserID == userID && session.ID != keepSessionID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+}
+
+// Global session storage instance
+var globalSessionStorage *SessionStorage
+
+// GetGlobalSessionStorage returns the global session storage instance
+func GetGlobalSessionStorage() *SessionStorage {
+	if globalSessionStorage == nil {
+		globalSessionStorage = NewSessionStorage()
+	}
+	return globalSessionStorage
+}
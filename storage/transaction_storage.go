@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"loyalty-core/models"
+	"sync"
+)
+
+// TransactionStorage provides in-memory storage for the local points ledger, kept separately
+// for each merchant
+type TransactionStorage struct {
+	transactions map[string]map[string][]models.Transaction // merchantID -> userID -> transactions, oldest first
+	mu           sync.RWMutex
+}
+
+// NewTransactionStorage creates a new transaction storage instance
+func NewTransactionStorage() *TransactionStorage {
+	return &TransactionStorage{
+		transactions: make(map[string]map[string][]models.Transaction),
+	}
+}
+
+// AddTransaction appends a transaction to the user's ledger within the transaction's merchant
+func (ts *TransactionStorage) AddTransaction(transaction models.Transaction) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	transaction.MerchantID = models.MerchantIDOrDefault(transaction.MerchantID)
+	ledgers := ts.transactions[transaction.MerchantID]
+	if ledgers == nil {
+		ledgers = make(map[string][]models.Transaction)
+		ts.transactions[transaction.MerchantID] = ledgers
+	}
+	ledgers[transaction.UserID] = append(ledgers[transaction.UserID], transaction)
+}
+
+// GetTransactionsByUserID returns a copy of the user's ledger within a merchant, oldest first
+func (ts *TransactionStorage) GetTransactionsByUserID(merchantID, userID string) []models.Transaction {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	ledger := ts.transactions[models.MerchantIDOrDefault(merchantID)][userID]
+	transactions := make([]models.Transaction, len(ledger))
+	copy(transactions, ledger)
+	return transactions
+}
+
+// GetMerchantTransactions returns a copy of the ledgers of all of a merchant's users
+func (ts *TransactionStorage) GetMerchantTransactions(merchantID string) []models.Transaction {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	transactions := []models.Transaction{}
+	for _, ledger := range ts.transactions[models.MerchantIDOrDefault(merchantID)] {
+		transactions = append(transactions, ledger...)
+	}
+	return transactions
+}
+
+// GetTransactionBySquareEventID finds the transaction recorded for a Square loyalty event
+func (ts *TransactionStorage) GetTransactionBySquareEventID(merchantID, userID, eventID string) (*models.Transaction, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	for _, transaction := range ts.transactions[models.MerchantIDOrDefault(merchantID)][userID] {
+		if transaction.SquareEventID == eventID {
+			found := transaction
+			return &found, true
+		}
+	}
+	return nil, false
+}
+
+// GetEarnTransactionByOrderID finds the transaction, of any of the merchant's users, that credited
+// points for an order
+func (ts *TransactionStorage) GetEarnTransactionByOrderID(merchantID, orderID string) (*models.Transaction, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	for _, transactions := range ts.transactions[models.MerchantIDOrDefault(merchantID)] {
+		for _, transaction := range transactions {
+			if transaction.OrderID == orderID && transaction.Type == models.TransactionTypeEarn {
+				found := transaction
+				return &found, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// SetSquareEventID links a ledger transaction to the Square event it was delivered as
+func (ts *TransactionStorage) SetSquareEventID(merchantID, userID, transactionID, eventID string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ledger := ts.transactions[models.MerchantIDOrDefault(merchantID)][userID]
+	for i := range ledger {
+		if ledger[i].ID == transactionID {
+			ledger[i].SquareEventID = eventID
+			return nil
+		}
+	}
+	return errors.New("transaction not found")
+}
+
+// RedactDescriptions replaces the description of every transaction of a user,
+// leaving IDs, amounts and timestamps intact
+func (ts *TransactionStorage) RedactDescriptions(merchantID, userID, replacement string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ledger := ts.transactions[models.MerchantIDOrDefault(merchantID)][userID]
+	for i := range ledger {
+		ledger[i].Description = replacement
+	}
+}
+
+// Global transaction storage instance
+var globalTransactionStorage *TransactionStorage
+
+// GetGlobalTransactionStorage returns the global transaction storage instance
+func GetGlobalTransactionStorage() *TransactionStorage {
+	if globalTransactionStorage == nil {
+		globalTransactionStorage = NewTransactionStorage()
+	}
+	return globalTransactionStorage
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"testing"
+
+	"loyalty-core/models"
+)
+
+func TestTransactionStorageIsolatesMerchants(t *testing.T) {
+	ts := NewTransactionStorage()
+	ts.AddTransaction(models.Transaction{ID: "txn-a", MerchantID: "brand-a", UserID: "user-1", Type: models.TransactionTypeEarn, Points: 10, OrderID: "order-1"})
+	ts.AddTransaction(models.Transaction{ID: "txn-b", MerchantID: "brand-b", UserID: "user-1", Type: models.TransactionTypeEarn, Points: 20})
+
+	// A user ID seen at two merchants still has one ledger per merchant
+	if ledger := ts.GetTransactionsByUserID("brand-a", "user-1"); len(ledger) != 1 || ledger[0].ID != "txn-a" {
+		t.Errorf("brand-a ledger = %+v, want only txn-a", ledger)
+	}
+	if ledger := ts.GetMerchantTransactions("brand-b"); len(ledger) != 1 || ledger[0].ID != "txn-b" {
+		t.Errorf("brand-b transactions = %+v, want only txn-b", ledger)
+	}
+	if ledger := ts.GetMerchantTransactions("brand-c"); len(ledger) != 0 {
+		t.Errorf("brand-c transactions = %+v, want none", ledger)
+	}
+
+	if _, found := ts.GetEarnTransactionByOrderID("brand-b", "order-1"); found {
+		t.Error("GetEarnTransactionByOrderID found another merchant's order")
+	}
+	if _, found := ts.GetEarnTransactionByOrderID("brand-a", "order-1"); !found {
+		t.Error("GetEarnTransactionByOrderID missed the merchant's own order")
+	}
+
+	if err := ts.SetSquareEventID("brand-b", "user-1", "txn-a", "event-1"); err == nil {
+		t.Error("SetSquareEventID updated another merchant's transaction")
+	}
+	if _, found := ts.GetTransactionBySquareEventID("brand-a", "user-1", "event-1"); found {
+		t.Error("transaction linked to an event through another merchant")
+	}
+
+	ts.RedactDescriptions("brand-b", "user-1", "[redacted]")
+	if ledger := ts.GetTransactionsByUserID("brand-a", "user-1"); ledger[0].Description == "[redacted]" {
+		t.Error("RedactDescriptions changed another merchant's ledger")
+	}
+}
+
+func TestTransactionStorageReturnsCopies(t *testing.T) {
+	ts := NewTransactionStorage()
+	ts.AddTransaction(models.Transaction{ID: "txn-a", MerchantID: "brand-a", UserID: "user-1", Points: 10})
+
+	ledger := ts.GetTransactionsByUserID("brand-a", "user-1")
+	ledger[0].MerchantID = "brand-b"
+	ledger[0].Points = 1000
+
+	if stored := ts.GetTransactionsByUserID("brand-a", "user-1"); stored[0].Points != 10 || stored[0].MerchantID != "brand-a" {
+		t.Errorf("stored transaction = %+v, changed through a returned copy", stored[0])
+	}
+}
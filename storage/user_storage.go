@@ -6,10 +6,11 @@ import (
 	"sync"
 )
 
-// UserStorage provides in-memory storage for users
+// UserStorage provides in-memory storage for users. Emails are unique per merchant, so the same
+// person can be a member of several brands with separate accounts.
 type UserStorage struct {
-	users        map[string]*models.User // userID -> User
-	usersByEmail map[string]*models.User // email -> User
+	users        map[string]*models.User            // userID -> User
+	usersByEmail map[string]map[string]*models.User // merchantID -> email -> User
 	mu           sync.RWMutex
 }
 
@@ -17,7 +18,7 @@ type UserStorage struct {
 func NewUserStorage() *UserStorage {
 	return &UserStorage{
 		users:        make(map[string]*models.User),
-		usersByEmail: make(map[string]*models.User),
+		usersByEmail: make(map[string]map[string]*models.User),
 	}
 }
 
@@ -26,13 +27,18 @@ func (us *UserStorage) CreateUser(user *models.User) error {
 	us.mu.Lock()
 	defer us.mu.Unlock()
 
+	user.MerchantID = models.MerchantIDOrDefault(user.MerchantID)
+
 	// Check if user already exists
-	if _, exists := us.usersByEmail[user.Email]; exists {
+	if _, exists := us.usersByEmail[user.MerchantID][user.Email]; exists {
 		return errors.New("user already exists")
 	}
 
+	if us.usersByEmail[user.MerchantID] == nil {
+		us.usersByEmail[user.MerchantID] = make(map[string]*models.User)
+	}
 	us.users[user.ID] = user
-	us.usersByEmail[user.Email] = user
+	us.usersByEmail[user.MerchantID][user.Email] = user
 	return nil
 }
 
@@ -49,12 +55,22 @@ func (us *UserStorage) GetUserByID(userID string) (*models.User, error) {
 	return user, nil
 }
 
-// GetUserByEmail retrieves a user by email
-func (us *UserStorage) GetUserByEmail(email string) (*models.User, error) {
+// GetMerchantUserByID retrieves a user by ID, as if no user existed when it belongs to another merchant
+func (us *UserStorage) GetMerchantUserByID(merchantID, userID string) (*models.User, error) {
+	user, err := us.GetUserByID(userID)
+	if err != nil || user.MerchantID != models.MerchantIDOrDefault(merchantID) {
+		return nil, errors.New("user not found")
+	}
+
+	return user, nil
+}
+
+// GetUserByEmail retrieves a merchant's user by email
+func (us *UserStorage) GetUserByEmail(merchantID, email string) (*models.User, error) {
 	us.mu.RLock()
 	defer us.mu.RUnlock()
 
-	user, exists := us.usersByEmail[email]
+	user, exists := us.usersByEmail[models.MerchantIDOrDefault(merchantID)][email]
 	if !exists {
 		return nil, errors.New("user not found")
 	}
@@ -62,21 +78,77 @@ func (us *UserStorage) GetUserByEmail(email string) (*models.User, error) {
 	return user, nil
 }
 
-// UpdateUser updates an existing user
+// GetUserBySquareAccountID retrieves the merchant's user linked to a Square loyalty account
+func (us *UserStorage) GetUserBySquareAccountID(merchantID, accountID string) (*models.User, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	merchantID = models.MerchantIDOrDefault(merchantID)
+	for _, user := range us.usersByEmail[merchantID] {
+		if accountID != "" && user.SquareAccountID == accountID {
+			return user, nil
+		}
+	}
+
+	return nil, errors.New("user not found")
+}
+
+// GetUserByLoyaltyID retrieves a merchant's user by their loyalty number
+func (us *UserStorage) GetUserByLoyaltyID(merchantID, loyaltyID string) (*models.User, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	for _, user := range us.usersByEmail[models.MerchantIDOrDefault(merchantID)] {
+		if loyaltyID != "" && user.LoyaltyID == loyaltyID {
+			return user, nil
+		}
+	}
+
+	return nil, errors.New("user not found")
+}
+
+// UpdateUser updates an existing user. A user cannot move to another merchant.
 func (us *UserStorage) UpdateUser(user *models.User) error {
 	us.mu.Lock()
 	defer us.mu.Unlock()
 
-	if _, exists := us.users[user.ID]; !exists {
+	existingUser, exists := us.users[user.ID]
+	if !exists {
 		return errors.New("user not found")
 	}
+	user.MerchantID = existingUser.MerchantID
+	usersByEmail := us.usersByEmail[user.MerchantID]
+
+	// Reject an email that belongs to another user of the merchant
+	if existing, exists := usersByEmail[user.Email]; exists && existing.ID != user.ID {
+		return errors.New("email already in use")
+	}
+
+	// Drop the old email index entry if the email changed
+	for email, existing := range usersByEmail {
+		if existing.ID == user.ID && email != user.Email {
+			delete(usersByEmail, email)
+		}
+	}
 
 	us.users[user.ID] = user
-	us.usersByEmail[user.Email] = user
+	usersByEmail[user.Email] = user
 	return nil
 }
 
-// GetAllUsers returns all users (for testing)
+// GetMerchantUsers returns the users of one merchant
+func (us *UserStorage) GetMerchantUsers(merchantID string) map[string]*models.User {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	users := make(map[string]*models.User)
+	for _, user := range us.usersByEmail[models.MerchantIDOrDefault(merchantID)] {
+		users[user.ID] = user
+	}
+	return users
+}
+
+// GetAllUsers returns the users of all merchants
 func (us *UserStorage) GetAllUsers() map[string]*models.User {
 	us.mu.RLock()
 	defer us.mu.RUnlock()
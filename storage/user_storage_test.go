@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"testing"
+
+	"loyalty-core/models"
+)
+
+func TestUserStorageIsolatesMerchants(t *testing.T) {
+	us := NewUserStorage()
+	alice := &models.User{ID: "user-a", MerchantID: "brand-a", Email: "alice@example.com", SquareAccountID: "account-1", LoyaltyID: "100001"}
+	if err := us.CreateUser(alice); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	// The same email at another brand is a separate account
+	other := &models.User{ID: "user-b", MerchantID: "brand-b", Email: "alice@example.com"}
+	if err := us.CreateUser(other); err != nil {
+		t.Fatalf("CreateUser at another merchant: %v", err)
+	}
+	if err := us.CreateUser(&models.User{ID: "user-c", MerchantID: "brand-a", Email: "alice@example.com"}); err == nil {
+		t.Fatal("CreateUser accepted a duplicate email within a merchant")
+	}
+
+	if user, err := us.GetMerchantUserByID("brand-a", alice.ID); err != nil || user.ID != alice.ID {
+		t.Fatalf("GetMerchantUserByID(own merchant) = %v, %v", user, err)
+	}
+	if user, err := us.GetMerchantUserByID("brand-b", alice.ID); err == nil {
+		t.Errorf("GetMerchantUserByID(another merchant) = %s, want not found", user.ID)
+	}
+	if user, err := us.GetUserByEmail("brand-b", alice.Email); err != nil || user.ID != other.ID {
+		t.Errorf("GetUserByEmail(brand-b) = %v, %v; want %s", user, err, other.ID)
+	}
+	if user, err := us.GetUserBySquareAccountID("brand-b", alice.SquareAccountID); err == nil {
+		t.Errorf("GetUserBySquareAccountID(another merchant) = %s, want not found", user.ID)
+	}
+	if user, err := us.GetUserByLoyaltyID("brand-b", alice.LoyaltyID); err == nil {
+		t.Errorf("GetUserByLoyaltyID(another merchant) = %s, want not found", user.ID)
+	}
+
+	members := us.GetMerchantUsers("brand-b")
+	if len(members) != 1 || members[other.ID] == nil {
+		t.Errorf("GetMerchantUsers(brand-b) = %v, want only %s", members, other.ID)
+	}
+}
+
+func TestUserStorageUpdateKeepsMerchant(t *testing.T) {
+	us := NewUserStorage()
+	if err := us.CreateUser(&models.User{ID: "user-a", MerchantID: "brand-a", Email: "alice@example.com"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if err := us.UpdateUser(&models.User{ID: "user-a", MerchantID: "brand-b", Email: "alice@example.com"}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if _, err := us.GetMerchantUserByID("brand-b", "user-a"); err == nil {
+		t.Error("UpdateUser moved the user to another merchant")
+	}
+	if _, err := us.GetMerchantUserByID("brand-a", "user-a"); err != nil {
+		t.Errorf("GetMerchantUserByID(brand-a) after update: %v", err)
+	}
+	if users := us.GetMerchantUsers("brand-b"); len(users) != 0 {
+		t.Errorf("GetMerchantUsers(brand-b) = %v, want none", users)
+	}
+}
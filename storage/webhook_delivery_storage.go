@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"errors"
+	"loyalty-core/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WebhookDeliveryStorage provides in-memory storage of outbound webhook deliveries and their
+// attempts
+type WebhookDeliveryStorage struct {
+	deliveries map[string]*models.WebhookDelivery // deliveryID -> delivery
+	mu         sync.RWMutex
+}
+
+// NewWebhookDeliveryStorage creates a new webhook delivery storage instance
+func NewWebhookDeliveryStorage() *WebhookDeliveryStorage {
+	return &WebhookDeliveryStorage{
+		deliveries: make(map[string]*models.WebhookDelivery),
+	}
+}
+
+// SaveDelivery adds or replaces a delivery
+func (ds *WebhookDeliveryStorage) SaveDelivery(delivery models.WebhookDelivery) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	delivery.Attempts = append([]models.WebhookAttempt{}, delivery.Attempts...)
+	ds.deliveries[delivery.ID] = &delivery
+}
+
+// GetDelivery retrieves a copy of a merchant's delivery
+func (ds *WebhookDeliveryStorage) GetDelivery(merchantID, deliveryID string) (*models.WebhookDelivery, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	delivery, exists := ds.deliveries[deliveryID]
+	if !exists || delivery.MerchantID != models.MerchantIDOrDefault(merchantID) {
+		return nil, errors.New("webhook delivery not found")
+	}
+	return copyDelivery(delivery), nil
+}
+
+// ListDeliveries returns copies of a subscription's deliveries with the given status (all if
+// status is empty), newest first
+func (ds *WebhookDeliveryStorage) ListDeliveries(merchantID, subscriptionID, status string) []models.WebhookDelivery {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	merchantID = models.MerchantIDOrDefault(merchantID)
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range ds.deliveries {
+		if delivery.MerchantID != merchantID || delivery.SubscriptionID != subscriptionID {
+			continue
+		}
+		if status == "" || delivery.Status == status {
+			deliveries = append(deliveries, *copyDelivery(delivery))
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+	return deliveries
+}
+
+// DueDeliveries returns pending deliveries whose next attempt is due, oldest first
+func (ds *WebhookDeliveryStorage) DueDeliveries(now time.Time) []models.WebhookDelivery {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	due := []models.WebhookDelivery{}
+	for _, delivery := range ds.deliveries {
+		if delivery.Status == models.WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, *copyDelivery(delivery))
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+	return due
+}
+
+// CountPending returns the number of deliveries that have not been delivered or given up on
+func (ds *WebhookDeliveryStorage) CountPending() int {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	pending := 0
+	for _, delivery := range ds.deliveries {
+		if delivery.Status == models.WebhookDeliveryPending {
+			pending++
+		}
+	}
+	return pending
+}
+
+func copyDelivery(delivery *models.WebhookDelivery) *models.WebhookDelivery {
+	copied := *delivery
+	copied.Attempts = append([]models.WebhookAttempt{}, delivery.Attempts...)
+	return &copied
+}
+
+// Global webhook delivery storage instance
+var globalWebhookDeliveryStorage *WebhookDeliveryStorage
+
+// GetGlobalWebhookDeliveryStorage returns the global webhook delivery storage instance
+func GetGlobalWebhookDeliveryStorage() *WebhookDeliveryStorage {
+	if globalWebhookDeliveryStorage == nil {
+		globalWebhookDeliveryStorage = NewWebhookDeliveryStorage()
+	}
+	return globalWebhookDeliveryStorage
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"loyalty-core/models"
+	"sync"
+	"time"
+)
+
+// WebhookEventStorage provides in-memory storage of processed webhook events for deduplication
+type WebhookEventStorage struct {
+	events map[string]*models.ProcessedWebhookEvent // eventID -> event
+	mu     sync.Mutex
+}
+
+// NewWebhookEventStorage creates a new webhook event storage instance
+func NewWebhookEventStorage() *WebhookEventStorage {
+	return &WebhookEventStorage{
+		events: make(map[string]*models.ProcessedWebhookEvent),
+	}
+}
+
+// Claim marks an event as being processed. It returns false if the event was already claimed.
+func (ws *WebhookEventStorage) Claim(eventID, eventType string) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, exists := ws.events[eventID]; exists {
+		return false
+	}
+
+	ws.events[eventID] = &models.ProcessedWebhookEvent{
+		EventID:     eventID,
+		Type:        eventType,
+		ProcessedAt: time.Now(),
+	}
+	return true
+}
+
+// Release forgets a claimed event so a redelivery can be processed again
+func (ws *WebhookEventStorage) Release(eventID string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	delete(ws.events, eventID)
+}
+
+// Global webhook event storage instance
+var globalWebhookEventStorage *WebhookEventStorage
+
+// GetGlobalWebhookEventStorage returns the global webhook event storage instance
+func GetGlobalWebhookEventStorage() *WebhookEventStorage {
+	if globalWebhookEventStorage == nil {
+		globalWebhookEventStorage = NewWebhookEventStorage()
+	}
+	return globalWebhookEventStorage
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"errors"
+	"loyalty-core/models"
+	"sort"
+	"sync"
+)
+
+// WebhookSubscriptionStorage provides in-memory storage of outbound webhook subscriptions
+type WebhookSubscriptionStorage struct {
+	subscriptions map[string]*models.WebhookSubscription // subscriptionID -> subscription
+	mu            sync.RWMutex
+}
+
+// NewWebhookSubscriptionStorage creates a new webhook subscription storage instance
+func NewWebhookSubscriptionStorage() *WebhookSubscriptionStorage {
+	return &WebhookSubscriptionStorage{
+		subscriptions: make(map[string]*models.WebhookSubscription),
+	}
+}
+
+// SaveSubscription adds or replaces a subscription
+func (ws *WebhookSubscriptionStorage) SaveSubscription(subscription models.WebhookSubscription) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	subscription.EventTypes = append([]string{}, subscription.EventTypes...)
+	ws.subscriptions[subscription.ID] = &subscription
+}
+
+// GetSubscription retrieves a copy of a merchant's subscription
+func (ws *WebhookSubscriptionStorage) GetSubscription(merchantID, subscriptionID string) (*models.WebhookSubscription, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	subscription, exists := ws.subscriptions[subscriptionID]
+	if !exists || subscription.MerchantID != models.MerchantIDOrDefault(merchantID) {
+		return nil, errors.New("webhook subscription not found")
+	}
+
+	copied := *subscription
+	copied.EventTypes = append([]string{}, subscription.EventTypes...)
+	return &copied, nil
+}
+
+// DeleteSubscription removes a merchant's subscription
+func (ws *WebhookSubscriptionStorage) DeleteSubscription(merchantID, subscriptionID string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	subscription, exists := ws.subscriptions[subscriptionID]
+	if !exists || subscription.MerchantID != models.MerchantIDOrDefault(merchantID) {
+		return errors.New("webhook subscription not found")
+	}
+
+	delete(ws.subscriptions, subscriptionID)
+	return nil
+}
+
+// ListSubscriptions returns copies of a merchant's subscriptions, oldest first
+func (ws *WebhookSubscriptionStorage) ListSubscriptions(merchantID string) []models.WebhookSubscription {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	merchantID = models.MerchantIDOrDefault(merchantID)
+	subscriptions := []models.WebhookSubscription{}
+	for _, subscription := range ws.subscriptions {
+		if subscription.MerchantID == merchantID {
+			copied := *subscription
+			copied.EventTypes = append([]string{}, subscription.EventTypes...)
+			subscriptions = append(subscriptions, copied)
+		}
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+	return subscriptions
+}
+
+// Global webhook subscription storage instance
+var globalWebhookSubscriptionStorage *WebhookSubscriptionStorage
+
+// GetGlobalWebhookSubscriptionStorage returns the global webhook subscription storage instance
+func GetGlobalWebhookSubscriptionStorage() *WebhookSubscriptionStorage {
+	if globalWebhookSubscriptionStorage == nil {
+		globalWebhookSubscriptionStorage = NewWebhookSubscriptionStorage()
+	}
+	return globalWebhookSubscriptionStorage
+}
@@ -8,17 +8,23 @@ import (
 )
 
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID     string `json:"user_id"`
+	Email      string `json:"email"`
+	MerchantID string `json:"merchant_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID, email, secret string) (string, error) {
+// TokenTTL is how long an issued token stays valid
+const TokenTTL = 24 * time.Hour
+
+func GenerateToken(userID, email, merchantID, sessionID, secret string) (string, error) {
 	claims := Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:     userID,
+		Email:      email,
+		MerchantID: merchantID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
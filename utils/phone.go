@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// NormalizePhone converts a phone number to E.164 format (+<country code><number>).
+// Numbers without an international prefix are assumed to belong to defaultCountryCode.
+func NormalizePhone(raw, defaultCountryCode string) (string, error) {
+	phone := strings.TrimSpace(raw)
+	if phone == "" {
+		return "", errors.New("phone number is required")
+	}
+
+	// Drop common formatting characters
+	phone = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "").Replace(phone)
+
+	switch {
+	case strings.HasPrefix(phone, "+"):
+		phone = phone[1:]
+	case strings.HasPrefix(phone, "00"):
+		phone = phone[2:]
+	default:
+		if defaultCountryCode == "" {
+			return "", errors.New("phone number must include a country code")
+		}
+		phone = strings.TrimPrefix(defaultCountryCode, "+") + strings.TrimPrefix(phone, "0")
+	}
+
+	for _, r := range phone {
+		if r < '0' || r > '9' {
+			return "", errors.New("phone number may only contain digits")
+		}
+	}
+
+	// E.164 allows at most 15 digits and country codes never start with 0
+	if len(phone) < 8 || len(phone) > 15 || phone[0] == '0' {
+		return "", errors.New("invalid phone number")
+	}
+
+	return "+" + phone, nil
+}
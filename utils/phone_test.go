@@ -0,0 +1,44 @@
+package utils
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		raw         string
+		countryCode string
+		want        string
+	}{
+		{raw: "+1 (555) 010-2030", countryCode: "1", want: "+15550102030"},
+		{raw: "555.010.2030", countryCode: "1", want: "+15550102030"},
+		{raw: "555-010-2030", countryCode: "+1", want: "+15550102030"},
+		{raw: "0044 20 7946 0018", countryCode: "1", want: "+442079460018"},
+		{raw: "020 7946 0018", countryCode: "44", want: "+442079460018"},
+		{raw: "  +49 30 901820  ", countryCode: "1", want: "+4930901820"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizePhone(tt.raw, tt.countryCode)
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizePhone(%q, %q) = %q, %v; want %q", tt.raw, tt.countryCode, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizePhoneRejects(t *testing.T) {
+	tests := []struct {
+		raw         string
+		countryCode string
+	}{
+		{raw: "", countryCode: "1"},
+		{raw: "   ", countryCode: "1"},
+		{raw: "555-0102", countryCode: ""},           // no country code to assume
+		{raw: "+1 555 CALL NOW", countryCode: "1"},   // letters
+		{raw: "+1234567", countryCode: "1"},          // too short
+		{raw: "+1234567890123456", countryCode: "1"}, // more than 15 digits
+		{raw: "+0123456789", countryCode: "1"},       // country codes never start with 0
+	}
+	for _, tt := range tests {
+		if got, err := NormalizePhone(tt.raw, tt.countryCode); err == nil {
+			t.Errorf("NormalizePhone(%q, %q) = %q, want an error", tt.raw, tt.countryCode, got)
+		}
+	}
+}